@@ -0,0 +1,161 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+/*
+ * age recipients.
+ *
+ * For disaster recovery without the machine, the secure fields can be
+ * encrypted with age (https://age-encryption.org) instead of plain AES-GCM:
+ * with SetAgeRecipients or WithAgeRecipients, each new SecurePassword value
+ * is an armored age file ("-----BEGIN AGE ENCRYPTED FILE-----") whose file
+ * key is wrapped for every X25519 recipient of the ops team and, in an
+ * "sconfig-key" stanza, with the config key. The program decrypts it with the
+ * config key as before; an operator decrypts it with the age tool and an
+ * identity file:
+ *
+ *	jq -r .database_secure_password config.json | age -d -i ops-key.txt
+ *
+ * The plaintext field still gets the marker. Values are decrypted whatever
+ * the setting, so files with AES-GCM and age values mix; ReencryptConfig
+ * moves all values to the current recipients.
+ */
+
+// ageArmorHeader starts an armored age file.
+const ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+// ageConfigKeyStanza is the stanza type of the file key wrapped with the
+// config key.
+const ageConfigKeyStanza = "sconfig-key"
+
+var (
+	ageMu         sync.Mutex
+	ageRecipients []age.Recipient
+)
+
+// SetAgeRecipients makes new secure values age-encrypted for the X25519
+// recipients ("age1..."), in addition to the config key. Without arguments
+// values are AES-GCM encrypted again.
+func SetAgeRecipients(recipients ...string) error {
+	parsed, err := parseAgeRecipients(recipients)
+	if err != nil {
+		return err
+	}
+	ageMu.Lock()
+	ageRecipients = parsed
+	ageMu.Unlock()
+	return nil
+}
+
+// WithAgeRecipients makes Load encrypt new secure values for the age
+// recipients, as SetAgeRecipients before the call would; they stay in effect
+// for later calls.
+func WithAgeRecipients(recipients ...string) LoadOption {
+	return func(o *loadOptions) {
+		o.ageRecipients = recipients
+		o.ageRecipientsSet = true
+	}
+}
+
+func parseAgeRecipients(recipients []string) ([]age.Recipient, error) {
+	var parsed []age.Recipient
+	for _, s := range recipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s", t("config.age_recipient_invalid", s, err))
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+func getAgeRecipients() []age.Recipient {
+	ageMu.Lock()
+	defer ageMu.Unlock()
+	return ageRecipients
+}
+
+// isAgeValue reports whether the secure value text is an armored age file.
+func isAgeValue(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), ageArmorHeader)
+}
+
+// encryptAge encrypts text for recipients and for key.
+func encryptAge(key []byte, recipients []age.Recipient, text string) (string, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, append([]age.Recipient{configKeyRecipient{key}}, recipients...)...)
+	if err != nil {
+		return "", fmt.Errorf("encrypt: age: %w", err)
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		return "", fmt.Errorf("encrypt: age: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("encrypt: age: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return "", fmt.Errorf("encrypt: age: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// decryptAge decrypts the armored age file text with key.
+func decryptAge(key []byte, text string) (string, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(text))), configKeyIdentity{key})
+	if err != nil {
+		return "", fmt.Errorf("decrypt: age: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	defer wipe(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: age: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// configKeyRecipient wraps the age file key with the config key.
+type configKeyRecipient struct {
+	key []byte
+}
+
+func (r configKeyRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	sealed, err := encryptWithKey(r.key, string(fileKey))
+	if err != nil {
+		return nil, err
+	}
+	body, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return []*age.Stanza{{Type: ageConfigKeyStanza, Body: body}}, nil
+}
+
+// configKeyIdentity unwraps the age file key with the config key.
+type configKeyIdentity struct {
+	key []byte
+}
+
+func (i configKeyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != ageConfigKeyStanza {
+			continue
+		}
+		fileKey, err := decryptWithKey(i.key, base64.StdEncoding.EncodeToString(s.Body))
+		if err != nil {
+			continue // wrapped with another config key
+		}
+		return []byte(fileKey), nil
+	}
+	return nil, age.ErrIncorrectIdentity
+}
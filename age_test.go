@@ -0,0 +1,72 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestLoad_AgeRecipients(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	defer SetAgeRecipients()
+	hw := func() (uint64, error) { return 4711, nil }
+	ops, err := age.GenerateX25519Identity()
+	if err != nil {
+		ts.Fatal(err)
+	}
+	configPath := filepath.Join(tempDir, "age.json")
+
+	if err := Load(&TestConfig{}, configPath, WithAgeRecipients("age1invalid")); err == nil || !strings.Contains(err.Error(), "age1invalid") {
+		ts.Fatalf("expected invalid recipient error, got %v", err)
+	}
+
+	// A value written before the recipients were set stays AES-GCM.
+	if err := LoadConfig(&TestConfig{DatabasePassword: "ops-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(configPath); strings.Contains(string(raw), "AGE ENCRYPTED") {
+		ts.Fatalf("value age-encrypted without recipients:\n%s", raw)
+	}
+	if err := Load(&TestConfig{}, configPath, WithVersion(1), WithHardwareIDFunc(hw), WithAgeRecipients(ops.Recipient().String())); err != nil {
+		ts.Fatalf("Load with recipients failed: %v", err)
+	}
+	if err := ReencryptConfig(&TestConfig{}, configPath, hw, hw); err != nil {
+		ts.Fatalf("ReencryptConfig failed: %v", err)
+	}
+
+	raw, _ := os.ReadFile(configPath)
+	var file map[string]interface{}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		ts.Fatal(err)
+	}
+	secure, _ := file["database_secure_password"].(string)
+	if !strings.HasPrefix(secure, ageArmorHeader) || file["database_password"] != PASSWORD_IS_SECURE {
+		ts.Fatalf("expected an age value and the marker:\n%s", raw)
+	}
+
+	// The program reads it with the config key ...
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig of the age value failed: %v", err)
+	}
+	if cfg.DatabasePassword != "ops-secret" {
+		ts.Errorf("DatabasePassword = %q", cfg.DatabasePassword)
+	}
+
+	// ... and ops with their identity, without the machine.
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(secure)), ops)
+	if err != nil {
+		ts.Fatalf("age decryption with the ops identity failed: %v", err)
+	}
+	if plain, _ := io.ReadAll(r); string(plain) != "ops-secret" {
+		ts.Errorf("ops decrypted %q", plain)
+	}
+}
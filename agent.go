@@ -0,0 +1,383 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+ * Local agent.
+ *
+ * Several processes on one host often read the same config. With LoadConfig
+ * every one of them derives the key and holds it in memory. In agent mode a
+ * single process, the agent, holds the key and serves the decrypted configs
+ * over a local socket (a unix domain socket; on Windows an AF_UNIX socket,
+ * which takes the place of a named pipe); sibling processes read them with
+ * LoadFromAgent and never derive the key themselves.
+ *
+ * The agent serves only the config files it was started with, and only to
+ * peers whose credentials the kernel reports (SO_PEERCRED on Linux,
+ * LOCAL_PEERCRED on macOS): by default processes of the agent's own user,
+ * otherwise the users and groups allowed with WithAgentUIDs and
+ * WithAgentGIDs. Where the peer cannot be identified the agent refuses every
+ * client. Every answer and every refusal is written to the audit log. The
+ * socket file should still be placed in a directory only the allowed users
+ * can reach.
+ *
+ * Rules (WithAgentRule) narrow a file down to some of its fields per peer,
+ * e.g. so that the backup job reads the backup credentials but not the
+ * database root password. A file with rules is served only to peers that
+ * match one of them, and only with the fields of the rules they match; the
+ * other keys are removed from the document before it leaves the agent.
+ * Peers are matched by user, primary group and executable (read from
+ * /proc/<pid>/exe on Linux and kern.procargs2 on macOS).
+ *
+ * The protocol is one JSON request and one JSON response per connection.
+ */
+
+// agentTimeout bounds one request, on the agent and on the client.
+const agentTimeout = 10 * time.Second
+
+// PeerCredentials identify the process at the other end of a local socket.
+type PeerCredentials struct {
+	PID        int
+	UID        int
+	GID        int
+	Executable string // absolute path of the program, "" if unknown
+}
+
+// AgentRule grants the matching peers access to some fields of a config
+// file. A peer matches if it runs as one of UIDs or with the primary group
+// of one of GIDs (if both are empty: if the agent allows it at all) and, if
+// Executables is set, runs one of these programs.
+type AgentRule struct {
+	UIDs        []int    `json:"uids,omitempty"`
+	GIDs        []int    `json:"gids,omitempty"`
+	Executables []string `json:"executables,omitempty"` // absolute paths
+	Fields      []string `json:"fields,omitempty"`      // document paths (see DocumentField.Path) with everything below them; all if empty
+}
+
+// Agent serves decrypted configs to sibling processes; see NewAgent.
+type Agent struct {
+	files   map[string]bool
+	uids    map[int]bool
+	gids    map[int]bool
+	rules   map[string][]AgentRule // by resolved file
+	pending []pendingRule          // rules until NewAgent resolves their files
+}
+
+type pendingRule struct {
+	path string
+	rule AgentRule
+}
+
+// AgentOption configures an Agent.
+type AgentOption func(*Agent)
+
+// WithAgentUIDs allows processes of the users uids, in addition to the
+// agent's own user.
+func WithAgentUIDs(uids ...int) AgentOption {
+	return func(a *Agent) {
+		for _, uid := range uids {
+			a.uids[uid] = true
+		}
+	}
+}
+
+// WithAgentGIDs allows processes whose primary group is one of gids.
+func WithAgentGIDs(gids ...int) AgentOption {
+	return func(a *Agent) {
+		for _, gid := range gids {
+			a.gids[gid] = true
+		}
+	}
+}
+
+// WithAgentRule restricts the config file at path to the peers and fields
+// of rule. Several rules for one file add up.
+func WithAgentRule(path string, rule AgentRule) AgentOption {
+	return func(a *Agent) {
+		a.pending = append(a.pending, pendingRule{path: path, rule: rule})
+	}
+}
+
+// NewAgent returns an agent that serves the config files at paths, resolved
+// like LoadConfig resolves them.
+func NewAgent(paths []string, opts ...AgentOption) (*Agent, error) {
+	a := &Agent{
+		files: make(map[string]bool),
+		uids:  map[int]bool{os.Getuid(): true},
+		gids:  make(map[int]bool),
+		rules: make(map[string][]AgentRule),
+	}
+	for _, path := range paths {
+		resolved, err := agentPath(path)
+		if err != nil {
+			return nil, err
+		}
+		a.files[resolved] = true
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	for _, p := range a.pending {
+		resolved, err := agentPath(p.path)
+		if err != nil {
+			return nil, err
+		}
+		if !a.files[resolved] {
+			return nil, fmt.Errorf("%s", t("config.agent_unknown_file", p.path))
+		}
+		a.rules[resolved] = append(a.rules[resolved], p.rule)
+	}
+	a.pending = nil
+	return a, nil
+}
+
+type agentRequest struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+type agentResponse struct {
+	Config json.RawMessage `json:"config,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Serve answers the connections accepted from l, which must be a unix socket
+// listener, until l is closed. The key is derived on the first request.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	var resp agentResponse
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		auditLog(t("config.agent_audit_unidentified", err))
+		resp.Error = t("config.agent_denied")
+		json.NewEncoder(conn).Encode(resp)
+		return
+	}
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil || req.Op != "load" {
+		auditLog(t("config.agent_audit_protocol", peer.UID, peer.GID, peer.PID, peer.Executable))
+		resp.Error = t("config.agent_protocol")
+		json.NewEncoder(conn).Encode(resp)
+		return
+	}
+	data, err := a.serve(peer, req.Path)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Config = data
+	}
+	json.NewEncoder(conn).Encode(resp)
+	wipe(data)
+}
+
+// serve returns the decrypted document of the config file at path for peer.
+func (a *Agent) serve(peer PeerCredentials, path string) ([]byte, error) {
+	resolved, err := agentPath(path)
+	if err != nil || !a.files[resolved] {
+		auditLog(t("config.agent_audit_denied", path, peer.UID, peer.GID, peer.PID, peer.Executable))
+		return nil, fmt.Errorf("%s", t("config.agent_unknown_file", path))
+	}
+	fields, ok := a.access(peer, resolved)
+	if !ok {
+		auditLog(t("config.agent_audit_denied", resolved, peer.UID, peer.GID, peer.PID, peer.Executable))
+		return nil, fmt.Errorf("%s", t("config.agent_denied"))
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	_, _, doc, err := readDocument(resolved, "config.agent_unsupported")
+	if err != nil {
+		return nil, err
+	}
+	key, err := documentKey(doc)
+	if err != nil {
+		return nil, err
+	}
+	_, err = decryptDocument(doc, key)
+	wipe(key)
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, metadataKey)
+	if fields != nil {
+		filterDocument(doc, "", fields)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	auditLog(t("config.agent_audit_served", resolved, peer.UID, peer.GID, peer.PID, peer.Executable))
+	return data, nil
+}
+
+func (a *Agent) allowed(peer PeerCredentials) bool {
+	return a.uids[peer.UID] || a.gids[peer.GID]
+}
+
+// access returns the fields of the file resolved that peer may read, nil for
+// all of them, and whether it may read the file at all.
+func (a *Agent) access(peer PeerCredentials, resolved string) ([]string, bool) {
+	rules := a.rules[resolved]
+	if len(rules) == 0 {
+		return nil, a.allowed(peer)
+	}
+	var fields []string
+	matched := false
+	for _, rule := range rules {
+		if !a.matches(rule, peer) {
+			continue
+		}
+		if len(rule.Fields) == 0 {
+			return nil, true
+		}
+		fields = append(fields, rule.Fields...)
+		matched = true
+	}
+	return fields, matched
+}
+
+func (a *Agent) matches(rule AgentRule, peer PeerCredentials) bool {
+	if len(rule.UIDs) == 0 && len(rule.GIDs) == 0 {
+		if !a.allowed(peer) {
+			return false
+		}
+	} else if !containsInt(rule.UIDs, peer.UID) && !containsInt(rule.GIDs, peer.GID) {
+		return false
+	}
+	if len(rule.Executables) == 0 {
+		return true
+	}
+	for _, exe := range rule.Executables {
+		if peer.Executable != "" && filepath.Clean(exe) == peer.Executable {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDocument removes from the decoded JSON value v, found at path, every
+// key that is neither one of fields, below one, nor above one.
+func filterDocument(v interface{}, path string, fields []string) {
+	keep := func(p string) (all, some bool) {
+		for _, f := range fields {
+			if p == f || strings.HasPrefix(p, f+".") || strings.HasPrefix(p, f+"[") {
+				return true, true
+			}
+			if strings.HasPrefix(f, p+".") || strings.HasPrefix(f, p+"[") {
+				some = true
+			}
+		}
+		return false, some
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			p := joinDocumentPath(path, name)
+			if all, some := keep(p); !some {
+				delete(v, name)
+			} else if !all {
+				filterDocument(value, p, fields)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if all, some := keep(p); !some {
+				v[i] = nil
+			} else if !all {
+				filterDocument(value, p, fields)
+			}
+		}
+	}
+}
+
+// agentPath resolves path to the absolute name the agent knows files by.
+func agentPath(path string) (string, error) {
+	resolved, err := resolveConfigPath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// LoadFromAgent reads the config file at path from the agent listening on
+// the unix socket socketPath into config (a pointer to a struct): defaults
+// first, then the decrypted values served by the agent, then secret
+// references, and validates it like LoadConfig. The key is not derived in
+// this process and the file is never written; path must be absolute or
+// resolve to the same file for the agent.
+func LoadFromAgent(config interface{}, socketPath, path string) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	conn, err := net.DialTimeout("unix", socketPath, agentTimeout)
+	if err != nil {
+		return fmt.Errorf("%s", t("config.agent_unreachable", socketPath, err))
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err := json.NewEncoder(conn).Encode(agentRequest{Op: "load", Path: path}); err != nil {
+		return fmt.Errorf("%s", t("config.agent_unreachable", socketPath, err))
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("%s", t("config.agent_unreachable", socketPath, err))
+	}
+	defer wipe(resp.Config)
+	if resp.Error != "" {
+		return fmt.Errorf("%s", t("config.agent_remote", resp.Error))
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := updateDefaultValues(v.Elem()); err != nil {
+		return fmt.Errorf(t("config.failed_defaulting"), err)
+	}
+	if err := decodeConfigStream(bytes.NewReader(resp.Config), config); err != nil {
+		return err
+	}
+	err = resolveSecretRefs(config)
+	// The struct is never written back; the bindings are not needed.
+	forgetSecretRefs(config)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
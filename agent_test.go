@@ -0,0 +1,176 @@
+//go:build linux || darwin
+
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgent(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "app.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "app-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	otherPath := filepath.Join(tempDir, "other.json")
+	if err := os.WriteFile(otherPath, []byte(`{"database_host":"other"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	agent, err := NewAgent([]string{configPath})
+	if err != nil {
+		ts.Fatalf("NewAgent failed: %v", err)
+	}
+	// Unix socket names are limited to about 100 bytes.
+	sockDir, err := os.MkdirTemp("", "sca")
+	if err != nil {
+		ts.Fatal(err)
+	}
+	defer os.RemoveAll(sockDir)
+	socket := filepath.Join(sockDir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- agent.Serve(l) }()
+
+	cfg := &TestConfig{}
+	if err := LoadFromAgent(cfg, socket, configPath); err != nil {
+		ts.Fatalf("LoadFromAgent failed: %v", err)
+	}
+	if cfg.DatabasePassword != "app-secret" || cfg.DatabaseSecurePassword != "" || cfg.DatabaseHost != "localhost" {
+		ts.Errorf("unexpected config %+v", cfg)
+	}
+
+	// Files the agent was not started with are refused.
+	if err := LoadFromAgent(&TestConfig{}, socket, otherPath); err == nil || !strings.Contains(err.Error(), t("config.agent_unknown_file", otherPath)) {
+		ts.Errorf("expected an unknown file error, got %v", err)
+	}
+
+	// Only the agent's own user is allowed by default.
+	if _, err := agent.serve(PeerCredentials{UID: os.Getuid() + 1, GID: 4711, PID: 1}, configPath); err == nil {
+		ts.Error("another user was served")
+	}
+	WithAgentGIDs(4711)(agent)
+	if _, err := agent.serve(PeerCredentials{UID: os.Getuid() + 1, GID: 4711, PID: 1}, configPath); err != nil {
+		ts.Errorf("allowed group refused: %v", err)
+	}
+
+	// A malformed request is refused and audited with the peer.
+	var audit bytes.Buffer
+	log.SetOutput(&audit)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		ts.Fatal(err)
+	}
+	json.NewEncoder(conn).Encode(map[string]string{"op": "dump", "path": configPath})
+	var resp agentResponse
+	json.NewDecoder(conn).Decode(&resp)
+	conn.Close()
+	log.SetOutput(os.Stderr) // after the handler's write, under the logger's lock
+	if resp.Error != t("config.agent_protocol") || resp.Config != nil {
+		ts.Errorf("unexpected response to a malformed request: %+v", resp)
+	}
+	if !strings.Contains(audit.String(), fmt.Sprintf("uid %d, gid %d", os.Getuid(), os.Getgid())) {
+		ts.Errorf("malformed request not audited with the peer: %q", audit.String())
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		ts.Errorf("Serve returned %v", err)
+	}
+}
+
+func TestAgent_Rules(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "app.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "root-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	const backupUID = 4242
+	agent, err := NewAgent([]string{configPath},
+		WithAgentRule(configPath, AgentRule{UIDs: []int{backupUID}, Executables: []string{"/usr/bin/backup"}, Fields: []string{"database_host", "database_user"}}),
+		WithAgentRule(configPath, AgentRule{Executables: []string{"/usr/bin/app"}}))
+	if err != nil {
+		ts.Fatalf("NewAgent failed: %v", err)
+	}
+	if _, err := NewAgent(nil, WithAgentRule(configPath, AgentRule{})); err == nil {
+		ts.Error("a rule for a file not served was accepted")
+	}
+
+	backup := PeerCredentials{UID: backupUID, GID: backupUID, PID: 1, Executable: "/usr/bin/backup"}
+	data, err := agent.serve(backup, configPath)
+	if err != nil {
+		ts.Fatalf("backup job refused: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	if doc["database_host"] != "localhost" || len(doc) != 2 || strings.Contains(string(data), "root-secret") {
+		ts.Errorf("backup job got %s", data)
+	}
+
+	// The rules replace the default access for this file.
+	for _, peer := range []PeerCredentials{
+		{UID: backupUID, GID: backupUID, PID: 1, Executable: "/usr/bin/other"},
+		{UID: os.Getuid(), GID: os.Getgid(), PID: 1, Executable: "/usr/bin/other"},
+	} {
+		if _, err := agent.serve(peer, configPath); err == nil {
+			ts.Errorf("%+v was served", peer)
+		}
+	}
+	app := PeerCredentials{UID: os.Getuid(), GID: os.Getgid(), PID: 1, Executable: "/usr/bin/app"}
+	if data, err := agent.serve(app, configPath); err != nil || !strings.Contains(string(data), "root-secret") {
+		ts.Errorf("app got %s, %v", data, err)
+	}
+}
+
+func TestFilterDocument(ts *testing.T) {
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(`{"a": 1, "b": {"c": 2, "d": 3}, "e": [{"f": 4, "g": 5}, {"f": 6}], "h": {"i": 7}}`), &doc)
+	filterDocument(doc, "", []string{"b.c", "e[0].f", "h"})
+	got, _ := json.Marshal(doc)
+	if want := `{"b":{"c":2},"e":[{"f":4},null],"h":{"i":7}}`; string(got) != want {
+		ts.Errorf("filtered document = %s, want %s", got, want)
+	}
+}
+
+func TestAgent_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "secured.json")
+	writeSecuredConfig(ts, configPath, "token-secret", hw)
+	agent, err := NewAgent([]string{configPath})
+	if err != nil {
+		ts.Fatalf("NewAgent failed: %v", err)
+	}
+	data, err := agent.serve(PeerCredentials{UID: os.Getuid(), GID: os.Getgid(), PID: 1}, configPath)
+	if err != nil {
+		ts.Fatalf("serve failed: %v", err)
+	}
+	var cfg securedTestConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		ts.Fatalf("invalid config: %v", err)
+	}
+	if cfg.APIToken != "token-secret" || cfg.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("secure fields served encrypted:\n%s", data)
+	}
+}
@@ -0,0 +1,356 @@
+package sconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * Config bundles for appliance export.
+ *
+ * An appliance configured at one site is shipped to another, but the
+ * passwords of its config files are bound to the key of the machine that
+ * wrote them. PackBundle decrypts the passwords of several config files (on
+ * the document, like DumpForRecovery) and packs the files with a manifest
+ * into a tar archive, which is encrypted with AES-256-GCM under a 32-byte
+ * recipient key agreed with the target site. UnpackBundle decrypts the
+ * archive on the target machine, checks every file against the manifest,
+ * encrypts the passwords with the key of that machine and writes the files
+ * (0600). The plaintext passwords exist only in memory. Both write audit
+ * lines to the standard logger. XML configs are not supported.
+ *
+ * A bundle file is the line bundleHeader followed by the base64 ciphertext of
+ * the archive. The archive holds bundleManifestName and the configs under
+ * bundleFilesDir.
+ */
+
+const (
+	bundleHeader       = "sconfig-bundle-v1"
+	bundleManifestName = "manifest.json"
+	bundleFilesDir     = "files/"
+)
+
+// bundleManifest describes the content of a bundle.
+type bundleManifest struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Host    string       `json:"host,omitempty"`
+	Files   []bundleFile `json:"files"`
+}
+
+// bundleFile is one config file of a bundle.
+type bundleFile struct {
+	Name      string `json:"name"`   // base name, also the name on the target
+	SHA256    string `json:"sha256"` // of the content in the archive
+	Passwords int    `json:"passwords"`
+}
+
+// PackBundle packs the config files at paths into the encrypted bundle
+// outFile for UnpackBundle on another machine. recipientKey is the 32-byte
+// key shared with the target site; keep it apart from the bundle. The files
+// must have distinct base names.
+func PackBundle(paths []string, outFile string, recipientKey []byte) error {
+	if len(recipientKey) != 32 {
+		return fmt.Errorf("%s", t("config.bundle_key", len(recipientKey)))
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return err
+	}
+	archive, manifest, passwords, err := packBundleArchive(paths, outFile)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptWithKey(recipientKey, string(archive))
+	wipe(archive)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(outFile, 0600, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s\n%s\n", bundleHeader, sealed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	auditLog(t("config.bundle_audit_pack", outFile, len(manifest.Files), passwords))
+	return nil
+}
+
+// packBundleArchive returns the tar archive of the config files at paths
+// with their passwords decrypted, its manifest and the number of passwords,
+// for the bundle or transfer target. The caller wipes the archive. Callers
+// hold stateMu.
+func packBundleArchive(paths []string, target string) ([]byte, *bundleManifest, int, error) {
+	manifest := &bundleManifest{Version: 1, Created: time.Now().UTC()}
+	manifest.Host, _ = os.Hostname()
+	contents := make(map[string][]byte)
+	defer func() {
+		for _, content := range contents {
+			wipe(content)
+		}
+	}()
+	passwords := 0
+	for _, path := range paths {
+		in, format, doc, err := readDocument(path, "config.bundle_unsupported")
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		name := filepath.Base(in)
+		if _, ok := contents[name]; ok {
+			return nil, nil, 0, fmt.Errorf("%s", t("config.bundle_duplicate", in, name))
+		}
+		key, err := documentKey(doc)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		count, err := decryptDocument(doc, key)
+		wipe(key)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		content, err := encodeDocument(format, doc)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		contents[name] = content
+		sum := sha256.Sum256(content)
+		manifest.Files = append(manifest.Files, bundleFile{Name: name, SHA256: hex.EncodeToString(sum[:]), Passwords: count})
+		passwords += count
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	manifestData, err := json.MarshalIndent(manifest, "", "\t")
+	if err == nil {
+		err = writeTarFile(tw, bundleManifestName, manifestData)
+	}
+	for _, f := range manifest.Files {
+		if err == nil {
+			err = writeTarFile(tw, bundleFilesDir+f.Name, contents[f.Name])
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		wipe(archive.Bytes())
+		return nil, nil, 0, fmt.Errorf("%s", t("config.bundle_write_failed", target, err))
+	}
+	return archive.Bytes(), manifest, passwords, nil
+}
+
+// writeTarFile adds a regular file to tw.
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// UnpackBundle unpacks the bundle written by PackBundle into destDir, with
+// the passwords encrypted with the key of this machine, and returns the paths
+// of the written files. Existing files of the same name are replaced.
+// recipientKey must be the key the bundle was packed with.
+func UnpackBundle(bundleFile, destDir string, recipientKey []byte) ([]string, error) {
+	if len(recipientKey) != 32 {
+		return nil, fmt.Errorf("%s", t("config.bundle_key", len(recipientKey)))
+	}
+	raw, err := os.ReadFile(bundleFile)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	header, sealed, _ := strings.Cut(string(raw), "\n")
+	if header != bundleHeader {
+		return nil, fmt.Errorf("%s", t("config.bundle_invalid", bundleFile))
+	}
+	archive, err := decryptWithKey(recipientKey, strings.TrimSpace(sealed))
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.bundle_decrypt_failed", bundleFile))
+	}
+	manifest, contents, err := readBundleArchive(bundleFile, []byte(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, content := range contents {
+			wipe(content)
+		}
+	}()
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	written, passwords, err := unpackBundleFiles(manifest, contents, destDir)
+	if err != nil {
+		return written, err
+	}
+	auditLog(t("config.bundle_audit_unpack", bundleFile, destDir, len(written), passwords))
+	return written, nil
+}
+
+// unpackBundleFiles writes the config files of a checked bundle archive to
+// destDir with the passwords encrypted with the current key, and returns
+// their paths and the number of passwords. Callers hold stateMu.
+func unpackBundleFiles(manifest *bundleManifest, contents map[string][]byte, destDir string) ([]string, int, error) {
+	if err := os.MkdirAll(destDir, 0700); err != nil {
+		return nil, 0, err
+	}
+	var written []string
+	passwords := 0
+	for _, f := range manifest.Files {
+		format, doc, err := decodeDocument(f.Name, contents[f.Name], "config.bundle_unsupported")
+		if err != nil {
+			return written, passwords, err
+		}
+		count, err := encryptDocument(doc)
+		if err != nil {
+			return written, passwords, err
+		}
+		setDocumentKeyMetadata(doc)
+		content, err := encodeDocument(format, doc)
+		if err != nil {
+			return written, passwords, err
+		}
+		out := filepath.Join(destDir, f.Name)
+		err = writeFileAtomic(out, 0600, func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return written, passwords, err
+		}
+		written = append(written, out)
+		passwords += count
+	}
+	return written, passwords, nil
+}
+
+// readBundleArchive returns the manifest and the config files of the
+// decrypted archive of bundleFile, each checked against the manifest.
+func readBundleArchive(bundleFile string, archive []byte) (*bundleManifest, map[string][]byte, error) {
+	var manifest *bundleManifest
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s", t("config.bundle_invalid", bundleFile))
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s", t("config.bundle_invalid", bundleFile))
+		}
+		if hdr.Name == bundleManifestName {
+			manifest = &bundleManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("%s", t("config.bundle_invalid", bundleFile))
+			}
+		} else if name, ok := strings.CutPrefix(hdr.Name, bundleFilesDir); ok {
+			contents[name] = data
+		}
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%s", t("config.bundle_invalid", bundleFile))
+	}
+	for _, f := range manifest.Files {
+		content, ok := contents[f.Name]
+		sum := sha256.Sum256(content)
+		// Names are base names; anything else could escape destDir.
+		if !ok || f.Name != filepath.Base(f.Name) || f.Name == "." || f.Name == ".." || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, fmt.Errorf("%s", t("config.bundle_corrupt", bundleFile, f.Name))
+		}
+	}
+	return manifest, contents, nil
+}
+
+// setDocumentKeyMetadata records the current key in the metadata block of
+// doc after encryptDocument. A document without a block gets one unless its
+// passwords are readable without it, i.e. encrypted with KDFLegacyRand or a
+// key provider key.
+func setDocumentKeyMetadata(doc map[string]interface{}) {
+	if _, ok := doc[metadataKey]; !ok {
+		_, salt := passphraseMetadata()
+		if kdf := currentKDF(); kdf != "" && kdf != KDFLegacyRand || salt != "" {
+			doc[metadataKey] = map[string]interface{}{}
+		}
+	}
+	if meta, ok := doc[metadataKey].(map[string]interface{}); ok {
+		meta["key"] = keyFingerprint()
+		if kdf := currentKDF(); kdf != "" {
+			meta["kdf"] = string(kdf)
+		} else {
+			delete(meta, "kdf")
+		}
+		if kdf, salt := passphraseMetadata(); salt != "" {
+			meta["passphrase"], meta["salt"] = kdf, salt
+		} else {
+			delete(meta, "passphrase")
+			delete(meta, "salt")
+		}
+	}
+}
+
+// isPlaintextPassword reports whether the value of a "…password" key is a
+// password to encrypt, not empty, the secure marker or a secret reference.
+func isPlaintextPassword(value string) bool {
+	return value != "" && value != PASSWORD_IS_SECURE_en && value != PASSWORD_IS_SECURE_de && !isSecretRef(value)
+}
+
+// encryptDocument encrypts the plaintext passwords and secure fields of the
+// decoded document v, the reverse of decryptDocument, with the current key
+// and returns their number. Secret references are left as they are.
+func encryptDocument(v interface{}) (int, error) {
+	count := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			n, err := encryptDocument(value)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+			plainKey, ok := secretPlainKey(v, name)
+			if !ok {
+				continue
+			}
+			password, _ := v[plainKey].(string)
+			if !isPlaintextPassword(password) {
+				continue
+			}
+			secure, err := encrypt(password)
+			if err != nil {
+				return 0, err
+			}
+			v[name] = secure
+			v[plainKey] = secureMarker()
+			count++
+		}
+	case []interface{}:
+		for _, value := range v {
+			n, err := encryptDocument(value)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+	return count, nil
+}
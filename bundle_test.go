@@ -0,0 +1,131 @@
+package sconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundle_PackUnpack(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer invalidateKey()
+	source := func() (uint64, error) { return 4711, nil }
+	target := func() (uint64, error) { return 9999, nil }
+	recipientKey := bytes.Repeat([]byte{0x42}, 32)
+
+	jsonPath := filepath.Join(tempDir, "site", "app.json")
+	yamlPath := filepath.Join(tempDir, "site", "db.yaml")
+	os.MkdirAll(filepath.Dir(jsonPath), 0700)
+	if err := LoadConfig(&TestConfig{DatabasePassword: "app-secret"}, 1, jsonPath, false, false, source); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := LoadConfig(&TestConfig{DatabasePassword: "db-secret"}, 1, yamlPath, false, false, source); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	bundle := filepath.Join(tempDir, "appliance.bundle")
+	if err := PackBundle([]string{jsonPath, yamlPath}, bundle, []byte("short")); err == nil || !contains(err.Error(), t("config.bundle_key", 5)) {
+		ts.Fatalf("expected key length error, got %v", err)
+	}
+	if err := PackBundle([]string{jsonPath, jsonPath}, bundle, recipientKey); err == nil || !contains(err.Error(), t("config.bundle_duplicate", jsonPath, "app.json")) {
+		ts.Fatalf("expected duplicate error, got %v", err)
+	}
+	if err := PackBundle([]string{jsonPath, yamlPath}, bundle, recipientKey); err != nil {
+		ts.Fatalf("PackBundle failed: %v", err)
+	}
+	raw, _ := os.ReadFile(bundle)
+	if !strings.HasPrefix(string(raw), bundleHeader+"\n") || strings.Contains(string(raw), "secret") {
+		ts.Fatalf("bundle is not encrypted:\n%s", raw)
+	}
+
+	// The target machine derives another key.
+	invalidateKey()
+	if err := LoadConfig(&TestConfig{}, 1, filepath.Join(tempDir, "target.json"), false, false, target); err != nil {
+		ts.Fatalf("LoadConfig on target failed: %v", err)
+	}
+	destDir := filepath.Join(tempDir, "target")
+	if _, err := UnpackBundle(bundle, destDir, bytes.Repeat([]byte{0x43}, 32)); err == nil || !contains(err.Error(), t("config.bundle_decrypt_failed", bundle)) {
+		ts.Fatalf("expected decrypt error, got %v", err)
+	}
+	written, err := UnpackBundle(bundle, destDir, recipientKey)
+	if err != nil {
+		ts.Fatalf("UnpackBundle failed: %v", err)
+	}
+	if len(written) != 2 {
+		ts.Fatalf("expected 2 files, got %v", written)
+	}
+	for path, want := range map[string]string{"app.json": "app-secret", "db.yaml": "db-secret"} {
+		path = filepath.Join(destDir, path)
+		raw, _ := os.ReadFile(path)
+		if strings.Contains(string(raw), want) {
+			ts.Errorf("%s: password written in plain text:\n%s", path, raw)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, path, false, false, target); err != nil {
+			ts.Fatalf("LoadConfig of %s failed: %v", path, err)
+		}
+		if cfg.DatabasePassword != want {
+			ts.Errorf("%s: expected %q, got %q", path, want, cfg.DatabasePassword)
+		}
+	}
+}
+
+func TestBundle_Corrupt(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	bundle := filepath.Join(tempDir, "bad.bundle")
+	os.WriteFile(bundle, []byte("not a bundle\n"), 0600)
+	if _, err := UnpackBundle(bundle, tempDir, make([]byte, 32)); err == nil || !contains(err.Error(), t("config.bundle_invalid", bundle)) {
+		ts.Errorf("expected invalid bundle error, got %v", err)
+	}
+
+	// A manifest entry that would escape the target directory is rejected.
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	writeTarFile(tw, bundleManifestName, []byte(`{"version":1,"files":[{"name":"../evil.json","sha256":""}]}`))
+	writeTarFile(tw, bundleFilesDir+"../evil.json", nil)
+	tw.Close()
+	if _, _, err := readBundleArchive(bundle, archive.Bytes()); err == nil || !contains(err.Error(), t("config.bundle_corrupt", bundle, "../evil.json")) {
+		ts.Errorf("expected corrupt bundle error, got %v", err)
+	}
+}
+
+func TestBundle_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer invalidateKey()
+	source := func() (uint64, error) { return 4711, nil }
+	target := func() (uint64, error) { return 9999, nil }
+	recipientKey := bytes.Repeat([]byte{0x42}, 32)
+
+	invalidateKey()
+	configPath := filepath.Join(tempDir, "secured.json")
+	writeSecuredConfig(ts, configPath, "token-secret", source)
+	bundle := filepath.Join(tempDir, "secured.bundle")
+	if err := PackBundle([]string{configPath}, bundle, recipientKey); err != nil {
+		ts.Fatalf("PackBundle failed: %v", err)
+	}
+
+	// The target machine derives another key and cannot read the source's
+	// ciphertexts.
+	invalidateKey()
+	if err := LoadConfig(&TestConfig{}, 1, filepath.Join(tempDir, "target.json"), false, false, target); err != nil {
+		ts.Fatalf("LoadConfig on target failed: %v", err)
+	}
+	destDir := filepath.Join(tempDir, "target")
+	if _, err := UnpackBundle(bundle, destDir, recipientKey); err != nil {
+		ts.Fatalf("UnpackBundle failed: %v", err)
+	}
+	path := filepath.Join(destDir, "secured.json")
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), "token-secret") {
+		ts.Errorf("secure field written in plain text:\n%s", raw)
+	}
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, path, false, false, target); err != nil {
+		ts.Fatalf("LoadConfig on target failed: %v", err)
+	}
+	if cfg.APIToken != "token-secret" || cfg.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("values on target = %+v", cfg)
+	}
+}
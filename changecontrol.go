@@ -0,0 +1,144 @@
+package sconfig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/user"
+	"time"
+)
+
+/*
+ * Change control.
+ *
+ * Shops with a change-control process approve every config change. After
+ * the approved change, Freeze returns a manifest with the canonical hash of
+ * the file; at startup VerifyFrozen (or Load with WithFrozenManifest)
+ * refuses a file that was modified outside that process.
+ *
+ * The canonical hash covers the values, not the bytes: the document with the
+ * passwords decrypted and without the metadata block, encoded as compact
+ * JSON with sorted keys. Reformatting, converting between JSON, YAML and
+ * TOML, re-encrypting the passwords (new nonces, a KDF migration) and the
+ * write-back of LoadConfig therefore keep the hash; any changed value breaks
+ * it. The hash is an HMAC under the config key, so it reveals nothing about
+ * the passwords, and the manifest is signed with the same key: only a
+ * process that can derive the key of this machine can freeze a file for it.
+ * XML configs are not supported.
+ */
+
+// FreezeManifest is the approval record returned by Freeze.
+type FreezeManifest struct {
+	Hash      string    `json:"hash"` // keyed canonical hash of the config
+	FrozenAt  time.Time `json:"frozen_at"`
+	FrozenBy  string    `json:"frozen_by,omitempty"`
+	Signature string    `json:"signature"`
+}
+
+// sign returns the signature of m under key.
+func (m *FreezeManifest) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "sconfig-freeze-manifest-v1\n%s\n%s\n%s", m.Hash, m.FrozenAt.UTC().Format(time.RFC3339Nano), m.FrozenBy)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Freeze returns the manifest of the config file at path in its approved
+// state, to be stored with the change record and passed to VerifyFrozen.
+// Freeze after the first LoadConfig, which may write the file back.
+func Freeze(path string) ([]byte, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	in, err := resolveConfigPath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := currentKeyCopy()
+	defer wipe(key)
+	hash, err := canonicalHash(in, key)
+	if err != nil {
+		return nil, err
+	}
+	m := &FreezeManifest{Hash: hash, FrozenAt: time.Now().UTC()}
+	if u, err := user.Current(); err == nil {
+		m.FrozenBy = u.Username
+	}
+	m.Signature = m.sign(key)
+	auditLog(t("config.freeze_audit", in, m.Hash))
+	return json.MarshalIndent(m, "", "\t")
+}
+
+// VerifyFrozen returns an error if the config file at path differs from the
+// state recorded in manifest, or if manifest was not created by Freeze on this
+// machine. Call it at startup and refuse to run on an error.
+func VerifyFrozen(path string, manifest []byte) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return err
+	}
+	in, err := resolveConfigPath(path)
+	if err != nil {
+		return err
+	}
+	return verifyFrozenFile(in, manifest)
+}
+
+// WithFrozenManifest makes Load fail, before reading the file, if it differs
+// from the state recorded in manifest (see VerifyFrozen).
+func WithFrozenManifest(manifest []byte) LoadOption {
+	return func(o *loadOptions) { o.frozen = manifest }
+}
+
+// verifyFrozenFile checks the file at the resolved path in against manifest.
+// Callers hold stateMu and have derived the key.
+func verifyFrozenFile(in string, manifest []byte) error {
+	var m FreezeManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("%s", t("config.freeze_manifest_invalid"))
+	}
+	key := currentKeyCopy()
+	defer wipe(key)
+	if !hmac.Equal([]byte(m.Signature), []byte(m.sign(key))) {
+		return fmt.Errorf("%s", t("config.freeze_manifest_invalid"))
+	}
+	hash, err := canonicalHash(in, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(hash), []byte(m.Hash)) {
+		return fmt.Errorf("%s", t("config.freeze_modified", in, m.FrozenAt.Format(time.DateTime), m.FrozenBy))
+	}
+	return nil
+}
+
+// canonicalHash returns the keyed hash of the values of the config file in.
+func canonicalHash(in string, key []byte) (string, error) {
+	_, _, doc, err := readDocument(in, "config.freeze_unsupported")
+	if err != nil {
+		return "", err
+	}
+	docKey, err := documentKey(doc)
+	if err != nil {
+		return "", err
+	}
+	_, err = decryptDocument(doc, docKey)
+	wipe(docKey)
+	if err != nil {
+		return "", err
+	}
+	delete(doc, metadataKey)
+	data, err := json.Marshal(doc) // map keys are sorted
+	if err != nil {
+		return "", fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	defer wipe(data)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sconfig-freeze-v1\n"))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
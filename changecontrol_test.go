@@ -0,0 +1,98 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreeze_VerifyFrozen(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "frozen.json")
+	cfg := &TestConfig{DatabasePassword: "approved", APIKey: "key-1"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	manifest, err := Freeze(configPath)
+	if err != nil {
+		ts.Fatalf("Freeze failed: %v", err)
+	}
+	if strings.Contains(string(manifest), "approved") {
+		ts.Fatalf("manifest reveals the password:\n%s", manifest)
+	}
+	if err := VerifyFrozen(configPath, manifest); err != nil {
+		ts.Fatalf("VerifyFrozen of the unchanged file failed: %v", err)
+	}
+	var m FreezeManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		ts.Fatalf("invalid manifest: %v", err)
+	}
+	modified := t("config.freeze_modified", configPath, m.FrozenAt.Format(time.DateTime), m.FrozenBy)
+
+	// Rewriting the same values (new ciphertexts) keeps the hash.
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := Load(&TestConfig{}, configPath, WithVersion(1), WithFrozenManifest(manifest)); err != nil {
+		ts.Fatalf("Load after a rewrite failed: %v", err)
+	}
+
+	// A changed value, also a changed password, is refused.
+	for _, change := range []func(*TestConfig){
+		func(c *TestConfig) { c.APIKey = "key-2" },
+		func(c *TestConfig) { c.APIKey = "key-1"; c.DatabasePassword = "unapproved" },
+	} {
+		change(cfg)
+		if err := UpdateConfig(cfg, configPath); err != nil {
+			ts.Fatalf("UpdateConfig failed: %v", err)
+		}
+		err := Load(&TestConfig{}, configPath, WithVersion(1), WithFrozenManifest(manifest))
+		if err == nil || !contains(err.Error(), modified) {
+			ts.Errorf("expected modified error, got %v", err)
+		}
+	}
+
+	// An edited manifest is refused.
+	tampered := []byte(strings.Replace(string(manifest), `"hash": "`, `"hash": "0`, 1))
+	if err := VerifyFrozen(configPath, tampered); err == nil || !contains(err.Error(), t("config.freeze_manifest_invalid")) {
+		ts.Errorf("expected invalid manifest error, got %v", err)
+	}
+}
+
+func TestFreeze_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "frozen.json")
+	writeSecuredConfig(ts, configPath, "approved", hw)
+	manifest, err := Freeze(configPath)
+	if err != nil {
+		ts.Fatalf("Freeze failed: %v", err)
+	}
+
+	// Rewriting the same value (a new ciphertext) keeps the hash.
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := VerifyFrozen(configPath, manifest); err != nil {
+		ts.Fatalf("VerifyFrozen after a rewrite failed: %v", err)
+	}
+
+	cfg.APIToken = "unapproved"
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := VerifyFrozen(configPath, manifest); err == nil {
+		ts.Error("a changed secure field was not detected")
+	}
+}
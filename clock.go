@@ -0,0 +1,55 @@
+package sconfig
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Clock skew.
+ *
+ * Timestamps written by another machine (the issue and expiry time of a
+ * license) are compared with the local wall clock, which drifts on VMs and
+ * jumps after a suspend or an NTP correction. Those checks allow a skew of
+ * clockSkew in the lenient direction, so a clock a few minutes off does not
+ * invalidate anything. Durations measured within the process (lease
+ * renewals, the validity of a two-person approval) use the monotonic clock
+ * reading of time.Now and are not affected by wall clock changes at all.
+ */
+
+// defaultClockSkew is the tolerance until SetClockSkew is called.
+const defaultClockSkew = 5 * time.Minute
+
+var (
+	clockSkewMu sync.Mutex
+	clockSkew   = defaultClockSkew
+)
+
+// SetClockSkew sets how far the local clock may be off from the clock of the
+// machine that wrote a timestamp (default 5 minutes); 0 checks exactly.
+func SetClockSkew(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	clockSkewMu.Lock()
+	clockSkew = d
+	clockSkewMu.Unlock()
+}
+
+func getClockSkew() time.Duration {
+	clockSkewMu.Lock()
+	defer clockSkewMu.Unlock()
+	return clockSkew
+}
+
+// notYetValid reports whether a foreign timestamp from is later than now,
+// beyond the tolerated skew.
+func notYetValid(from, now time.Time) bool {
+	return from.After(now.Add(getClockSkew()))
+}
+
+// expiredAt reports whether a foreign expiry time until has passed at now,
+// beyond the tolerated skew. A zero until never expires.
+func expiredAt(until, now time.Time) bool {
+	return !until.IsZero() && !now.Before(until.Add(getClockSkew()))
+}
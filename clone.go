@@ -0,0 +1,263 @@
+package sconfig
+
+import (
+	"fmt"
+	"reflect"
+)
+
+/*
+ * Deep copies and merges of config structs.
+ *
+ * A copy shares no pointers, slices or maps with the original, so changing
+ * one never changes the other. Unexported fields are copied by value.
+ *
+ * Clone and Merge are aware of the password fields: by default only the
+ * ciphertext in <Name>SecurePassword travels, the <Name>Password field of the
+ * result holds the secure marker (or stays empty if the password was never
+ * encrypted), and resolved secret references are copied as the reference, not
+ * as the secret. MergePlaintext copies the decrypted values as well.
+ */
+
+// MergePolicy selects how Merge and Clone treat existing values and secrets.
+// The zero value overwrites with every non-zero source field and copies no
+// plaintext secrets.
+type MergePolicy uint8
+
+const (
+	// MergeFillEmpty only sets fields that are zero in the destination.
+	MergeFillEmpty MergePolicy = 1 << iota
+	// MergePlaintext also copies decrypted passwords and resolved secret
+	// references. Only use it for copies that never leave the process.
+	MergePlaintext
+)
+
+// Clone returns a deep copy of config (a pointer to a struct) of the same
+// type. Without MergePlaintext the copy carries only the ciphertexts, see
+// MergePolicy.
+func Clone(config interface{}, policy ...MergePolicy) interface{} {
+	if config == nil {
+		return nil
+	}
+	var p MergePolicy
+	for _, o := range policy {
+		p |= o
+	}
+	c := &copier{}
+	if p&MergePlaintext == 0 {
+		c.refs = resolvedRefAddrs(config)
+	}
+	dst := c.deepCopy(reflect.ValueOf(config))
+	if p&MergePlaintext == 0 {
+		stripSecrets(dst)
+	}
+	return dst.Interface()
+}
+
+// Merge copies the non-zero fields of src into dst. Both must be of the same
+// struct type; dst must be a pointer, src may be a pointer or a value. Nested
+// structs are merged field by field, all other values (slices, maps, pointers)
+// are replaced by a deep copy. A password pair is merged as one value: the
+// ciphertext is copied together with the secure marker, or with the plaintext
+// if policy contains MergePlaintext.
+func Merge(dst, src interface{}, policy MergePolicy) error {
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr || d.IsNil() || d.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s", t("config.merge_type_mismatch", reflect.TypeOf(src), reflect.TypeOf(dst)))
+	}
+	s := reflect.ValueOf(src)
+	if s.Kind() == reflect.Ptr && !s.IsNil() {
+		s = s.Elem()
+	}
+	if !s.IsValid() || s.Type() != d.Elem().Type() {
+		return fmt.Errorf("%s", t("config.merge_type_mismatch", reflect.TypeOf(src), reflect.TypeOf(dst)))
+	}
+	c := &copier{policy: policy}
+	if policy&MergePlaintext == 0 {
+		c.refs = resolvedRefAddrs(src)
+	}
+	c.merge(d.Elem(), s)
+	return nil
+}
+
+// copier carries the state of one Clone or Merge.
+type copier struct {
+	policy MergePolicy
+	refs   map[uintptr]secretBinding // resolved references of the source by field address
+}
+
+// deepCopy returns a deep copy of v.
+func deepCopy(v reflect.Value) reflect.Value {
+	return (&copier{}).deepCopy(v)
+}
+
+func (c *copier) deepCopy(v reflect.Value) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+	dst := reflect.New(v.Type()).Elem()
+	c.copyValue(dst, v)
+	return dst
+}
+
+func (c *copier) copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		c.copyValue(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(c.deepCopy(src.Elem()))
+	case reflect.Struct:
+		dst.Set(src) // also carries the unexported fields
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				c.copyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			c.copyValue(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			c.copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(c.deepCopy(iter.Key()), c.deepCopy(iter.Value()))
+		}
+		dst.Set(m)
+	case reflect.String:
+		dst.SetString(c.stringValue(src))
+	default:
+		dst.Set(src)
+	}
+}
+
+// stringValue returns the value of the string src, or its secret reference if
+// src holds a resolved secret.
+func (c *copier) stringValue(src reflect.Value) string {
+	if c.refs != nil && src.CanAddr() {
+		if b, ok := c.refs[src.UnsafeAddr()]; ok && src.String() == b.resolved {
+			return b.ref
+		}
+	}
+	return src.String()
+}
+
+// merge merges the struct src into the struct dst.
+func (c *copier) merge(dst, src reflect.Value) {
+	info := getStructInfo(src.Type())
+	inPair := make(map[int]bool, 2*len(info.pairs))
+	for _, pair := range info.pairs {
+		inPair[pair.plain], inPair[pair.secure] = true, true
+		c.mergePair(dst, src, pair)
+	}
+	for i := 0; i < src.NumField(); i++ {
+		d, s := dst.Field(i), src.Field(i)
+		if inPair[i] || !d.CanSet() {
+			continue
+		}
+		if s.Kind() == reflect.Struct && allExported(s.Type()) {
+			c.merge(d, s)
+			continue
+		}
+		if s.IsZero() || c.policy&MergeFillEmpty != 0 && !d.IsZero() {
+			continue
+		}
+		v := c.deepCopy(s)
+		if c.policy&MergePlaintext == 0 {
+			stripSecrets(v)
+		}
+		d.Set(v)
+	}
+}
+
+// mergePair merges one <Name>Password/<Name>SecurePassword pair.
+func (c *copier) mergePair(dst, src reflect.Value, pair passwordPair) {
+	if pair.binary {
+		c.mergeBinaryPair(dst, src, pair)
+		return
+	}
+	plain := c.stringValue(src.Field(pair.plain))
+	secure := src.Field(pair.secure).String()
+	dstPlain, dstSecure := dst.Field(pair.plain), dst.Field(pair.secure)
+	if c.policy&MergeFillEmpty != 0 && (dstPlain.String() != "" || dstSecure.String() != "") {
+		return
+	}
+	switch {
+	case c.policy&MergePlaintext != 0:
+		if plain == "" && secure == "" {
+			return
+		}
+		dstPlain.SetString(plain)
+		dstSecure.SetString(secure)
+	case isSecretRef(plain):
+		dstPlain.SetString(plain)
+		dstSecure.SetString("")
+	case secure != "":
+		dstPlain.SetString(secureMarker())
+		dstSecure.SetString(secure)
+	}
+}
+
+// allExported reports whether every field of the struct type t is exported,
+// i.e. whether it can be merged field by field.
+func allExported(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return false
+		}
+	}
+	return true
+}
+
+// stripSecrets drops the plaintext passwords from the struct, pointer or
+// struct slice v.
+func stripSecrets(v reflect.Value) {
+	w := &walker{phases: phaseStrip}
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			w.walk(v.Index(i))
+		}
+		return
+	}
+	w.walk(v)
+}
+
+// mergeBinaryPair merges a pair whose <Name>Password is a []byte, which is
+// empty instead of holding the secure marker.
+func (c *copier) mergeBinaryPair(dst, src reflect.Value, pair passwordPair) {
+	plain, secure := src.Field(pair.plain), src.Field(pair.secure).String()
+	dstPlain, dstSecure := dst.Field(pair.plain), dst.Field(pair.secure)
+	if c.policy&MergeFillEmpty != 0 && (dstPlain.Len() > 0 || dstSecure.String() != "") {
+		return
+	}
+	switch {
+	case c.policy&MergePlaintext != 0:
+		if plain.Len() == 0 && secure == "" {
+			return
+		}
+		dstPlain.Set(c.deepCopy(plain))
+		dstSecure.SetString(secure)
+	case secure != "":
+		dstPlain.SetBytes(nil)
+		dstSecure.SetString(secure)
+	}
+}
@@ -0,0 +1,133 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClone(ts *testing.T) {
+	src := &TestSliceConfig{
+		Version: 3,
+		Servers: []TestConfig{
+			{DatabaseHost: "a", DatabasePassword: "plain-a", DatabaseSecurePassword: "cipher-a"},
+			{DatabaseHost: "b", DatabasePassword: "never-encrypted"},
+		},
+	}
+
+	c := Clone(src).(*TestSliceConfig)
+	if &c.Servers[0] == &src.Servers[0] {
+		ts.Fatal("clone shares the slice with the original")
+	}
+	if c.Servers[0].DatabaseSecurePassword != "cipher-a" {
+		ts.Errorf("ciphertext not copied: %q", c.Servers[0].DatabaseSecurePassword)
+	}
+	if c.Servers[0].DatabasePassword != secureMarker() {
+		ts.Errorf("expected secure marker, got %q", c.Servers[0].DatabasePassword)
+	}
+	if c.Servers[1].DatabasePassword != "" {
+		ts.Errorf("unencrypted plaintext copied: %q", c.Servers[1].DatabasePassword)
+	}
+	if src.Servers[0].DatabasePassword != "plain-a" {
+		ts.Errorf("original modified: %q", src.Servers[0].DatabasePassword)
+	}
+
+	p := Clone(src, MergePlaintext).(*TestSliceConfig)
+	if p.Servers[0].DatabasePassword != "plain-a" || p.Servers[1].DatabasePassword != "never-encrypted" {
+		ts.Errorf("MergePlaintext did not copy plaintext: %+v", p.Servers)
+	}
+}
+
+func TestClone_SecretRefs(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	ts.Setenv("SCONFIG_TEST_CLONE_PASS", "env-secret")
+	configPath := filepath.Join(tempDir, "clone_refs.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "secretref:env:SCONFIG_TEST_CLONE_PASS"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	c := Clone(cfg).(*TestConfig)
+	if c.DatabaseHost != "secretref:env:SCONFIG_TEST_CLONE_PASS" {
+		ts.Errorf("expected the reference in the clone, got %q", c.DatabaseHost)
+	}
+	if cfg.DatabaseHost != "env-secret" {
+		ts.Errorf("original lost its resolved value: %q", cfg.DatabaseHost)
+	}
+	if p := Clone(cfg, MergePlaintext).(*TestConfig); p.DatabaseHost != "env-secret" {
+		ts.Errorf("expected the resolved value with MergePlaintext, got %q", p.DatabaseHost)
+	}
+}
+
+func TestMerge(ts *testing.T) {
+	base := func() *NestedTestConfig {
+		return &NestedTestConfig{
+			Version: 2,
+			MainConfig: TestConfig{
+				DatabaseHost:           "main",
+				DatabasePort:           5432,
+				DatabasePassword:       "main-plain",
+				DatabaseSecurePassword: "main-cipher",
+			},
+		}
+	}
+	tenant := &NestedTestConfig{
+		MainConfig: TestConfig{
+			DatabaseHost:           "tenant",
+			DatabasePassword:       "tenant-plain",
+			DatabaseSecurePassword: "tenant-cipher",
+		},
+		SecondaryConfig: TestConfig{DatabaseName: "reports"},
+	}
+
+	ts.Run("overwrite", func(ts *testing.T) {
+		dst := base()
+		if err := Merge(dst, tenant, 0); err != nil {
+			ts.Fatalf("Merge failed: %v", err)
+		}
+		m := dst.MainConfig
+		if m.DatabaseHost != "tenant" || m.DatabasePort != 5432 || dst.SecondaryConfig.DatabaseName != "reports" {
+			ts.Errorf("unexpected result: %+v", dst)
+		}
+		if m.DatabaseSecurePassword != "tenant-cipher" || m.DatabasePassword != secureMarker() {
+			ts.Errorf("expected ciphertext and marker, got %q / %q", m.DatabasePassword, m.DatabaseSecurePassword)
+		}
+	})
+
+	ts.Run("fill empty", func(ts *testing.T) {
+		dst := base()
+		if err := Merge(dst, *tenant, MergeFillEmpty); err != nil {
+			ts.Fatalf("Merge failed: %v", err)
+		}
+		m := dst.MainConfig
+		if m.DatabaseHost != "main" || dst.SecondaryConfig.DatabaseName != "reports" {
+			ts.Errorf("unexpected result: %+v", dst)
+		}
+		if m.DatabasePassword != "main-plain" || m.DatabaseSecurePassword != "main-cipher" {
+			ts.Errorf("existing password replaced: %q / %q", m.DatabasePassword, m.DatabaseSecurePassword)
+		}
+	})
+
+	ts.Run("plaintext", func(ts *testing.T) {
+		dst := base()
+		if err := Merge(dst, tenant, MergePlaintext); err != nil {
+			ts.Fatalf("Merge failed: %v", err)
+		}
+		if dst.MainConfig.DatabasePassword != "tenant-plain" {
+			ts.Errorf("expected plaintext, got %q", dst.MainConfig.DatabasePassword)
+		}
+	})
+
+	ts.Run("type mismatch", func(ts *testing.T) {
+		err := Merge(base(), &TestConfig{}, 0)
+		if err == nil {
+			ts.Fatal("expected an error")
+		}
+		if err := Merge(*base(), tenant, 0); err == nil {
+			ts.Fatal("expected an error for a non-pointer destination")
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package sconfig
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Cloud instance identity.
+ *
+ * Cloud VMs often present MAC addresses and disk serials that change with a
+ * resize, a stop and start or a live migration, and with them the hardware
+ * ID. The instance ID stays. SetCloudIdentity takes it from the instance
+ * metadata service of EC2 (IMDSv2), GCE or Azure and adds it to the
+ * identifiers, or with CloudIdentityExclusive uses it alone, which keeps the
+ * key for the lifetime of the instance. Once enabled, a metadata service that
+ * does not answer is an error instead of a silently different key. The probe
+ * is named "cloud-metadata" in SetProbeAllowlist.
+ */
+
+// CloudIdentityMode selects how the cloud instance ID enters the hardware ID.
+type CloudIdentityMode int
+
+const (
+	// CloudIdentityOff does not query the metadata service (default).
+	CloudIdentityOff CloudIdentityMode = iota
+	// CloudIdentityAdd adds the instance ID to the hardware identifiers.
+	CloudIdentityAdd
+	// CloudIdentityExclusive uses the instance ID alone, no hardware is
+	// probed.
+	CloudIdentityExclusive
+)
+
+// cloudMetadataTimeout bounds a single request to a metadata service.
+const cloudMetadataTimeout = 2 * time.Second
+
+// cloudEndpoints are the base URLs of the metadata services; tests replace
+// them.
+var cloudEndpoints = struct {
+	aws, gce, azure string
+}{
+	aws:   "http://169.254.169.254",
+	gce:   "http://metadata.google.internal",
+	azure: "http://169.254.169.254",
+}
+
+var (
+	cloudIdentityMu   sync.Mutex
+	cloudIdentityMode CloudIdentityMode
+)
+
+// SetCloudIdentity selects whether the hardware ID uses the instance ID of
+// the cloud VM. Like SetKeyProvider it must be called before the first
+// LoadConfig.
+func SetCloudIdentity(mode CloudIdentityMode) {
+	cloudIdentityMu.Lock()
+	cloudIdentityMode = mode
+	cloudIdentityMu.Unlock()
+	invalidateKey()
+}
+
+func getCloudIdentity() CloudIdentityMode {
+	cloudIdentityMu.Lock()
+	defer cloudIdentityMu.Unlock()
+	return cloudIdentityMode
+}
+
+// cloudInstanceID returns the instance ID of the first metadata service that
+// answers, prefixed with its provider ("aws:i-0abc…").
+func cloudInstanceID() (string, error) {
+	if !allowProbe("cloud-metadata") {
+		return "", errProbeNotAllowed
+	}
+	// Never through a proxy: the services answer on link-local addresses only.
+	client := &http.Client{
+		Timeout:   cloudMetadataTimeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	providers := []struct {
+		name  string
+		query func(*http.Client) (string, error)
+	}{
+		{"aws", awsInstanceID},
+		{"gce", gceInstanceID},
+		{"azure", azureInstanceID},
+	}
+	var failures []string
+	for _, p := range providers {
+		id, err := p.query(client)
+		if err == nil && id != "" {
+			return p.name + ":" + id, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("empty answer")
+		}
+		failures = append(failures, p.name+": "+err.Error())
+	}
+	return "", fmt.Errorf("%s", t("config.cloud_identity_failed", strings.Join(failures, "; ")))
+}
+
+// awsInstanceID queries the EC2 metadata service with an IMDSv2 session token.
+func awsInstanceID(client *http.Client) (string, error) {
+	token, err := metadataRequest(client, http.MethodPut, cloudEndpoints.aws+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return "", err
+	}
+	return metadataRequest(client, http.MethodGet, cloudEndpoints.aws+"/latest/meta-data/instance-id",
+		map[string]string{"X-aws-ec2-metadata-token": token})
+}
+
+// gceInstanceID queries the GCE metadata service.
+func gceInstanceID(client *http.Client) (string, error) {
+	return metadataRequest(client, http.MethodGet, cloudEndpoints.gce+"/computeMetadata/v1/instance/id",
+		map[string]string{"Metadata-Flavor": "Google"})
+}
+
+// azureInstanceID queries the Azure instance metadata service for the VM ID.
+func azureInstanceID(client *http.Client) (string, error) {
+	return metadataRequest(client, http.MethodGet, cloudEndpoints.azure+"/metadata/instance/compute/vmId?api-version=2021-02-01&format=text",
+		map[string]string{"Metadata": "true"})
+}
+
+// metadataRequest sends a request with header to a metadata service and
+// returns the trimmed body of a 200 answer.
+func metadataRequest(client *http.Client, method, rawURL string, header map[string]string) (string, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
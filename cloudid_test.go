@@ -0,0 +1,69 @@
+package sconfig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCloudIdentity(ts *testing.T) {
+	origEndpoints := cloudEndpoints
+	defer func() { cloudEndpoints = origEndpoints }()
+	defer SetCloudIdentity(CloudIdentityOff)
+
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" && r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") != "":
+			w.Write([]byte("session-token"))
+		case r.Method == http.MethodGet && r.URL.Path == "/latest/meta-data/instance-id" && r.Header.Get("X-aws-ec2-metadata-token") == "session-token":
+			w.Write([]byte("i-0123456789abcdef0\n"))
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer aws.Close()
+	gce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/id" || r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("4711"))
+	}))
+	defer gce.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	cloudEndpoints.aws, cloudEndpoints.gce, cloudEndpoints.azure = aws.URL, down.URL, down.URL
+	SetCloudIdentity(CloudIdentityExclusive)
+	id, sources, err := HardwareIdentifiers()
+	if err != nil || len(sources) != 1 || sources[0] != (HardwareIdentifier{Source: "cloud-instance-id", Value: "aws:i-0123456789abcdef0"}) {
+		ts.Fatalf("exclusive identifiers = %v, %v", sources, err)
+	}
+	if again, _, _ := HardwareIdentifiers(); again != id {
+		ts.Errorf("hardware ID changed from 0x%x to 0x%x", id, again)
+	}
+
+	cloudEndpoints.aws, cloudEndpoints.gce = down.URL, gce.URL
+	if _, sources, err := HardwareIdentifiers(); err != nil || len(sources) != 1 || sources[0].Value != "gce:4711" {
+		ts.Errorf("GCE identifiers = %v, %v", sources, err)
+	}
+
+	// With the hardware identifiers the instance ID is one of several.
+	SetCloudIdentity(CloudIdentityAdd)
+	if _, sources, err := HardwareIdentifiers(); err == nil && (len(sources) < 1 || sources[0].Source != "cloud-instance-id") {
+		ts.Errorf("added identifiers = %v", sources)
+	}
+
+	// No metadata service is an error, not a different key.
+	cloudEndpoints.gce = down.URL
+	SetCloudIdentity(CloudIdentityExclusive)
+	if _, _, err := HardwareIdentifiers(); err == nil || !contains(err.Error(), "aws: 404 Not Found") {
+		ts.Errorf("without metadata service: %v", err)
+	}
+
+	SetProbeAllowlist("/etc/machine-id")
+	defer SetProbeAllowlist()
+	if _, _, err := HardwareIdentifiers(); err == nil || !contains(err.Error(), "cloud-metadata") {
+		ts.Errorf("cloud probe outside the allowlist: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// runCompletion prints the completion script for the shell in args.
+func runCompletion(inv *invocation, args []string) int {
+	if len(args) != 1 {
+		return inv.usage("usage: sconfig completion bash|zsh|fish")
+	}
+	var script strings.Builder
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(&script)
+	case "zsh":
+		writeZshCompletion(&script)
+	case "fish":
+		writeFishCompletion(&script)
+	default:
+		return inv.usage(fmt.Sprintf("sconfig: unknown shell %q (bash, zsh or fish)", args[0]))
+	}
+	inv.printf("%s", script.String())
+	inv.result = map[string]string{"shell": args[0], "script": script.String()}
+	return exitOK
+}
+
+// commandValues returns the fixed argument values of c, e.g. the shells of
+// completion, taken from its "a|b|c" synopsis.
+func commandValues(c command) []string {
+	if c.args == "" || strings.ContainsAny(c.args, "<[ ") || !strings.Contains(c.args, "|") {
+		return nil
+	}
+	return strings.Split(c.args, "|")
+}
+
+func writeBashCompletion(w io.Writer) {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(w, `# bash completion for sconfig, generated by "sconfig completion bash"
+_sconfig() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd= i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		if [[ ${COMP_WORDS[i]} != -* ]]; then
+			cmd=${COMP_WORDS[i]}
+			break
+		fi
+	done
+	if [ -z "$cmd" ]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	case $cmd in
+`, strings.Join(append([]string{"--json"}, names...), " "))
+	for _, c := range commands {
+		var words []string
+		words = append(words, c.flags...)
+		words = append(words, commandValues(c)...)
+		var parts []string
+		if len(words) > 0 {
+			parts = append(parts, fmt.Sprintf(`$(compgen -W %q -- "$cur")`, strings.Join(words, " ")))
+		}
+		if c.files {
+			parts = append(parts, `$(compgen -f -- "$cur")`)
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(w, "\t%s) COMPREPLY=(%s) ;;\n", c.name, strings.Join(parts, " "))
+		}
+	}
+	fmt.Fprint(w, `	esac
+}
+complete -o filenames -F _sconfig sconfig
+`)
+}
+
+func writeZshCompletion(w io.Writer) {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	colon := strings.NewReplacer(":", `\:`).Replace
+	fmt.Fprint(w, `#compdef sconfig
+# zsh completion for sconfig, generated by "sconfig completion zsh"
+_sconfig() {
+	local -a commands
+	commands=(
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t\t%s\n", quote(colon(c.name)+":"+colon(c.summary)))
+	}
+	fmt.Fprint(w, `	)
+	local -a args
+	args=(${words[2,CURRENT-1]:#-*})
+	if (( $#args == 0 )); then
+		_describe 'command' commands
+		compadd -- --json
+		return
+	fi
+	case $args[1] in
+`)
+	for _, c := range commands {
+		var alts []string
+		if words := append(append([]string(nil), c.flags...), commandValues(c)...); len(words) > 0 {
+			alts = append(alts, quote("words:argument:("+strings.Join(words, " ")+")"))
+		}
+		if c.files {
+			alts = append(alts, quote("files:file:_files"))
+		}
+		if len(alts) > 0 {
+			fmt.Fprintf(w, "\t%s) _alternative %s ;;\n", c.name, strings.Join(alts, " "))
+		}
+	}
+	fmt.Fprint(w, `	esac
+}
+_sconfig "$@"
+`)
+}
+
+func writeFishCompletion(w io.Writer) {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+	}
+	fmt.Fprint(w, "# fish completion for sconfig, generated by \"sconfig completion fish\"\ncomplete -c sconfig -f\n"+
+		"complete -c sconfig -l json -d 'print a JSON result instead of the normal output'\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c sconfig -n __fish_use_subcommand -a %s -d %s\n", c.name, quote(c.summary))
+	}
+	for _, c := range commands {
+		seen := quote("__fish_seen_subcommand_from " + c.name)
+		for _, flag := range c.flags {
+			fmt.Fprintf(w, "complete -c sconfig -n %s -l %s\n", seen, strings.TrimLeft(flag, "-"))
+		}
+		if values := commandValues(c); len(values) > 0 {
+			fmt.Fprintf(w, "complete -c sconfig -n %s -a %s\n", seen, quote(strings.Join(values, " ")))
+		}
+		if c.files {
+			fmt.Fprintf(w, "complete -c sconfig -n %s -F\n", seen)
+		}
+	}
+}
+
+// runMan prints the man page.
+func runMan(inv *invocation, args []string) int {
+	if len(args) != 0 {
+		return inv.usage("usage: sconfig man")
+	}
+	var page strings.Builder
+	writeMan(&page)
+	inv.printf("%s", page.String())
+	inv.result = map[string]string{"man": page.String()}
+	return exitOK
+}
+
+// writeMan writes the man page sconfig(1) in roff format.
+func writeMan(stdout io.Writer) {
+	fmt.Fprint(stdout, `.TH SCONFIG 1 "" "sconfig" "User Commands"
+.SH NAME
+sconfig \- maintenance operations on sconfig config files
+.SH SYNOPSIS
+.B sconfig
+.RB [ \-\-json ]
+.I command
+.RI [ arguments ]
+.SH DESCRIPTION
+sconfig works on the config files of programs that use the sconfig library,
+whose passwords are encrypted with a key bound to the machine.
+.SH COMMANDS
+`)
+	for _, c := range commands {
+		fmt.Fprintf(stdout, ".TP\n.B %s", roffEscape(c.name))
+		if c.args != "" {
+			fmt.Fprintf(stdout, " \\fI%s\\fR", roffEscape(c.args))
+		}
+		fmt.Fprintf(stdout, "\n%s\n", roffEscape(c.doc))
+	}
+	fmt.Fprint(stdout, `.SH ENVIRONMENT
+.TP
+.B SCONFIG_TWO_PERSON_RULE
+Two comma-separated verifiers (see
+.BR approval-hash );
+recover then requires the passphrases of both operators.
+.SH OPTIONS
+.TP
+.B \-\-json
+Print a single JSON object with the fields command, ok, exit_code, error and
+result instead of the normal output (not for edit).
+.SH EXIT STATUS
+0 on success, 1 if the command failed or a check found a difference, 2 on
+usage errors. The exit codes are stable.
+`)
+}
+
+// roffEscape escapes text for a roff line.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setEcho switches the echo of the terminal f on or off and reports whether
+// f is a terminal.
+func setEcho(f *os.File, on bool) bool {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = f
+	return cmd.Run() == nil
+}
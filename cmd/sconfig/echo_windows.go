@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+const enableEchoInput = 0x0004
+
+// setEcho switches the echo of the console f on or off and reports whether
+// f is a console.
+func setEcho(f *os.File, on bool) bool {
+	var mode uint32
+	if r, _, _ := procGetConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return false
+	}
+	if on {
+		mode |= enableEchoInput
+	} else {
+		mode &^= enableEchoInput
+	}
+	r, _, _ := procSetConsoleMode.Call(f.Fd(), uintptr(mode))
+	return r != 0
+}
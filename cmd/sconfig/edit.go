@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/janmz/sconfig/v2"
+)
+
+// runEdit is the interactive editor: it shows the config tree with numbered
+// values and the passwords masked, edits values by number and saves with the
+// passwords encrypted (see sconfig.OpenDocument).
+func runEdit(inv *invocation, args []string) int {
+	if inv.json {
+		return inv.usage("sconfig: edit is interactive and has no --json output")
+	}
+	if len(args) != 1 {
+		return inv.usage("usage: sconfig edit <config>")
+	}
+	doc, err := sconfig.OpenDocument(args[0])
+	if err != nil {
+		return inv.fail(err)
+	}
+	stdin, stdout, stderr := inv.stdin, inv.stdout, inv.stderr
+	in := bufio.NewReader(stdin)
+	changed := make(map[string]bool)
+	for {
+		fields := doc.Fields()
+		printTree(stdout, doc.Path(), fields, changed)
+		answer, ok := prompt(in, stdout, "number to edit, s to save, q to quit: ")
+		if !ok {
+			if len(changed) > 0 {
+				fmt.Fprintln(stderr, "sconfig: end of input, unsaved changes discarded")
+				return exitFailure
+			}
+			return exitOK
+		}
+		switch answer {
+		case "s":
+			if err := doc.Save(); err != nil {
+				fmt.Fprintf(stderr, "sconfig: %v\n", err)
+				continue
+			}
+			changed = make(map[string]bool)
+			fmt.Fprintf(stdout, "saved %s\n", doc.Path())
+			continue
+		case "q":
+			if len(changed) == 0 {
+				return exitOK
+			}
+			if answer, _ := prompt(in, stdout, "discard unsaved changes? [y/N] "); strings.EqualFold(answer, "y") {
+				return exitOK
+			}
+			continue
+		case "":
+			continue
+		}
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 1 || n > len(fields) {
+			fmt.Fprintf(stdout, "no value %q\n", answer)
+			continue
+		}
+		field := fields[n-1]
+		value, ok := readValue(in, stdin, stdout, field)
+		if !ok {
+			continue
+		}
+		if err := doc.Set(field.Path, value); err != nil {
+			fmt.Fprintf(stdout, "%v\n", err)
+			continue
+		}
+		changed[field.Path] = true
+	}
+}
+
+// printTree prints fields as a tree, numbered from 1. Changed values are
+// marked with "*".
+func printTree(w io.Writer, path string, fields []sconfig.DocumentField, changed map[string]bool) {
+	fmt.Fprintf(w, "\n%s\n", path)
+	var previous []string
+	for i, field := range fields {
+		parts := strings.Split(field.Path, ".")
+		parents := parts[:len(parts)-1]
+		common := 0
+		for common < len(parents) && common < len(previous) && parents[common] == previous[common] {
+			common++
+		}
+		for depth := common; depth < len(parents); depth++ {
+			fmt.Fprintf(w, "      %s%s\n", strings.Repeat("  ", depth), parents[depth])
+		}
+		previous = parents
+		value := field.Value
+		if field.Secret {
+			value = "******"
+		}
+		mark := " "
+		if changed[field.Path] {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%4d%s %s%s: %s\n", i+1, mark, strings.Repeat("  ", len(parents)), parts[len(parts)-1], value)
+	}
+}
+
+// readValue asks for the new value of field. An empty answer keeps the
+// current value. Passwords are read without echo and entered twice.
+func readValue(in *bufio.Reader, stdin io.Reader, stdout io.Writer, field sconfig.DocumentField) (string, bool) {
+	if !field.Secret {
+		value, ok := prompt(in, stdout, fmt.Sprintf("%s [%s]: ", field.Path, field.Value))
+		return value, ok && value != ""
+	}
+	if f, ok := stdin.(*os.File); ok && setEcho(f, false) {
+		defer func() {
+			setEcho(f, true)
+			fmt.Fprintln(stdout)
+		}()
+	}
+	value, ok := prompt(in, stdout, fmt.Sprintf("new password for %s (empty keeps it): ", field.Path))
+	if !ok || value == "" {
+		return "", false
+	}
+	again, _ := prompt(in, stdout, "\nrepeat: ")
+	if again != value {
+		fmt.Fprintln(stdout, "\npasswords do not match, not changed")
+		return "", false
+	}
+	return value, true
+}
+
+// prompt writes text and reads one line; ok is false at the end of input.
+func prompt(in *bufio.Reader, stdout io.Writer, text string) (string, bool) {
+	fmt.Fprint(stdout, text)
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
@@ -0,0 +1,1049 @@
+// Command sconfig provides maintenance operations on sconfig config files.
+//
+// Usage:
+//
+//	sconfig [--json] convert <input> <output>
+//	sconfig [--json] recover --confirm-plaintext <config>
+//	sconfig [--json] encrypt <config>
+//	sconfig [--json] decrypt --confirm-plaintext <config>
+//	sconfig [--json] rekey [--old-key-file <file> | --old-hw-id <id> | --old-hw-record <file>] [--new-key-file <file> | --new-hw] <config>...
+//	sconfig [--json] rotate [--old-key-file <file> | --old-hw-id <id> | --old-hw-record <file>] [--new-key-file <file> | --new-hw] <config>...
+//	sconfig [--json] approval-hash
+//	sconfig [--json] hardware-id [--record <file> | --compare <file>]
+//	sconfig [--json] license-fingerprint
+//	sconfig [--json] freeze <config> <manifest>
+//	sconfig [--json] verify-frozen <config> <manifest>
+//	sconfig [--json] inspect <config>
+//	sconfig [--json] validate <config>
+//	sconfig [--json] diff <config-a> <config-b>
+//	sconfig [--json] transfer [--cert <file> --key <file> --ca <file>] --receive <addr> <dir> | --to <host:port> <config>...
+//	sconfig [--json] agent --socket <path> [--allow-uid <uid>]... [--allow-gid <gid>]... [--rules <file>] <config>...
+//	sconfig edit <config>
+//	sconfig [--json] interop vectors | results | verify <results>
+//	sconfig [--json] completion bash|zsh|fish
+//	sconfig [--json] man
+//
+// convert re-serializes a config file between JSON, YAML and TOML. The output
+// format follows the extension of <output>. Encrypted passwords are copied
+// unchanged, so no hardware key is needed and the converted file loads on the
+// same machine exactly like the original.
+//
+// recover writes a copy of <config> with all passwords decrypted, for disaster
+// recovery (see sconfig.DumpForRecovery). It must run on the machine the key
+// is bound to and refuses to run without --confirm-plaintext. If the
+// environment variable SCONFIG_TWO_PERSON_RULE holds two comma-separated
+// verifiers, recover reads the passphrases of both operators from the first
+// two lines of stdin (see sconfig.SetTwoPersonRule).
+//
+// encrypt encrypts the plaintext passwords of <config> in place with the key
+// of this machine, e.g. of a config written by hand, so no Go program has to
+// load it first. Every "…password" key holding a password is encrypted; a
+// missing "…secure_password" key is added next to it. decrypt prints <config>
+// with all passwords decrypted to stdout instead of writing a copy, with the
+// same confirmation, two-person rule and audit lines as recover (see
+// sconfig.WriteRecovery).
+//
+// rekey re-encrypts the passwords of the <config> files from an old key to a
+// new one (see sconfig.RekeyFile), e.g. after a VM was moved and its hardware
+// ID changed. The old key is a key file (--old-key-file), the key of the
+// hardware ID of the old machine (--old-hw-id, as printed by hardware-id), or
+// that of a hardware-id --record file (--old-hw-record); without one it is
+// the hardware key of this machine. The new key is the hardware key of this
+// machine (--new-hw, the default) or a key file (--new-key-file), which is
+// created if it does not exist. A file whose passwords the old key does not
+// decrypt is left unchanged. rotate is rekey under the name key rotation
+// scripts use, with the same flags, exit codes and JSON output.
+//
+// approval-hash reads a passphrase from the first line of stdin and prints its
+// verifier for the two-person rule.
+//
+// hardware-id prints the hardware ID and the identifiers it is computed from.
+// With --record it saves them to <file> (mode 0600, the values are device
+// serials); with --compare it reports which identifiers changed since the
+// recording and exits with 1 if the hardware ID, and so the key, changed. Run
+// --compare after reboots, updates and network changes before binding
+// secrets to the hardware key.
+//
+// license-fingerprint prints the machine fingerprint a license is issued for
+// (see sconfig.MachineFingerprint); customers send it to the vendor.
+//
+// freeze writes the manifest of the approved state of <config> to <manifest>
+// (see sconfig.Freeze); verify-frozen checks <config> against it and exits
+// with 1 if the config was modified since.
+//
+// inspect prints the values of <config> with the passwords masked, each
+// marked as encrypted or as plaintext in the file; validate
+// checks that <config> can be read and all its passwords decrypted with the
+// key of this machine, and exits with 1 if not.
+//
+// diff compares two configs value by value (see sconfig.Compare), e.g. stage
+// and production, and exits with 1 if they differ. Passwords are compared
+// decrypted if the key of this machine decrypts them, by ciphertext
+// otherwise, and are never printed: a changed password is reported as
+// changed, one that cannot be compared without the key as unknown.
+//
+// transfer moves configs to a new machine over the network (see
+// sconfig.ReceiveTransfer). On the new machine, transfer --receive listens on
+// <addr> (e.g. :7420) and prints a pairing code valid for 10 minutes to
+// stderr; on the old machine, transfer --to reads that code from the first
+// line of stdin and sends the configs. The passwords are decrypted in memory
+// only and written encrypted with the key of the new machine into <dir>. The
+// receiver accepts one connection; a wrong code aborts the transfer. With
+// --cert, --key and --ca (PEM files) the connection is mutual TLS: both sides
+// present a certificate signed by the CA.
+//
+// agent serves the decrypted <config> files to sibling processes on the unix
+// socket <path> (see sconfig.NewAgent), which read them with
+// sconfig.LoadFromAgent, so that only the agent holds the key. Processes of
+// the same user are served, and those of the users and groups given with
+// --allow-uid and --allow-gid; the agent runs until it is interrupted. With
+// --rules, a JSON file mapping config paths to lists of sconfig.AgentRule,
+// e.g. {"/etc/app/app.json": [{"uids": [34], "fields": ["backup"]}]}, those
+// files are served only to the peers of their rules and only with the fields
+// granted to them.
+//
+// edit is an interactive editor for servers without the config struct of the
+// application (see sconfig.OpenDocument). It shows the config tree with
+// numbered values and the passwords masked; entering a number edits the value
+// (numbers and booleans keep their type, passwords are read without echo and
+// entered twice), s saves with the passwords encrypted and q quits. It must
+// run on the machine the key is bound to.
+//
+// interop prints the interop test vectors (see sconfig.InteropVectors), or
+// the results of this implementation for them; verify checks the result file
+// of another implementation, e.g. the PHP variant, and exits with 1 if it
+// failed a vector.
+//
+// completion prints the completion script for bash, zsh or fish, and man the
+// man page sconfig(1) in roff format; both are generated from the command
+// table, like the usage.
+//
+// If the environment variable SCONFIG_MACHINE_ID_FILE names a machine ID
+// file, the hardware ID of all commands comes from it (see
+// sconfig.SetMachineIDFile), as in the applications that use it.
+//
+// SCONFIG_CLOUD_IDENTITY set to "add" or "exclusive" does the same for the
+// instance ID of a cloud VM (see sconfig.SetCloudIdentity), and
+// SCONFIG_MAC_PLATFORM_IDS=1 adds the serial number and hardware UUID of a
+// Mac (see sconfig.SetMacPlatformIdentifiers).
+//
+// The exit codes are stable: 0 on success, 1 if the command failed or a
+// check found a difference, 2 on usage errors. With --json, anywhere on the
+// command line, a command prints a single JSON object to stdout instead of
+// its normal output:
+//
+//	{"command": "validate", "ok": false, "exit_code": 1, "error": "...", "result": {...}}
+//
+// result holds the data of the command and is present on success and for
+// failed checks; its fields are only ever added to. edit is interactive and
+// has no JSON output.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/janmz/sconfig/v2"
+)
+
+// Exit codes. They are part of the command line contract and do not change.
+const (
+	exitOK      = 0 // success
+	exitFailure = 1 // the command failed, or a check found a difference
+	exitUsage   = 2 // invalid command line
+)
+
+// command is a subcommand of sconfig. The usage, the shell completions and
+// the man page are generated from the commands table.
+type command struct {
+	name    string
+	args    string   // argument synopsis
+	summary string   // one line for the usage
+	doc     string   // description for the man page
+	flags   []string // flags, for completion
+	files   bool     // takes file arguments, for completion
+	run     func(inv *invocation, args []string) int
+}
+
+// commands is set in init: help, completion and man refer to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "convert", args: "<input> <output>", files: true, run: runConvert,
+			summary: "convert a config file between JSON, YAML and TOML",
+			doc: "Re-serializes a config file between JSON, YAML and TOML. The output format follows the extension of <output>. " +
+				"Encrypted passwords are copied unchanged, so no hardware key is needed."},
+		{name: "recover", args: "--confirm-plaintext <config>", flags: []string{"--confirm-plaintext"}, files: true, run: runRecover,
+			summary: "write a plaintext copy of the passwords for disaster recovery",
+			doc: "Writes a copy of <config> with all passwords decrypted. It must run on the machine the key is bound to. " +
+				"If SCONFIG_TWO_PERSON_RULE holds two comma-separated verifiers, the passphrases of both operators are read from the first two lines of stdin."},
+		{name: "encrypt", args: "<config>", files: true, run: runEncrypt,
+			summary: "encrypt the plaintext passwords of a config in place",
+			doc: "Encrypts the plaintext passwords of <config> in place with the key of this machine. " +
+				"Every \"...password\" key holding a password is encrypted; a missing \"...secure_password\" key is added next to it."},
+		{name: "decrypt", args: "--confirm-plaintext <config>", flags: []string{"--confirm-plaintext"}, files: true, run: runDecrypt,
+			summary: "print a config with the passwords decrypted",
+			doc: "Prints <config> with all passwords decrypted to stdout. It must run on the machine the key is bound to. " +
+				"The confirmation and the two-person rule are those of recover."},
+		{name: "rekey", args: "[--old-key-file <file> | --old-hw-id <id> | --old-hw-record <file>] [--new-key-file <file> | --new-hw] <config>...",
+			flags: []string{"--old-key-file", "--old-hw-id", "--old-hw-record", "--new-key-file", "--new-hw"}, files: true, run: runRekey("rekey"),
+			summary: "re-encrypt configs from an old key to a new one",
+			doc: "Re-encrypts the passwords of the configs from the old key to the new one, e.g. after a VM was moved. " +
+				"The old key is a key file, the hardware ID of the old machine or a hardware-id --record file, by default the hardware key of this machine. " +
+				"The new key is the hardware key of this machine (--new-hw, the default) or a key file, created if missing. " +
+				"A file the old key does not decrypt is left unchanged."},
+		{name: "rotate", args: "[--old-key-file <file> | --old-hw-id <id> | --old-hw-record <file>] [--new-key-file <file> | --new-hw] <config>...",
+			flags: []string{"--old-key-file", "--old-hw-id", "--old-hw-record", "--new-key-file", "--new-hw"}, files: true, run: runRekey("rotate"),
+			summary: "rotate the key of configs, same as rekey",
+			doc: "Same as rekey, with the same flags, exit codes and JSON output, e.g. sconfig rotate --new-key-file new.key config.json " +
+				"to move to a fresh key file."},
+		{name: "approval-hash", run: runApprovalHash,
+			summary: "print the two-person rule verifier of the passphrase on stdin",
+			doc:     "Reads a passphrase from the first line of stdin and prints its verifier for the two-person rule."},
+		{name: "hardware-id", args: "[--record <file> | --compare <file>]", flags: []string{"--record", "--compare"}, files: true, run: runHardwareID,
+			summary: "print, record or compare the hardware identifiers",
+			doc: "Prints the hardware ID and the identifiers it is computed from. --record saves them to <file> (mode 0600); " +
+				"--compare reports which identifiers changed since the recording and exits with 1 if the hardware ID changed."},
+		{name: "license-fingerprint", run: runLicenseFingerprint,
+			summary: "print the machine fingerprint for license requests",
+			doc:     "Prints the machine fingerprint a license is issued for."},
+		{name: "freeze", args: "<config> <manifest>", files: true, run: runFreeze("freeze"),
+			summary: "record the approved state of a config",
+			doc:     "Writes the manifest of the approved state of <config> to <manifest>."},
+		{name: "verify-frozen", args: "<config> <manifest>", files: true, run: runFreeze("verify-frozen"),
+			summary: "check a config against its recorded state",
+			doc:     "Checks <config> against <manifest> and exits with 1 if the config was modified since."},
+		{name: "inspect", args: "<config>", files: true, run: runInspect,
+			summary: "print the values of a config, passwords masked",
+			doc: "Prints the values of <config> with the passwords masked, each marked as encrypted or as plaintext in the file. " +
+				"It must run on the machine the key is bound to."},
+		{name: "validate", args: "<config>", files: true, run: runValidate,
+			summary: "check that a config can be read and decrypted",
+			doc:     "Checks that <config> can be read and all its passwords decrypted with the key of this machine, and exits with 1 if not."},
+		{name: "diff", args: "<config-a> <config-b>", files: true, run: runDiff,
+			summary: "compare two configs, passwords never printed",
+			doc: "Compares <config-a> and <config-b> value by value and exits with 1 if they differ. " +
+				"Passwords are compared decrypted with the key of this machine if possible, by ciphertext otherwise, and never printed."},
+		{name: "transfer", args: "[--cert <file> --key <file> --ca <file>] --receive <addr> <dir> | --to <host:port> <config>...",
+			flags: []string{"--receive", "--to", "--cert", "--key", "--ca"}, files: true, run: runTransfer,
+			summary: "move configs to a new machine over the network",
+			doc: "On the new machine, --receive listens on <addr> and prints a pairing code valid for 10 minutes to stderr. " +
+				"On the old machine, --to reads the code from the first line of stdin and sends the configs. " +
+				"The passwords are re-encrypted with the key of the new machine and never written in plaintext. " +
+				"--cert, --key and --ca (PEM files) make the connection mutual TLS."},
+		{name: "agent", args: "--socket <path> [--allow-uid <uid>]... [--allow-gid <gid>]... [--rules <file>] <config>...",
+			flags: []string{"--socket", "--allow-uid", "--allow-gid", "--rules"}, files: true, run: runAgent,
+			summary: "serve decrypted configs to local processes",
+			doc: "Serves the decrypted configs on the unix socket <path> to processes of the same user and of the users and groups given with --allow-uid and --allow-gid, " +
+				"which read them with sconfig.LoadFromAgent. It runs until interrupted. " +
+				"--rules names a JSON file mapping config paths to lists of rules (uids, gids, executables, fields) that restrict those files to some peers and fields."},
+		{name: "edit", args: "<config>", files: true, run: runEdit,
+			summary: "edit a config interactively, passwords masked and encrypted",
+			doc: "Shows the config tree with numbered values and the passwords masked. A number edits the value, s saves with the passwords encrypted, q quits. " +
+				"Passwords are read without echo and entered twice."},
+		{name: "interop", args: "vectors | results | verify <results>", files: true, run: runInterop,
+			summary: "print the interop test vectors or check a port against them",
+			doc: "vectors prints the interop test vectors, results the results of this implementation for them. " +
+				"verify checks the result file of another implementation against the vectors and exits with 1 if a vector failed."},
+		{name: "completion", args: "bash|zsh|fish", run: runCompletion,
+			summary: "print the shell completion script",
+			doc:     "Prints the completion script for the shell, e.g. sconfig completion bash > /etc/bash_completion.d/sconfig."},
+		{name: "man", run: runMan,
+			summary: "print the man page",
+			doc:     "Prints this man page in roff format, e.g. sconfig man > /usr/local/share/man/man1/sconfig.1."},
+	}
+}
+
+// usage returns the usage text.
+func usage() string {
+	var b strings.Builder
+	b.WriteString("usage: sconfig [--json] <command> [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		synopsis := strings.TrimSpace(c.name + " " + c.args)
+		if len(synopsis) <= 26 {
+			fmt.Fprintf(&b, "  %-26s %s\n", synopsis, c.summary)
+		} else {
+			fmt.Fprintf(&b, "  %s\n  %-26s %s\n", synopsis, "", c.summary)
+		}
+	}
+	b.WriteString("\nexit codes: 0 success, 1 failure or difference found, 2 usage error\n")
+	return b.String()
+}
+
+// invocation is one run of a command. With --json the normal output is
+// suppressed and run prints the result as a single JSON object instead.
+type invocation struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	json           bool
+	result         interface{} // the data of the command, for --json
+	err            string      // the error, for --json
+}
+
+// jsonResult is the output of a command with --json.
+type jsonResult struct {
+	Command  string      `json:"command"`
+	OK       bool        `json:"ok"`
+	ExitCode int         `json:"exit_code"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// printf writes normal output to stdout; it is suppressed with --json.
+func (inv *invocation) printf(format string, args ...interface{}) {
+	if !inv.json {
+		fmt.Fprintf(inv.stdout, format, args...)
+	}
+}
+
+// notef writes a note for the operator to stderr; it is suppressed with
+// --json.
+func (inv *invocation) notef(format string, args ...interface{}) {
+	if !inv.json {
+		fmt.Fprintf(inv.stderr, format, args...)
+	}
+}
+
+// fail reports err and returns exitFailure.
+func (inv *invocation) fail(err error) int {
+	inv.err = err.Error()
+	inv.notef("sconfig: %v\n", err)
+	return exitFailure
+}
+
+// usage reports a usage error and returns exitUsage.
+func (inv *invocation) usage(text string) int {
+	inv.err = strings.TrimPrefix(text, "sconfig: ")
+	inv.notef("%s\n", text)
+	return exitUsage
+}
+
+// flags returns a flag set for the command name that reports parse errors
+// like usage.
+func (inv *invocation) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(inv.stderr)
+	if inv.json {
+		fs.SetOutput(io.Discard)
+	}
+	return fs
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	inv := &invocation{stdin: stdin, stdout: stdout, stderr: stderr}
+	if path := os.Getenv("SCONFIG_MACHINE_ID_FILE"); path != "" {
+		sconfig.SetMachineIDFile(path, sconfig.MachineIDHardware)
+	}
+	switch os.Getenv("SCONFIG_CLOUD_IDENTITY") {
+	case "add":
+		sconfig.SetCloudIdentity(sconfig.CloudIdentityAdd)
+	case "exclusive":
+		sconfig.SetCloudIdentity(sconfig.CloudIdentityExclusive)
+	}
+	if os.Getenv("SCONFIG_MAC_PLATFORM_IDS") == "1" {
+		sconfig.SetMacPlatformIdentifiers(true)
+	}
+	var rest []string
+	for _, arg := range args {
+		if arg == "--json" || arg == "-json" {
+			inv.json = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	name := ""
+	if len(rest) > 0 {
+		name = rest[0]
+	}
+	code := dispatch(inv, rest)
+	if inv.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "\t")
+		enc.Encode(jsonResult{Command: name, OK: code == exitOK, ExitCode: code, Error: inv.err, Result: inv.result})
+	}
+	return code
+}
+
+func dispatch(inv *invocation, args []string) int {
+	if len(args) == 0 {
+		inv.notef("%s", usage())
+		inv.err = "no command"
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		inv.notef("%s", usage())
+		type commandInfo struct {
+			Name    string `json:"name"`
+			Args    string `json:"args,omitempty"`
+			Summary string `json:"summary"`
+		}
+		var infos []commandInfo
+		for _, c := range commands {
+			infos = append(infos, commandInfo{c.name, c.args, c.summary})
+		}
+		inv.result = map[string]interface{}{"commands": infos}
+		return exitOK
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(inv, args[1:])
+		}
+	}
+	inv.notef("%s", usage())
+	return inv.usage(fmt.Sprintf("sconfig: unknown command %q", args[0]))
+}
+
+func runConvert(inv *invocation, args []string) int {
+	if len(args) != 2 {
+		return inv.usage("usage: sconfig convert <input> <output>")
+	}
+	if err := sconfig.Convert(args[0], args[1]); err != nil {
+		return inv.fail(err)
+	}
+	inv.result = map[string]string{"input": args[0], "output": args[1]}
+	return exitOK
+}
+
+func runApprovalHash(inv *invocation, args []string) int {
+	lines := readLines(inv.stdin, 1)
+	if len(args) != 0 || len(lines) != 1 {
+		return inv.usage("usage: sconfig approval-hash < passphrase")
+	}
+	verifier, err := sconfig.HashApprovalPassphrase(lines[0])
+	if err != nil {
+		return inv.fail(err)
+	}
+	inv.printf("%s\n", verifier)
+	inv.result = map[string]string{"verifier": verifier}
+	return exitOK
+}
+
+func runLicenseFingerprint(inv *invocation, args []string) int {
+	if len(args) != 0 {
+		return inv.usage("usage: sconfig license-fingerprint")
+	}
+	fingerprint, err := sconfig.MachineFingerprint()
+	if err != nil {
+		return inv.fail(err)
+	}
+	inv.printf("%s\n", fingerprint)
+	inv.result = map[string]string{"fingerprint": fingerprint}
+	return exitOK
+}
+
+func runRecover(inv *invocation, args []string) int {
+	fs := inv.flags("recover")
+	confirm := fs.Bool("confirm-plaintext", false, "confirm that a plaintext copy of the secrets is wanted")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return inv.usage("usage: sconfig recover --confirm-plaintext <config>")
+	}
+	token, code := approvePlaintext(inv, *confirm)
+	if code != exitOK {
+		return code
+	}
+	out, err := sconfig.DumpForRecovery(fs.Arg(0), token)
+	if err != nil {
+		return inv.fail(err)
+	}
+	inv.notef("plaintext copy written to %s - delete it after use\n", out)
+	inv.result = map[string]string{"output": out}
+	return exitOK
+}
+
+func runDecrypt(inv *invocation, args []string) int {
+	fs := inv.flags("decrypt")
+	confirm := fs.Bool("confirm-plaintext", false, "confirm that the secrets are printed in plaintext")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return inv.usage("usage: sconfig decrypt --confirm-plaintext <config>")
+	}
+	token, code := approvePlaintext(inv, *confirm)
+	if code != exitOK {
+		return code
+	}
+	out := inv.stdout
+	var content strings.Builder
+	if inv.json {
+		out = &content
+	}
+	count, err := sconfig.WriteRecovery(out, fs.Arg(0), token)
+	if err != nil {
+		return inv.fail(err)
+	}
+	inv.result = map[string]interface{}{"path": fs.Arg(0), "passwords": count, "content": content.String()}
+	return exitOK
+}
+
+// approvePlaintext returns the token for a plaintext export confirmed on the
+// command line. If SCONFIG_TWO_PERSON_RULE is set, it first reads the
+// passphrases of both operators from stdin and approves the export.
+func approvePlaintext(inv *invocation, confirm bool) (string, int) {
+	if !confirm {
+		return "", exitOK
+	}
+	if rule := os.Getenv("SCONFIG_TWO_PERSON_RULE"); rule != "" {
+		if err := sconfig.SetTwoPersonRule(strings.Split(rule, ",")...); err != nil {
+			return "", inv.fail(err)
+		}
+		lines := readLines(inv.stdin, 2)
+		if len(lines) != 2 {
+			return "", inv.usage("sconfig: two operator passphrases expected on stdin")
+		}
+		if err := sconfig.ApprovePlaintext(lines[0], lines[1]); err != nil {
+			return "", inv.fail(err)
+		}
+	}
+	return sconfig.RecoveryConfirmation, exitOK
+}
+
+func runEncrypt(inv *invocation, args []string) int {
+	if len(args) != 1 {
+		return inv.usage("usage: sconfig encrypt <config>")
+	}
+	doc, err := sconfig.OpenDocument(args[0])
+	if err != nil {
+		return inv.fail(err)
+	}
+	encrypted := []string{}
+	for _, field := range doc.Fields() {
+		if field.Plaintext {
+			encrypted = append(encrypted, field.Path)
+		}
+	}
+	inv.result = map[string]interface{}{"path": doc.Path(), "encrypted": encrypted}
+	if len(encrypted) == 0 {
+		inv.printf("no plaintext passwords in %s\n", doc.Path())
+		return exitOK
+	}
+	if err := doc.Save(); err != nil {
+		return inv.fail(err)
+	}
+	for _, path := range encrypted {
+		inv.printf("encrypted %s\n", path)
+	}
+	inv.printf("%d passwords encrypted in %s\n", len(encrypted), doc.Path())
+	return exitOK
+}
+
+// runRekey returns the command that re-encrypts configs to a new key, named
+// cmd (rekey or rotate).
+func runRekey(cmd string) func(inv *invocation, args []string) int {
+	return func(inv *invocation, args []string) int {
+		return rekey(inv, cmd, args)
+	}
+}
+
+func rekey(inv *invocation, cmd string, args []string) int {
+	synopsis := "usage: sconfig " + cmd + " [--old-key-file <file> | --old-hw-id <id> | --old-hw-record <file>] [--new-key-file <file> | --new-hw] <config>..."
+	fs := inv.flags(cmd)
+	oldKeyFile := fs.String("old-key-file", "", "the old key is the key `file`")
+	oldHardwareID := fs.String("old-hw-id", "", "the old key is the hardware key of `id`")
+	oldRecord := fs.String("old-hw-record", "", "the old key is the hardware key recorded in `file` by hardware-id --record")
+	newKeyFile := fs.String("new-key-file", "", "the new key is the key `file`, created if missing")
+	newHardware := fs.Bool("new-hw", false, "the new key is the hardware key of this machine (default)")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		return inv.usage(synopsis)
+	}
+	olds := 0
+	for _, set := range []bool{*oldKeyFile != "", *oldHardwareID != "", *oldRecord != ""} {
+		if set {
+			olds++
+		}
+	}
+	if olds > 1 || (*newKeyFile != "" && *newHardware) || (olds == 0 && *newKeyFile == "") {
+		return inv.usage(synopsis)
+	}
+
+	var from, to sconfig.KeyProvider
+	switch {
+	case *oldKeyFile != "":
+		if _, err := os.Stat(*oldKeyFile); err != nil {
+			return inv.fail(err) // KeyFileProvider would create it
+		}
+		from = &sconfig.KeyFileProvider{Path: *oldKeyFile}
+	case *oldRecord != "":
+		data, err := os.ReadFile(*oldRecord)
+		if err != nil {
+			return inv.fail(err)
+		}
+		var recorded hardwareRecord
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			return inv.fail(fmt.Errorf("invalid record %s: %v", *oldRecord, err))
+		}
+		*oldHardwareID = recorded.HardwareID
+		fallthrough
+	case *oldHardwareID != "":
+		id, err := strconv.ParseUint(*oldHardwareID, 0, 64)
+		if err != nil {
+			return inv.usage(fmt.Sprintf("sconfig: invalid hardware ID %q", *oldHardwareID))
+		}
+		from = sconfig.HardwareKey{ID: func() (uint64, error) { return id, nil }}
+	}
+	if *newKeyFile != "" {
+		to = &sconfig.KeyFileProvider{Path: *newKeyFile}
+	}
+
+	type rekeyed struct {
+		Path      string `json:"path"`
+		Passwords int    `json:"passwords"`
+	}
+	files := []rekeyed{}
+	inv.result = map[string]interface{}{"files": &files}
+	for _, path := range fs.Args() {
+		count, err := sconfig.RekeyFile(path, from, to)
+		if err != nil {
+			return inv.fail(fmt.Errorf("%s: %v", path, err))
+		}
+		inv.printf("%s: %d passwords re-encrypted\n", path, count)
+		files = append(files, rekeyed{Path: path, Passwords: count})
+	}
+	return exitOK
+}
+
+// readLines returns up to n non-empty lines from r.
+func readLines(r io.Reader, n int) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for len(lines) < n && scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// hardwareRecord is the file written by hardware-id --record.
+type hardwareRecord struct {
+	Recorded    time.Time                    `json:"recorded"`
+	HardwareID  string                       `json:"hardware_id"`
+	Identifiers []sconfig.HardwareIdentifier `json:"identifiers"`
+}
+
+func runHardwareID(inv *invocation, args []string) int {
+	fs := inv.flags("hardware-id")
+	record := fs.String("record", "", "save the identifiers to `file`")
+	compare := fs.String("compare", "", "compare the identifiers with those recorded in `file`")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || (*record != "" && *compare != "") {
+		return inv.usage("usage: sconfig hardware-id [--record <file> | --compare <file>]")
+	}
+	id, identifiers, err := sconfig.HardwareIdentifiers()
+	if err != nil {
+		return inv.fail(err)
+	}
+	current := hardwareRecord{Recorded: time.Now().UTC(), HardwareID: fmt.Sprintf("0x%016x", id), Identifiers: identifiers}
+
+	switch {
+	case *record != "":
+		data, _ := json.MarshalIndent(current, "", "\t")
+		if err := os.WriteFile(*record, append(data, '\n'), 0600); err != nil {
+			return inv.fail(err)
+		}
+		inv.printf("recorded hardware ID %s with %d identifiers in %s\n", current.HardwareID, len(identifiers), *record)
+		inv.result = current
+		return exitOK
+	case *compare != "":
+		data, err := os.ReadFile(*compare)
+		if err != nil {
+			return inv.fail(err)
+		}
+		var recorded hardwareRecord
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			return inv.fail(fmt.Errorf("invalid record %s: %v", *compare, err))
+		}
+		return compareHardware(inv, recorded, current)
+	}
+	inv.printf("hardware ID %s\n", current.HardwareID)
+	for _, ident := range identifiers {
+		inv.printf("  %-24s %s\n", ident.Source, ident.Value)
+	}
+	inv.result = current
+	return exitOK
+}
+
+// hardwareComparison is the result of hardware-id --compare.
+type hardwareComparison struct {
+	Recorded           time.Time          `json:"recorded"`
+	HardwareID         string             `json:"hardware_id"`
+	RecordedHardwareID string             `json:"recorded_hardware_id"`
+	Changed            bool               `json:"changed"`
+	Identifiers        []identifierStatus `json:"identifiers"`
+}
+
+type identifierStatus struct {
+	Source string `json:"source"`
+	Status string `json:"status"` // stable, CHANGED, NEW or MISSING
+}
+
+// compareHardware prints a stability report of current against recorded and
+// fails if the hardware ID changed.
+func compareHardware(inv *invocation, recorded, current hardwareRecord) int {
+	values := func(r hardwareRecord) map[string]string {
+		m := make(map[string]string, len(r.Identifiers))
+		for _, ident := range r.Identifiers {
+			m[ident.Source] += ident.Value + "\n"
+		}
+		return m
+	}
+	before, after := values(recorded), values(current)
+	var sources []string
+	for _, ident := range recorded.Identifiers {
+		sources = append(sources, ident.Source)
+	}
+	for _, ident := range current.Identifiers {
+		if _, ok := before[ident.Source]; !ok {
+			sources = append(sources, ident.Source)
+		}
+	}
+	report := hardwareComparison{Recorded: recorded.Recorded, HardwareID: current.HardwareID, RecordedHardwareID: recorded.HardwareID,
+		Changed: recorded.HardwareID != current.HardwareID}
+	inv.printf("compared with the recording of %s\n", recorded.Recorded.Format(time.RFC3339))
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+		old, hadOld := before[source]
+		cur, hasCur := after[source]
+		status := "stable"
+		switch {
+		case !hasCur:
+			status = "MISSING"
+		case !hadOld:
+			status = "NEW"
+		case old != cur:
+			status = "CHANGED"
+		}
+		inv.printf("  %-8s %s\n", status, source)
+		report.Identifiers = append(report.Identifiers, identifierStatus{Source: source, Status: status})
+	}
+	inv.result = report
+	if report.Changed {
+		inv.printf("hardware ID CHANGED (%s -> %s): configs encrypted with the hardware key cannot be read\n", recorded.HardwareID, current.HardwareID)
+		inv.err = "hardware ID changed"
+		return exitFailure
+	}
+	inv.printf("hardware ID unchanged (%s)\n", current.HardwareID)
+	return exitOK
+}
+
+// runFreeze returns the command that writes (freeze) or checks
+// (verify-frozen) the manifest of a config.
+func runFreeze(cmd string) func(inv *invocation, args []string) int {
+	return func(inv *invocation, args []string) int {
+		if len(args) != 2 {
+			return inv.usage(fmt.Sprintf("usage: sconfig %s <config> <manifest>", cmd))
+		}
+		config, manifest := args[0], args[1]
+		inv.result = map[string]string{"config": config, "manifest": manifest}
+		if cmd == "freeze" {
+			data, err := sconfig.Freeze(config)
+			if err == nil {
+				err = os.WriteFile(manifest, append(data, '\n'), 0644)
+			}
+			if err != nil {
+				return inv.fail(err)
+			}
+			return exitOK
+		}
+		data, err := os.ReadFile(manifest)
+		if err == nil {
+			err = sconfig.VerifyFrozen(config, data)
+		}
+		if err != nil {
+			return inv.fail(err)
+		}
+		inv.printf("ok\n")
+		return exitOK
+	}
+}
+
+func runInspect(inv *invocation, args []string) int {
+	if len(args) != 1 {
+		return inv.usage("usage: sconfig inspect <config>")
+	}
+	doc, err := sconfig.OpenDocument(args[0])
+	if err != nil {
+		return inv.fail(err)
+	}
+	fields := doc.Fields()
+	for _, field := range fields {
+		value := field.Value
+		switch {
+		case field.Plaintext:
+			value = "****** (plaintext - run sconfig encrypt)"
+		case field.Secret:
+			value = "****** (encrypted)"
+		}
+		inv.printf("%s = %s\n", field.Path, value)
+	}
+	inv.result = map[string]interface{}{"path": doc.Path(), "fields": fields}
+	return exitOK
+}
+
+func runValidate(inv *invocation, args []string) int {
+	if len(args) != 1 {
+		return inv.usage("usage: sconfig validate <config>")
+	}
+	doc, err := sconfig.OpenDocument(args[0])
+	if err != nil {
+		inv.result = map[string]interface{}{"path": args[0], "valid": false}
+		return inv.fail(err)
+	}
+	values, passwords := 0, 0
+	for _, field := range doc.Fields() {
+		values++
+		if field.Secret {
+			passwords++
+		}
+	}
+	inv.printf("ok: %s, %d values, %d passwords\n", doc.Path(), values, passwords)
+	inv.result = map[string]interface{}{"path": doc.Path(), "valid": true, "values": values, "passwords": passwords}
+	return exitOK
+}
+
+func runInterop(inv *invocation, args []string) int {
+	switch {
+	case len(args) == 1 && args[0] == "vectors":
+		vectors := sconfig.InteropVectors()
+		inv.printf("%s", vectors)
+		inv.result = json.RawMessage(vectors)
+		return exitOK
+	case len(args) == 1 && args[0] == "results":
+		results, err := sconfig.InteropResults()
+		if err != nil {
+			return inv.fail(err)
+		}
+		inv.printf("%s\n", results)
+		inv.result = json.RawMessage(results)
+		return exitOK
+	case len(args) == 2 && args[0] == "verify":
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return inv.fail(err)
+		}
+		failures, err := sconfig.VerifyInterop(data)
+		if err != nil {
+			return inv.fail(err)
+		}
+		inv.result = map[string]interface{}{"results": args[1], "failures": append([]sconfig.InteropFailure{}, failures...)}
+		for _, f := range failures {
+			inv.printf("FAIL %s\n", f)
+		}
+		if len(failures) > 0 {
+			return inv.fail(fmt.Errorf("%d interop vectors failed", len(failures)))
+		}
+		inv.printf("ok: all interop vectors passed\n")
+		return exitOK
+	}
+	return inv.usage("usage: sconfig interop vectors | results | verify <results>")
+}
+
+// diffMarks are the line prefixes of the kinds of difference.
+var diffMarks = map[sconfig.DiffKind]string{
+	sconfig.DiffAdded:   "+",
+	sconfig.DiffRemoved: "-",
+	sconfig.DiffChanged: "~",
+	sconfig.DiffUnknown: "?",
+}
+
+func runDiff(inv *invocation, args []string) int {
+	if len(args) != 2 {
+		return inv.usage("usage: sconfig diff <config-a> <config-b>")
+	}
+	diffs, err := sconfig.Compare(args[0], args[1])
+	if err != nil {
+		return inv.fail(err)
+	}
+	if diffs == nil {
+		diffs = []sconfig.ConfigDifference{}
+	}
+	inv.result = map[string]interface{}{"a": args[0], "b": args[1], "equal": len(diffs) == 0, "differences": diffs}
+	for _, d := range diffs {
+		switch {
+		case d.Kind == sconfig.DiffUnknown:
+			inv.printf("%s %s (password, cannot be compared without the key)\n", diffMarks[d.Kind], d.Path)
+		case d.Secret:
+			inv.printf("%s %s (password)\n", diffMarks[d.Kind], d.Path)
+		case d.Kind == sconfig.DiffAdded:
+			inv.printf("%s %s = %s\n", diffMarks[d.Kind], d.Path, d.B)
+		case d.Kind == sconfig.DiffRemoved:
+			inv.printf("%s %s = %s\n", diffMarks[d.Kind], d.Path, d.A)
+		default:
+			inv.printf("%s %s: %s -> %s\n", diffMarks[d.Kind], d.Path, d.A, d.B)
+		}
+	}
+	if len(diffs) > 0 {
+		inv.err = fmt.Sprintf("%d differences", len(diffs))
+		return exitFailure
+	}
+	inv.printf("no differences\n")
+	return exitOK
+}
+
+func runTransfer(inv *invocation, args []string) int {
+	const usageText = "usage: sconfig transfer [--cert <file> --key <file> --ca <file>] --receive <addr> <dir> | --to <host:port> <config>..."
+	fs := inv.flags("transfer")
+	receive := fs.String("receive", "", "listen on `addr` and receive configs into the directory")
+	to := fs.String("to", "", "send the configs to the receiver at `host:port`")
+	certFile := fs.String("cert", "", "certificate of this side for mutual TLS (PEM `file`)")
+	keyFile := fs.String("key", "", "private key of the certificate (PEM `file`)")
+	caFile := fs.String("ca", "", "CA certificate the peer's certificate is signed by (PEM `file`)")
+	if err := fs.Parse(args); err != nil || (*receive == "") == (*to == "") {
+		return inv.usage(usageText)
+	}
+	tlsFlags := 0
+	for _, f := range []string{*certFile, *keyFile, *caFile} {
+		if f != "" {
+			tlsFlags++
+		}
+	}
+	switch tlsFlags {
+	case 0:
+	case 3:
+		config, err := mutualTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			return inv.fail(err)
+		}
+		sconfig.SetTransport(sconfig.TLSTransport(config))
+	default:
+		return inv.usage("sconfig: --cert, --key and --ca are needed together")
+	}
+	if *receive != "" {
+		if fs.NArg() != 1 {
+			return inv.usage(usageText)
+		}
+		code, err := sconfig.NewTransferCode()
+		if err != nil {
+			return inv.fail(err)
+		}
+		l, err := sconfig.ListenTransfer(*receive)
+		if err != nil {
+			return inv.fail(err)
+		}
+		defer l.Close()
+		// The operator needs the code, also with --json.
+		fmt.Fprintf(inv.stderr, "listening on %s, pairing code: %s (valid for 10 minutes)\n", l.Addr(), code)
+		written, err := sconfig.ReceiveTransfer(l, code, fs.Arg(0))
+		inv.result = map[string]interface{}{"listen": l.Addr().String(), "written": written}
+		if err != nil {
+			return inv.fail(err)
+		}
+		for _, path := range written {
+			inv.printf("%s\n", path)
+		}
+		return exitOK
+	}
+	if fs.NArg() == 0 {
+		return inv.usage(usageText)
+	}
+	inv.notef("pairing code: ")
+	lines := readLines(inv.stdin, 1)
+	if len(lines) != 1 {
+		return inv.usage("sconfig: pairing code expected on stdin")
+	}
+	if err := sconfig.SendTransfer(*to, lines[0], fs.Args()); err != nil {
+		return inv.fail(err)
+	}
+	inv.printf("sent %d config files to %s\n", fs.NArg(), *to)
+	inv.result = map[string]interface{}{"to": *to, "files": fs.Args()}
+	return exitOK
+}
+
+func runAgent(inv *invocation, args []string) int {
+	const usageText = "usage: sconfig agent --socket <path> [--allow-uid <uid>]... [--allow-gid <gid>]... [--rules <file>] <config>..."
+	fs := inv.flags("agent")
+	socket := fs.String("socket", "", "serve on the unix socket `path`")
+	var uids, gids []int
+	intList := func(list *[]int) func(string) error {
+		return func(s string) error {
+			n, err := strconv.Atoi(s)
+			*list = append(*list, n)
+			return err
+		}
+	}
+	fs.Func("allow-uid", "also serve processes of the user `uid`", intList(&uids))
+	fs.Func("allow-gid", "also serve processes of the group `gid`", intList(&gids))
+	rulesFile := fs.String("rules", "", "restrict configs to the peers and fields of the rules in the JSON `file`")
+	if err := fs.Parse(args); err != nil || *socket == "" || fs.NArg() == 0 {
+		return inv.usage(usageText)
+	}
+	opts := []sconfig.AgentOption{sconfig.WithAgentUIDs(uids...), sconfig.WithAgentGIDs(gids...)}
+	if *rulesFile != "" {
+		data, err := os.ReadFile(*rulesFile)
+		if err != nil {
+			return inv.fail(err)
+		}
+		var rules map[string][]sconfig.AgentRule
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return inv.fail(fmt.Errorf("%s: %w", *rulesFile, err))
+		}
+		for path, list := range rules {
+			for _, rule := range list {
+				opts = append(opts, sconfig.WithAgentRule(path, rule))
+			}
+		}
+	}
+	agent, err := sconfig.NewAgent(fs.Args(), opts...)
+	if err != nil {
+		return inv.fail(err)
+	}
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return inv.fail(err)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		l.Close() // also removes the socket file
+	}()
+	fmt.Fprintf(inv.stderr, "serving %d config files on %s\n", fs.NArg(), *socket)
+	inv.result = map[string]interface{}{"socket": *socket, "files": fs.Args()}
+	if err := agent.Serve(l); err != nil {
+		return inv.fail(err)
+	}
+	return exitOK
+}
+
+// mutualTLSConfig returns the TLS config for transfer with a certificate of
+// this side and the CA both sides' certificates are signed by.
+func mutualTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/janmz/sconfig/v2"
+)
+
+// runCLI runs the command line args with stdin and returns the exit code and
+// the output.
+func runCLI(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr strings.Builder
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// runJSON runs the command line args with --json and decodes the envelope,
+// which must be the only output.
+func runJSON(ts *testing.T, stdin string, args ...string) jsonEnvelope {
+	ts.Helper()
+	code, stdout, stderr := runCLI(stdin, append([]string{"--json"}, args...)...)
+	if stderr != "" {
+		ts.Errorf("%v: output on stderr with --json: %q", args, stderr)
+	}
+	var env jsonEnvelope
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	if err := dec.Decode(&env); err != nil || dec.More() {
+		ts.Fatalf("%v: stdout is not a single JSON object (%v):\n%s", args, err, stdout)
+	}
+	if env.ExitCode != code || env.OK != (code == exitOK) {
+		ts.Errorf("%v: envelope %+v does not match exit code %d", args, env, code)
+	}
+	return env
+}
+
+// jsonEnvelope is jsonResult with the result kept raw.
+type jsonEnvelope struct {
+	Command  string          `json:"command"`
+	OK       bool            `json:"ok"`
+	ExitCode int             `json:"exit_code"`
+	Error    string          `json:"error"`
+	Result   json.RawMessage `json:"result"`
+}
+
+// testRoot returns a temporary directory the library accepts config paths
+// in, like the directory of the executable.
+func testRoot(ts *testing.T) string {
+	root := ts.TempDir()
+	sconfig.SetExecutableRootForTest(root)
+	ts.Cleanup(func() { sconfig.SetExecutableRootForTest("") })
+	return root
+}
+
+func writeConfig(ts *testing.T, dir, name, content string) string {
+	ts.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestRun_Usage(ts *testing.T) {
+	if code, stdout, stderr := runCLI(""); code != exitUsage || stdout != "" || !strings.Contains(stderr, "usage: sconfig") {
+		ts.Errorf("no command: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, _, stderr := runCLI("", "frobnicate"); code != exitUsage || !strings.Contains(stderr, `unknown command "frobnicate"`) {
+		ts.Errorf("unknown command: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runCLI("", "encrypt"); code != exitUsage || !strings.Contains(stderr, "usage: sconfig encrypt") {
+		ts.Errorf("missing argument: exit %d, stderr %q", code, stderr)
+	}
+
+	env := runJSON(ts, "", "frobnicate")
+	if env.Command != "frobnicate" || env.ExitCode != exitUsage || !strings.Contains(env.Error, "unknown command") {
+		ts.Errorf("unknown command with --json: %+v", env)
+	}
+	env = runJSON(ts, "", "help")
+	var help struct {
+		Commands []struct {
+			Name string `json:"name"`
+		} `json:"commands"`
+	}
+	if err := json.Unmarshal(env.Result, &help); err != nil || len(help.Commands) != len(commands) {
+		ts.Errorf("help with --json: %v, %s", err, env.Result)
+	}
+}
+
+func TestRun_EncryptInspectDecrypt(ts *testing.T) {
+	root := testRoot(ts)
+	path := writeConfig(ts, root, "app.json", `{"database_host": "db1", "database_password": "cli-secret"}`)
+
+	code, stdout, _ := runCLI("", "inspect", path)
+	if code != exitOK || !strings.Contains(stdout, "database_password = ****** (plaintext - run sconfig encrypt)") ||
+		!strings.Contains(stdout, "database_host = db1") {
+		ts.Errorf("inspect before encrypt: exit %d:\n%s", code, stdout)
+	}
+
+	code, stdout, _ = runCLI("", "encrypt", path)
+	if code != exitOK || !strings.Contains(stdout, "encrypted database_password") {
+		ts.Errorf("encrypt: exit %d:\n%s", code, stdout)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), "cli-secret") || !strings.Contains(string(raw), `"database_secure_password"`) {
+		ts.Errorf("password not encrypted in place:\n%s", raw)
+	}
+	env := runJSON(ts, "", "encrypt", path)
+	if env.Command != "encrypt" || !env.OK || !strings.Contains(string(env.Result), `"encrypted": []`) {
+		ts.Errorf("second encrypt with --json: %+v, %s", env, env.Result)
+	}
+
+	code, stdout, _ = runCLI("", "inspect", path)
+	if code != exitOK || !strings.Contains(stdout, "database_password = ****** (encrypted)") || strings.Contains(stdout, "cli-secret") {
+		ts.Errorf("inspect after encrypt: exit %d:\n%s", code, stdout)
+	}
+	env = runJSON(ts, "", "inspect", path)
+	var inspected struct {
+		Fields []struct {
+			Path      string `json:"path"`
+			Secret    bool   `json:"secret"`
+			Plaintext bool   `json:"plaintext"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(env.Result, &inspected); err != nil || !env.OK {
+		ts.Fatalf("inspect with --json: %v, %+v", err, env)
+	}
+	secrets := 0
+	for _, f := range inspected.Fields {
+		if f.Secret {
+			secrets++
+			if f.Plaintext || f.Path != "database_password" {
+				ts.Errorf("unexpected secret field %+v", f)
+			}
+		}
+	}
+	if secrets != 1 || strings.Contains(string(env.Result), "cli-secret") {
+		ts.Errorf("inspect with --json: %s", env.Result)
+	}
+
+	// decrypt needs the confirmation.
+	code, stdout, stderr := runCLI("", "decrypt", path)
+	if code != exitFailure || strings.Contains(stdout, "cli-secret") || stderr == "" {
+		ts.Errorf("decrypt without --confirm-plaintext: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	code, stdout, _ = runCLI("", "decrypt", "--confirm-plaintext", path)
+	if code != exitOK || !strings.Contains(stdout, `"database_password": "cli-secret"`) {
+		ts.Errorf("decrypt: exit %d:\n%s", code, stdout)
+	}
+	env = runJSON(ts, "", "decrypt", "--confirm-plaintext", path)
+	var decrypted struct {
+		Passwords int    `json:"passwords"`
+		Content   string `json:"content"`
+	}
+	if err := json.Unmarshal(env.Result, &decrypted); err != nil || decrypted.Passwords != 1 ||
+		!strings.Contains(decrypted.Content, `"database_password": "cli-secret"`) {
+		ts.Errorf("decrypt with --json: %v, %s", err, env.Result)
+	}
+	if code, _, _ := runCLI("", "decrypt", "--confirm-plaintext", filepath.Join(root, "missing.json")); code != exitFailure {
+		ts.Errorf("decrypt of a missing file: exit %d", code)
+	}
+}
+
+func TestRun_Validate(ts *testing.T) {
+	root := testRoot(ts)
+	path := writeConfig(ts, root, "app.json", `{"database_password": "validate-secret", "port": 5432}`)
+	if code, _, _ := runCLI("", "encrypt", path); code != exitOK {
+		ts.Fatalf("encrypt: exit %d", code)
+	}
+	code, stdout, _ := runCLI("", "validate", path)
+	if code != exitOK || !strings.Contains(stdout, "ok: ") || !strings.Contains(stdout, "1 passwords") {
+		ts.Errorf("validate: exit %d:\n%s", code, stdout)
+	}
+	env := runJSON(ts, "", "validate", path)
+	if !strings.Contains(string(env.Result), `"valid": true`) {
+		ts.Errorf("validate with --json: %s", env.Result)
+	}
+
+	broken := writeConfig(ts, root, "broken.json", `{"database_password": `)
+	if code, _, stderr := runCLI("", "validate", broken); code != exitFailure || !strings.Contains(stderr, "sconfig: ") {
+		ts.Errorf("validate of a broken file: exit %d, stderr %q", code, stderr)
+	}
+	env = runJSON(ts, "", "validate", broken)
+	if env.OK || env.Error == "" || !strings.Contains(string(env.Result), `"valid": false`) {
+		ts.Errorf("validate of a broken file with --json: %+v, %s", env, env.Result)
+	}
+}
+
+func TestRun_Rekey(ts *testing.T) {
+	root := testRoot(ts)
+	path := writeConfig(ts, root, "app.json", `{"database_password": "rekey-secret"}`)
+	if code, _, _ := runCLI("", "encrypt", path); code != exitOK {
+		ts.Fatalf("encrypt: exit %d", code)
+	}
+	if code, _, _ := runCLI("", "rekey"); code != exitUsage {
+		ts.Errorf("rekey without configs: exit %d", code)
+	}
+	if code, _, _ := runCLI("", "rekey", "--new-hw", path); code != exitUsage {
+		ts.Errorf("rekey from and to the hardware key: exit %d", code)
+	}
+
+	// Move to a key file, then back to the hardware key.
+	keyFile := filepath.Join(root, "moved.key")
+	code, stdout, _ := runCLI("", "rekey", "--new-key-file", keyFile, path)
+	if code != exitOK || !strings.Contains(stdout, path+": 1 passwords re-encrypted") {
+		ts.Errorf("rekey to a key file: exit %d:\n%s", code, stdout)
+	}
+	if code, _, _ := runCLI("", "validate", path); code != exitFailure {
+		ts.Errorf("validate with the hardware key after rekey: exit %d", code)
+	}
+	env := runJSON(ts, "", "rekey", "--old-key-file", keyFile, "--new-hw", path)
+	var rekeyed struct {
+		Files []struct {
+			Path      string `json:"path"`
+			Passwords int    `json:"passwords"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(env.Result, &rekeyed); err != nil || !env.OK || len(rekeyed.Files) != 1 || rekeyed.Files[0].Passwords != 1 {
+		ts.Errorf("rekey back with --json: %v, %+v, %s", err, env, env.Result)
+	}
+	if code, stdout, _ := runCLI("", "decrypt", "--confirm-plaintext", path); code != exitOK || !strings.Contains(stdout, "rekey-secret") {
+		ts.Errorf("decrypt after rekey back: exit %d:\n%s", code, stdout)
+	}
+	if code, _, _ := runCLI("", "rekey", "--old-key-file", filepath.Join(root, "missing.key"), path); code != exitFailure {
+		ts.Errorf("rekey from a missing key file: exit %d", code)
+	}
+
+	// rotate is rekey under another name.
+	if code, _, stderr := runCLI("", "rotate", "--new-hw", path); code != exitUsage || !strings.Contains(stderr, "usage: sconfig rotate") {
+		ts.Errorf("rotate usage: exit %d, stderr %q", code, stderr)
+	}
+	env = runJSON(ts, "", "rotate", "--new-key-file", keyFile, path)
+	if env.Command != "rotate" || !env.OK || !strings.Contains(string(env.Result), `"passwords": 1`) {
+		ts.Errorf("rotate with --json: %+v, %s", env, env.Result)
+	}
+	if code, stdout, _ := runCLI("", "rotate", "--old-key-file", keyFile, path); code != exitOK || !strings.Contains(stdout, path+": 1 passwords re-encrypted") {
+		ts.Errorf("rotate back: exit %d:\n%s", code, stdout)
+	}
+	if code, _, _ := runCLI("", "validate", path); code != exitOK {
+		ts.Errorf("validate after rotate back: exit %d", code)
+	}
+}
+
+func TestRun_HardwareID(ts *testing.T) {
+	record := filepath.Join(ts.TempDir(), "hardware.json")
+	code, stdout, _ := runCLI("", "hardware-id", "--record", record)
+	if code != exitOK || !strings.Contains(stdout, "recorded hardware ID 0x") {
+		ts.Fatalf("hardware-id --record: exit %d:\n%s", code, stdout)
+	}
+	if info, err := os.Stat(record); err != nil || info.Mode().Perm()&0077 != 0 && filepath.Separator == '/' {
+		ts.Errorf("record: %v, %v", info, err)
+	}
+	code, stdout, _ = runCLI("", "hardware-id", "--compare", record)
+	if code != exitOK || !strings.Contains(stdout, "hardware ID unchanged") {
+		ts.Errorf("hardware-id --compare: exit %d:\n%s", code, stdout)
+	}
+
+	data, _ := os.ReadFile(record)
+	var recorded hardwareRecord
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		ts.Fatalf("record is not JSON: %v", err)
+	}
+	recorded.HardwareID = "0x0000000000000001"
+	data, _ = json.Marshal(recorded)
+	os.WriteFile(record, data, 0600)
+	env := runJSON(ts, "", "hardware-id", "--compare", record)
+	var report hardwareComparison
+	if err := json.Unmarshal(env.Result, &report); err != nil || env.ExitCode != exitFailure || !report.Changed ||
+		env.Error != "hardware ID changed" {
+		ts.Errorf("compare with another hardware ID: %v, %+v, %s", err, env, env.Result)
+	}
+
+	if code, _, _ := runCLI("", "hardware-id", "--record", record, "--compare", record); code != exitUsage {
+		ts.Errorf("--record and --compare together: exit %d", code)
+	}
+}
+
+func TestRun_CompletionAndMan(ts *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		code, stdout, _ := runCLI("", "completion", shell)
+		if code != exitOK {
+			ts.Errorf("completion %s: exit %d", shell, code)
+		}
+		for _, c := range commands {
+			if !strings.Contains(stdout, c.name) {
+				ts.Errorf("completion %s lacks the command %s", shell, c.name)
+			}
+		}
+	}
+	if code, _, _ := runCLI("", "completion", "tcsh"); code != exitUsage {
+		ts.Errorf("completion of an unknown shell: exit %d", code)
+	}
+
+	code, stdout, _ := runCLI("", "man")
+	if code != exitOK || !strings.HasPrefix(stdout, ".TH") {
+		ts.Errorf("man: exit %d:\n%.200s", code, stdout)
+	}
+	for _, c := range commands {
+		if !strings.Contains(stdout, ".B "+roffEscape(c.name)) {
+			ts.Errorf("man page lacks the command %s", c.name)
+		}
+	}
+	env := runJSON(ts, "", "man")
+	if !env.OK || len(env.Result) == 0 {
+		ts.Errorf("man with --json: %+v", env)
+	}
+}
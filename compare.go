@@ -0,0 +1,176 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+/*
+ * Comparing configs.
+ *
+ * Staged rollouts need to verify that two installations run the same config
+ * (or differ only where intended) without revealing the passwords. Compare
+ * diffs two config files structurally, on the document like Freeze: values
+ * by their JSON value, whatever the format, and passwords without ever
+ * returning them. A password is compared decrypted if the key of this
+ * machine decrypts the file; otherwise two identical ciphertexts are equal,
+ * and different ciphertexts of a file that cannot be decrypted give
+ * DiffUnknown, as the same password encrypts differently every time. The
+ * metadata block is not compared. XML configs are not supported.
+ */
+
+// DiffKind says how a value differs between two configs.
+type DiffKind string
+
+const (
+	DiffAdded   DiffKind = "added"   // only in the second config
+	DiffRemoved DiffKind = "removed" // only in the first config
+	DiffChanged DiffKind = "changed" // in both, with different values
+	DiffUnknown DiffKind = "unknown" // a password that cannot be compared without the key
+)
+
+// ConfigDifference is one difference found by Compare.
+type ConfigDifference struct {
+	Path   string   `json:"path"` // see DocumentField.Path
+	Kind   DiffKind `json:"kind"`
+	A      string   `json:"a,omitempty"` // the value in the first config as JSON; "" for a password
+	B      string   `json:"b,omitempty"` // the value in the second config as JSON; "" for a password
+	Secret bool     `json:"secret"`      // a password
+}
+
+// compareLeaf is a value of a flattened document.
+type compareLeaf struct {
+	value  string // JSON value, or the password if known
+	secret bool
+	cipher string // ciphertext of a password that could not be decrypted
+}
+
+// Compare returns the differences between the config files at pathA and
+// pathB, sorted by path; none if they hold the same values.
+func Compare(pathA, pathB string) ([]ConfigDifference, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	a, err := flattenForCompare(pathA)
+	if err != nil {
+		return nil, err
+	}
+	b, err := flattenForCompare(pathB)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(a)+len(b))
+	for path := range a {
+		paths = append(paths, path)
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []ConfigDifference
+	for _, path := range paths {
+		la, inA := a[path]
+		lb, inB := b[path]
+		d := ConfigDifference{Path: path, Secret: la.secret || lb.secret}
+		switch {
+		case !inA:
+			d.Kind = DiffAdded
+		case !inB:
+			d.Kind = DiffRemoved
+		case la.secret != lb.secret:
+			d.Kind = DiffChanged
+		case !la.secret:
+			if la.value == lb.value {
+				continue
+			}
+			d.Kind = DiffChanged
+		case la.cipher == "" && lb.cipher == "":
+			if la.value == lb.value {
+				continue
+			}
+			d.Kind = DiffChanged
+		case la.cipher != "" && la.cipher == lb.cipher:
+			continue
+		default:
+			d.Kind = DiffUnknown
+		}
+		if !la.secret {
+			d.A = la.value
+		}
+		if !lb.secret {
+			d.B = lb.value
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// flattenForCompare reads the config file at path into its values by path,
+// with the passwords decrypted if the current key can. Callers hold stateMu.
+func flattenForCompare(path string) (map[string]compareLeaf, error) {
+	_, _, doc, err := readDocument(path, "config.compare_unsupported")
+	if err != nil {
+		return nil, err
+	}
+	key, err := documentKey(doc)
+	if err == nil {
+		_, err = decryptDocument(doc, key)
+		wipe(key)
+	}
+	if err != nil {
+		// Another machine's file: compare the passwords by ciphertext.
+		if _, _, doc, err = readDocument(path, "config.compare_unsupported"); err != nil {
+			return nil, err
+		}
+	}
+	delete(doc, metadataKey)
+	leaves := make(map[string]compareLeaf)
+	if err := flattenValue(doc, "", leaves); err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+func flattenValue(v interface{}, path string, leaves map[string]compareLeaf) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if plainKey, ok := secretPlainKey(v, name); ok {
+				// The pair is one password, named by its plaintext key.
+				password, _ := v[plainKey].(string)
+				secure, _ := value.(string)
+				leaf := compareLeaf{secret: true, value: password}
+				if secure != "" {
+					leaf = compareLeaf{secret: true, cipher: secure}
+				}
+				leaves[joinDocumentPath(path, plainKey)] = leaf
+				continue
+			}
+			if isPasswordKey(v, name) {
+				continue
+			}
+			if err := flattenValue(value, joinDocumentPath(path, name), leaves); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			if err := flattenValue(elem, fmt.Sprintf("%s[%d]", path, i), leaves); err != nil {
+				return err
+			}
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf(t("config.failed_build_json"), err)
+		}
+		leaves[path] = compareLeaf{value: string(data)}
+	}
+	return nil
+}
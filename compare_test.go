@@ -0,0 +1,109 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	other := func() (uint64, error) { return 9999, nil }
+	pathA := filepath.Join(tempDir, "stage.json")
+	pathB := filepath.Join(tempDir, "prod.yaml")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "same-secret", APIKey: "k1"}, 1, pathA, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	prod := &TestConfig{DatabasePassword: "same-secret", APIKey: "k2"}
+	if err := LoadConfig(prod, 1, pathB, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	prod.DatabasePort = 6543
+	if err := UpdateConfig(prod, pathB); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+
+	// Equal passwords with different ciphertexts are equal.
+	diffs, err := Compare(pathA, pathB)
+	if err != nil {
+		ts.Fatalf("Compare failed: %v", err)
+	}
+	want := []ConfigDifference{
+		{Path: "api_key", Kind: DiffChanged, A: `"k1"`, B: `"k2"`},
+		{Path: "database_port", Kind: DiffChanged, A: "5432", B: "6543"},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		ts.Fatalf("Compare = %+v, want %+v", diffs, want)
+	}
+
+	prod.DatabasePassword = "new-secret"
+	if err := UpdateConfig(prod, pathB); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	diffs, _ = Compare(pathA, pathB)
+	if len(diffs) != 3 || diffs[1] != (ConfigDifference{Path: "database_password", Kind: DiffChanged, Secret: true}) {
+		ts.Fatalf("expected a changed secret, got %+v", diffs)
+	}
+	if out, _ := json.Marshal(diffs); strings.Contains(string(out), "same-secret") || strings.Contains(string(out), "new-secret") {
+		ts.Fatalf("Compare revealed a password: %s", out)
+	}
+
+	// Files of another machine are compared by ciphertext.
+	invalidateKey()
+	foreign := filepath.Join(tempDir, "foreign.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "foreign-secret"}, 1, foreign, false, false, other); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	invalidateKey()
+	if err := LoadConfig(&TestConfig{}, 1, pathA, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(foreign)
+	copied := filepath.Join(tempDir, "foreign-copy.json")
+	os.WriteFile(copied, raw, 0600)
+	if diffs, err := Compare(foreign, copied); err != nil || len(diffs) != 0 {
+		ts.Fatalf("Compare of identical foreign files = %+v, %v", diffs, err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	doc["database_secure_password"], _ = encryptWithKey(bytes.Repeat([]byte{1}, 32), "foreign-secret")
+	raw, _ = json.Marshal(doc)
+	os.WriteFile(copied, raw, 0600)
+	diffs, err = Compare(foreign, copied)
+	if err != nil || len(diffs) != 1 || diffs[0] != (ConfigDifference{Path: "database_password", Kind: DiffUnknown, Secret: true}) {
+		ts.Fatalf("expected an unknown secret, got %+v, %v", diffs, err)
+	}
+}
+
+func TestCompare_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	pathA := filepath.Join(tempDir, "stage.json")
+	pathB := filepath.Join(tempDir, "prod.json")
+	writeSecuredConfig(ts, pathA, "same-token", hw)
+	writeSecuredConfig(ts, pathB, "same-token", hw)
+
+	// Equal values with different ciphertexts are equal.
+	if diffs, err := Compare(pathA, pathB); err != nil || len(diffs) != 0 {
+		ts.Fatalf("Compare = %+v, %v", diffs, err)
+	}
+
+	writeSecuredConfig(ts, filepath.Join(tempDir, "new.json"), "new-token", hw)
+	diffs, err := Compare(pathA, filepath.Join(tempDir, "new.json"))
+	if err != nil {
+		ts.Fatalf("Compare failed: %v", err)
+	}
+	want := []ConfigDifference{{Path: "api_token", Kind: DiffChanged, Secret: true}}
+	if !reflect.DeepEqual(diffs, want) {
+		ts.Fatalf("Compare = %+v, want %+v", diffs, want)
+	}
+}
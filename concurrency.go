@@ -0,0 +1,276 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Optimistic concurrency on write-back.
+ *
+ * A program loads its config, changes a value at runtime and writes the
+ * struct back with UpdateConfig. If another tool (the CLI, a second instance,
+ * an admin's editor) changed the file in between, writing the struct would
+ * silently drop that change: the last writer wins. LoadConfig therefore
+ * remembers the file it read (its SHA-256 and content). Before a rewrite the
+ * file is hashed again under the file lock (filelock.go); if it changed, the
+ * struct is merged with the new file field by field, as a three-way merge
+ * against the remembered content:
+ *
+ *   - fields only the other writer changed take its value,
+ *   - fields only this program changed keep the program's value,
+ *   - fields both changed to different values are a conflict: nothing is
+ *     written and UpdateConfig returns a *ConflictError naming them.
+ *
+ * Passwords are compared by their plaintext, not by the ciphertexts, which
+ * differ on every encryption. The write-back of LoadConfig itself (new
+ * passwords encrypted, Version updated) has nothing to merge: if the file
+ * changed between reading and rewriting it, LoadConfig reads it again and
+ * retries.
+ *
+ * Every sconfig rewrite holds the lock from the check to the rename, so
+ * writers using sconfig never interleave; other writers are detected by the
+ * hash as long as they finish before the lock is taken.
+ */
+
+// maxLoadRetries bounds how often LoadConfig reads the file again when it
+// changed before the write-back.
+const maxLoadRetries = 3
+
+// errFileChanged reports that the config file changed between reading and
+// rewriting it in LoadConfig.
+var errFileChanged = errors.New("config file changed during load")
+
+// ConflictError reports fields of a config file that another writer changed
+// since the struct was loaded, while the program changed them differently.
+type ConflictError struct {
+	Path   string
+	Fields []string // Go field paths, e.g. "Database.Host"
+}
+
+func (e *ConflictError) Error() string {
+	return t("config.write_conflict", e.Path, strings.Join(e.Fields, ", "))
+}
+
+// loadedFile is the config file a struct was last read from or written to.
+type loadedFile struct {
+	path    string
+	sum     [sha256.Size]byte
+	content []byte // the base of the three-way merge
+}
+
+var (
+	loadedFilesMu sync.Mutex
+	loadedFiles   = map[interface{}]*loadedFile{} // config -> file it was loaded from
+)
+
+// recordLoadedFile remembers the current content of the file at path as what
+// config was loaded from. Without a readable file nothing is remembered.
+func recordLoadedFile(config interface{}, path string) {
+	content, err := os.ReadFile(path)
+	loadedFilesMu.Lock()
+	defer loadedFilesMu.Unlock()
+	if err != nil {
+		delete(loadedFiles, config)
+		return
+	}
+	loadedFiles[config] = &loadedFile{path: path, sum: sha256.Sum256(content), content: content}
+}
+
+// forgetLoadedFile drops what config was loaded from.
+func forgetLoadedFile(config interface{}) {
+	loadedFilesMu.Lock()
+	delete(loadedFiles, config)
+	loadedFilesMu.Unlock()
+}
+
+// loadedFileOf returns the file config was loaded from, nil if unknown.
+func loadedFileOf(config interface{}) *loadedFile {
+	loadedFilesMu.Lock()
+	defer loadedFilesMu.Unlock()
+	return loadedFiles[config]
+}
+
+// fileSum returns the SHA-256 of the file at path and whether it exists.
+func fileSum(path string) ([sha256.Size]byte, bool) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, false
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, true
+}
+
+// mergeConcurrentChanges merges the changes another writer made to the file
+// at path since config was loaded from it into config, which is about to be
+// written there. The caller holds the file lock and has put the secret
+// references and the base values of overridden fields back into config.
+func mergeConcurrentChanges(config interface{}, path string) error {
+	state := loadedFileOf(config)
+	if state == nil || state.path != path {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || sha256.Sum256(content) == state.sum {
+		// A deleted file is simply written again.
+		return nil
+	}
+	typ := reflect.TypeOf(config).Elem()
+	base, theirs := reflect.New(typ), reflect.New(typ)
+	name := namespaceOf(config)
+	if err := decodeConfigBytes(path, name, state.content, base.Interface()); err != nil {
+		return err
+	}
+	if err := decodeConfigBytes(path, name, content, theirs.Interface()); err != nil {
+		return err
+	}
+	var m merge
+	m.fields(reflect.ValueOf(config).Elem(), base.Elem(), theirs.Elem(), "")
+	if len(m.conflicts) > 0 {
+		return &ConflictError{Path: path, Fields: m.conflicts}
+	}
+	for _, take := range m.takes {
+		take.field.Set(deepCopy(take.value))
+	}
+	return nil
+}
+
+// decodeConfigBytes decodes content, read from the file at path, into config
+// like LoadConfig does (defaults first, passwords decrypted), from the
+// namespace name if it is not "".
+func decodeConfigBytes(path, name string, content []byte, config interface{}) error {
+	v := reflect.ValueOf(config).Elem()
+	if err := updateDefaultValues(v); err != nil {
+		return fmt.Errorf(t("config.failed_defaulting"), err)
+	}
+	format := formatForRead(path, content)
+	if format == FormatXML {
+		if _, err := decodeXMLStream(bytes.NewReader(content), config); err != nil {
+			return err
+		}
+		return decodePasswords(v)
+	}
+	data, err := toJSON(format, content)
+	if err != nil {
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if name != "" {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf(t("config.failed_parsing"), err)
+		}
+		raw, ok := doc[name]
+		if !ok {
+			return nil
+		}
+		data = raw
+	}
+	if err := decodeConfigStream(bytes.NewReader(data), config); err != nil {
+		return err
+	}
+	if err := decodePasswords(v); err != nil {
+		return fmt.Errorf(t("config.failed_decode_pw"), err)
+	}
+	return nil
+}
+
+// merge is the outcome of a three-way merge: the fields of ours to take from
+// theirs, and the fields both sides changed differently.
+type merge struct {
+	takes     []mergeTake
+	conflicts []string
+}
+
+type mergeTake struct {
+	field reflect.Value // addressable field in ours
+	value reflect.Value // value from theirs
+}
+
+// fields compares the leaf fields of the struct ours, found at path, with
+// base and theirs. Nothing is changed until the merge has no conflicts.
+func (m *merge) fields(ours, base, theirs reflect.Value, path string) {
+	secure := secureFields(ours.Type())
+	for i := 0; i < ours.NumField(); i++ {
+		o, b, th := ours.Field(i), base.Field(i), theirs.Field(i)
+		if !o.CanSet() || secure[i] {
+			continue
+		}
+		name := ours.Type().Field(i).Name
+		if path != "" {
+			name = path + "." + name
+		}
+		if o.Kind() == reflect.Struct && allExported(o.Type()) {
+			m.fields(o, b, th, name)
+			continue
+		}
+		switch {
+		case equalPlain(th, b):
+		case equalPlain(o, b):
+			m.takes = append(m.takes, mergeTake{field: o, value: th})
+		case !equalPlain(o, th):
+			m.conflicts = append(m.conflicts, name)
+		}
+	}
+}
+
+// secureFields returns the indexes of the <Name>SecurePassword fields of the
+// struct type t.
+func secureFields(t reflect.Type) map[int]bool {
+	pairs := getStructInfo(t).pairs
+	if len(pairs) == 0 {
+		return nil
+	}
+	secure := make(map[int]bool, len(pairs))
+	for _, pair := range pairs {
+		secure[pair.secure] = true
+	}
+	return secure
+}
+
+// equalPlain reports whether a and b are deeply equal, ignoring the
+// ciphertexts of passwords in structs.
+func equalPlain(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalPlain(a.Elem(), b.Elem())
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalPlain(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if len(getStructInfo(a.Type()).pairs) == 0 {
+			break
+		}
+		secure := secureFields(a.Type())
+		for i := 0; i < a.NumField(); i++ {
+			if !secure[i] && a.Field(i).CanInterface() && !equalPlain(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,94 @@
+package sconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateConfig_ConcurrentChanges(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	configPath := filepath.Join(tempDir, "concurrent.json")
+	if err := os.WriteFile(configPath, []byte(`{"currency": "USD", "db_password": "old-secret"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+
+	// Two tools load the same file.
+	first, second := &billingTestConfig{}, &billingTestConfig{}
+	for _, cfg := range []*billingTestConfig{first, second} {
+		if err := Load(cfg, configPath, hw, WithVersion(3)); err != nil {
+			ts.Fatalf("Load failed: %v", err)
+		}
+	}
+
+	first.Currency = "CHF"
+	if err := UpdateConfig(first, configPath); err != nil {
+		ts.Fatalf("UpdateConfig of first failed: %v", err)
+	}
+	// second changes another field: the currency of first is kept.
+	second.DBPassword = "new-secret"
+	if err := UpdateConfig(second, configPath); err != nil {
+		ts.Fatalf("UpdateConfig of second failed: %v", err)
+	}
+	if second.Currency != "CHF" || second.DBPassword != "new-secret" {
+		ts.Errorf("second after merge = %+v", second)
+	}
+	reloaded := &billingTestConfig{}
+	if err := Load(reloaded, configPath, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.Currency != "CHF" || reloaded.DBPassword != "new-secret" {
+		ts.Errorf("file after both updates = %+v", reloaded)
+	}
+
+	// Both change the currency: the second writer gets a conflict.
+	second.Currency = "JPY"
+	if err := UpdateConfig(second, configPath); err != nil {
+		ts.Fatalf("UpdateConfig of second failed: %v", err)
+	}
+	first.Currency = "GBP"
+	err := UpdateConfig(first, configPath)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !reflect.DeepEqual(conflict.Fields, []string{"Currency"}) {
+		ts.Fatalf("expected a conflict on Currency, got %v", err)
+	}
+	if first.DBPassword != "old-secret" {
+		ts.Errorf("the conflicting update merged fields into the struct: %+v", first)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), `"JPY"`) {
+		ts.Errorf("the conflicting update was written:\n%s", raw)
+	}
+
+	// Changing both to the same value is no conflict.
+	first.Currency = "JPY"
+	if err := UpdateConfig(first, configPath); err != nil {
+		ts.Errorf("UpdateConfig with the same value failed: %v", err)
+	}
+	if first.DBPassword != "new-secret" {
+		ts.Errorf("password of the other writer not merged: %+v", first)
+	}
+}
+
+func TestEqualPlain(ts *testing.T) {
+	type entry struct {
+		Name                string
+		TokenPassword       string
+		TokenSecurePassword string
+	}
+	a := []entry{{Name: "a", TokenPassword: "x", TokenSecurePassword: "cipher-1"}}
+	b := []entry{{Name: "a", TokenPassword: "x", TokenSecurePassword: "cipher-2"}}
+	if !equalPlain(reflect.ValueOf(a), reflect.ValueOf(b)) {
+		ts.Error("different ciphertexts of the same password compared unequal")
+	}
+	b[0].TokenPassword = "y"
+	if equalPlain(reflect.ValueOf(a), reflect.ValueOf(b)) {
+		ts.Error("different passwords compared equal")
+	}
+}
@@ -0,0 +1,70 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+ * Per-user config directory.
+ *
+ * Configs are usually kept next to the executable or in the working
+ * directory. Applications that store them per user instead get the platform
+ * directory from EnsureConfigDir ($XDG_CONFIG_HOME or ~/.config on Linux,
+ * ~/Library/Application Support on macOS, %AppData% on Windows). The
+ * directory is created before the first write with access for the owner only
+ * (0700 on Unix, a protected owner-only ACL on Windows), and an existing
+ * directory is tightened the same way: a world-readable config directory is a
+ * common insecure setup. Paths inside it are accepted by LoadConfig and
+ * UpdateConfig like paths under the executable directory.
+ */
+
+var (
+	configDirsMu sync.Mutex
+	configDirs   []string // directories returned by EnsureConfigDir
+)
+
+// EnsureConfigDir creates the per-user config directory of app with
+// owner-only access, or restricts an existing one, and returns its path.
+// app is a plain directory name such as "myapp".
+func EnsureConfigDir(app string) (string, error) {
+	if app == "" || app == "." || app == ".." || strings.ContainsAny(app, `/\`) {
+		return "", fmt.Errorf("%s", t("config.config_dir_name", app))
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("%s", t("config.config_dir_failed", err))
+	}
+	dir := filepath.Join(base, app)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("%s", t("config.config_dir_failed", err))
+	}
+	if err := restrictDir(dir); err != nil {
+		return "", fmt.Errorf("%s", t("config.config_dir_failed", err))
+	}
+	configDirsMu.Lock()
+	defer configDirsMu.Unlock()
+	for _, d := range configDirs {
+		if d == dir {
+			return dir, nil
+		}
+	}
+	configDirs = append(configDirs, dir)
+	return dir, nil
+}
+
+// underConfigDir reports whether path lies in a directory returned by
+// EnsureConfigDir.
+func underConfigDir(path string) bool {
+	configDirsMu.Lock()
+	defer configDirsMu.Unlock()
+	for _, dir := range configDirs {
+		if ok, err := pathUnderBase(dir, path); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
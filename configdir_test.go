@@ -0,0 +1,38 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEnsureConfigDir(ts *testing.T) {
+	if runtime.GOOS != "linux" {
+		ts.Skip("uses XDG_CONFIG_HOME")
+	}
+	testExeRoot(ts)
+	base := ts.TempDir()
+	ts.Setenv("XDG_CONFIG_HOME", base)
+	if _, err := EnsureConfigDir("../escape"); err == nil {
+		ts.Error("expected error for a name with a path separator")
+	}
+	existing := filepath.Join(base, "myapp")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		ts.Fatalf("Mkdir failed: %v", err)
+	}
+	dir, err := EnsureConfigDir("myapp")
+	if err != nil {
+		ts.Fatalf("EnsureConfigDir failed: %v", err)
+	}
+	if dir != existing {
+		ts.Errorf("expected %s, got %s", existing, dir)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		ts.Errorf("expected mode 0700, got %v", info.Mode().Perm())
+	}
+	cfg := &TestConfig{DatabasePassword: "user-dir-secret"}
+	if err := LoadConfig(cfg, 1, filepath.Join(dir, "config.json"), false, false); err != nil {
+		ts.Fatalf("LoadConfig in the config dir failed: %v", err)
+	}
+}
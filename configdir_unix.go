@@ -0,0 +1,10 @@
+//go:build !windows
+
+package sconfig
+
+import "os"
+
+// restrictDir limits dir to its owner.
+func restrictDir(dir string) error {
+	return os.Chmod(dir, 0700)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package sconfig
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                                                 = syscall.NewLazyDLL("advapi32.dll")
+	procConvertStringSecurityDescriptorToSecurityDescriptorW = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	procSetFileSecurityW                                     = advapi32.NewProc("SetFileSecurityW")
+)
+
+const (
+	sddlRevision1                    = 1
+	daclSecurityInformation          = 0x00000004
+	protectedDaclSecurityInformation = 0x80000000
+)
+
+// restrictDir replaces the ACL of dir by a protected one (no inherited
+// entries) granting full access to the current user and SYSTEM only.
+func restrictDir(dir string) error {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return err
+	}
+	sddl, err := syscall.UTF16PtrFromString("D:P(A;OICI;FA;;;" + sid + ")(A;OICI;FA;;;SY)")
+	if err != nil {
+		return err
+	}
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	var sd uintptr
+	if r, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(
+		uintptr(unsafe.Pointer(sddl)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return err
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+	if r, _, err := procSetFileSecurityW.Call(uintptr(unsafe.Pointer(path)),
+		daclSecurityInformation|protectedDaclSecurityInformation, sd); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Container identity.
+ *
+ * In a container the MAC address belongs to a virtual interface that is
+ * recreated with every pod, and disk serials and DMI data are those of
+ * whichever node runs it: the hardware ID, and with it the key, changes on
+ * every reschedule. WithContainerIdentity derives the hardware ID from
+ * sources that stay with the deployment instead, when the process runs in a
+ * container (/.dockerenv, /run/.containerenv, a container cgroup or
+ * KUBERNETES_SERVICE_HOST); outside a container the hardware is probed as
+ * usual, so the same build runs on a developer machine.
+ *
+ * All sources given are combined and all are required: a missing one is an
+ * error instead of a different key. Secret files and environment variables
+ * enter the hardware ID as SHA-256 hashes, so HardwareIdentifiers and sconfig
+ * hardware-id do not show them. The service account is identified by the
+ * subject of its token (system:serviceaccount:<namespace>:<name>), which is
+ * known to everyone with access to the cluster: prefer a mounted secret.
+ */
+
+// ContainerIdentitySource is a source of the hardware ID in a container.
+type ContainerIdentitySource struct {
+	kind string // "secret-file", "env" or "serviceaccount"
+	name string // path or variable name
+}
+
+// ContainerSecretFile uses the content of the file at path, e.g. a mounted
+// Kubernetes secret or Docker secret (/run/secrets/<name>).
+func ContainerSecretFile(path string) ContainerIdentitySource {
+	return ContainerIdentitySource{kind: "secret-file", name: path}
+}
+
+// ContainerEnv uses the value of the environment variable name, set by the
+// operator of the deployment.
+func ContainerEnv(name string) ContainerIdentitySource {
+	return ContainerIdentitySource{kind: "env", name: name}
+}
+
+// ContainerServiceAccount uses the namespace and name of the Kubernetes
+// service account of the pod.
+func ContainerServiceAccount() ContainerIdentitySource {
+	return ContainerIdentitySource{kind: "serviceaccount"}
+}
+
+func (s ContainerIdentitySource) String() string {
+	if s.name == "" {
+		return s.kind
+	}
+	return s.kind + " " + s.name
+}
+
+// containerFiles are the files container detection and the service account
+// source read; tests replace them.
+var containerFiles = struct {
+	dockerenv, containerenv, cgroup, serviceAccountToken string
+}{
+	dockerenv:           "/.dockerenv",
+	containerenv:        "/run/.containerenv",
+	cgroup:              "/proc/1/cgroup",
+	serviceAccountToken: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+}
+
+var (
+	containerIdentityMu      sync.Mutex
+	containerIdentitySources []ContainerIdentitySource // nil: the hardware is probed
+)
+
+// WithContainerIdentity makes the hardware ID come from sources instead of
+// the hardware when the process runs in a container; without sources the
+// service account of the pod is used. Like WithKeyStore it stays in effect
+// for later calls.
+func WithContainerIdentity(sources ...ContainerIdentitySource) LoadOption {
+	if len(sources) == 0 {
+		sources = []ContainerIdentitySource{ContainerServiceAccount()}
+	}
+	return func(o *loadOptions) { o.containerIdentity = sources }
+}
+
+// useContainerIdentity makes sources the container identity if they are not
+// already. Callers hold stateMu.
+func useContainerIdentity(sources []ContainerIdentitySource) {
+	containerIdentityMu.Lock()
+	defer containerIdentityMu.Unlock()
+	if reflect.DeepEqual(containerIdentitySources, sources) {
+		return
+	}
+	containerIdentitySources = append([]ContainerIdentitySource(nil), sources...)
+	initialized = false
+}
+
+func getContainerIdentity() []ContainerIdentitySource {
+	containerIdentityMu.Lock()
+	defer containerIdentityMu.Unlock()
+	return containerIdentitySources
+}
+
+// InContainer reports whether the process runs in a container (Docker,
+// Podman, containerd or Kubernetes).
+func InContainer() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	for _, path := range []string{containerFiles.dockerenv, containerFiles.containerenv} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	cgroup, err := os.ReadFile(containerFiles.cgroup)
+	if err != nil {
+		return false
+	}
+	for _, marker := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if bytes.Contains(cgroup, []byte(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerIdentity passes the identifier of each of sources to add.
+func containerIdentity(sources []ContainerIdentitySource, add func(source, value string)) error {
+	for _, s := range sources {
+		value, err := s.identifier()
+		if err != nil {
+			return fmt.Errorf("%s", t("config.container_identity_failed", s, err))
+		}
+		add("container-"+s.kind, value)
+	}
+	return nil
+}
+
+// identifier returns the identifier of the source: the hash of a secret,
+// the subject of the service account.
+func (s ContainerIdentitySource) identifier() (string, error) {
+	var secret []byte
+	switch s.kind {
+	case "secret-file":
+		data, err := probeFile(s.name)
+		if err != nil {
+			return "", err
+		}
+		secret = bytes.TrimSpace(data)
+		defer wipe(data)
+	case "env":
+		secret = []byte(strings.TrimSpace(os.Getenv(s.name)))
+	case "serviceaccount":
+		return serviceAccountSubject()
+	default:
+		return "", fmt.Errorf("unknown source %q", s.kind)
+	}
+	if len(secret) == 0 {
+		return "", fmt.Errorf("empty")
+	}
+	sum := sha256.Sum256(secret)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// serviceAccountSubject returns the subject of the service account token of
+// the pod. The token is not verified: it identifies, the key protects.
+func serviceAccountSubject() (string, error) {
+	token, err := probeFile(containerFiles.serviceAccountToken)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("not a JWT")
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || !strings.HasPrefix(claims.Subject, "system:serviceaccount:") {
+		return "", fmt.Errorf("no service account subject")
+	}
+	return claims.Subject, nil
+}
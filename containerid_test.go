@@ -0,0 +1,77 @@
+package sconfig
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerIdentity(ts *testing.T) {
+	tempDir := ts.TempDir()
+	origFiles := containerFiles
+	defer func() { containerFiles = origFiles }()
+	defer useContainerIdentity(nil)
+	ts.Setenv("KUBERNETES_SERVICE_HOST", "")
+	write := func(name, content string) string {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			ts.Fatal(err)
+		}
+		return path
+	}
+
+	containerFiles.dockerenv = filepath.Join(tempDir, "dockerenv")
+	containerFiles.containerenv = filepath.Join(tempDir, "containerenv")
+	containerFiles.cgroup = write("cgroup", "0::/init.scope\n")
+	if InContainer() {
+		ts.Fatal("host detected as container")
+	}
+	write("cgroup", "0::/kubepods.slice/kubepods-besteffort.slice/cri-containerd-4711.scope\n")
+	if !InContainer() {
+		ts.Fatal("kubepods cgroup not detected")
+	}
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:billing:api"}`))
+	containerFiles.serviceAccountToken = write("token", "eyJhbGciOiJSUzI1NiJ9."+claims+".c2lnbmF0dXJl\n")
+	secret := write("secret", "pod-secret\n")
+	ts.Setenv("SCONFIG_TEST_IDENTITY", "operator-value")
+
+	var o loadOptions
+	WithContainerIdentity()(&o)
+	useContainerIdentity(o.containerIdentity)
+	id, sources, err := HardwareIdentifiers()
+	if err != nil || len(sources) != 1 || sources[0] != (HardwareIdentifier{Source: "container-serviceaccount", Value: "system:serviceaccount:billing:api"}) {
+		ts.Fatalf("service account identifiers = %v, %v", sources, err)
+	}
+	if again, _, _ := HardwareIdentifiers(); again != id {
+		ts.Errorf("hardware ID changed from 0x%x to 0x%x", id, again)
+	}
+
+	useContainerIdentity([]ContainerIdentitySource{ContainerSecretFile(secret), ContainerEnv("SCONFIG_TEST_IDENTITY")})
+	_, sources, err = HardwareIdentifiers()
+	if err != nil || len(sources) != 2 {
+		ts.Fatalf("secret identifiers = %v, %v", sources, err)
+	}
+	for _, s := range sources {
+		if contains(s.Value, "pod-secret") || contains(s.Value, "operator-value") || !contains(s.Value, "sha256:") {
+			ts.Errorf("identifier %v shows the secret", s)
+		}
+	}
+
+	// A missing source is an error, not a different key.
+	ts.Setenv("SCONFIG_TEST_IDENTITY", "")
+	if _, _, err := HardwareIdentifiers(); err == nil || !contains(err.Error(), "env SCONFIG_TEST_IDENTITY") {
+		ts.Errorf("missing env source: %v", err)
+	}
+
+	// Outside a container the hardware is probed.
+	write("cgroup", "0::/\n")
+	if _, sources, err := HardwareIdentifiers(); err == nil {
+		for _, s := range sources {
+			if contains(s.Source, "container") {
+				ts.Errorf("container identifier %v outside a container", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+ * Conversion between config formats.
+ *
+ * Convert works on the document only: it never decrypts anything and needs no
+ * hardware key. Encrypted `<Name>SecurePassword` values and the
+ * PASSWORD_IS_SECURE markers are plain strings in every format and are copied
+ * unchanged, so a converted file loads with the same key and the same pair
+ * semantics as the original.
+ */
+
+// Convert re-serializes the config file at inPath into outPath. The input
+// format is taken from the extension of inPath or sniffed from its content;
+// the output format is taken from the extension of outPath (.json, .yaml/.yml,
+// .toml). Both paths are subject to the same restrictions as in LoadConfig.
+// XML configs are mapped through `xml` tags and cannot be converted without the
+// struct; Convert returns an error for them. outPath is replaced atomically and
+// gets the permissions of inPath.
+func Convert(inPath, outPath string) error {
+	in, err := resolveConfigPath(inPath)
+	if err != nil {
+		return err
+	}
+	out, err := resolveConfigPath(outPath)
+	if err != nil {
+		return err
+	}
+	outFormat := formatFromExtension(out)
+	if outFormat == FormatUnknown || outFormat == FormatXML {
+		return fmt.Errorf("%s", t("config.convert_unsupported", out, outFormat))
+	}
+
+	info, err := os.Stat(in)
+	if err != nil {
+		return fmt.Errorf(t("config.read_failed"), err)
+	}
+	content, err := os.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf(t("config.read_failed"), err)
+	}
+	inFormat := formatForRead(in, content)
+	if inFormat == FormatXML {
+		return fmt.Errorf("%s", t("config.convert_unsupported", in, inFormat))
+	}
+	data, err := toJSON(inFormat, content)
+	if err != nil {
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if err := checkJSONObject(data); err != nil {
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+
+	var result []byte
+	if outFormat == FormatJSON {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, bytes.TrimSpace(data), "", "\t"); err != nil {
+			return fmt.Errorf(t("config.failed_parsing"), err)
+		}
+		buf.WriteByte('\n')
+		result = buf.Bytes()
+	} else if result, err = fromJSON(outFormat, data); err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	if err := writeFileAtomic(out, info.Mode().Perm(), func(w io.Writer) error {
+		_, err := w.Write(result)
+		return err
+	}); err != nil {
+		return err
+	}
+	recordFormat(out, outFormat)
+	return nil
+}
+
+// checkJSONObject reports an error unless data is exactly one JSON object, the
+// only top-level value a config struct can be decoded from.
+func checkJSONObject(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var doc map[string]json.RawMessage
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if doc == nil {
+		return errors.New("top-level value is not an object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+		return err
+	}
+	return nil
+}
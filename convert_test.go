@@ -0,0 +1,87 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvert(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	jsonPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"database_host": "convert-host", "database_password": "convert-secret"}`), 0640); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := LoadConfig(&TestConfig{}, 1, jsonPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	var original TestConfig
+	raw, _ := os.ReadFile(jsonPath)
+	if err := json.Unmarshal(raw, &original); err != nil {
+		ts.Fatalf("Unmarshal failed: %v", err)
+	}
+	if original.DatabaseSecurePassword == "" {
+		ts.Fatal("expected encrypted password in source file")
+	}
+
+	ts.Run("JSON to YAML to TOML to JSON keeps ciphertexts", func(ts *testing.T) {
+		yamlPath := filepath.Join(tempDir, "config.yaml")
+		tomlPath := filepath.Join(tempDir, "config.toml")
+		backPath := filepath.Join(tempDir, "back.json")
+		for _, step := range [][2]string{{jsonPath, yamlPath}, {yamlPath, tomlPath}, {tomlPath, backPath}} {
+			if err := Convert(step[0], step[1]); err != nil {
+				ts.Fatalf("Convert(%s, %s) failed: %v", filepath.Base(step[0]), filepath.Base(step[1]), err)
+			}
+		}
+		raw, _ := os.ReadFile(tomlPath)
+		if sniffFormat(raw) != FormatTOML {
+			ts.Errorf("expected TOML output, got:\n%s", raw)
+		}
+		var back TestConfig
+		raw, _ = os.ReadFile(backPath)
+		if err := json.Unmarshal(raw, &back); err != nil {
+			ts.Fatalf("Unmarshal failed: %v", err)
+		}
+		if back != original {
+			ts.Errorf("round trip changed the config:\n got %+v\nwant %+v", back, original)
+		}
+
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, tomlPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig (toml) failed: %v", err)
+		}
+		if cfg.DatabasePassword != "convert-secret" || cfg.DatabaseHost != "convert-host" {
+			ts.Errorf("unexpected values after conversion: host=%q password=%q", cfg.DatabaseHost, cfg.DatabasePassword)
+		}
+	})
+
+	ts.Run("Unsupported output format", func(ts *testing.T) {
+		for _, out := range []string{"config.xml", "config.conf"} {
+			if err := Convert(jsonPath, filepath.Join(tempDir, out)); err == nil {
+				ts.Errorf("expected error for output %s", out)
+			}
+		}
+	})
+
+	ts.Run("XML input is rejected", func(ts *testing.T) {
+		xmlPath := filepath.Join(tempDir, "service.xml")
+		if err := os.WriteFile(xmlPath, []byte(`<service><port>1</port></service>`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := Convert(xmlPath, filepath.Join(tempDir, "service.json")); err == nil {
+			ts.Error("expected error for XML input")
+		}
+	})
+
+	ts.Run("Non-object input is rejected", func(ts *testing.T) {
+		listPath := filepath.Join(tempDir, "list.json")
+		if err := os.WriteFile(listPath, []byte(`[1, 2]`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := Convert(listPath, filepath.Join(tempDir, "list.yaml"))
+		if err == nil || !contains(err.Error(), t("config.failed_parsing")) {
+			ts.Errorf("expected parsing error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Field descriptions.
+ *
+ * A `desc:"..."` tag documents a field next to its declaration. The text is
+ * added to the errors about the field (invalid default, failed decryption),
+ * to the preflight report, to the comments of a generated config template
+ * and to the field reference written by WriteFieldDocs, so the meaning of a
+ * field is maintained in one place.
+ */
+
+// FieldDoc describes one leaf field of a config struct.
+type FieldDoc struct {
+	Key         string `json:"key"`                   // file keys joined by ".", "[]" for slice elements
+	Type        string `json:"type"`                  // Go type
+	Default     string `json:"default,omitempty"`     // `default:"..."`
+	Description string `json:"description,omitempty"` // `desc:"..."`
+	Secret      bool   `json:"secret,omitempty"`      // <Name>Password, stored encrypted
+	Env         string `json:"env,omitempty"`         // `env:"..."`
+	Flag        string `json:"flag,omitempty"`        // `flag:"..."`
+	Validate    string `json:"validate,omitempty"`    // `validate:"..."`
+}
+
+// Describe lists the fields of the config struct type (a struct or a pointer
+// to one) in declaration order. The <Name>SecurePassword fields are not
+// listed; their <Name>Password field is marked as Secret.
+func Describe(config interface{}) []FieldDoc {
+	typ := reflect.TypeOf(config)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return describeType(typ)
+}
+
+func describeType(typ reflect.Type) []FieldDoc {
+	var docs []FieldDoc
+	describeStruct(typ, "", &docs)
+	return docs
+}
+
+func describeStruct(typ reflect.Type, prefix string, docs *[]FieldDoc) {
+	info := getStructInfo(typ)
+	secret := make(map[int]bool, 2*len(info.pairs))
+	for _, pair := range info.pairs {
+		secret[pair.plain] = true
+		secret[pair.secure] = false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, ok := fileKey(field)
+		if !ok {
+			continue
+		}
+		if isSecret, inPair := secret[i]; inPair && !isSecret {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		ft := field.Type
+		switch {
+		case ft.Kind() == reflect.Struct && field.Anonymous && field.Tag.Get("json") == "":
+			describeStruct(ft, prefix, docs)
+			continue
+		case ft.Kind() == reflect.Struct:
+			describeStruct(ft, key, docs)
+			continue
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			describeStruct(ft.Elem(), key+"[]", docs)
+			continue
+		}
+		*docs = append(*docs, FieldDoc{
+			Key:         key,
+			Type:        field.Type.String(),
+			Default:     field.Tag.Get("default"),
+			Description: info.descs[i],
+			Secret:      secret[i],
+			Env:         field.Tag.Get("env"),
+			Flag:        field.Tag.Get("flag"),
+			Validate:    field.Tag.Get("validate"),
+		})
+	}
+}
+
+// fileKey returns the key of field in a JSON, YAML or TOML config file and
+// false for fields that are not written.
+func fileKey(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+// WriteFieldDocs writes a Markdown table of the fields of the config struct
+// type to w.
+func WriteFieldDocs(w io.Writer, config interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString("| Key | Type | Default | Description |\n|---|---|---|---|\n")
+	cell := func(s string) string { return strings.ReplaceAll(s, "|", `\|`) }
+	for _, doc := range Describe(config) {
+		desc := doc.Description
+		if doc.Secret {
+			desc = strings.TrimSpace(desc + " (encrypted)")
+		}
+		def := ""
+		if doc.Default != "" {
+			def = "`" + doc.Default + "`"
+		}
+		fmt.Fprintf(&buf, "| `%s` | %s | %s | %s |\n", cell(doc.Key), cell(doc.Type), cell(def), cell(desc))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteTemplate writes an example config of the config struct type in the
+// given format to w: every field holds its default, passwords are empty.
+// YAML and TOML templates carry the field descriptions as comments; JSON and
+// XML have no comments.
+func WriteTemplate(w io.Writer, config interface{}, format Format) error {
+	typ := reflect.TypeOf(config)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	v := reflect.New(typ)
+	if err := updateDefaultValues(v.Elem()); err != nil {
+		return fmt.Errorf(t("config.failed_defaulting"), err)
+	}
+	docs := describeType(typ)
+	descs := make(map[string]string)
+	for _, doc := range docs {
+		if doc.Description != "" {
+			descs[doc.Key] = doc.Description
+		}
+	}
+	var out []byte
+	var err error
+	switch format {
+	case FormatXML:
+		out, err = xml.MarshalIndent(v.Interface(), "", "\t")
+		out = append([]byte(xml.Header), append(out, '\n')...)
+	case FormatYAML:
+		out, err = templateYAML(v.Interface(), descs)
+	default:
+		if out, err = marshalConfig(v.Interface()); err == nil {
+			var buf bytes.Buffer
+			err = json.Indent(&buf, out, "", "\t")
+			out = buf.Bytes()
+		}
+		if err == nil && format == FormatTOML {
+			out, err = jsonToTOML(out)
+			out = append(tomlComments(docs), out...)
+		} else {
+			out = append(out, '\n')
+		}
+	}
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// templateYAML encodes config as YAML with the descriptions as comments above
+// their keys.
+func templateYAML(config interface{}, descs map[string]string) ([]byte, error) {
+	data, err := marshalConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := jsonTokensToYAMLNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	commentYAML(node, "", descs)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// commentYAML sets the descriptions as head comments of the mapping keys in n.
+func commentYAML(n *yaml.Node, prefix string, descs map[string]string) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			n.Content[i].HeadComment = descs[key]
+			commentYAML(n.Content[i+1], key, descs)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			commentYAML(item, prefix+"[]", descs)
+		}
+	}
+}
+
+// tomlComments lists the field descriptions as a comment block: the TOML
+// encoder cannot place comments next to keys.
+func tomlComments(docs []FieldDoc) []byte {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		if doc.Description != "" {
+			fmt.Fprintf(&buf, "# %s: %s\n", doc.Key, doc.Description)
+		}
+	}
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
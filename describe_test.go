@@ -0,0 +1,83 @@
+package sconfig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// DescTestConfig documents its fields with desc tags.
+type DescTestConfig struct {
+	Version          int    `json:"version" default:"1"`
+	Host             string `json:"host" default:"localhost" desc:"database host name"`
+	Port             int    `json:"port" default:"5432" desc:"database port"`
+	DBPassword       string `json:"db_password" desc:"password of the orders database"`
+	DBSecurePassword string `json:"db_secure_password"`
+	Cache            struct {
+		Dir string `json:"dir" default:"/var/cache/app" desc:"cache directory"`
+	} `json:"cache"`
+	Internal string `json:"-"`
+}
+
+func TestDescribe(ts *testing.T) {
+	docs := Describe(&DescTestConfig{})
+	var keys []string
+	for _, doc := range docs {
+		keys = append(keys, doc.Key)
+	}
+	if got := strings.Join(keys, ","); got != "version,host,port,db_password,cache.dir" {
+		ts.Fatalf("unexpected keys %s", got)
+	}
+	if docs[3].Description != "password of the orders database" || !docs[3].Secret || docs[1].Default != "localhost" {
+		ts.Errorf("unexpected docs %+v", docs)
+	}
+
+	var md bytes.Buffer
+	if err := WriteFieldDocs(&md, DescTestConfig{}); err != nil {
+		ts.Fatalf("WriteFieldDocs failed: %v", err)
+	}
+	if !strings.Contains(md.String(), "| `cache.dir` | string | `/var/cache/app` | cache directory |") ||
+		!strings.Contains(md.String(), "password of the orders database (encrypted)") {
+		ts.Errorf("unexpected field docs:\n%s", md.String())
+	}
+}
+
+func TestWriteTemplate(ts *testing.T) {
+	var yml bytes.Buffer
+	if err := WriteTemplate(&yml, &DescTestConfig{}, FormatYAML); err != nil {
+		ts.Fatalf("WriteTemplate failed: %v", err)
+	}
+	for _, want := range []string{"# database host name\nhost: localhost\n", "  # cache directory\n  dir: /var/cache/app\n"} {
+		if !strings.Contains(yml.String(), want) {
+			ts.Errorf("YAML template lacks %q:\n%s", want, yml.String())
+		}
+	}
+	var toml bytes.Buffer
+	if err := WriteTemplate(&toml, &DescTestConfig{}, FormatTOML); err != nil {
+		ts.Fatalf("WriteTemplate failed: %v", err)
+	}
+	if !strings.HasPrefix(toml.String(), "# host: database host name\n") || !strings.Contains(toml.String(), "port = 5432") {
+		ts.Errorf("unexpected TOML template:\n%s", toml.String())
+	}
+}
+
+// BadDefaultConfig has an invalid default on a described field.
+type BadDefaultConfig struct {
+	Port int `json:"port" default:"eighty" desc:"listen port"`
+}
+
+func TestDescInErrors(ts *testing.T) {
+	err := WriteTemplate(&bytes.Buffer{}, &BadDefaultConfig{}, FormatJSON)
+	if err == nil || !contains(err.Error(), "Port (listen port)") {
+		ts.Errorf("expected description in default error, got %v", err)
+	}
+
+	type refConfig struct {
+		Token string `json:"token" desc:"API token of the billing service"`
+	}
+	report := Preflight(&refConfig{Token: "secretref:env:SCONFIG_DESC_TEST_UNSET"})
+	c := report.Checks[len(report.Checks)-1]
+	if c.Kind != "secretref" || c.OK || c.Description != "API token of the billing service" {
+		ts.Errorf("unexpected preflight check %+v", c)
+	}
+}
@@ -0,0 +1,343 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Editing config files without their struct.
+ *
+ * Operators on a server do not have the config struct of the application,
+ * and hand-editing a file with encrypted passwords is error-prone. A
+ * Document is a config file opened for editing on the document, like
+ * DumpForRecovery and Convert: OpenDocument decrypts the passwords in memory,
+ * Fields lists the values with the passwords masked, Set changes a value
+ * (keeping its JSON type) or enters a new password, and Save encrypts the
+ * passwords with the current key and writes the file atomically. The CLI
+ * builds its editor (sconfig edit) and sconfig encrypt on it. XML configs are
+ * not supported.
+ *
+ * A "…password" key without a "…secure_password" sibling, as in a config
+ * written by hand, is a password as well: OpenDocument adds the sibling, named
+ * in the style of the key (database_password gets database_secure_password,
+ * dbPassword dbSecurePassword), so Save encrypts it. Passwords the file holds
+ * unencrypted are marked as Plaintext until Save.
+ */
+
+// Document is a config file opened for editing with OpenDocument.
+type Document struct {
+	path   string
+	format Format
+	mode   os.FileMode
+	root   map[string]interface{}
+
+	plaintext map[string]bool // paths of the passwords stored unencrypted in the file
+}
+
+// DocumentField is one value of a Document.
+type DocumentField struct {
+	Path   string `json:"path"`   // keys joined with ".", list elements as [i], e.g. "servers[0].host"
+	Value  string `json:"value"`  // the value as text; "" for a password
+	Secret bool   `json:"secret"` // a password; its value is not shown
+
+	// Plaintext marks a password stored unencrypted in the file; Save
+	// encrypts it.
+	Plaintext bool `json:"plaintext,omitempty"`
+}
+
+// OpenDocument reads the config file at path for editing and decrypts its
+// passwords in memory.
+func OpenDocument(path string) (*Document, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	in, format, root, err := readDocument(path, "config.document_unsupported")
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(in)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	plaintext := make(map[string]bool)
+	pairPasswords(root, "", plaintext)
+	key, err := documentKey(root)
+	if err != nil {
+		return nil, err
+	}
+	_, err = decryptDocument(root, key)
+	wipe(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{path: in, format: format, mode: info.Mode().Perm(), root: root, plaintext: plaintext}, nil
+}
+
+// pairPasswords adds the missing "…secure_password" sibling of every
+// "…password" key holding a password in the decoded JSON value v, found at
+// path, and records the paths of the passwords that are not encrypted in
+// plaintext.
+func pairPasswords(v interface{}, path string, plaintext map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			if path == "" && name == metadataKey {
+				continue
+			}
+			if password, ok := v[name].(string); ok && isPlaintextPassword(password) {
+				if _, isSecure := secretPlainKey(v, name); !isSecure && !isPasswordKey(v, name) {
+					if secureKey, ok := securePasswordKey(name); ok {
+						v[secureKey] = ""
+					}
+				}
+			}
+			pairPasswords(v[name], joinDocumentPath(path, name), plaintext)
+		}
+		for name, value := range v {
+			plainKey, ok := secretPlainKey(v, name)
+			if !ok {
+				continue
+			}
+			if secure, _ := value.(string); secure != "" {
+				continue
+			}
+			if password, _ := v[plainKey].(string); isPlaintextPassword(password) {
+				plaintext[joinDocumentPath(path, plainKey)] = true
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			pairPasswords(elem, fmt.Sprintf("%s[%d]", path, i), plaintext)
+		}
+	}
+}
+
+// securePasswordKey returns the "…secure_password" key for the password key
+// name in the style of name, or false if name does not end in "password" or
+// is a "…secure_password" key itself.
+func securePasswordKey(name string) (string, bool) {
+	i := len(name) - len("password")
+	if i < 0 || !strings.EqualFold(name[i:], "password") || strings.HasSuffix(strings.ToLower(strings.TrimRight(name[:i], "_-")), "secure") {
+		return "", false // not a password, or a lone ciphertext
+	}
+	prefix, word := name[:i], name[i:]
+	secure := "secure"
+	switch {
+	case word == "PASSWORD":
+		secure = "SECURE"
+	case word[0] == 'P':
+		secure = "Secure"
+	}
+	if strings.HasSuffix(prefix, "_") || strings.HasSuffix(prefix, "-") {
+		secure += prefix[len(prefix)-1:]
+	}
+	return prefix + secure + word, true
+}
+
+// Path returns the path of the file.
+func (d *Document) Path() string {
+	return d.path
+}
+
+// Fields returns the values of the document in key order. The metadata block
+// and the ciphertext fields are left out.
+func (d *Document) Fields() []DocumentField {
+	var fields []DocumentField
+	documentFields(d.root, "", &fields)
+	for i := range fields {
+		fields[i].Plaintext = d.plaintext[fields[i].Path]
+	}
+	return fields
+}
+
+func documentFields(v interface{}, path string, fields *[]DocumentField) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if path == "" && name == metadataKey {
+				continue
+			}
+			if _, ok := secretPlainKey(v, name); ok {
+				continue // the ciphertext of a password or secure field
+			}
+			if isPasswordKey(v, name) {
+				*fields = append(*fields, DocumentField{Path: joinDocumentPath(path, name), Secret: true})
+				continue
+			}
+			documentFields(v[name], joinDocumentPath(path, name), fields)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			documentFields(elem, fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	case nil:
+		*fields = append(*fields, DocumentField{Path: path, Value: "null"})
+	case string:
+		*fields = append(*fields, DocumentField{Path: path, Value: v})
+	default:
+		*fields = append(*fields, DocumentField{Path: path, Value: fmt.Sprint(v)})
+	}
+}
+
+func joinDocumentPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// isPasswordKey reports whether name is the plaintext key of a password pair
+// or a secure field in obj.
+func isPasswordKey(obj map[string]interface{}, name string) bool {
+	for other := range obj {
+		if plain, ok := secretPlainKey(obj, other); ok && plain == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Set changes the value at path (see DocumentField.Path). Numbers and
+// booleans must stay numbers and booleans; a password is replaced by value
+// in plaintext until Save encrypts it. Keys cannot be added.
+func (d *Document) Set(path, value string) error {
+	parent, key, index := d.locate(path)
+	switch {
+	case parent != nil:
+		current, ok := parent[key]
+		if !ok || path == metadataKey || strings.HasPrefix(path, metadataKey+".") {
+			break
+		}
+		if _, isSecure := secretPlainKey(parent, key); isSecure {
+			break
+		}
+		converted, err := convertDocumentValue(path, current, value)
+		if err != nil {
+			return err
+		}
+		parent[key] = converted
+		return nil
+	case index != nil:
+		converted, err := convertDocumentValue(path, index.list[index.i], value)
+		if err != nil {
+			return err
+		}
+		index.list[index.i] = converted
+		return nil
+	}
+	return fmt.Errorf("%s", t("config.document_unknown_field", path))
+}
+
+// listElem is a located list element.
+type listElem struct {
+	list []interface{}
+	i    int
+}
+
+// locate returns the object and key, or the list element, that path names.
+func (d *Document) locate(path string) (map[string]interface{}, string, *listElem) {
+	var current interface{} = d.root
+	var parent map[string]interface{}
+	var key string
+	var elem *listElem
+	for _, part := range strings.Split(path, ".") {
+		name, indexes, _ := strings.Cut(part, "[")
+		obj, ok := current.(map[string]interface{})
+		if !ok || name == "" {
+			return nil, "", nil
+		}
+		parent, key, elem = obj, name, nil
+		current = obj[name]
+		if indexes == "" {
+			continue
+		}
+		for _, idx := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			i, err := strconv.Atoi(idx)
+			list, ok := current.([]interface{})
+			if err != nil || !ok || i < 0 || i >= len(list) {
+				return nil, "", nil
+			}
+			parent, elem = nil, &listElem{list: list, i: i}
+			current = list[i]
+		}
+	}
+	return parent, key, elem
+}
+
+// convertDocumentValue converts value to the JSON type of current.
+func convertDocumentValue(path string, current interface{}, value string) (interface{}, error) {
+	switch current.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, fmt.Errorf("%s", t("config.document_not_scalar", path))
+	case json.Number:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("%s", t("config.document_type", path, "number", value))
+		}
+		return json.Number(value), nil
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s", t("config.document_type", path, "boolean", value))
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// Save encrypts the passwords with the current key and writes the file
+// atomically, keeping its format and mode. The passwords stay decrypted in
+// the Document.
+func (d *Document) Save() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return err
+	}
+	// Encrypt a copy, so the Document keeps the plaintext for further edits.
+	data, err := json.Marshal(d.root)
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	err = dec.Decode(&doc)
+	wipe(data)
+	if err != nil {
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if _, err := encryptDocument(doc); err != nil {
+		return err
+	}
+	setDocumentKeyMetadata(doc)
+	content, err := encodeDocument(d.format, doc)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(d.path, d.mode, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err == nil {
+		d.plaintext = map[string]bool{}
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocument_EditAndSave(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "edit.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "old-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	doc, err := OpenDocument(configPath)
+	if err != nil {
+		ts.Fatalf("OpenDocument failed: %v", err)
+	}
+	fields := map[string]DocumentField{}
+	for _, f := range doc.Fields() {
+		fields[f.Path] = f
+	}
+	if f := fields["database_password"]; !f.Secret || f.Value != "" {
+		ts.Errorf("password not masked: %+v", f)
+	}
+	if _, ok := fields["database_secure_password"]; ok {
+		ts.Errorf("ciphertext listed")
+	}
+	if _, ok := fields[metadataKey+".key"]; ok {
+		ts.Errorf("metadata listed")
+	}
+	if f := fields["database_port"]; f.Value != "5432" {
+		ts.Errorf("database_port = %+v", f)
+	}
+
+	if err := doc.Set("database_port", "many"); err == nil || !contains(err.Error(), t("config.document_type", "database_port", "number", "many")) {
+		ts.Errorf("expected type error, got %v", err)
+	}
+	if err := doc.Set("no_such_value", "x"); err == nil || !contains(err.Error(), t("config.document_unknown_field", "no_such_value")) {
+		ts.Errorf("expected unknown field error, got %v", err)
+	}
+	if err := doc.Set("database_secure_password", "x"); err == nil {
+		ts.Errorf("ciphertext was editable")
+	}
+	for path, value := range map[string]string{"database_port": "6543", "debug": "false", "database_password": "new-secret"} {
+		if err := doc.Set(path, value); err != nil {
+			ts.Fatalf("Set(%s) failed: %v", path, err)
+		}
+	}
+	if err := doc.Save(); err != nil {
+		ts.Fatalf("Save failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "new-secret") || !strings.Contains(string(raw), `"database_port": 6543`) {
+		ts.Fatalf("unexpected file after Save:\n%s", raw)
+	}
+
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig after Save failed: %v", err)
+	}
+	if cfg.DatabasePassword != "new-secret" || cfg.DatabasePort != 6543 || cfg.Debug {
+		ts.Errorf("edits not loaded: %+v", cfg)
+	}
+}
+
+func TestDocument_EncryptPlaintext(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	configPath := filepath.Join(tempDir, "plain.json")
+	content := `{"host": "db", "database_password": "hand-written", "servers": [{"dbPassword": "second"}], "reset_password": ""}`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	doc, err := OpenDocument(configPath)
+	if err != nil {
+		ts.Fatalf("OpenDocument failed: %v", err)
+	}
+	var plaintext []string
+	for _, f := range doc.Fields() {
+		if f.Plaintext {
+			plaintext = append(plaintext, f.Path)
+		}
+		if f.Plaintext && (!f.Secret || f.Value != "") {
+			ts.Errorf("plaintext password not masked: %+v", f)
+		}
+	}
+	if strings.Join(plaintext, ",") != "database_password,servers[0].dbPassword" {
+		ts.Errorf("plaintext passwords = %q", plaintext)
+	}
+	if err := doc.Save(); err != nil {
+		ts.Fatalf("Save failed: %v", err)
+	}
+	for _, f := range doc.Fields() {
+		if f.Plaintext {
+			ts.Errorf("%s still plaintext after Save", f.Path)
+		}
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "hand-written") || strings.Contains(string(raw), `"second"`) ||
+		!strings.Contains(string(raw), `"dbSecurePassword": "`) || strings.Contains(string(raw), "reset_secure_password") {
+		ts.Fatalf("unexpected file after Save:\n%s", raw)
+	}
+	var out strings.Builder
+	if _, err := WriteRecovery(&out, configPath, RecoveryConfirmation); err != nil {
+		ts.Fatalf("WriteRecovery failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"database_password": "hand-written"`) || !strings.Contains(out.String(), `"dbPassword": "second"`) {
+		ts.Errorf("passwords not decrypted:\n%s", out.String())
+	}
+}
+
+func TestSecurePasswordKey(ts *testing.T) {
+	for name, want := range map[string]string{
+		"database_password": "database_secure_password",
+		"smtp-password":     "smtp-secure-password",
+		"dbPassword":        "dbSecurePassword",
+		"DB_PASSWORD":       "DB_SECURE_PASSWORD",
+		"password":          "securepassword",
+		"secure_password":   "",
+		"passwd":            "",
+	} {
+		if got, ok := securePasswordKey(name); got != want || ok != (want != "") {
+			ts.Errorf("securePasswordKey(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestDocument_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "secured.json")
+	writeSecuredConfig(ts, configPath, "old-token", hw)
+
+	doc, err := OpenDocument(configPath)
+	if err != nil {
+		ts.Fatalf("OpenDocument failed: %v", err)
+	}
+	fields := map[string]DocumentField{}
+	for _, f := range doc.Fields() {
+		fields[f.Path] = f
+	}
+	if f := fields["api_token"]; !f.Secret || f.Value != "" {
+		ts.Errorf("secure field not masked: %+v", f)
+	}
+	if _, ok := fields["api_token__enc"]; ok {
+		ts.Errorf("shadow key listed")
+	}
+	if err := doc.Set("api_token__enc", "x"); err == nil {
+		ts.Errorf("shadow key was editable")
+	}
+	if err := doc.Set("api_token", "new-token"); err != nil {
+		ts.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Save(); err != nil {
+		ts.Fatalf("Save failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "-token") {
+		ts.Fatalf("secure field written in plain text:\n%s", raw)
+	}
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig after Save failed: %v", err)
+	}
+	if cfg.APIToken != "new-token" || cfg.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("edits not loaded: %+v", cfg)
+	}
+}
@@ -0,0 +1,106 @@
+package sconfig
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"reflect"
+)
+
+/*
+ * Dual-read during credential rotation.
+ *
+ * Rotating an API key or a shared secret without downtime needs a window in
+ * which both the new and the old value are accepted. A config declares the
+ * old value as a second password pair next to the current one:
+ *
+ *	APIPassword               string // current
+ *	APISecurePassword         string
+ *	APIPreviousPassword       string // accepted until the rotation is done
+ *	APIPreviousSecurePassword string
+ *
+ * Both pairs are encrypted like any other. RotateSecret moves the current
+ * value to the previous one, SecretCandidates returns both and AcceptsSecret
+ * checks a presented value against both in constant time. Names are Go field
+ * paths without the "Password" suffix, e.g. "API" or "Upstream.Token".
+ */
+
+// rotationFields returns the current and previous password fields of name.
+// Both must have their SecurePassword counterpart, so neither value is ever
+// written in plain text.
+func rotationFields(config interface{}, name string) (current, previous reflect.Value, err error) {
+	v := reflect.ValueOf(config)
+	ok := true
+	for _, field := range []string{name + "SecurePassword", name + "PreviousSecurePassword"} {
+		if _, found := fieldByPath(v, field); !found {
+			ok = false
+		}
+	}
+	if ok {
+		current, ok = fieldByPath(v, name+"Password")
+	}
+	if ok {
+		previous, ok = fieldByPath(v, name+"PreviousPassword")
+	}
+	if !ok || !current.CanSet() || !previous.CanSet() {
+		return reflect.Value{}, reflect.Value{}, fmt.Errorf("%s", t("config.rotation_unknown_field", name))
+	}
+	return current, previous, nil
+}
+
+// SecretCandidates returns the decrypted current and previous value of the
+// secret name of a loaded config, current first. Empty values and a previous
+// value equal to the current one are left out.
+func SecretCandidates(config interface{}, name string) ([]string, error) {
+	current, previous, err := rotationFields(config, name)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, v := range []string{current.String(), previous.String()} {
+		if v == "" || isMarker(v) {
+			continue // empty, or not decrypted
+		}
+		if len(values) == 0 || values[0] != v {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// AcceptsSecret reports whether presented equals the current or the previous
+// value of the secret name. Both comparisons run in constant time.
+func AcceptsSecret(config interface{}, name, presented string) (bool, error) {
+	values, err := SecretCandidates(config, name)
+	if err != nil {
+		return false, err
+	}
+	match := 0
+	for _, v := range values {
+		match |= subtle.ConstantTimeCompare([]byte(v), []byte(presented))
+	}
+	return match == 1, nil
+}
+
+// RotateSecret makes value the current value of the secret name of a loaded
+// config and keeps the old current value as the previous one. UpdateConfig
+// writes both encrypted.
+func RotateSecret(config interface{}, name, value string) error {
+	current, previous, err := rotationFields(config, name)
+	if err != nil {
+		return err
+	}
+	previous.SetString(current.String())
+	current.SetString(value)
+	return nil
+}
+
+// FinishRotation drops the previous value of the secret name once no client
+// uses it any more. UpdateConfig writes the change.
+func FinishRotation(config interface{}, name string) error {
+	_, previous, err := rotationFields(config, name)
+	if err != nil {
+		return err
+	}
+	previous.SetString("")
+	return nil
+}
@@ -0,0 +1,72 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type RotationTestConfig struct {
+	Version                   int
+	APIPassword               string
+	APISecurePassword         string
+	APIPreviousPassword       string
+	APIPreviousSecurePassword string
+}
+
+func TestRotateSecret_DualRead(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	path := filepath.Join(tempDir, "rotation.json")
+	hw := func() (uint64, error) { return 4711, nil }
+
+	cfg := &RotationTestConfig{APIPassword: "key-one"}
+	if err := LoadConfig(cfg, 1, path, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if values, err := SecretCandidates(cfg, "API"); err != nil || len(values) != 1 || values[0] != "key-one" {
+		ts.Fatalf("candidates before rotation: %v, %q", err, values)
+	}
+
+	if err := RotateSecret(cfg, "API", "key-two"); err != nil {
+		ts.Fatalf("RotateSecret failed: %v", err)
+	}
+	if err := UpdateConfig(cfg, path); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "key-one") || strings.Contains(string(raw), "key-two") {
+		ts.Fatalf("secret written in plain text: %s", raw)
+	}
+
+	cfg = &RotationTestConfig{}
+	if err := LoadConfig(cfg, 1, path, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	values, err := SecretCandidates(cfg, "API")
+	if err != nil || len(values) != 2 || values[0] != "key-two" || values[1] != "key-one" {
+		ts.Fatalf("candidates during rotation: %v, %q", err, values)
+	}
+	for presented, want := range map[string]bool{"key-two": true, "key-one": true, "key-three": false, "": false} {
+		if ok, err := AcceptsSecret(cfg, "API", presented); err != nil || ok != want {
+			ts.Errorf("AcceptsSecret(%q) = %v, %v; want %v", presented, ok, err, want)
+		}
+	}
+
+	if err := FinishRotation(cfg, "API"); err != nil {
+		ts.Fatalf("FinishRotation failed: %v", err)
+	}
+	if ok, _ := AcceptsSecret(cfg, "API", "key-one"); ok {
+		ts.Error("previous value still accepted after FinishRotation")
+	}
+}
+
+func TestRotateSecret_UnknownField(ts *testing.T) {
+	// TestConfig has DatabasePassword but no previous pair.
+	for _, name := range []string{"Database", "Missing"} {
+		_, err := SecretCandidates(&TestConfig{}, name)
+		if err == nil || !contains(err.Error(), t("config.rotation_unknown_field", name)) {
+			ts.Errorf("%s: expected unknown field error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package sconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * time.Duration and time.Time fields.
+ *
+ * encoding/json treats a time.Duration as a plain integer of nanoseconds,
+ * which nobody wants to write or read in a config file. sconfig maps
+ * Duration fields itself, without a wrapper type in the config struct: in a
+ * file, a Duration is read from a string such as "1m30s" (time.ParseDuration)
+ * or, as before, from an integer of nanoseconds, and always written back as a
+ * string. `default:"30s"`, environment variables and flags take the same
+ * strings.
+ *
+ * A time.Time is read and written as RFC 3339 by encoding/json. A
+ * `layout:"..."` tag gives another layout, a Go reference layout or the name
+ * of one of the layouts of package time (see timeLayouts):
+ *
+ *	Start  time.Time `json:"start" default:"2024-01-01T00:00:00Z"`
+ *	Cutoff time.Time `json:"cutoff" layout:"DateOnly" default:"2024-06-30"`
+ *
+ * The field is then read in that layout and written back in it, so a value
+ * keeps its form across rewrites. Defaults, environment variables and flags
+ * use the same layout.
+ *
+ * The conversion rewrites the JSON document along the struct type, keeping
+ * the order of its keys, and only runs for struct types that contain a
+ * Duration or a layout tag somewhere; YAML and TOML files get it through
+ * their JSON form. XML files keep the forms of encoding/xml (integers,
+ * RFC 3339).
+ */
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// timeLayouts are the layouts a layout tag may name instead of spelling them.
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+	"Kitchen":     time.Kitchen,
+}
+
+// timeLayout returns the layout of the layout tag tag, RFC 3339 if it is
+// empty.
+func timeLayout(tag string) string {
+	if tag == "" {
+		return time.RFC3339Nano
+	}
+	if layout, ok := timeLayouts[tag]; ok {
+		return layout
+	}
+	return tag
+}
+
+// parseTime parses value in the layout of the layout tag tag.
+func parseTime(value, tag string) (time.Time, error) {
+	return time.Parse(timeLayout(tag), value)
+}
+
+var textFieldTypes sync.Map // reflect.Type -> bool
+
+// hasTextFields reports whether a Duration, a layout tag or a secure tag is
+// reachable from the type t.
+func hasTextFields(t reflect.Type) bool {
+	if cached, ok := textFieldTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	found := containsTextField(t, map[reflect.Type]bool{})
+	textFieldTypes.Store(t, found)
+	return found
+}
+
+func containsTextField(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t == durationType {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return containsTextField(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag
+			if tag.Get("layout") != "" || tag.Get("secure") == "true" || containsTextField(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// marshalConfig encodes config as JSON with its Durations as strings and its
+// Times in their layouts.
+func marshalConfig(config interface{}) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil || !hasTextFields(reflect.TypeOf(config)) {
+		return data, err
+	}
+	return convertTextFields(data, reflect.TypeOf(config), true)
+}
+
+// convertTextFields rewrites the values of the Duration fields and the
+// layout-tagged Time fields of type t in the JSON document data: toText
+// writes Durations as strings and Times in their layouts, otherwise both are
+// parsed into the forms of encoding/json. Data after the document is kept as
+// is.
+func convertTextFields(data []byte, t reflect.Type, toText bool) ([]byte, error) {
+	var out bytes.Buffer
+	if err := copyJSON(&out, bytes.NewReader(data), t, toText, false); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// copyJSON is convertTextFields from r to w: the document is converted token
+// by token, so neither it nor its conversion is held in memory. indent
+// formats the output like json.Indent with tabs.
+func copyJSON(w io.Writer, r io.Reader, t reflect.Type, toText, indent bool) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	out := bufio.NewWriter(w)
+	c := &textConverter{dec: dec, out: out, toText: toText, indent: indent}
+	if err := c.value(t, "", ""); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.MultiReader(dec.Buffered(), r)); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+type textConverter struct {
+	dec     *json.Decoder
+	out     *bufio.Writer
+	scratch bytes.Buffer // for scalar
+	toText  bool
+	indent  bool
+	depth   int
+}
+
+// value copies the next JSON value, found at path, of the Go type t (nil if
+// unknown) and converts it if t is a Duration, or a Time with the layout tag
+// layout.
+func (c *textConverter) value(t reflect.Type, layout, path string) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return c.object(t, layout, path)
+		}
+		return c.array(t, layout, path)
+	case json.Number:
+		if t == durationType && c.toText {
+			n, err := tok.Int64()
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return c.scalar(time.Duration(n).String())
+		}
+		c.out.WriteString(tok.String())
+		return nil
+	case string:
+		if t == durationType && !c.toText {
+			d, err := time.ParseDuration(tok)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			c.out.WriteString(strconv.FormatInt(int64(d), 10))
+			return nil
+		}
+		if t == timeType && layout != "" {
+			from, to := time.RFC3339Nano, timeLayout(layout)
+			if !c.toText {
+				from, to = to, from
+			}
+			tm, err := time.Parse(from, tok)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return c.scalar(tm.Format(to))
+		}
+	}
+	return c.scalar(tok)
+}
+
+func (c *textConverter) object(t reflect.Type, layout, path string) error {
+	c.open('{')
+	var shadows []secureShadow // read: ciphertexts of secure fields from their shadow keys
+	plains := map[string]string{}
+	first := true
+	for c.dec.More() {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var field reflect.StructField
+		isField := false
+		if t != nil && t.Kind() == reflect.Struct {
+			field, isField = jsonField(t, key)
+			if base, ok := strings.CutSuffix(key, shadowSuffix); ok && !c.toText && !isField && isSecureField(t, base) {
+				tok, err := c.dec.Token()
+				if err != nil {
+					return err
+				}
+				sealed, ok := tok.(string)
+				if !ok {
+					return errSecureNotString(joinDocumentPath(path, key))
+				}
+				shadows = append(shadows, secureShadow{key: base, sealed: sealed})
+				continue
+			}
+		}
+		c.next(first)
+		first = false
+		if err := c.scalar(key); err != nil {
+			return err
+		}
+		c.colon()
+		if isField && field.Tag.Get("secure") == "true" {
+			if err := c.secured(key, joinDocumentPath(path, key), plains); err != nil {
+				return err
+			}
+			continue
+		}
+		var elem reflect.Type
+		elemLayout := ""
+		if t != nil && t.Kind() == reflect.Map {
+			elem, elemLayout = t.Elem(), layout
+		} else if isField {
+			elem, elemLayout = field.Type, field.Tag.Get("layout")
+		}
+		if err := c.value(elem, elemLayout, joinDocumentPath(path, key)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dec.Token(); err != nil {
+		return err
+	}
+	// A later duplicate key wins in encoding/json: the ciphertext replaces the
+	// marker, unless a new plaintext was written into the key.
+	for _, shadow := range shadows {
+		if value := plains[shadow.key]; value != "" && !isMarker(value) {
+			continue
+		}
+		c.next(first)
+		first = false
+		if err := c.scalar(shadow.key); err != nil {
+			return err
+		}
+		c.colon()
+		if err := c.scalar(encryptedPrefix + shadow.sealed); err != nil {
+			return err
+		}
+	}
+	c.close('}', first)
+	return nil
+}
+
+// secureShadow is the ciphertext of the secure field key, read from its
+// shadow key.
+type secureShadow struct {
+	key, sealed string
+}
+
+// isSecureField reports whether key names a string field of the struct type
+// t tagged secure:"true".
+func isSecureField(t reflect.Type, key string) bool {
+	field, ok := jsonField(t, key)
+	return ok && field.Type.Kind() == reflect.String && field.Tag.Get("secure") == "true"
+}
+
+// errSecureNotString reports a secure field at path that holds no string.
+func errSecureNotString(path string) error {
+	return fmt.Errorf("%s: %s", path, t("config.secure_not_string"))
+}
+
+// secured copies the value of the secure field key, found at path. Written,
+// a ciphertext becomes the secure marker and the shadow key; read, the value
+// is recorded in plains.
+func (c *textConverter) secured(key, path string, plains map[string]string) error {
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+	value, ok := tok.(string)
+	if !ok {
+		if _, isDelim := tok.(json.Delim); isDelim {
+			return errSecureNotString(path)
+		}
+		return c.scalar(tok)
+	}
+	if !c.toText {
+		plains[key] = value
+		return c.scalar(value)
+	}
+	sealed, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return c.scalar(value)
+	}
+	if err := c.scalar(secureMarker()); err != nil {
+		return err
+	}
+	c.next(false)
+	if err := c.scalar(key + shadowSuffix); err != nil {
+		return err
+	}
+	c.colon()
+	return c.scalar(sealed)
+}
+
+func (c *textConverter) array(t reflect.Type, layout, path string) error {
+	var elem reflect.Type
+	if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		elem = t.Elem()
+	}
+	c.open('[')
+	i := 0
+	for ; c.dec.More(); i++ {
+		c.next(i == 0)
+		if err := c.value(elem, layout, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dec.Token(); err != nil {
+		return err
+	}
+	c.close(']', i == 0)
+	return nil
+}
+
+// open writes the start of an object or array.
+func (c *textConverter) open(delim byte) {
+	c.out.WriteByte(delim)
+	c.depth++
+}
+
+// next separates a member or element from the one before, unless it is the
+// first.
+func (c *textConverter) next(first bool) {
+	if !first {
+		c.out.WriteByte(',')
+	}
+	c.newline()
+}
+
+// colon separates a key from its value.
+func (c *textConverter) colon() {
+	c.out.WriteByte(':')
+	if c.indent {
+		c.out.WriteByte(' ')
+	}
+}
+
+// close writes the end of an object or array; an empty one stays on its
+// line.
+func (c *textConverter) close(delim byte, empty bool) {
+	c.depth--
+	if !empty {
+		c.newline()
+	}
+	c.out.WriteByte(delim)
+}
+
+func (c *textConverter) newline() {
+	if c.indent {
+		c.out.WriteByte('\n')
+		for i := 0; i < c.depth; i++ {
+			c.out.WriteByte('\t')
+		}
+	}
+}
+
+// scalar writes v as JSON without escaping HTML characters.
+func (c *textConverter) scalar(v interface{}) error {
+	c.scratch.Reset()
+	enc := json.NewEncoder(&c.scratch)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := c.out.Write(c.scratch.Bytes()[:c.scratch.Len()-1]) // without the newline of Encode
+	return err
+}
+
+// jsonField returns the field of the struct type t that encoding/json
+// decodes the key into.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold *reflect.StructField
+	var find func(t reflect.Type) *reflect.StructField
+	find = func(t reflect.Type) *reflect.StructField {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			ft := field.Type
+			if field.Anonymous && name == "" {
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					if found := find(ft); found != nil {
+						return found
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if name == key {
+				return &field
+			}
+			if fold == nil && strings.EqualFold(name, key) {
+				fold = &field
+			}
+		}
+		return nil
+	}
+	if exact := find(t); exact != nil {
+		return *exact, true
+	}
+	if fold != nil {
+		return *fold, true
+	}
+	return reflect.StructField{}, false
+}
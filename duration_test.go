@@ -0,0 +1,149 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type durationTestConfig struct {
+	Timeout  time.Duration            `json:"timeout" default:"30s"`
+	Interval time.Duration            `json:"interval"`
+	Retry    *time.Duration           `json:"retry"`
+	Backoff  []time.Duration          `json:"backoff"`
+	Limits   map[string]time.Duration `json:"limits"`
+	Name     string                   `json:"name"`
+	Nested   struct {
+		Idle time.Duration `json:"idle" env:"SCONFIG_TEST_IDLE"`
+	} `json:"nested"`
+}
+
+func TestLoadConfig_Durations(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		configPath := filepath.Join(tempDir, name)
+		cfg := &durationTestConfig{Name: "<a&b>", Backoff: []time.Duration{time.Second, 2500 * time.Millisecond}}
+		if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		cfg.Limits = map[string]time.Duration{"read": time.Minute}
+		if err := UpdateConfig(cfg, configPath); err != nil {
+			ts.Fatalf("%s: UpdateConfig failed: %v", name, err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		for _, want := range []string{"30s", "1s", "2.5s", "1m0s", "<a&b>"} {
+			if !strings.Contains(string(raw), want) {
+				ts.Errorf("%s: %q not written:\n%s", name, want, raw)
+			}
+		}
+		loaded := &durationTestConfig{}
+		if err := LoadConfig(loaded, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("%s: reload failed: %v", name, err)
+		}
+		if loaded.Timeout != 30*time.Second || loaded.Limits["read"] != time.Minute || len(loaded.Backoff) != 2 || loaded.Backoff[1] != 2500*time.Millisecond {
+			ts.Errorf("%s: reloaded %+v", name, loaded)
+		}
+	}
+
+	// Strings and integer nanoseconds are both read; the key order is kept.
+	configPath := filepath.Join(tempDir, "mixed.json")
+	if err := os.WriteFile(configPath, []byte(`{"name": "x", "interval": 1500000000, "retry": "5m", "timeout": "1h", "nested": {"idle": "90s"}}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	ts.Setenv("SCONFIG_TEST_IDLE", "2m")
+	cfg := &durationTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Interval != 1500*time.Millisecond || cfg.Retry == nil || *cfg.Retry != 5*time.Minute || cfg.Timeout != time.Hour || cfg.Nested.Idle != 2*time.Minute {
+		ts.Errorf("unexpected config %+v", cfg)
+	}
+	data, err := marshalConfig(cfg)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(data, &doc)
+	if doc["interval"] != "1.5s" || doc["retry"] != "5m0s" {
+		ts.Errorf("marshaled %s", data)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"timeout": "soon"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := LoadConfig(&durationTestConfig{}, 1, configPath, false, false, hw); err == nil || !strings.Contains(err.Error(), "timeout") {
+		ts.Errorf("expected an error naming the field, got %v", err)
+	}
+}
+
+type timeTestConfig struct {
+	Start    time.Time   `json:"start" default:"2024-01-01T00:00:00Z"`
+	Cutoff   time.Time   `json:"cutoff" layout:"DateOnly" default:"2024-06-30"`
+	Reminder time.Time   `json:"reminder" layout:"02.01.2006 15:04" env:"SCONFIG_TEST_REMINDER"`
+	Holidays []time.Time `json:"holidays" layout:"DateOnly"`
+	Last     *time.Time  `json:"last" layout:"DateTime"`
+}
+
+func TestLoadConfig_Times(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	for _, name := range []string{"times.json", "times.yaml"} {
+		configPath := filepath.Join(tempDir, name)
+		cfg := &timeTestConfig{Holidays: []time.Time{time.Date(2024, 12, 24, 0, 0, 0, 0, time.UTC)}}
+		if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		if !cfg.Start.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !cfg.Cutoff.Equal(time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)) {
+			ts.Errorf("%s: defaults not applied: %+v", name, cfg)
+		}
+		last := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+		cfg.Last = &last
+		if err := UpdateConfig(cfg, configPath); err != nil {
+			ts.Fatalf("%s: UpdateConfig failed: %v", name, err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		for _, want := range []string{"2024-01-01T00:00:00Z", "2024-06-30", "2024-12-24", "2024-03-01 12:30:00"} {
+			if !strings.Contains(string(raw), want) {
+				ts.Errorf("%s: %q not written:\n%s", name, want, raw)
+			}
+		}
+		if strings.Contains(string(raw), "2024-06-30T") {
+			ts.Errorf("%s: cutoff not written in its layout:\n%s", name, raw)
+		}
+		loaded := &timeTestConfig{}
+		if err := LoadConfig(loaded, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("%s: reload failed: %v", name, err)
+		}
+		if !loaded.Cutoff.Equal(cfg.Cutoff) || loaded.Last == nil || !loaded.Last.Equal(last) || len(loaded.Holidays) != 1 || !loaded.Holidays[0].Equal(cfg.Holidays[0]) {
+			ts.Errorf("%s: reloaded %+v", name, loaded)
+		}
+	}
+
+	configPath := filepath.Join(tempDir, "reminder.json")
+	if err := os.WriteFile(configPath, []byte(`{"cutoff": "2025-02-28"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	ts.Setenv("SCONFIG_TEST_REMINDER", "24.12.2025 18:00")
+	cfg := &timeTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !cfg.Reminder.Equal(time.Date(2025, 12, 24, 18, 0, 0, 0, time.UTC)) || cfg.Cutoff.Month() != time.February {
+		ts.Errorf("unexpected config %+v", cfg)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"cutoff": "2025-02-28T00:00:00Z"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := LoadConfig(&timeTestConfig{}, 1, configPath, false, false, hw); err == nil || !strings.Contains(err.Error(), "cutoff") {
+		ts.Errorf("expected an error naming the field, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+/*
+ * Environment variable overrides.
+ *
+ * Containers and CI pipelines configure programs through the environment. A
+ * field tagged `env:"NAME"` takes the value of the environment variable NAME,
+ * if it is set, after the file and the local override file have been applied:
+ *
+ *	DatabaseHost     string `json:"database_host" env:"DB_HOST"`
+ *	DatabasePassword string `json:"database_password" env:"DB_PASSWORD"`
+ *
+ * Like the override file, the environment only changes the config in memory:
+ * UpdateConfig writes the value from the file for every overridden field the
+ * program did not change since loading. A password supplied through the
+ * environment is encrypted into its SecurePassword field in memory, so the
+ * struct is the same as for a password from the file, and its plaintext is
+ * never written. String, integer, float and bool fields are supported; a tag
+ * in the element type of a struct slice applies to every element.
+ */
+
+// taggedField is a scalar field carrying an `env:"..."` or `flag:"..."` tag
+// (see flags.go).
+type taggedField struct {
+	index  int
+	env    string
+	flag   string
+	layout string // `layout:"..."` of a time.Time field
+}
+
+// applyEnvOverrides sets the env-tagged fields of config from the
+// environment and remembers them like the fields of the override file of
+// path, so that they are not written back.
+func applyEnvOverrides(path string, config interface{}) error {
+	return applyTaggedOverrides(path, config, &walker{phases: phaseEnv})
+}
+
+// applyTaggedOverrides walks config with w (phaseEnv or phaseFlags) and adds
+// the fields it set to the override state of config.
+func applyTaggedOverrides(path string, config interface{}, w *walker) error {
+	if err := w.walk(reflect.ValueOf(config)); err != nil {
+		return err
+	}
+	if len(w.overridden) == 0 {
+		return nil
+	}
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	state := overrides[config]
+	if state == nil {
+		state = &overrideState{path: path}
+		overrides[config] = state
+	}
+	for _, b := range w.overridden {
+		// A field already overridden keeps the value from the base file.
+		merged := false
+		for i := range state.bindings {
+			if state.bindings[i].field.UnsafeAddr() == b.field.UnsafeAddr() && state.bindings[i].field.Type() == b.field.Type() {
+				state.bindings[i].local = b.local
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			state.bindings = append(state.bindings, b)
+		}
+	}
+	return nil
+}
+
+/*
+ * Set the env-tagged (phaseEnv) or flag-tagged (phaseFlags) fields of one
+ * struct. A password is encrypted into its SecurePassword field as well.
+ */
+func (w *walker) applyTagged(v reflect.Value, info *structInfo, p phase) error {
+	for _, tagged := range info.tagged {
+		var value, name, errKey string
+		var ok bool
+		switch p {
+		case phaseEnv:
+			if tagged.env == "" {
+				continue
+			}
+			value, ok = os.LookupEnv(tagged.env)
+			name, errKey = tagged.env, "config.env_invalid"
+		case phaseFlags:
+			if tagged.flag == "" {
+				continue
+			}
+			value, ok = w.flags[tagged.flag]
+			name, errKey = "-"+tagged.flag, "config.flag_invalid"
+		}
+		if !ok {
+			continue
+		}
+		fieldValue := v.Field(tagged.index)
+		base := deepCopy(fieldValue)
+		if err := setScalarValue(fieldValue, value, tagged.layout); err != nil {
+			return fmt.Errorf("%s", t(errKey, name, info.label(v.Type(), tagged.index), err))
+		}
+		w.overridden = append(w.overridden, overrideBinding{field: fieldValue, base: base, local: deepCopy(fieldValue)})
+		for _, pair := range info.pairs {
+			if pair.plain != tagged.index || isSecretRef(value) {
+				continue
+			}
+			secureValue := v.Field(pair.secure)
+			secureBase := deepCopy(secureValue)
+			secure, err := encrypt(value)
+			if err != nil {
+				return err
+			}
+			secureValue.SetString(secure)
+			rememberSecret(value)
+			w.overridden = append(w.overridden, overrideBinding{field: secureValue, base: secureBase, local: deepCopy(secureValue)})
+		}
+	}
+	return nil
+}
+
+// setScalarValue parses value into the scalar or time.Time field, a time in
+// the layout of the layout tag layout.
+func setScalarValue(field reflect.Value, value, layout string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	if field.Type() == timeType {
+		tm, err := parseTime(value, layout)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("%s", t("config.tag_unsupported", field.Type()))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type envTestConfig struct {
+	Host           string  `json:"host" default:"localhost" env:"SCONFIG_TEST_HOST"`
+	Port           int     `json:"port" default:"5432" env:"SCONFIG_TEST_PORT"`
+	Ratio          float64 `json:"ratio" env:"SCONFIG_TEST_RATIO"`
+	Debug          bool    `json:"debug" env:"SCONFIG_TEST_DEBUG"`
+	Password       string  `json:"password" env:"SCONFIG_TEST_PASSWORD"`
+	SecurePassword string  `json:"secure_password"`
+	Nested         struct {
+		Name string `json:"name" env:"SCONFIG_TEST_NAME"`
+	} `json:"nested"`
+}
+
+func TestLoadConfig_EnvOverrides(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"host": "db.prod", "password": "file-secret", "nested": {"name": "base"}}`), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	ts.Setenv("SCONFIG_TEST_HOST", "db.env")
+	ts.Setenv("SCONFIG_TEST_PORT", "6543")
+	ts.Setenv("SCONFIG_TEST_RATIO", "0.5")
+	ts.Setenv("SCONFIG_TEST_DEBUG", "true")
+	ts.Setenv("SCONFIG_TEST_PASSWORD", "env-secret")
+	ts.Setenv("SCONFIG_TEST_NAME", "env")
+
+	cfg := &envTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Host != "db.env" || cfg.Port != 6543 || cfg.Ratio != 0.5 || !cfg.Debug || cfg.Nested.Name != "env" {
+		ts.Errorf("environment not applied: %+v", cfg)
+	}
+	if cfg.Password != "env-secret" {
+		ts.Errorf("Password = %q", cfg.Password)
+	}
+	if plain, err := decrypt(cfg.SecurePassword); err != nil || plain != "env-secret" {
+		ts.Errorf("SecurePassword decrypts to %q, %v", plain, err)
+	}
+
+	cfg.Debug = false
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "db.env") || strings.Contains(string(raw), "env-secret") {
+		ts.Fatalf("environment values written back:\n%s", raw)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	if doc["host"] != "db.prod" || doc["port"] != float64(5432) || doc["debug"] != false || doc["password"] != PASSWORD_IS_SECURE ||
+		doc["nested"].(map[string]interface{})["name"] != "base" {
+		ts.Errorf("unexpected file:\n%s", raw)
+	}
+	secure, _ := doc["secure_password"].(string)
+	if plain, err := decrypt(secure); err != nil || plain != "file-secret" {
+		ts.Errorf("file password = %q, %v", plain, err)
+	}
+	if cfg.Host != "db.env" || cfg.Password != "env-secret" {
+		ts.Errorf("environment values lost after UpdateConfig: %+v", cfg)
+	}
+
+	ts.Setenv("SCONFIG_TEST_PORT", "many")
+	err := LoadConfig(&envTestConfig{}, 1, configPath, false, false, hw)
+	if err == nil || !strings.Contains(err.Error(), "SCONFIG_TEST_PORT") {
+		ts.Fatalf("expected an invalid value error, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package sconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Evaluated config sources (CUE, Jsonnet).
+ *
+ * Some teams write their configs in a higher-level language and only the
+ * evaluated output is plain data. For a path with a registered extension
+ * LoadConfig runs the evaluator and decodes its JSON output. The source is
+ * never rewritten: everything sconfig writes (encrypted passwords, version,
+ * UpdateConfig) goes to the evaluated output cache "<source>.json" next to
+ * it. On the next load the passwords from the cache are used for every
+ * password pair the source leaves empty, so secrets live encrypted in the
+ * cache and the source can be committed without them.
+ *
+ * By default ".cue" runs "cue export --out json" and ".jsonnet" runs
+ * "jsonnet". If the tool is not installed (e.g. on a production host) and
+ * the cache exists, the cache is loaded instead, with a warning.
+ */
+
+// Evaluator evaluates the config source at path and returns a JSON document.
+type Evaluator func(path string) ([]byte, error)
+
+var (
+	evaluatorsMu sync.Mutex
+	evaluators   = map[string]Evaluator{
+		".cue":     CommandEvaluator("cue", "export", "--out", "json"),
+		".jsonnet": CommandEvaluator("jsonnet"),
+	}
+)
+
+// RegisterEvaluator makes LoadConfig and UpdateConfig treat files with the
+// extension ext (e.g. ".cue") as evaluated sources. A nil eval removes the
+// evaluator for ext.
+func RegisterEvaluator(ext string, eval Evaluator) {
+	ext = strings.ToLower(ext)
+	evaluatorsMu.Lock()
+	defer evaluatorsMu.Unlock()
+	if eval == nil {
+		delete(evaluators, ext)
+		return
+	}
+	evaluators[ext] = eval
+}
+
+// CommandEvaluator returns an Evaluator that runs the command name with args
+// and the source path as last argument and returns its standard output.
+func CommandEvaluator(name string, args ...string) Evaluator {
+	return func(path string) ([]byte, error) {
+		cmd := exec.Command(name, append(append([]string(nil), args...), path)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil && stderr.Len() > 0 {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return out, err
+	}
+}
+
+// evaluatorFor returns the evaluator registered for the extension of path.
+func evaluatorFor(path string) Evaluator {
+	evaluatorsMu.Lock()
+	defer evaluatorsMu.Unlock()
+	return evaluators[strings.ToLower(filepath.Ext(path))]
+}
+
+// evaluatedCachePath returns the evaluated output cache of the source path.
+func evaluatedCachePath(source string) string {
+	return source + ".json"
+}
+
+// decodeEvaluated evaluates source into config and takes the passwords the
+// source leaves empty from the cache. It returns the metadata of the cache and
+// whether the cache is missing or older than the source.
+func decodeEvaluated(source, cache string, config interface{}) (*fileMetadata, bool, error) {
+	data, err := evaluatorFor(source)(source)
+	if err != nil {
+		if _, statErr := os.Stat(cache); errors.Is(err, exec.ErrNotFound) && statErr == nil {
+			warn(t("config.evaluate_cached", source, cache, err))
+			meta, err := decodeConfigFile(cache, config)
+			return meta, false, err
+		}
+		return nil, false, fmt.Errorf("%s", t("config.evaluate_failed", source, err))
+	}
+	defer wipe(data)
+	if err := decodeConfigStream(bytes.NewReader(data), config); err != nil {
+		return nil, false, err
+	}
+	cacheInfo, err := os.Stat(cache)
+	if err != nil {
+		return nil, true, nil
+	}
+	cached := reflect.New(reflect.TypeOf(config).Elem())
+	meta, err := decodeConfigFile(cache, cached.Interface())
+	if err != nil {
+		return nil, false, err
+	}
+	c := &copier{policy: MergeFillEmpty | MergePlaintext}
+	c.mergePairs(reflect.ValueOf(config).Elem(), cached.Elem())
+	stale := true
+	if sourceInfo, err := os.Stat(source); err == nil {
+		stale = sourceInfo.ModTime().After(cacheInfo.ModTime())
+	}
+	return meta, stale, nil
+}
+
+// mergePairs merges only the password pairs of the struct src into dst,
+// including those of nested structs.
+func (c *copier) mergePairs(dst, src reflect.Value) {
+	for _, pair := range getStructInfo(src.Type()).pairs {
+		c.mergePair(dst, src, pair)
+	}
+	for i := 0; i < src.NumField(); i++ {
+		if s := src.Field(i); s.Kind() == reflect.Struct && allExported(s.Type()) && dst.Field(i).CanSet() {
+			c.mergePairs(dst.Field(i), s)
+		}
+	}
+}
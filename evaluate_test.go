@@ -0,0 +1,96 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_EvaluatedSource(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	sourcePath := filepath.Join(tempDir, "app.src")
+	cachePath := sourcePath + ".json"
+	// The test evaluator passes the source through unchanged.
+	RegisterEvaluator(".src", func(path string) ([]byte, error) { return os.ReadFile(path) })
+	defer RegisterEvaluator(".src", nil)
+
+	source := `{"database_host": "db1", "database_password": "s3cret"}`
+	if err := os.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, sourcePath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabaseHost != "db1" || cfg.DatabasePassword != "s3cret" {
+		ts.Fatalf("unexpected config %+v", cfg)
+	}
+	if raw, _ := os.ReadFile(sourcePath); string(raw) != source {
+		ts.Errorf("source was rewritten: %s", raw)
+	}
+	var cached map[string]interface{}
+	raw, err := os.ReadFile(cachePath)
+	if err != nil || json.Unmarshal(raw, &cached) != nil {
+		ts.Fatalf("cache not written: %v", err)
+	}
+	if cached["database_secure_password"] == "" || strings.Contains(string(raw), "s3cret") {
+		ts.Fatalf("password not encrypted in cache: %s", raw)
+	}
+
+	// The secret now lives in the cache only.
+	source = `{"database_host": "db2"}`
+	if err := os.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg = &TestConfig{}
+	if err := LoadConfig(cfg, 1, sourcePath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabaseHost != "db2" || cfg.DatabasePassword != "s3cret" {
+		ts.Fatalf("password not taken from cache: %+v", cfg)
+	}
+
+	cfg.DatabasePort = 6543
+	if err := UpdateConfig(cfg, sourcePath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(sourcePath); string(raw) != source {
+		ts.Errorf("UpdateConfig rewrote the source: %s", raw)
+	}
+	if raw, _ := os.ReadFile(cachePath); !strings.Contains(string(raw), "6543") {
+		ts.Errorf("UpdateConfig did not write the cache: %s", raw)
+	}
+}
+
+func TestLoadConfig_EvaluatorFailure(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	sourcePath := filepath.Join(tempDir, "app.src")
+	if err := os.WriteFile(sourcePath, []byte(`{}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	RegisterEvaluator(".src", func(string) ([]byte, error) { return nil, errors.New("syntax error") })
+	defer RegisterEvaluator(".src", nil)
+	err := LoadConfig(&TestConfig{}, 1, sourcePath, false, false, func() (uint64, error) { return 4711, nil })
+	if err == nil || !contains(err.Error(), "syntax error") {
+		ts.Fatalf("expected evaluation error, got %v", err)
+	}
+
+	// Without the tool the existing cache is loaded.
+	RegisterEvaluator(".src", CommandEvaluator("sconfig-no-such-evaluator"))
+	if err := os.WriteFile(sourcePath+".json", []byte(`{"database_host": "cached"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	var warnings []string
+	SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	defer SetWarningHandler(defaultWarningHandler)
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, sourcePath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabaseHost != "cached" || len(warnings) == 0 {
+		ts.Errorf("cache not used: %+v, warnings %v", cfg, warnings)
+	}
+}
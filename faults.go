@@ -0,0 +1,90 @@
+package sconfig
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Fault injection.
+ *
+ * A program that has a degraded mode (start with cached credentials when the
+ * key cannot be derived, keep running when the config cannot be written)
+ * needs to test it, and the failures behind it are hard to produce on a
+ * developer machine: a changed hardware ID, a ciphertext from another host, a
+ * read-only file system, a hanging probe command. SetFaultsForTest makes
+ * sconfig fail in exactly these places, deterministically, until it is
+ * called with nil:
+ *
+ *	sconfig.SetFaultsForTest(&sconfig.Faults{Write: fs.ErrPermission})
+ *	defer sconfig.SetFaultsForTest(nil)
+ *
+ * The injected errors take the same paths as real ones: an injected
+ * decryption failure is counted in DecryptFailures and reported like a
+ * wrong key, an injected write error is wrapped like a failed rename.
+ */
+
+// Faults selects the failures sconfig simulates. The zero value simulates
+// none.
+type Faults struct {
+	// HardwareID is returned instead of collecting the hardware ID, also
+	// from a function passed WithHardwareIDFunc.
+	HardwareID error
+	// Decrypt maps fields to the error their decryption fails with: the
+	// <Name> of a <Name>Password pair or the Go name of a secure-tagged
+	// field. The key "*" matches every field.
+	Decrypt map[string]error
+	// Write is returned by every write of a config file, also of its last
+	// good copy, before anything is written.
+	Write error
+	// ProbeDelay is added to every hardware probe (command or file).
+	ProbeDelay time.Duration
+}
+
+var activeFaults atomic.Pointer[Faults]
+
+// SetFaultsForTest makes sconfig simulate the failures of f; nil stops the
+// simulation. When a HardwareID fault is set or removed, the key is derived
+// again at the next LoadConfig, so that it takes effect. For tests only.
+func SetFaultsForTest(f *Faults) {
+	old := activeFaults.Swap(f)
+	if old != nil && old.HardwareID != nil || f != nil && f.HardwareID != nil {
+		invalidateKey()
+	}
+}
+
+// hardwareIDFault returns the simulated hardware ID failure, nil if none.
+func hardwareIDFault() error {
+	if f := activeFaults.Load(); f != nil {
+		return f.HardwareID
+	}
+	return nil
+}
+
+// decryptFault returns the simulated decryption failure of the field name,
+// nil if none.
+func decryptFault(name string) error {
+	f := activeFaults.Load()
+	if f == nil {
+		return nil
+	}
+	if err, ok := f.Decrypt[name]; ok {
+		return err
+	}
+	return f.Decrypt["*"]
+}
+
+// writeFault returns the simulated write failure, nil if none.
+func writeFault() error {
+	if f := activeFaults.Load(); f != nil {
+		return f.Write
+	}
+	return nil
+}
+
+// probeDelay waits for the simulated delay of a hardware probe.
+func probeDelay() {
+	if f := activeFaults.Load(); f != nil && f.ProbeDelay > 0 {
+		time.Sleep(f.ProbeDelay)
+	}
+}
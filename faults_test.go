@@ -0,0 +1,61 @@
+package sconfig
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetFaultsForTest(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	defer SetFaultsForTest(nil)
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "faults.json")
+	cfg := &TestConfig{DatabasePassword: "db-secret", APIKey: "api-key"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	errNoHardware := errors.New("no hardware")
+	SetFaultsForTest(&Faults{HardwareID: errNoHardware})
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); !errors.Is(err, errNoHardware) {
+		ts.Errorf("expected the hardware ID fault, got %v", err)
+	}
+
+	errWrongKey := errors.New("wrong key")
+	SetFaultsForTest(&Faults{Decrypt: map[string]error{"Database": errWrongKey}})
+	before := DecryptFailures()
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); err == nil {
+		ts.Error("expected the decryption fault of Database")
+	}
+	if DecryptFailures() != before+1 {
+		ts.Error("the decryption fault was not counted")
+	}
+
+	SetFaultsForTest(&Faults{Write: fs.ErrPermission})
+	cfg.DatabaseHost = "db.new"
+	if err := UpdateConfig(cfg, configPath); !errors.Is(err, fs.ErrPermission) {
+		ts.Errorf("expected the write fault, got %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if contains(string(raw), "db.new") {
+		ts.Error("the file was written despite the write fault")
+	}
+
+	SetFaultsForTest(&Faults{ProbeDelay: 50 * time.Millisecond})
+	start := time.Now()
+	probeFile(filepath.Join(tempDir, "no-such-probe"))
+	if time.Since(start) < 50*time.Millisecond {
+		ts.Error("the probe was not delayed")
+	}
+
+	SetFaultsForTest(nil)
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); err != nil {
+		ts.Errorf("LoadConfig after the faults failed: %v", err)
+	}
+}
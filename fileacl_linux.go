@@ -0,0 +1,10 @@
+package sconfig
+
+import "syscall"
+
+// hasExtendedACL reports whether the file at path has a POSIX ACL beyond its
+// mode bits.
+func hasExtendedACL(path string) bool {
+	n, err := syscall.Getxattr(path, "system.posix_acl_access", nil)
+	return err == nil && n > 0
+}
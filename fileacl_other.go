@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sconfig
+
+// hasExtendedACL is only implemented on Linux.
+func hasExtendedACL(path string) bool {
+	return false
+}
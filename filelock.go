@@ -0,0 +1,170 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Cross-process file locks.
+ *
+ * A rewrite that keeps parts of the file written by other processes (a
+ * namespace of a shared file, see namespace.go, or fields changed by another
+ * writer, see concurrency.go) reads the file, changes its own part and
+ * renames a new file into place. Two processes doing this at the
+ * same time would each drop the other's change, so the read-modify-write
+ * runs under an exclusive lock. The config file itself cannot carry the lock,
+ * as the rename replaces it; the lock is held on the sidecar file
+ * <path>.lock, which is created on first use and left in place (removing it
+ * would let a waiting process lock a file that no longer has a name).
+ *
+ * On network shares flock and byte-range locks are unreliable: NFS clients
+ * without a lock daemon grant every lock, and SMB mounts may lock only on the
+ * local client. There the lock is the existence of the sentinel file
+ * <path>.lck, created with O_EXCL, which NFSv3 and later and SMB perform
+ * atomically on the server, and removed on release. A sentinel left behind by
+ * a crashed writer is taken over once it is older than sentinelStale; a
+ * rewrite takes far less. LockAuto, the default, uses the sentinel for files on
+ * network filesystems (see isNetworkFS) and flock elsewhere; all writers of
+ * one file must agree on the strategy.
+ */
+
+// LockStrategy selects how rewrites of a config file exclude each other.
+type LockStrategy int
+
+const (
+	// LockAuto uses LockSentinel on network filesystems, LockFlock elsewhere.
+	LockAuto LockStrategy = iota
+	// LockFlock locks <path>.lock with flock (LockFileEx on Windows).
+	LockFlock
+	// LockSentinel creates the sentinel file <path>.lck with O_EXCL.
+	LockSentinel
+)
+
+const (
+	sentinelRetry   = 50 * time.Millisecond // between attempts to create the sentinel
+	sentinelTimeout = 30 * time.Second      // longest wait for the sentinel
+	sentinelStale   = 2 * time.Minute       // age after which a sentinel is left over
+)
+
+var (
+	lockStrategiesMu sync.Mutex
+	lockStrategies   = map[string]LockStrategy{} // config file -> strategy given to Load
+)
+
+// WithLockStrategy sets how rewrites of the config file lock it, for this
+// load and all later loads and rewrites of the file in the process.
+func WithLockStrategy(s LockStrategy) LoadOption {
+	return func(o *loadOptions) {
+		o.lockStrategy = s
+		o.lockStrategySet = true
+	}
+}
+
+// setLockStrategy remembers the strategy for the config file at path.
+func setLockStrategy(path string, s LockStrategy) {
+	lockStrategiesMu.Lock()
+	defer lockStrategiesMu.Unlock()
+	if s == LockAuto {
+		delete(lockStrategies, path)
+	} else {
+		lockStrategies[path] = s
+	}
+}
+
+// lockStrategyFor returns the strategy for the config file at path, with
+// LockAuto resolved.
+func lockStrategyFor(path string) LockStrategy {
+	lockStrategiesMu.Lock()
+	s := lockStrategies[path]
+	lockStrategiesMu.Unlock()
+	if s != LockAuto {
+		return s
+	}
+	if isNetworkFS(filepath.Dir(path)) {
+		return LockSentinel
+	}
+	return LockFlock
+}
+
+// lockConfigFile takes the exclusive lock for rewriting the config file at
+// path, waiting for other processes that hold it, and returns the function
+// that releases it.
+func lockConfigFile(path string) (func(), error) {
+	if lockStrategyFor(path) == LockSentinel {
+		return lockSentinel(path)
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.lock_failed", path, err))
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s", t("config.lock_failed", path, err))
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// lockSentinel takes the lock of the config file at path by creating its
+// sentinel file, waiting up to sentinelTimeout for another holder.
+func lockSentinel(path string) (func(), error) {
+	name := path + ".lck"
+	deadline := time.Now().Add(sentinelTimeout)
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			host, _ := os.Hostname()
+			fmt.Fprintf(f, "%s %d\n", host, os.Getpid())
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("%s", t("config.lock_failed", path, err))
+		}
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > sentinelStale {
+			staleSentinelFound()
+			takeOverSentinel(name) // left by a crashed writer
+			continue
+		}
+		if time.Now().After(deadline) {
+			owner, _ := os.ReadFile(name)
+			return nil, fmt.Errorf("%s", t("config.lock_failed", path, t("config.lock_held", name, strings.TrimSpace(string(owner)))))
+		}
+		time.Sleep(sentinelRetry)
+	}
+}
+
+var (
+	sentinelMoves      atomic.Int64 // makes the names of moved sentinels unique
+	staleSentinelFound = func() {}  // for tests: between finding a stale sentinel and taking it over
+)
+
+// takeOverSentinel removes the stale sentinel name. Removing it by name could
+// remove the new sentinel of another waiter that took it over first, so it
+// is renamed to a name of its own first: of several waiters only one moves
+// it, and one that moved a new sentinel instead, found fresh again, puts it
+// back. Link fails if the file system has no hard links; Rename then puts it
+// back.
+func takeOverSentinel(name string) {
+	host, _ := os.Hostname()
+	moved := fmt.Sprintf("%s.stale-%s-%d-%d", name, host, os.Getpid(), sentinelMoves.Add(1))
+	if err := os.Rename(name, moved); err != nil {
+		return // moved by another waiter
+	}
+	if info, err := os.Stat(moved); err == nil && time.Since(info.ModTime()) <= sentinelStale {
+		// Unlike Rename, Link does not replace a sentinel created meanwhile.
+		if err := os.Link(moved, name); err != nil && !os.IsExist(err) {
+			os.Rename(moved, name)
+			return
+		}
+	}
+	os.Remove(moved)
+}
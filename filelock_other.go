@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package sconfig
+
+import "os"
+
+// lockFile is a no-op where the platform has no file locks; rewrites are
+// then only serialized within the process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package sconfig
+
+import (
+	"os"
+	"reflect"
+	"sync"
+)
+
+/*
+ * File permissions of rewrites.
+ *
+ * A rewrite keeps the permissions of the file it replaces; a new file gets
+ * 0644. A file holding ciphertexts (a <Name>SecurePassword or secure-tagged
+ * field that is not empty) is written 0600 instead, or stricter if the file
+ * was: the ciphertexts and the secure markers tell other local users which
+ * secrets exist, and the ciphertexts are one hardware ID away from the
+ * plaintext. WithFileMode sets the mode of every rewrite of a file, e.g. 0640
+ * for a service group that reads the config.
+ */
+
+// secretFileMode is the mode of a config file holding ciphertexts.
+const secretFileMode os.FileMode = 0600
+
+var (
+	fileModesMu sync.Mutex
+	fileModes   = map[string]os.FileMode{} // config file -> mode given to Load
+)
+
+// WithFileMode sets the permissions of the config file written by this load
+// and all later rewrites of the file in the process, instead of keeping the
+// permissions of the file or restricting them to 0600 for a file with
+// encrypted passwords.
+func WithFileMode(mode os.FileMode) LoadOption {
+	return func(o *loadOptions) {
+		o.fileMode = mode.Perm()
+		o.fileModeSet = true
+	}
+}
+
+// setFileMode remembers the mode for the config file at path.
+func setFileMode(path string, mode os.FileMode) {
+	fileModesMu.Lock()
+	defer fileModesMu.Unlock()
+	fileModes[path] = mode
+}
+
+// writeModeFor returns the mode of a rewrite of the config file at path with
+// config, whose passwords are encrypted; mode is the mode of the existing
+// file, or 0644 for a new one.
+func writeModeFor(path string, config interface{}, mode os.FileMode) os.FileMode {
+	fileModesMu.Lock()
+	set, ok := fileModes[path]
+	fileModesMu.Unlock()
+	if ok {
+		return set
+	}
+	w := &walker{phases: phaseCount}
+	if err := w.walk(reflect.ValueOf(config)); err != nil || w.secrets == 0 {
+		return mode
+	}
+	if restricted := mode & secretFileMode; restricted != 0 {
+		return restricted
+	}
+	return secretFileMode
+}
@@ -0,0 +1,89 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLoadConfig_FileMode(ts *testing.T) {
+	if runtime.GOOS == "windows" {
+		ts.Skip("no Unix permissions on Windows")
+	}
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	mode := func(path string) os.FileMode {
+		info, err := os.Stat(path)
+		if err != nil {
+			ts.Fatalf("Stat failed: %v", err)
+		}
+		return info.Mode().Perm()
+	}
+
+	// Without passwords a new file gets 0644 and an existing one keeps its mode.
+	plainPath := filepath.Join(tempDir, "plain.json")
+	reports := &reportsTestConfig{}
+	if err := LoadConfig(reports, 1, plainPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if got := mode(plainPath); got != 0644 {
+		ts.Errorf("new file without passwords: mode %o, want 644", got)
+	}
+	if err := os.Chmod(plainPath, 0640); err != nil {
+		ts.Fatal(err)
+	}
+	reports.Title = "Weekly"
+	if err := UpdateConfig(reports, plainPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if got := mode(plainPath); got != 0640 {
+		ts.Errorf("rewrite without passwords: mode %o, want 640", got)
+	}
+
+	// An encrypted password restricts the file to its owner, or keeps it
+	// stricter.
+	securePath := filepath.Join(tempDir, "secure.json")
+	if err := os.WriteFile(securePath, []byte(`{"database_password": "mode-secret"}`), 0644); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, securePath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if got := mode(securePath); got != 0600 {
+		ts.Errorf("rewrite with a password: mode %o, want 600", got)
+	}
+	if err := os.Chmod(securePath, 0400); err != nil {
+		ts.Fatal(err)
+	}
+	cfg.DatabaseHost = "db.internal"
+	if err := UpdateConfig(cfg, securePath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if got := mode(securePath); got != 0400 {
+		ts.Errorf("rewrite of a read-only file: mode %o, want 400", got)
+	}
+
+	// WithFileMode applies to the load and the later rewrites.
+	groupPath := filepath.Join(tempDir, "group.json")
+	if err := os.WriteFile(groupPath, []byte(`{"database_password": "group-secret"}`), 0644); err != nil {
+		ts.Fatal(err)
+	}
+	grouped := &TestConfig{}
+	if err := Load(grouped, groupPath, WithHardwareIDFunc(hw), WithVersion(1), WithFileMode(0640)); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if got := mode(groupPath); got != 0640 {
+		ts.Errorf("WithFileMode(0640): mode %o", got)
+	}
+	grouped.DatabasePort = 6543
+	if err := UpdateConfig(grouped, groupPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if got := mode(groupPath); got != 0640 {
+		ts.Errorf("rewrite after WithFileMode(0640): mode %o", got)
+	}
+}
@@ -0,0 +1,143 @@
+package sconfig
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+ * Command-line flags.
+ *
+ * CLIs built on sconfig expose config overrides as flags. BindFlags registers
+ * a flag for every field tagged `flag:"name"` with a flag.FlagSet; after
+ * fs.Parse, LoadConfig applies the flags given on the command line over the
+ * file, the override file and the environment:
+ *
+ *	DatabaseHost     string `json:"database_host" flag:"db-host" desc:"database server"`
+ *	DatabasePassword string `json:"database_password" flag:"db-password"`
+ *
+ *	sconfig.BindFlags(&cfg, flag.CommandLine)
+ *	flag.Parse()
+ *	err := sconfig.LoadConfig(&cfg, 1, "config.json", false, false)
+ *
+ * The flags are handled like the env tag (see env.go): in memory only, not
+ * written back by UpdateConfig, and a password flag is encrypted into its
+ * SecurePassword field. The `desc:"..."` text is the usage of the flag.
+ */
+
+// flagValue is a flag registered by BindFlags. It keeps the text given on
+// the command line; LoadConfig sets the field from it.
+type flagValue struct {
+	typ    reflect.Type
+	layout string // `layout:"..."` of a time.Time field
+	value  string
+	isSet  bool
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+// Set checks that s parses as the type of the field.
+func (f *flagValue) Set(s string) error {
+	if err := setScalarValue(reflect.New(f.typ).Elem(), s, f.layout); err != nil {
+		return err
+	}
+	f.value, f.isSet = s, true
+	return nil
+}
+
+// IsBoolFlag lets bool fields be set with -name alone.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+var (
+	flagBindingsMu sync.Mutex
+	flagBindings   = map[interface{}]map[string]*flagValue{}
+)
+
+// BindFlags registers a flag with fs for every field of config (a pointer to
+// a struct) tagged `flag:"name"`, including nested structs. Call it before
+// fs.Parse; LoadConfig and Load then apply the flags that were given. A
+// tagged field of a type that is not a string, number, bool or time.Time
+// makes BindFlags fail.
+func BindFlags(config interface{}, fs *flag.FlagSet) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	values := make(map[string]*flagValue)
+	if err := bindFlags(v.Elem().Type(), fs, values); err != nil {
+		return err
+	}
+	flagBindingsMu.Lock()
+	flagBindings[config] = values
+	flagBindingsMu.Unlock()
+	return nil
+}
+
+func bindFlags(typ reflect.Type, fs *flag.FlagSet, values map[string]*flagValue) error {
+	info := getStructInfo(typ)
+	for _, tagged := range info.tagged {
+		if tagged.flag == "" || values[tagged.flag] != nil {
+			continue
+		}
+		field := typ.Field(tagged.index)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			if field.Type != timeType {
+				return fmt.Errorf("%s", t("config.tag_unsupported", field.Type))
+			}
+		case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface, reflect.Array:
+			return fmt.Errorf("%s", t("config.tag_unsupported", field.Type))
+		}
+		usage := info.descs[tagged.index]
+		if usage == "" {
+			key, _ := fileKey(field)
+			usage = t("config.flag_usage", key)
+		}
+		value := &flagValue{typ: field.Type, layout: tagged.layout, isBool: field.Type.Kind() == reflect.Bool}
+		values[tagged.flag] = value
+		fs.Var(value, tagged.flag, usage)
+	}
+	for _, i := range info.nested {
+		if err := bindFlags(typ.Field(i).Type, fs, values); err != nil {
+			return err
+		}
+	}
+	for _, i := range info.slices {
+		elem := typ.Field(i).Type.Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if err := bindFlags(elem, fs, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyFlagOverrides sets the fields of the flags given on the command line,
+// see BindFlags, and remembers them like the fields of the override file of
+// path.
+func applyFlagOverrides(path string, config interface{}) error {
+	flagBindingsMu.Lock()
+	given := make(map[string]string)
+	for name, value := range flagBindings[config] {
+		if value.isSet {
+			given[name] = value.value
+		}
+	}
+	flagBindingsMu.Unlock()
+	if len(given) == 0 {
+		return nil
+	}
+	return applyTaggedOverrides(path, config, &walker{phases: phaseFlags, flags: given})
+}
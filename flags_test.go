@@ -0,0 +1,70 @@
+package sconfig
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type flagTestConfig struct {
+	Host           string `json:"host" flag:"db-host" desc:"database server"`
+	Port           int    `json:"port" default:"5432" flag:"db-port" env:"SCONFIG_TEST_FLAG_PORT"`
+	Debug          bool   `json:"debug" flag:"debug"`
+	Password       string `json:"password" flag:"db-password"`
+	SecurePassword string `json:"secure_password"`
+}
+
+func TestBindFlags(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"host": "db.prod", "password": "file-secret"}`), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	ts.Setenv("SCONFIG_TEST_FLAG_PORT", "7000")
+
+	cfg := &flagTestConfig{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := BindFlags(cfg, fs); err != nil {
+		ts.Fatalf("BindFlags failed: %v", err)
+	}
+	if f := fs.Lookup("db-host"); f == nil || f.Usage != "database server" {
+		ts.Fatalf("flag db-host not registered with its description: %+v", f)
+	}
+	if err := fs.Parse([]string{"-db-port", "many"}); err == nil {
+		ts.Fatal("expected a parse error for a non-numeric port")
+	}
+	if err := fs.Parse([]string{"-db-host", "db.flag", "-db-port", "6543", "-debug", "-db-password", "flag-secret"}); err != nil {
+		ts.Fatalf("Parse failed: %v", err)
+	}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	// The flag wins over the environment.
+	if cfg.Host != "db.flag" || cfg.Port != 6543 || !cfg.Debug || cfg.Password != "flag-secret" {
+		ts.Errorf("flags not applied: %+v", cfg)
+	}
+	if plain, err := decrypt(cfg.SecurePassword); err != nil || plain != "flag-secret" {
+		ts.Errorf("SecurePassword decrypts to %q, %v", plain, err)
+	}
+
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "db.flag") || strings.Contains(string(raw), "flag-secret") || !strings.Contains(string(raw), "db.prod") {
+		ts.Errorf("flag values written back:\n%s", raw)
+	}
+
+	if err := BindFlags(&struct {
+		Hosts []string `flag:"hosts"`
+	}{}, flag.NewFlagSet("test", flag.ContinueOnError)); err == nil {
+		ts.Error("expected an error for a slice field")
+	}
+}
@@ -0,0 +1,633 @@
+package sconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Config file formats.
+ *
+ * The struct is mapped through its `json` tags: YAML and TOML files are
+ * transcoded to JSON before decoding and the JSON output is transcoded back
+ * when writing. XML is the exception: legacy XML configs are decoded and
+ * encoded with encoding/xml and the struct's `xml` tags. Defaults, version
+ * sync and password handling work on the struct and therefore behave
+ * identically for every format.
+ *
+ * JSON files may contain line (//) and block comments and trailing commas
+ * (JSONC); they are blanked out before decoding. A write-back keeps them
+ * (rewrite.go).
+ *
+ * The format is chosen by extension (.json/.jsonc, .yaml/.yml, .toml, .xml). For other
+ * extensions (.conf, .txt, none) the content is sniffed, and the detected
+ * format is recorded per path so that a write-back uses the same serializer.
+ */
+
+// Format identifies the serialization of a config file.
+type Format int
+
+const (
+	// FormatUnknown means the format could not be determined from the
+	// extension; the content has to be sniffed.
+	FormatUnknown Format = iota
+	// FormatJSON is plain JSON.
+	FormatJSON
+	// FormatYAML is YAML 1.2.
+	FormatYAML
+	// FormatTOML is TOML 1.0.
+	FormatTOML
+	// FormatXML is XML mapped through the struct's `xml` tags (encoding/xml).
+	FormatXML
+)
+
+// String returns the lower-case name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatYAML:
+		return "yaml"
+	case FormatTOML:
+		return "toml"
+	case FormatXML:
+		return "xml"
+	}
+	return "unknown"
+}
+
+// formatFromExtension maps well-known extensions to their format.
+func formatFromExtension(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonc":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	case ".xml":
+		return FormatXML
+	}
+	return FormatUnknown
+}
+
+var (
+	tomlTableLine = regexp.MustCompile(`^\[\[?\s*[A-Za-z0-9_."' -]+\s*\]\]?$`)
+	tomlKeyLine   = regexp.MustCompile(`^[A-Za-z0-9_."'-]+(\s*\.\s*[A-Za-z0-9_"'-]+)*\s*=`)
+	yamlKeyLine   = regexp.MustCompile(`^(-\s+)?("[^"]*"|'[^']*'|[^\s:#"'][^:#]*?)\s*:(\s|$)`)
+)
+
+// sniffFormat guesses the format of content. JSON wins when the document is
+// an object, XML when it starts with a tag, TOML when the first significant line is a table header or a
+// `key = value` assignment, YAML when it is a `key: value` mapping or a
+// document marker. Empty content is treated as JSON.
+func sniffFormat(content []byte) Format {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	if len(trimmed) == 0 || trimmed[0] == '{' || bytes.HasPrefix(trimmed, []byte("//")) || bytes.HasPrefix(trimmed, []byte("/*")) {
+		return FormatJSON
+	}
+	if trimmed[0] == '[' && json.Valid(trimmed) {
+		return FormatJSON
+	}
+	if trimmed[0] == '<' {
+		return FormatXML
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case line == "---" || strings.HasPrefix(line, "%YAML"):
+			return FormatYAML
+		case tomlTableLine.MatchString(line), tomlKeyLine.MatchString(line):
+			return FormatTOML
+		case yamlKeyLine.MatchString(line):
+			return FormatYAML
+		}
+		break
+	}
+	return FormatJSON
+}
+
+// detectedFormats remembers the format sniffed for a path (by absolute path).
+var (
+	detectedFormatsMu sync.Mutex
+	detectedFormats   = map[string]Format{}
+)
+
+func recordFormat(path string, f Format) {
+	detectedFormatsMu.Lock()
+	detectedFormats[path] = f
+	detectedFormatsMu.Unlock()
+}
+
+// formatForRead determines the format of the file at path with the given
+// content and records it for later writes.
+func formatForRead(path string, content []byte) Format {
+	f := formatFromExtension(path)
+	if f == FormatUnknown {
+		f = sniffFormat(content)
+	}
+	recordFormat(path, f)
+	return f
+}
+
+// formatForWrite determines the serializer for path: the extension, else the
+// format recorded when the file was read, else JSON.
+func formatForWrite(path string) Format {
+	if f := formatFromExtension(path); f != FormatUnknown {
+		return f
+	}
+	detectedFormatsMu.Lock()
+	defer detectedFormatsMu.Unlock()
+	if f, ok := detectedFormats[path]; ok {
+		return f
+	}
+	return FormatJSON
+}
+
+// errXMLTranscode is returned when XML would have to be transcoded: XML is
+// mapped through the struct's `xml` tags, which JSON cannot express.
+var errXMLTranscode = errors.New("xml configs can only be read and written through a struct")
+
+// toJSON transcodes content in format f to JSON.
+func toJSON(f Format, content []byte) ([]byte, error) {
+	switch f {
+	case FormatYAML:
+		return yamlToJSON(content)
+	case FormatTOML:
+		return tomlToJSON(content)
+	case FormatXML:
+		return nil, errXMLTranscode
+	}
+	return stripJSONC(content), nil
+}
+
+// fromJSON transcodes the JSON document data to format f.
+func fromJSON(f Format, data []byte) ([]byte, error) {
+	switch f {
+	case FormatYAML:
+		return jsonToYAML(data)
+	case FormatTOML:
+		return jsonToTOML(data)
+	case FormatXML:
+		return nil, errXMLTranscode
+	}
+	return data, nil
+}
+
+/*
+ * JSONC
+ */
+
+// stripJSONC replaces the comments and trailing commas of the JSON document
+// content by spaces; see jsoncReader. Content without any returns unchanged.
+func stripJSONC(content []byte) []byte {
+	if !bytes.ContainsAny(content, "/,") {
+		return content
+	}
+	out, _ := io.ReadAll(newJSONCReader(bytes.NewReader(content)))
+	return out
+}
+
+// jsoncReader reads a JSON document with the comments and trailing commas
+// replaced by spaces. Line breaks stay, so positions in decoding errors still
+// match the file. It streams: a comma and the blanks after it are held back
+// only until the next significant byte shows whether the comma is trailing.
+type jsoncReader struct {
+	in      io.Reader
+	buf     []byte
+	pending []byte // a comma and the blanks after it
+	out     []byte // ready to be read
+	off     int    // read position in out
+	state   byte   // 0, '"' string, '\\' escape, '/' slash, 'l' line comment, '*' block comment, 'e' block comment end
+	err     error
+}
+
+func newJSONCReader(r io.Reader) *jsoncReader {
+	return &jsoncReader{in: r, buf: make([]byte, 32*1024)}
+}
+
+func (r *jsoncReader) Read(p []byte) (int, error) {
+	for r.off == len(r.out) && r.err == nil {
+		r.out, r.off = r.out[:0], 0
+		n, err := r.in.Read(r.buf)
+		r.process(r.buf[:n])
+		if err != nil {
+			r.err = err
+			if r.state == '/' {
+				r.emit('/')
+			}
+			r.flush(false)
+		}
+	}
+	if r.off == len(r.out) {
+		return 0, r.err
+	}
+	n := copy(p, r.out[r.off:])
+	r.off += n
+	return n, nil
+}
+
+// process handles a chunk of input. Runs of bytes that need no decision
+// (inside strings, or between the special bytes with nothing pending) are
+// copied at once.
+func (r *jsoncReader) process(chunk []byte) {
+	for i := 0; i < len(chunk); {
+		var special string
+		switch {
+		case r.state == '"':
+			special = "\"\\"
+		case r.state == 0 && len(r.pending) == 0:
+			special = "/,\"}]"
+		}
+		if special != "" {
+			j := bytes.IndexAny(chunk[i:], special)
+			if j < 0 {
+				r.out = append(r.out, chunk[i:]...)
+				return
+			}
+			r.out = append(r.out, chunk[i:i+j]...)
+			i += j
+		}
+		r.next(chunk[i])
+		i++
+	}
+}
+
+// next processes one input byte.
+func (r *jsoncReader) next(c byte) {
+	switch r.state {
+	case '"':
+		switch c {
+		case '\\':
+			r.state = '\\'
+		case '"':
+			r.state = 0
+		}
+		r.out = append(r.out, c)
+		return
+	case '\\':
+		r.state = '"'
+		r.out = append(r.out, c)
+		return
+	case 'l':
+		if c == '\n' {
+			r.state = 0
+		}
+		r.blank(c)
+		return
+	case '*', 'e':
+		switch {
+		case r.state == 'e' && c == '/':
+			r.state = 0
+		case c == '*':
+			r.state = 'e'
+		default:
+			r.state = '*'
+		}
+		r.blank(c)
+		return
+	case '/':
+		switch c {
+		case '/':
+			r.state = 'l'
+			r.blank('/')
+			r.blank(c)
+			return
+		case '*':
+			r.state = '*'
+			r.blank('/')
+			r.blank(c)
+			return
+		}
+		r.state = 0
+		r.emit('/')
+	}
+	switch c {
+	case '/':
+		r.state = '/'
+	case ' ', '\t', '\n', '\r':
+		r.blank(c)
+	case ',':
+		r.flush(false)
+		r.pending = append(r.pending, c)
+	case '}', ']':
+		r.flush(true)
+		r.out = append(r.out, c)
+	default:
+		r.emit(c)
+		if c == '"' {
+			r.state = '"'
+		}
+	}
+}
+
+// emit writes the significant byte c after what is pending.
+func (r *jsoncReader) emit(c byte) {
+	r.flush(false)
+	r.out = append(r.out, c)
+}
+
+// blank writes c, or a space for it, where it belongs: behind a pending
+// comma, else to the output.
+func (r *jsoncReader) blank(c byte) {
+	if c != '\n' && c != '\r' && c != '\t' {
+		c = ' '
+	}
+	if len(r.pending) > 0 {
+		r.pending = append(r.pending, c)
+	} else {
+		r.out = append(r.out, c)
+	}
+}
+
+// flush writes the pending comma and blanks; a trailing comma becomes a
+// space.
+func (r *jsoncReader) flush(trailing bool) {
+	if len(r.pending) == 0 {
+		return
+	}
+	if trailing {
+		r.pending[0] = ' '
+	}
+	r.out = append(r.out, r.pending...)
+	r.pending = r.pending[:0]
+}
+
+/*
+ * YAML
+ */
+
+func yamlToJSON(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 { // empty document
+		return []byte("{}"), nil
+	}
+	var buf bytes.Buffer
+	if err := writeYAMLNodeAsJSON(&buf, &doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeYAMLNodeAsJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeYAMLNodeAsJSON(buf, n.Content[0])
+	case yaml.AliasNode:
+		return writeYAMLNodeAsJSON(buf, n.Alias)
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeYAMLNodeAsJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		first := true
+		var writePairs func(m *yaml.Node) error
+		writePairs = func(m *yaml.Node) error {
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				key, value := m.Content[i], m.Content[i+1]
+				if key.Tag == "!!merge" {
+					for value.Kind == yaml.AliasNode {
+						value = value.Alias
+					}
+					if value.Kind != yaml.MappingNode {
+						return fmt.Errorf("yaml: line %d: merge value is not a mapping", value.Line)
+					}
+					if err := writePairs(value); err != nil {
+						return err
+					}
+					continue
+				}
+				if !first {
+					buf.WriteByte(',')
+				}
+				first = false
+				name, _ := json.Marshal(key.Value)
+				buf.Write(name)
+				buf.WriteByte(':')
+				if err := writeYAMLNodeAsJSON(buf, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := writePairs(n); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+		return nil
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			buf.WriteString("null")
+		case "!!bool":
+			var b bool
+			if err := n.Decode(&b); err != nil {
+				return err
+			}
+			buf.WriteString(strconv.FormatBool(b))
+		case "!!int":
+			var i int64
+			if err := n.Decode(&i); err != nil {
+				var u uint64
+				if err2 := n.Decode(&u); err2 != nil {
+					return err
+				}
+				buf.WriteString(strconv.FormatUint(u, 10))
+				return nil
+			}
+			buf.WriteString(strconv.FormatInt(i, 10))
+		case "!!float":
+			var f float64
+			if err := n.Decode(&f); err != nil {
+				return err
+			}
+			if math.IsInf(f, 0) || math.IsNaN(f) {
+				return fmt.Errorf("yaml: line %d: %s cannot be represented in a config value", n.Line, n.Value)
+			}
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		default: // !!str, !!timestamp, !!binary and custom tags keep their text
+			value, _ := json.Marshal(n.Value)
+			buf.Write(value)
+		}
+		return nil
+	}
+	return fmt.Errorf("yaml: line %d: unsupported node", n.Line)
+}
+
+// jsonToYAML converts a JSON document into YAML, keeping the key order of the
+// JSON output (which is the struct field order).
+func jsonToYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := jsonTokensToYAMLNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonTokensToYAMLNode(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keyTok.(string)}
+				value, err := jsonTokensToYAMLNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, key, value)
+			}
+			_, err := dec.Token() // '}'
+			return n, err
+		case '[':
+			n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for dec.More() {
+				item, err := jsonTokensToYAMLNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, item)
+			}
+			_, err := dec.Token() // ']'
+			return n, err
+		}
+	case string:
+		n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if strings.Contains(v, "\n") {
+			n.Style = yaml.LiteralStyle
+		}
+		return n, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(string(v), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(v)}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+/*
+ * TOML
+ */
+
+func tomlToJSON(content []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if _, err := toml.NewDecoder(bytes.NewReader(content)).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return json.Marshal(doc)
+}
+
+// jsonToTOML converts a JSON object into TOML. TOML has no null, so null
+// values are omitted; they decode back to the zero value.
+func jsonToTOML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(tomlValue(doc)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tomlValue prepares a decoded JSON value for the TOML encoder: numbers get
+// their native type and nulls are dropped.
+func tomlValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			if item == nil {
+				continue
+			}
+			out[k] = tomlValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(x))
+		for _, item := range x {
+			if item == nil {
+				continue
+			}
+			out = append(out, tomlValue(item))
+		}
+		return out
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return string(x)
+	}
+	return v
+}
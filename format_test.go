@@ -0,0 +1,316 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSniffFormat(ts *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    Format
+	}{
+		{"empty", "", FormatJSON},
+		{"json object", "  {\"a\": 1}", FormatJSON},
+		{"json with BOM", "\xef\xbb\xbf{\"a\": 1}", FormatJSON},
+		{"yaml mapping", "# comment\nname: value\nport: 80\n", FormatYAML},
+		{"yaml document marker", "---\nname: value\n", FormatYAML},
+		{"yaml quoted key", "\"database host\": db\n", FormatYAML},
+		{"toml assignment", "# comment\nname = \"value\"\n", FormatTOML},
+		{"toml table", "[server]\nport = 80\n", FormatTOML},
+		{"toml array of tables", "[[servers]]\nport = 80\n", FormatTOML},
+		{"toml dotted key", "server.port = 80\n", FormatTOML},
+		{"jsonc leading comment", "// generated\n{\"a\": 1}", FormatJSON},
+		{"jsonc leading block comment", "/* app */ {\"a\": 1}", FormatJSON},
+	}
+	for _, c := range cases {
+		if got := sniffFormat([]byte(c.content)); got != c.want {
+			ts.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestStripJSONC(ts *testing.T) {
+	cases := []struct {
+		name, content, want string
+	}{
+		{"plain", `{"a": [1, 2]}`, `{"a": [1, 2]}`},
+		{"line comment", "{\"a\": 1 // port\n}", "{\"a\": 1        \n}"},
+		{"block comment", `{/* x */"a": 1}`, `{       "a": 1}`},
+		{"multi-line block comment", "{/*\n x **/\"a\": 1}", "{  \n      \"a\": 1}"},
+		{"trailing commas", "{\"a\": [1, 2,], \"b\": 3,\n}", "{\"a\": [1, 2 ], \"b\": 3 \n}"},
+		{"comma before comment", "{\"a\": 1, // last\n}", "{\"a\": 1         \n}"},
+		{"strings untouched", `{"u": "http://x/*y*/", "c": ",}", "q": "\"//"}`, `{"u": "http://x/*y*/", "c": ",}", "q": "\"//"}`},
+		{"division-like slash", `{"a": 1/2}`, `{"a": 1/2}`},
+	}
+	for _, c := range cases {
+		if got := string(stripJSONC([]byte(c.content))); got != c.want {
+			ts.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		// The streaming reader gives the same result byte by byte.
+		got, err := io.ReadAll(iotest.OneByteReader(newJSONCReader(iotest.OneByteReader(strings.NewReader(c.content)))))
+		if err != nil || string(got) != c.want {
+			ts.Errorf("%s: streamed %q, %v", c.name, got, err)
+		}
+	}
+}
+
+func TestLoadConfig_JSONC(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	content := `// Database settings of the billing service
+{
+	"database_host": "db.internal", // primary
+	/* the password is encrypted at the first start */
+	"database_password": "jsonc-secret",
+	"database_port": 5432,
+}
+`
+	for _, name := range []string{"app.jsonc", "app.json", "app.conf"} {
+		configPath := filepath.Join(tempDir, name)
+		if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+			ts.Fatal(err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		if cfg.DatabaseHost != "db.internal" || cfg.DatabasePort != 5432 || cfg.DatabasePassword != "jsonc-secret" {
+			ts.Errorf("%s: loaded %+v", name, cfg)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if strings.Contains(string(raw), "jsonc-secret") || !json.Valid(stripJSONC(raw)) {
+			ts.Errorf("%s: write-back is not encrypted JSON:\n%s", name, raw)
+		}
+		if !strings.Contains(string(raw), "// primary") || !strings.Contains(string(raw), "/* the password is encrypted at the first start */") {
+			ts.Errorf("%s: write-back dropped the comments:\n%s", name, raw)
+		}
+		reloaded := &TestConfig{}
+		if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil || reloaded.DatabasePassword != "jsonc-secret" {
+			ts.Errorf("%s: reload = %+v, %v", name, reloaded, err)
+		}
+	}
+}
+
+func TestLoadConfig_FormatDetection(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+
+	ts.Run("YAML by content in .conf", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "service.conf")
+		content := "database_host: yaml-host\ndatabase_port: 6543\ndatabase_password: yaml-secret\n"
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg.DatabaseHost != "yaml-host" || cfg.DatabasePort != 6543 {
+			ts.Errorf("unexpected values: host=%q port=%d", cfg.DatabaseHost, cfg.DatabasePort)
+		}
+		if cfg.DatabaseName != "testdb" {
+			ts.Errorf("expected default database_name, got %q", cfg.DatabaseName)
+		}
+		if cfg.DatabasePassword != "yaml-secret" {
+			ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if sniffFormat(raw) != FormatYAML {
+			ts.Errorf("expected write-back as YAML, got:\n%s", raw)
+		}
+		if strings.Contains(string(raw), "yaml-secret") {
+			ts.Error("plaintext password written to file")
+		}
+
+		// Second load reads the rewritten YAML and decrypts again.
+		cfg2 := &TestConfig{}
+		if err := LoadConfig(cfg2, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig (reload) failed: %v", err)
+		}
+		if cfg2.DatabasePassword != "yaml-secret" {
+			ts.Errorf("reload: expected decrypted password, got %q", cfg2.DatabasePassword)
+		}
+	})
+
+	ts.Run("YAML by .yml extension", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "service.yml")
+		content := "version: 1\ndatabase_user: yml-user\ndatabase_password: yml-secret\n"
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 3, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg.Version != 3 || cfg.DatabaseUser != "yml-user" || cfg.DatabasePort != 5432 || cfg.DatabasePassword != "yml-secret" {
+			ts.Errorf("unexpected values: %+v", cfg)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if !strings.Contains(string(raw), "version: 3") || strings.Contains(string(raw), "yml-secret") {
+			ts.Errorf("unexpected YAML write-back:\n%s", raw)
+		}
+	})
+
+	ts.Run("TOML by content in .txt", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "service.txt")
+		content := "# service settings\ndatabase_host = \"toml-host\"\ndatabase_port = 7654\ndebug = false\ndatabase_password = \"toml-secret\"\n"
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg.DatabaseHost != "toml-host" || cfg.DatabasePort != 7654 || cfg.Debug {
+			ts.Errorf("unexpected values: host=%q port=%d debug=%v", cfg.DatabaseHost, cfg.DatabasePort, cfg.Debug)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if sniffFormat(raw) != FormatTOML {
+			ts.Errorf("expected write-back as TOML, got:\n%s", raw)
+		}
+		cfg2 := &TestConfig{}
+		if err := LoadConfig(cfg2, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig (reload) failed: %v", err)
+		}
+		if cfg2.DatabasePassword != "toml-secret" || cfg2.DatabasePort != 7654 {
+			ts.Errorf("reload: unexpected values: password=%q port=%d", cfg2.DatabasePassword, cfg2.DatabasePort)
+		}
+	})
+
+	ts.Run("JSON by content in .conf", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "legacy.conf")
+		if err := os.WriteFile(configPath, []byte(`{"database_host": "json-host"}`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 2, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if sniffFormat(raw) != FormatJSON {
+			ts.Errorf("expected write-back as JSON, got:\n%s", raw)
+		}
+	})
+
+	ts.Run("Nested structures and slices in TOML", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "servers.toml")
+		cfg := &TestSliceConfig{Servers: []TestConfig{{DatabasePassword: "one"}, {DatabasePassword: "two"}}}
+		if err := LoadConfig(cfg, 3, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		cfg2 := &TestSliceConfig{}
+		if err := LoadConfig(cfg2, 3, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig (reload) failed: %v", err)
+		}
+		if len(cfg2.Servers) != 2 || cfg2.Servers[1].DatabasePassword != "two" {
+			ts.Errorf("unexpected servers after reload: %+v", cfg2.Servers)
+		}
+	})
+
+	ts.Run("Invalid YAML", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "broken.yaml")
+		if err := os.WriteFile(configPath, []byte("a: [1, 2\n"), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := LoadConfig(&TestConfig{}, 1, configPath, false, false)
+		if err == nil || !contains(err.Error(), t("config.failed_parsing")) {
+			ts.Errorf("expected parsing error, got: %v", err)
+		}
+	})
+}
+
+// XMLTestConfig is a legacy service config mapped through `xml` tags.
+type XMLTestConfig struct {
+	XMLName          xml.Name      `xml:"service"`
+	Version          int           `xml:"version,attr"`
+	Name             string        `xml:"name" default:"legacy-service"`
+	Port             int           `xml:"port" default:"8080"`
+	DBPassword       string        `xml:"database>password"`
+	DBSecurePassword string        `xml:"database>securePassword"`
+	Endpoints        []string      `xml:"endpoints>endpoint"`
+	Upstreams        []XMLUpstream `xml:"upstream"`
+}
+
+// XMLUpstream is a repeated XML element carrying its own password pair.
+type XMLUpstream struct {
+	Host                string `xml:"host,attr"`
+	TokenPassword       string `xml:"tokenPassword"`
+	TokenSecurePassword string `xml:"tokenSecurePassword"`
+}
+
+func TestLoadConfig_XML(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "service.xml")
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- legacy Windows service settings -->
+<service version="1">
+	<port>9090</port>
+	<database><password>xml-secret</password></database>
+	<endpoints><endpoint>a</endpoint><endpoint>b</endpoint></endpoints>
+	<upstream host="u1"><tokenPassword>token-1</tokenPassword></upstream>
+</service>
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &XMLTestConfig{}
+	if err := LoadConfig(cfg, 2, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Port != 9090 || cfg.Name != "legacy-service" || cfg.Version != 2 {
+		ts.Errorf("unexpected values: port=%d name=%q version=%d", cfg.Port, cfg.Name, cfg.Version)
+	}
+	if cfg.DBPassword != "xml-secret" || len(cfg.Upstreams) != 1 || cfg.Upstreams[0].TokenPassword != "token-1" {
+		ts.Errorf("passwords not decrypted: %q %+v", cfg.DBPassword, cfg.Upstreams)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "xml-secret") || strings.Contains(string(raw), "token-1") {
+		ts.Errorf("plaintext password written to file:\n%s", raw)
+	}
+	if !strings.HasPrefix(string(raw), "<?xml") || !strings.Contains(string(raw), `<service version="2">`) {
+		ts.Errorf("expected XML write-back, got:\n%s", raw)
+	}
+
+	cfg2 := &XMLTestConfig{}
+	if err := LoadConfig(cfg2, 2, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig (reload) failed: %v", err)
+	}
+	if cfg2.DBPassword != "xml-secret" || len(cfg2.Endpoints) != 2 {
+		ts.Errorf("reload: unexpected values: %q %v", cfg2.DBPassword, cfg2.Endpoints)
+	}
+
+	ts.Run("Sniffed in .config", func(ts *testing.T) {
+		sniffPath := filepath.Join(tempDir, "app.config")
+		if err := os.WriteFile(sniffPath, []byte(`<service><port>1</port></service>`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &XMLTestConfig{}
+		if err := LoadConfig(cfg, 1, sniffPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		raw, _ := os.ReadFile(sniffPath)
+		if sniffFormat(raw) != FormatXML {
+			ts.Errorf("expected XML write-back, got:\n%s", raw)
+		}
+	})
+
+	ts.Run("Trailing element is rejected", func(ts *testing.T) {
+		badPath := filepath.Join(tempDir, "bad.xml")
+		if err := os.WriteFile(badPath, []byte(`<service></service><service></service>`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := LoadConfig(&XMLTestConfig{}, 1, badPath, false, false)
+		if err == nil || !contains(err.Error(), t("config.failed_parsing")) {
+			ts.Errorf("expected parsing error, got: %v", err)
+		}
+	})
+}
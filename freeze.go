@@ -0,0 +1,71 @@
+package sconfig
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+/*
+ * Frozen configs.
+ *
+ * With WithFreeze a Loader keeps a deep copy of the config after every load.
+ * Readers get that snapshot from Frozen() instead of sharing the struct the
+ * application may still modify. A fingerprint of the snapshot detects
+ * accidental writes: VerifyFrozen reports them in every build, and in builds
+ * with the sconfigdebug tag Frozen() panics as soon as a mutation is seen.
+ */
+
+// WithFreeze makes the Loader treat the loaded config as immutable: Frozen
+// returns a deep copy taken at load time, and Save, MarkChanged and Flush are
+// rejected.
+func WithFreeze() LoaderOption {
+	return func(l *Loader) {
+		l.freeze = true
+	}
+}
+
+// freezeSnapshot stores a deep copy of the config and its fingerprint.
+func (l *Loader) freezeSnapshot() {
+	l.frozen = deepCopy(reflect.ValueOf(l.config)).Interface()
+	l.frozenSum = fingerprint(l.frozen)
+}
+
+// Frozen returns the snapshot taken by the last Load of a Loader created with
+// WithFreeze, or nil. The snapshot has the type of the config passed to
+// NewLoader and must not be modified.
+func (l *Loader) Frozen() interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if freezeChecks && l.frozen != nil {
+		if err := l.verifyFrozen(); err != nil {
+			panic(err)
+		}
+	}
+	return l.frozen
+}
+
+// VerifyFrozen returns an error if the snapshot returned by Frozen has been
+// modified since it was taken.
+func (l *Loader) VerifyFrozen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.verifyFrozen()
+}
+
+func (l *Loader) verifyFrozen() error {
+	if l.frozen == nil || fingerprint(l.frozen) == l.frozenSum {
+		return nil
+	}
+	return fmt.Errorf("%s", t("config.frozen_mutated", l.path))
+}
+
+// fingerprint hashes the JSON form of v (exported fields).
+func fingerprint(v interface{}) [32]byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return [32]byte{}
+	}
+	return sha256.Sum256(data)
+}
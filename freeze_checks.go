@@ -0,0 +1,6 @@
+//go:build sconfigdebug
+
+package sconfig
+
+// freezeChecks makes Loader.Frozen verify the snapshot on every call.
+const freezeChecks = true
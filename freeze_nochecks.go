@@ -0,0 +1,7 @@
+//go:build !sconfigdebug
+
+package sconfig
+
+// freezeChecks makes Loader.Frozen verify the snapshot on every call; enable
+// it with the sconfigdebug build tag.
+const freezeChecks = false
@@ -0,0 +1,83 @@
+package sconfig
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoader_Freeze(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	cfg := &TestSliceConfig{Servers: []TestConfig{{DatabasePassword: "frozen-secret"}}}
+	l := NewLoader(cfg, filepath.Join(tempDir, "frozen.json"), 1, WithFreeze())
+	defer l.Close()
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	snap, ok := l.Frozen().(*TestSliceConfig)
+	if !ok || snap == cfg {
+		ts.Fatalf("expected a separate *TestSliceConfig snapshot, got %T", l.Frozen())
+	}
+	if snap.Servers[0].DatabasePassword != "frozen-secret" {
+		ts.Errorf("expected decrypted password in snapshot, got %q", snap.Servers[0].DatabasePassword)
+	}
+
+	// Changes of the application's struct do not reach the snapshot.
+	cfg.Servers[0].DatabaseHost = "changed"
+	if snap.Servers[0].DatabaseHost == "changed" || l.VerifyFrozen() != nil {
+		ts.Error("snapshot must not share memory with the loaded struct")
+	}
+
+	if err := l.Save(); err == nil || !contains(err.Error(), t("config.frozen")) {
+		ts.Errorf("expected frozen error from Save, got: %v", err)
+	}
+	l.MarkChanged()
+	if err := l.Flush(); err != nil {
+		ts.Errorf("Flush on a frozen Loader must be a no-op, got: %v", err)
+	}
+
+	// Writes to the snapshot are detected.
+	snap.Servers[0].DatabaseHost = "mutated"
+	if err := l.VerifyFrozen(); err == nil {
+		ts.Error("expected VerifyFrozen to detect the mutation")
+	}
+	if freezeChecks {
+		defer func() {
+			if recover() == nil {
+				ts.Error("expected Frozen to panic on a mutated snapshot in debug builds")
+			}
+		}()
+		l.Frozen()
+	}
+}
+
+func TestDeepCopy(ts *testing.T) {
+	type inner struct {
+		Tags []string
+		Meta map[string]*int
+	}
+	type outer struct {
+		In     inner
+		Ptr    *inner
+		Any    interface{}
+		hidden int
+	}
+	n := 1
+	src := &outer{
+		In:     inner{Tags: []string{"a"}, Meta: map[string]*int{"n": &n}},
+		Ptr:    &inner{Tags: []string{"b"}},
+		Any:    []int{1},
+		hidden: 7,
+	}
+	dst := deepCopy(reflect.ValueOf(src)).Interface().(*outer)
+	if dst == src || dst.Ptr == src.Ptr || dst.hidden != 7 {
+		ts.Fatal("expected a new struct with copied unexported fields")
+	}
+	dst.In.Tags[0] = "x"
+	*dst.In.Meta["n"] = 2
+	dst.Ptr.Tags[0] = "y"
+	dst.Any.([]int)[0] = 9
+	if src.In.Tags[0] != "a" || n != 1 || src.Ptr.Tags[0] != "b" || src.Any.([]int)[0] != 1 {
+		ts.Errorf("copy shares memory with the original: %+v", src)
+	}
+}
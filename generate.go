@@ -0,0 +1,366 @@
+package sconfig
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"regexp/syntax"
+	"strings"
+	"time"
+)
+
+/*
+ * Random configs for tests.
+ *
+ * Property-based and integration tests of a program's config handling need
+ * many different, valid configs. GenerateRandom fills a fresh struct with
+ * random values that satisfy its tags: validate rules (required, min and max
+ * on numbers and lengths, pattern, from which matching strings are
+ * generated), the layout of time.Time fields and the password pairs, whose
+ * <Name>Password gets a random plaintext and whose <Name>SecurePassword stays
+ * empty (ciphertexts belong to one machine). Nested structs, struct pointers,
+ * slices and maps are filled recursively; recursive types stop after a few
+ * levels. Version fields are left at zero, LoadConfig sets them.
+ *
+ * The same type and seed always give the same config. Constraints the tags
+ * cannot express are checked by the Validate methods; a config they reject
+ * is generated again, up to generateAttempts times.
+ */
+
+// generateAttempts bounds how often GenerateRandom retries a config rejected
+// by validation.
+const generateAttempts = 100
+
+// Sizes of generated values without min and max rules.
+const (
+	generateMaxLen   = 16   // characters of a string
+	generateMaxItems = 3    // elements of a slice or map
+	generateMaxDepth = 4    // levels of struct pointers of the same type
+	generateMaxInt   = 1000 // upper bound of numbers
+	generateMaxRep   = 8    // extra repetitions of * and + in patterns
+)
+
+// generateRunes are the characters of generated strings without a pattern.
+const generateRunes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GenerateRandom returns a pointer to a new struct of configType (a struct
+// type or a pointer to one) filled with random values that pass validation.
+// The values depend only on the type and seed. If no generated config passes
+// validation, the last one is returned with the *ValidationError.
+func GenerateRandom(configType reflect.Type, seed uint64) (interface{}, error) {
+	if configType != nil && configType.Kind() == reflect.Ptr {
+		configType = configType.Elem()
+	}
+	if configType == nil || configType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	g := &generator{rnd: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)), depth: map[reflect.Type]int{}}
+	var config reflect.Value
+	var err error
+	for attempt := 0; attempt < generateAttempts; attempt++ {
+		config = reflect.New(configType)
+		if err = g.fillStruct(config.Elem()); err != nil {
+			return nil, err
+		}
+		if err = validateConfig(config.Interface()); err == nil {
+			break
+		}
+	}
+	return config.Interface(), err
+}
+
+// generator fills values from one random source.
+type generator struct {
+	rnd   *rand.Rand
+	depth map[reflect.Type]int // struct pointers being filled, by type
+}
+
+// fillStruct fills the exported fields of the struct v.
+func (g *generator) fillStruct(v reflect.Value) error {
+	info := getStructInfo(v.Type())
+	rules := make(map[int]*validateField, len(info.rules))
+	for k := range info.rules {
+		if info.rules[k].err != nil {
+			return fmt.Errorf("%s", t("config.validate_tag_invalid", info.label(v.Type(), info.rules[k].index), info.rules[k].tag, info.rules[k].err))
+		}
+		rules[info.rules[k].index] = &info.rules[k]
+	}
+	secure := secureFields(v.Type())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() || secure[i] || i == info.versionIndex {
+			continue
+		}
+		if err := g.fill(v.Field(i), rules[i], field.Tag.Get("layout")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fill sets v to a random value satisfying rule (nil without a validate tag).
+func (g *generator) fill(v reflect.Value, rule *validateField, layout string) error {
+	required := rule != nil && rule.required
+	switch v.Type() {
+	case timeType:
+		return g.fillTime(v, layout)
+	case durationType:
+		if rule == nil || rule.min == nil && rule.max == nil {
+			// Whole seconds up to a day, as durations are usually written.
+			v.SetInt(int64(1+g.rnd.IntN(24*60*60)) * int64(time.Second))
+			return nil
+		}
+		lo, hi := g.bounds(rule, math.MinInt64, math.MaxInt64)
+		v.SetInt(int64(g.between(lo, hi, required)))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(required || g.rnd.IntN(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := v.Type().Bits()
+		lo, hi := g.bounds(rule, -math.Ldexp(1, bits-1), math.Ldexp(1, bits-1)-1)
+		v.SetInt(int64(g.between(lo, hi, required)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		lo, hi := g.bounds(rule, 0, math.Ldexp(1, v.Type().Bits())-1)
+		v.SetUint(uint64(g.between(lo, hi, required)))
+	case reflect.Float32, reflect.Float64:
+		lo, hi := g.bounds(rule, -math.MaxFloat32, math.MaxFloat32)
+		x := lo + g.rnd.Float64()*(hi-lo)
+		if required && x == 0 {
+			x = hi
+		}
+		v.SetFloat(x)
+	case reflect.String:
+		s, err := g.text(rule)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Struct:
+		return g.fillStruct(v)
+	case reflect.Ptr:
+		elem := v.Type().Elem()
+		if elem.Kind() == reflect.Ptr || g.depth[elem] >= generateMaxDepth {
+			return nil
+		}
+		g.depth[elem]++
+		defer func() { g.depth[elem]-- }()
+		p := reflect.New(elem)
+		if err := g.fill(p.Elem(), nil, layout); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Slice:
+		n := g.length(rule, generateMaxItems)
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for k := 0; k < n; k++ {
+			if err := g.fill(s.Index(k), nil, layout); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		n := g.length(rule, generateMaxItems)
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for m.Len() < n {
+			key := reflect.New(v.Type().Key()).Elem()
+			key.SetString(g.word(1 + g.rnd.IntN(generateMaxLen)))
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := g.fill(value, nil, layout); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	}
+	return nil
+}
+
+// fillTime sets v to a random time between 2000 and 2040 that survives
+// formatting with layout, the `layout` tag of the field.
+func (g *generator) fillTime(v reflect.Value, layout string) error {
+	from := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	to := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	at := time.Unix(from+g.rnd.Int64N(to-from), 0).UTC()
+	layout = timeLayout(layout)
+	parsed, err := time.Parse(layout, at.Format(layout))
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+// bounds returns the range of a number: the min and max rules, else 0 to
+// generateMaxInt, always within lo and hi, the range of its type.
+func (g *generator) bounds(rule *validateField, lo, hi float64) (float64, float64) {
+	from, to := math.Max(lo, 0), math.Min(hi, generateMaxInt)
+	if rule != nil && rule.min != nil {
+		from = math.Max(lo, *rule.min)
+		if rule.max == nil {
+			to = math.Min(hi, from+generateMaxInt)
+		}
+	}
+	if rule != nil && rule.max != nil {
+		to = math.Min(hi, *rule.max)
+		if rule.min == nil {
+			from = math.Max(lo, math.Min(0, to-generateMaxInt))
+		}
+	}
+	return from, to
+}
+
+// between returns a random integer from lo to hi, not 0 if required and the
+// range allows it.
+func (g *generator) between(lo, hi float64, required bool) float64 {
+	from, to := math.Ceil(lo), math.Floor(hi)
+	if to < from {
+		return from
+	}
+	for {
+		x := from + math.Floor(g.rnd.Float64()*(to-from+1))
+		if x > to {
+			x = to
+		}
+		if x != 0 || !required || from == to {
+			return x
+		}
+	}
+}
+
+// length returns a random length for a string, slice or map: within the min
+// and max rules, else up to limit, at least 1 if required.
+func (g *generator) length(rule *validateField, limit int) int {
+	lo, hi := 0, limit
+	if rule != nil {
+		if rule.required {
+			lo = 1
+		}
+		if rule.min != nil {
+			lo = int(math.Ceil(*rule.min))
+			if hi < lo {
+				hi = lo + limit
+			}
+		}
+		if rule.max != nil {
+			hi = int(math.Floor(*rule.max))
+		}
+	}
+	if hi <= lo {
+		return max(lo, 0)
+	}
+	return lo + g.rnd.IntN(hi-lo+1)
+}
+
+// text returns a random string satisfying rule: one matching its pattern,
+// else one of generateRunes.
+func (g *generator) text(rule *validateField) (string, error) {
+	n := g.length(rule, generateMaxLen)
+	if rule == nil || rule.pattern == nil {
+		return g.word(n), nil
+	}
+	re, err := syntax.Parse(rule.pattern.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	re = re.Simplify()
+	// Patterns rarely bound the length; try for one that fits.
+	var s string
+	for attempt := 0; attempt < generateAttempts; attempt++ {
+		var b strings.Builder
+		g.match(&b, re)
+		s = b.String()
+		if n := float64(len([]rune(s))); (rule.min == nil || n >= *rule.min) && (rule.max == nil || n <= *rule.max) &&
+			(!rule.required || s != "") {
+			break
+		}
+	}
+	return s, nil
+}
+
+// word returns n random characters of generateRunes.
+func (g *generator) word(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = generateRunes[g.rnd.IntN(len(generateRunes))]
+	}
+	return string(b)
+}
+
+// match writes a random string matching re to b.
+func (g *generator) match(b *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && g.rnd.IntN(2) == 1 {
+				r = toggleCase(r)
+			}
+			b.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		b.WriteRune(g.classRune(re.Rune))
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		b.WriteByte(generateRunes[g.rnd.IntN(len(generateRunes))])
+	case syntax.OpCapture:
+		g.match(b, re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			g.match(b, sub)
+		}
+	case syntax.OpAlternate:
+		g.match(b, re.Sub[g.rnd.IntN(len(re.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		lo, hi := 0, generateMaxRep
+		switch re.Op {
+		case syntax.OpPlus:
+			lo, hi = 1, 1+generateMaxRep
+		case syntax.OpQuest:
+			hi = 1
+		case syntax.OpRepeat:
+			lo, hi = re.Min, re.Max
+			if hi < 0 {
+				hi = lo + generateMaxRep
+			}
+		}
+		for n := lo + g.rnd.IntN(hi-lo+1); n > 0; n-- {
+			g.match(b, re.Sub[0])
+		}
+	}
+	// Empty matches and anchors write nothing.
+}
+
+// classRune returns a random rune of the character class ranges, printable
+// ASCII if the class has any.
+func (g *generator) classRune(ranges []rune) rune {
+	var printable []rune
+	for k := 0; k+1 < len(ranges); k += 2 {
+		lo, hi := max(ranges[k], ' '), min(ranges[k+1], '~')
+		if lo <= hi {
+			printable = append(printable, lo, hi)
+		}
+	}
+	if len(printable) > 0 {
+		ranges = printable
+	}
+	if len(ranges) == 0 {
+		return 'x'
+	}
+	k := 2 * g.rnd.IntN(len(ranges)/2)
+	return ranges[k] + rune(g.rnd.Int32N(int32(ranges[k+1]-ranges[k]+1)))
+}
+
+// toggleCase switches the case of an ASCII letter.
+func toggleCase(r rune) rune {
+	switch {
+	case 'a' <= r && r <= 'z':
+		return r - 'a' + 'A'
+	case 'A' <= r && r <= 'Z':
+		return r - 'A' + 'a'
+	}
+	return r
+}
@@ -0,0 +1,102 @@
+package sconfig
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type generatedServer struct {
+	Host             string `json:"host" validate:"required,pattern=^[a-z]+(-[a-z0-9]+)*\\.example\\.(com|org)$"`
+	Port             uint16 `json:"port" validate:"min=1024,max=65535"`
+	DBPassword       string `json:"db_password" validate:"min=8,max=32"`
+	DBSecurePassword string `json:"db_secure_password"`
+}
+
+type generatedConfig struct {
+	Version  int                         `json:"version"`
+	Mode     string                      `json:"mode" validate:"required,pattern=^(dev|staging|prod)$"`
+	Ratio    float64                     `json:"ratio" validate:"min=0.1,max=0.9"`
+	Offset   int8                        `json:"offset" validate:"min=-10,max=-1"`
+	Enabled  bool                        `json:"enabled" validate:"required"`
+	Timeout  time.Duration               `json:"timeout"`
+	Since    time.Time                   `json:"since" layout:"date"`
+	Tags     []string                    `json:"tags" validate:"min=1,max=2"`
+	Primary  generatedServer             `json:"primary"`
+	Replica  *generatedServer            `json:"replica"`
+	Regions  map[string]*generatedServer `json:"regions"`
+	MinConns int                         `json:"min_conns" validate:"min=1,max=10"`
+	MaxConns int                         `json:"max_conns" validate:"min=1,max=10"`
+}
+
+// Validate checks what the tags cannot express; GenerateRandom retries.
+func (c *generatedConfig) Validate() error {
+	if c.MinConns > c.MaxConns {
+		return errors.New("min_conns above max_conns")
+	}
+	return nil
+}
+
+func TestGenerateRandom(ts *testing.T) {
+	host := regexp.MustCompile(`^[a-z]+(-[a-z0-9]+)*\.example\.(com|org)$`)
+	for seed := uint64(0); seed < 50; seed++ {
+		generated, err := GenerateRandom(reflect.TypeOf(generatedConfig{}), seed)
+		if err != nil {
+			ts.Fatalf("seed %d: %v", seed, err)
+		}
+		cfg := generated.(*generatedConfig)
+		if cfg.Mode != "dev" && cfg.Mode != "staging" && cfg.Mode != "prod" {
+			ts.Errorf("seed %d: mode %q", seed, cfg.Mode)
+		}
+		if !host.MatchString(cfg.Primary.Host) || cfg.Primary.Port < 1024 {
+			ts.Errorf("seed %d: primary %+v", seed, cfg.Primary)
+		}
+		if cfg.Primary.DBPassword == "" || cfg.Primary.DBSecurePassword != "" {
+			ts.Errorf("seed %d: password pair %q/%q", seed, cfg.Primary.DBPassword, cfg.Primary.DBSecurePassword)
+		}
+		if cfg.Replica == nil || !host.MatchString(cfg.Replica.Host) {
+			ts.Errorf("seed %d: replica %+v", seed, cfg.Replica)
+		}
+		if cfg.Version != 0 || cfg.Since.Truncate(24*time.Hour) != cfg.Since || cfg.Timeout%time.Second != 0 {
+			ts.Errorf("seed %d: version %d, since %v, timeout %v", seed, cfg.Version, cfg.Since, cfg.Timeout)
+		}
+
+		again, _ := GenerateRandom(reflect.TypeOf(&generatedConfig{}), seed)
+		if !reflect.DeepEqual(cfg, again) {
+			ts.Fatalf("seed %d generated different configs:\n%+v\n%+v", seed, cfg, again)
+		}
+	}
+	a, _ := GenerateRandom(reflect.TypeOf(generatedConfig{}), 1)
+	b, _ := GenerateRandom(reflect.TypeOf(generatedConfig{}), 2)
+	if reflect.DeepEqual(a, b) {
+		ts.Error("different seeds generated the same config")
+	}
+
+	if _, err := GenerateRandom(reflect.TypeOf(0), 1); err == nil {
+		ts.Error("expected an error for a non-struct type")
+	}
+}
+
+func TestGenerateRandom_LoadConfig(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	generated, err := GenerateRandom(reflect.TypeOf(generatedConfig{}), 7)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	cfg := generated.(*generatedConfig)
+	password := cfg.Primary.DBPassword
+	configPath := filepath.Join(tempDir, "generated.json")
+	if err := LoadConfig(cfg, 2, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig of a generated config failed: %v", err)
+	}
+	reloaded := &generatedConfig{}
+	if err := LoadConfig(reloaded, 2, configPath, false, false); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.Primary.DBPassword != password || reloaded.Mode != cfg.Mode || !reloaded.Since.Equal(cfg.Since) {
+		ts.Errorf("reloaded = %+v, want %+v", reloaded, cfg)
+	}
+}
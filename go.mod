@@ -4,4 +4,13 @@ go 1.25.0
 
 require github.com/nicksnyder/go-i18n/v2 v2.6.0
 
-require golang.org/x/text v0.28.0
+require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.35.0
+	golang.org/x/term v0.34.0
+	golang.org/x/text v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
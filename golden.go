@@ -0,0 +1,104 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+/*
+ * Redacted output for golden files.
+ *
+ * Tests of programs using sconfig compare a loaded config with a golden file.
+ * The file written by LoadConfig cannot serve as one: a ciphertext changes
+ * with every encryption (fresh nonce) and with the key of the machine, and
+ * the plaintext must not be committed. RedactedJSON serializes the config
+ * like a config file, but replaces both fields of a password pair by a
+ * placeholder derived from the plaintext: the same password always gives the
+ * same placeholder, on every machine, and a changed password shows up in the
+ * diff. Secret references are written as the reference. The placeholder holds
+ * only a short prefix of a salted SHA-256, but a weak password can still be
+ * guessed from it; golden files are meant for test secrets.
+ *
+ * The sconfigtest package compares the output with golden files.
+ */
+
+// redactedPrefix starts the placeholder of a redacted password.
+const redactedPrefix = "sconfig-redacted:"
+
+// RedactedJSON returns config (a pointer to a struct) as indented JSON, like
+// a config file, with every password replaced by a stable placeholder that
+// depends only on its plaintext.
+func RedactedJSON(config interface{}) ([]byte, error) {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	c := &copier{refs: resolvedRefAddrs(config)}
+	redacted := c.deepCopy(v)
+	if err := (&walker{phases: phaseRedact}).walk(redacted); err != nil {
+		return nil, err
+	}
+	data, err := marshalConfig(redacted.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "\t"); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// redactPairs replaces the password pairs and the secure fields of one
+// struct by placeholders. Secret references stay; a password that could not
+// be decrypted gets a placeholder without a hash.
+func redactPairs(v reflect.Value, info *structInfo) {
+	for _, pair := range info.pairs {
+		plainValue, secureValue := v.Field(pair.plain), v.Field(pair.secure)
+		if pair.binary {
+			// The placeholder goes into the ciphertext field, which is a string.
+			switch {
+			case plainValue.Len() > 0:
+				secureValue.SetString(redactedPlaceholder(string(plainValue.Bytes())))
+			case secureValue.String() != "":
+				secureValue.SetString(redactedPrefix + "unknown")
+			}
+			plainValue.SetBytes(nil)
+			continue
+		}
+		plain := plainValue.String()
+		if isSecretRef(plain) || plain == "" && secureValue.String() == "" {
+			continue
+		}
+		placeholder := redactedPrefix + "unknown"
+		if plain != "" && plain != secureMarker() {
+			placeholder = redactedPlaceholder(plain)
+		}
+		plainValue.SetString(placeholder)
+		if secureValue.String() != "" {
+			secureValue.SetString(placeholder)
+		}
+	}
+	for _, i := range info.secured {
+		fieldValue := v.Field(i)
+		value := fieldValue.String()
+		switch {
+		case value == "" || isSecretRef(value):
+		case isEncryptedForm(value) || isMarker(value):
+			fieldValue.SetString(redactedPrefix + "unknown")
+		default:
+			fieldValue.SetString(redactedPlaceholder(value))
+		}
+	}
+}
+
+// redactedPlaceholder returns the placeholder of the password plain.
+func redactedPlaceholder(plain string) string {
+	sum := sha256.Sum256([]byte("sconfig golden\x00" + plain))
+	return redactedPrefix + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,61 @@
+package sconfig
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactedJSON(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	ts.Setenv("SCONFIG_TEST_API_KEY", "api-secret")
+	defer invalidateKey()
+	load := func(name string, hwid uint64, password string) *TestConfig {
+		invalidateKey()
+		configPath := filepath.Join(tempDir, name)
+		cfg := &TestConfig{DatabasePassword: password, APIKey: "secretref:env:SCONFIG_TEST_API_KEY"}
+		hw := func() (uint64, error) { return hwid, nil }
+		if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		return cfg
+	}
+	// Different machines, different ciphertexts, the same output.
+	first := load("first.json", 4711, "db-secret")
+	second := load("second.json", 815, "db-secret")
+	if first.DatabaseSecurePassword == second.DatabaseSecurePassword {
+		ts.Fatal("expected different ciphertexts")
+	}
+	a, err := RedactedJSON(first)
+	if err != nil {
+		ts.Fatalf("RedactedJSON failed: %v", err)
+	}
+	b, _ := RedactedJSON(second)
+	if !bytes.Equal(a, b) {
+		ts.Errorf("outputs differ:\n%s\n%s", a, b)
+	}
+	for _, leak := range []string{"db-secret", "api-secret", first.DatabaseSecurePassword} {
+		if strings.Contains(string(a), leak) {
+			ts.Errorf("output contains %q:\n%s", leak, a)
+		}
+	}
+	for _, want := range []string{redactedPlaceholder("db-secret"), "secretref:env:SCONFIG_TEST_API_KEY"} {
+		if !strings.Contains(string(a), want) {
+			ts.Errorf("output lacks %q:\n%s", want, a)
+		}
+	}
+	if first.DatabasePassword != "db-secret" || first.APIKey != "api-secret" {
+		ts.Errorf("the config was modified: %+v", first)
+	}
+
+	// A changed password changes the output.
+	third := load("third.json", 4711, "other-secret")
+	c, _ := RedactedJSON(third)
+	if bytes.Equal(a, c) {
+		ts.Error("a changed password gave the same output")
+	}
+	if _, err := RedactedJSON(*first); err == nil {
+		ts.Error("expected an error for a non-pointer config")
+	}
+}
@@ -0,0 +1,75 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+/*
+ * Health report.
+ *
+ * Operators verify the config state of a running service through its health
+ * port: which file is loaded, in which version and since when, whether the
+ * current encryption key is the one the passwords were encrypted with, and
+ * how many secrets the config holds. Secret values and the key itself are
+ * never part of the report.
+ */
+
+// HealthStatus is the config state reported by Health and HealthHandler.
+type HealthStatus struct {
+	Path     string     `json:"path"`
+	Version  int        `json:"version"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+	// KeyMatch is nil if the file does not record a key fingerprint yet.
+	KeyMatch *bool `json:"key_fingerprint_match,omitempty"`
+	Secrets  int   `json:"secrets"` // encrypted passwords plus secret references
+	Healthy  bool  `json:"healthy"`
+}
+
+// Health returns the current config state. It is healthy once the config has
+// been loaded, the Loader is open and the key fingerprint does not mismatch.
+func (l *Loader) Health() HealthStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := HealthStatus{Path: l.path, Version: getStructVersion(reflect.ValueOf(l.config))}
+	path, err := resolveConfigPath(l.path)
+	if err == nil {
+		status.Path = path
+	}
+	if !l.loadedAt.IsZero() {
+		loadedAt := l.loadedAt
+		status.LoadedAt = &loadedAt
+	}
+	if meta := loadedMetadata(path); meta != nil && meta.Key != "" {
+		if current := keyFingerprint(); current != "" {
+			match := current == meta.Key
+			status.KeyMatch = &match
+		}
+	}
+	w := &walker{phases: phaseCount}
+	_ = w.walk(reflect.ValueOf(l.config))
+	status.Secrets = w.secrets
+	for _, b := range bindingsOf(l.config) {
+		if isSecretRef(b.ref) {
+			status.Secrets++
+		}
+	}
+	status.Healthy = status.LoadedAt != nil && !l.closed && (status.KeyMatch == nil || *status.KeyMatch)
+	return status
+}
+
+// HealthHandler returns an http.HandlerFunc that writes Health as JSON, with
+// status 200 if healthy and 503 otherwise.
+func (l *Loader) HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := l.Health()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,51 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoader_HealthHandler(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	ts.Setenv("SCONFIG_TEST_HEALTH_HOST", "db.internal")
+	cfg := &TestConfig{DatabasePassword: "health-secret", APIKey: "secretref:env:SCONFIG_TEST_HEALTH_HOST"}
+	l := NewLoader(cfg, filepath.Join(tempDir, "health.json"), 1)
+	defer l.Close()
+	handler := l.HealthHandler()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/health/config", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		ts.Errorf("expected 503 before Load, got %d", rec.Code)
+	}
+
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/health/config", nil))
+	if rec.Code != http.StatusOK {
+		ts.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "health-secret") || strings.Contains(body, "db.internal") {
+		ts.Errorf("secret value in health output: %s", body)
+	}
+	var status HealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		ts.Fatalf("invalid JSON: %v", err)
+	}
+	if status.Version != 1 || status.LoadedAt == nil || status.Secrets != 2 || !status.Healthy {
+		ts.Errorf("unexpected status: %s", body)
+	}
+	if status.KeyMatch == nil || !*status.KeyMatch {
+		ts.Errorf("expected matching key fingerprint: %s", body)
+	}
+	if !strings.HasSuffix(filepath.ToSlash(status.Path), "/health.json") {
+		ts.Errorf("unexpected path %q", status.Path)
+	}
+}
@@ -0,0 +1,30 @@
+package sconfig
+
+/*
+ * Hardware identifiers.
+ *
+ * The hardware ID is a hash over several identifiers, and a change in any of
+ * them changes the key. HardwareIdentifiers exposes the individual values so
+ * their stability can be checked over reboots, updates and network changes
+ * (CLI: sconfig hardware-id --record/--compare) before secrets are bound to
+ * them.
+ */
+
+// HardwareIdentifier is one value the hardware ID is computed from.
+type HardwareIdentifier struct {
+	Source string `json:"source"` // e.g. "mac", "machine-id", "disk-serial"
+	Value  string `json:"value"`
+}
+
+// HardwareIdentifiers returns the current hardware ID and the identifiers it
+// is computed from, in collection order. The values are device serials and
+// similar; treat them as confidential.
+func HardwareIdentifiers() (uint64, []HardwareIdentifier, error) {
+	var sources []HardwareIdentifier
+	id, err := withProbeAllowlist(func() (uint64, error) {
+		id, s, err := collectHardwareID(false)
+		sources = s
+		return id, err
+	})
+	return id, sources, err
+}
@@ -0,0 +1,25 @@
+package sconfig
+
+import "testing"
+
+func TestHardwareIdentifiers(ts *testing.T) {
+	want, wantErr := secure_config_getHardwareID()
+	id, identifiers, err := HardwareIdentifiers()
+	if (err == nil) != (wantErr == nil) {
+		ts.Fatalf("unexpected error: %v (hardware ID: %v)", err, wantErr)
+	}
+	if err != nil {
+		ts.Skip("no hardware identifiers on this machine")
+	}
+	if id != want {
+		ts.Errorf("hardware ID %x, want %x", id, want)
+	}
+	if len(identifiers) == 0 {
+		ts.Fatal("no identifiers returned")
+	}
+	for _, ident := range identifiers {
+		if ident.Source == "" || ident.Value == "" {
+			ts.Errorf("incomplete identifier %+v", ident)
+		}
+	}
+}
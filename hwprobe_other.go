@@ -0,0 +1,25 @@
+//go:build !windows
+
+package sconfig
+
+import "errors"
+
+// The Windows probes (hwprobe_windows.go) are only implemented on Windows.
+
+var errWindowsProbe = errors.New("Windows probes are not available on this platform")
+
+func windowsMachineGUID() (string, error) {
+	return "", errWindowsProbe
+}
+
+func windowsSMBIOS() (smbiosInfo, error) {
+	return smbiosInfo{}, errWindowsProbe
+}
+
+func windowsDiskSerials() ([]string, error) {
+	return nil, errWindowsProbe
+}
+
+func windowsGatewayMAC() (string, error) {
+	return "", errWindowsProbe
+}
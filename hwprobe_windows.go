@@ -0,0 +1,146 @@
+//go:build windows
+
+package sconfig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+/*
+ * Windows hardware probes without external commands.
+ *
+ * wmic is gone from current Windows 11 builds, and the output of reg,
+ * ipconfig and wmic is localized. The probes read the registry, the SMBIOS
+ * table, the storage descriptors of the physical drives and the adapter
+ * list of the IP helper API directly. Their names in SetProbeAllowlist are
+ * the registry key, "smbios", "physical-drives" and "adapters".
+ */
+
+var procGetSystemFirmwareTable = kernel32.NewProc("GetSystemFirmwareTable")
+
+// rsmbProvider is the firmware table provider "RSMB" (raw SMBIOS).
+const rsmbProvider = 'R'<<24 | 'S'<<16 | 'M'<<8 | 'B'
+
+const cryptographyKey = `SOFTWARE\Microsoft\Cryptography`
+
+// windowsMachineGUID returns the MachineGuid of the installation.
+func windowsMachineGUID() (string, error) {
+	if !allowProbe(`HKLM\` + cryptographyKey) {
+		return "", errProbeNotAllowed
+	}
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, cryptographyKey, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+	guid, _, err := k.GetStringValue("MachineGuid")
+	return strings.TrimSpace(guid), err
+}
+
+// windowsSMBIOS returns the identifiers in the SMBIOS table.
+func windowsSMBIOS() (smbiosInfo, error) {
+	if !allowProbe("smbios") {
+		return smbiosInfo{}, errProbeNotAllowed
+	}
+	if err := procGetSystemFirmwareTable.Find(); err != nil {
+		return smbiosInfo{}, err
+	}
+	size, _, err := procGetSystemFirmwareTable.Call(rsmbProvider, 0, 0, 0)
+	if size == 0 {
+		return smbiosInfo{}, err
+	}
+	raw := make([]byte, size)
+	n, _, err := procGetSystemFirmwareTable.Call(rsmbProvider, 0, uintptr(unsafe.Pointer(&raw[0])), size)
+	if n == 0 || n > size {
+		return smbiosInfo{}, err
+	}
+	return parseRawSMBIOS(raw[:n])
+}
+
+// windowsDiskSerials returns the serial numbers of the physical drives.
+func windowsDiskSerials() ([]string, error) {
+	if !allowProbe("physical-drives") {
+		return nil, errProbeNotAllowed
+	}
+	const ioctlStorageQueryProperty = 0x2D1400
+	// STORAGE_PROPERTY_QUERY: StorageDeviceProperty, PropertyStandardQuery.
+	query := make([]byte, 12)
+	var serials []string
+	for i := 0; i < 32; i++ {
+		name, err := windows.UTF16PtrFromString(fmt.Sprintf(`\\.\PhysicalDrive%d`, i))
+		if err != nil {
+			return nil, err
+		}
+		// No access rights are needed for the query, so it works without
+		// administrator rights.
+		h, err := windows.CreateFile(name, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+		if err != nil {
+			continue
+		}
+		buf := make([]byte, 1024)
+		var n uint32
+		err = windows.DeviceIoControl(h, ioctlStorageQueryProperty, &query[0], uint32(len(query)), &buf[0], uint32(len(buf)), &n, nil)
+		windows.CloseHandle(h)
+		if err != nil {
+			continue
+		}
+		if serial := storageDescriptorSerial(buf[:n]); serial != "" {
+			serials = append(serials, serial)
+		}
+	}
+	return serials, nil
+}
+
+// storageDescriptorSerial returns the serial number in a
+// STORAGE_DEVICE_DESCRIPTOR.
+func storageDescriptorSerial(desc []byte) string {
+	if len(desc) < 28 {
+		return ""
+	}
+	offset := binary.LittleEndian.Uint32(desc[24:28])
+	if offset == 0 || offset >= uint32(len(desc)) {
+		return ""
+	}
+	serial := desc[offset:]
+	if end := strings.IndexByte(string(serial), 0); end >= 0 {
+		serial = serial[:end]
+	}
+	return strings.TrimSpace(string(serial))
+}
+
+// windowsGatewayMAC returns the MAC address of the adapter with a default
+// gateway; of several, the last one, like the former ipconfig /all probe.
+func windowsGatewayMAC() (string, error) {
+	if !allowProbe("adapters") {
+		return "", errProbeNotAllowed
+	}
+	size := uint32(15000)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_GATEWAYS, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) {
+			return "", err
+		}
+	}
+	mac := ""
+	for a := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); a != nil; a = a.Next {
+		if a.FirstGatewayAddress == nil || a.PhysicalAddressLength == 0 || a.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+		mac = net.HardwareAddr(a.PhysicalAddress[:a.PhysicalAddressLength]).String()
+	}
+	return mac, nil
+}
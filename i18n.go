@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"golang.org/x/text/language"
@@ -17,6 +18,7 @@ var localesFS embed.FS
 
 var (
 	bundle      *i18n.Bundle
+	langMu      sync.RWMutex // guards localizer and currentLang
 	localizer   *i18n.Localizer
 	currentLang = "en"
 )
@@ -109,13 +111,28 @@ func loadExternalTranslations() {
 
 // setLanguage sets the current language
 func setLanguage(lang string) {
-	currentLang = lang
 	// Create localizer for the current language
-	localizer = i18n.NewLocalizer(bundle, lang)
+	l := i18n.NewLocalizer(bundle, lang)
+	langMu.Lock()
+	currentLang = lang
+	localizer = l
+	langMu.Unlock()
 }
 
 // translate translates a key to the current language
 func translate(key string, args ...interface{}) string {
+	langMu.RLock()
+	l := localizer
+	langMu.RUnlock()
+	return translateWith(l, key, args...)
+}
+
+// translateIn translates a key to lang without changing the current language.
+func translateIn(lang, key string, args ...interface{}) string {
+	return translateWith(i18n.NewLocalizer(bundle, lang), key, args...)
+}
+
+func translateWith(localizer *i18n.Localizer, key string, args ...interface{}) string {
 	if localizer == nil {
 		// Fallback if localizer is not initialized
 		if len(args) > 0 {
@@ -177,5 +194,7 @@ func t(key string, args ...interface{}) string {
 
 // getCurrentLanguage returns the current language code
 func getCurrentLanguage() string {
+	langMu.RLock()
+	defer langMu.RUnlock()
 	return currentLang
 }
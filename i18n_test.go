@@ -6,6 +6,11 @@ import (
 )
 
 func TestI18n(ts *testing.T) {
+	// Restore the language so later tests compare against the same markers
+	// that LoadConfig initialized.
+	origLang := getCurrentLanguage()
+	defer setLanguage(origLang)
+
 	// Test the t() function with different keys
 	ts.Run("Testing i18n functionality", func(ts *testing.T) {
 		currLang := getCurrentLanguage()
@@ -0,0 +1,309 @@
+package sconfig
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Interop test vectors.
+ *
+ * The PHP variant and other ports read and write the same files, so they
+ * have to compute the same hardware ID, derive the same key and read and
+ * write the same ciphertexts. interop/vectors.json holds the vectors of this
+ * implementation, the reference:
+ *
+ *	markers      the secure marker per language
+ *	hardware_ids identifiers -> hardware ID (sorted, joined with "|", SHA-256,
+ *	             first 8 bytes little-endian)
+ *	kdfs         KDF version and hardware ID -> key
+ *	key_scopes   base key, scope and identity -> scoped key
+ *	envelopes    key and ciphertext -> plaintext, or an error if not valid
+ *	encrypt      key and plaintext, to be encrypted by the port
+ *
+ * A port computes a result for every vector ID and writes them as
+ *
+ *	{"implementation": "php", "results": {"kdf-hkdf-v1-1": {"value": "..."}, "envelope-tampered": {"error": "..."}}}
+ *
+ * and VerifyInterop (CLI: sconfig interop verify) checks them: values are
+ * compared with the vectors, and the ciphertexts of the encrypt vectors are
+ * decrypted by this implementation. The vectors are regenerated by
+ * TestInteropVectors with SCONFIG_UPDATE_GOLDEN=1; existing vectors only
+ * change with a new format version.
+ */
+
+//go:embed interop/vectors.json
+var interopVectorsJSON []byte
+
+// interopFormat names the vector file format.
+const interopFormat = "sconfig-interop"
+
+// interopSuite is the content of interop/vectors.json.
+type interopSuite struct {
+	Format      string             `json:"format"`
+	Version     int                `json:"version"`
+	Markers     []markerVector     `json:"markers"`
+	HardwareIDs []hardwareIDVector `json:"hardware_ids"`
+	KDFs        []kdfVector        `json:"kdfs"`
+	KeyScopes   []keyScopeVector   `json:"key_scopes"`
+	Envelopes   []envelopeVector   `json:"envelopes"`
+	Encrypt     []encryptVector    `json:"encrypt"`
+}
+
+type markerVector struct {
+	ID     string `json:"id"`
+	Lang   string `json:"lang"`
+	Marker string `json:"marker"`
+}
+
+type hardwareIDVector struct {
+	ID          string   `json:"id"`
+	Identifiers []string `json:"identifiers"`
+	Combined    string   `json:"combined"`    // for debugging a port
+	HardwareID  string   `json:"hardware_id"` // "0x" and 16 hex digits
+}
+
+type kdfVector struct {
+	ID         string `json:"id"`
+	KDF        KDF    `json:"kdf"`
+	HardwareID string `json:"hardware_id"`
+	Key        string `json:"key"` // hex
+}
+
+type keyScopeVector struct {
+	ID       string `json:"id"`
+	BaseKey  string `json:"base_key"` // hex
+	Scope    string `json:"scope"`
+	Identity string `json:"identity"` // user ID, application ID or executable hash
+	Key      string `json:"key"`      // hex
+}
+
+type envelopeVector struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"` // hex
+	Envelope  string `json:"envelope"`
+	Valid     bool   `json:"valid"`
+	Plaintext string `json:"plaintext,omitempty"`
+	Note      string `json:"note,omitempty"`
+}
+
+type encryptVector struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"` // hex
+	Plaintext string `json:"plaintext"`
+}
+
+// interopResults is the result file of an implementation.
+type interopResults struct {
+	Implementation string                  `json:"implementation"`
+	Results        map[string]interopValue `json:"results"`
+}
+
+type interopValue struct {
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// InteropFailure is a vector an implementation did not pass.
+type InteropFailure struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+func (f InteropFailure) String() string {
+	return f.ID + ": " + f.Reason
+}
+
+// InteropVectors returns the interop test vectors (interop/vectors.json).
+func InteropVectors() []byte {
+	return append([]byte(nil), interopVectorsJSON...)
+}
+
+// InteropResults returns the results of this implementation for the interop
+// test vectors, in the format VerifyInterop reads.
+func InteropResults() ([]byte, error) {
+	suite, err := parseInteropSuite(interopVectorsJSON)
+	if err != nil {
+		return nil, err
+	}
+	results := interopResults{Implementation: "go " + Version, Results: make(map[string]interopValue)}
+	set := func(id, value string, err error) {
+		if err != nil {
+			results.Results[id] = interopValue{Error: err.Error()}
+		} else {
+			results.Results[id] = interopValue{Value: value}
+		}
+	}
+	for _, v := range suite.Markers {
+		set(v.ID, translateIn(v.Lang, "config.password_message"), nil)
+	}
+	for _, v := range suite.HardwareIDs {
+		id, _ := interopHardwareID(v.Identifiers)
+		set(v.ID, fmt.Sprintf("0x%016x", id), nil)
+	}
+	for _, v := range suite.KDFs {
+		key, err := interopKDF(v.KDF, v.HardwareID)
+		set(v.ID, hex.EncodeToString(key), err)
+	}
+	for _, v := range suite.KeyScopes {
+		key, err := interopKeyScope(v)
+		set(v.ID, hex.EncodeToString(key), err)
+	}
+	for _, v := range suite.Envelopes {
+		plaintext, err := interopDecrypt(v.Key, v.Envelope)
+		set(v.ID, plaintext, err)
+	}
+	for _, v := range suite.Encrypt {
+		key, err := hex.DecodeString(v.Key)
+		if err != nil {
+			set(v.ID, "", err)
+			continue
+		}
+		sealed, err := encryptWithKey(key, v.Plaintext)
+		set(v.ID, sealed, err)
+	}
+	return json.MarshalIndent(results, "", "\t")
+}
+
+// VerifyInterop checks the results of an implementation (see InteropResults
+// for the format) against the interop test vectors and returns the vectors
+// it did not pass, sorted by ID. The error is only set if results cannot be
+// read.
+func VerifyInterop(results []byte) ([]InteropFailure, error) {
+	suite, err := parseInteropSuite(interopVectorsJSON)
+	if err != nil {
+		return nil, err
+	}
+	var got interopResults
+	if err := json.Unmarshal(results, &got); err != nil {
+		return nil, fmt.Errorf("%s", t("config.interop_invalid", err))
+	}
+	var failures []InteropFailure
+	fail := func(id, format string, args ...interface{}) {
+		failures = append(failures, InteropFailure{ID: id, Reason: fmt.Sprintf(format, args...)})
+	}
+	// value returns the result of id, or reports it as failed.
+	value := func(id string) (string, bool) {
+		r, ok := got.Results[id]
+		switch {
+		case !ok:
+			fail(id, "no result")
+		case r.Error != "":
+			fail(id, "error %q", r.Error)
+		default:
+			return r.Value, true
+		}
+		return "", false
+	}
+	for _, v := range suite.Markers {
+		if marker, ok := value(v.ID); ok && marker != v.Marker {
+			fail(v.ID, "marker %q, want %q", marker, v.Marker)
+		}
+	}
+	for _, v := range suite.HardwareIDs {
+		if id, ok := value(v.ID); ok {
+			if n, err := strconv.ParseUint(id, 0, 64); err != nil || fmt.Sprintf("0x%016x", n) != v.HardwareID {
+				fail(v.ID, "hardware ID %q, want %s (identifiers joined: %q)", id, v.HardwareID, v.Combined)
+			}
+		}
+	}
+	for _, v := range suite.KDFs {
+		if key, ok := value(v.ID); ok && !strings.EqualFold(key, v.Key) {
+			fail(v.ID, "key %s, want %s", key, v.Key)
+		}
+	}
+	for _, v := range suite.KeyScopes {
+		if key, ok := value(v.ID); ok && !strings.EqualFold(key, v.Key) {
+			fail(v.ID, "key %s, want %s", key, v.Key)
+		}
+	}
+	for _, v := range suite.Envelopes {
+		if !v.Valid {
+			if r, ok := got.Results[v.ID]; !ok {
+				fail(v.ID, "no result")
+			} else if r.Error == "" {
+				fail(v.ID, "accepted an invalid envelope (%s)", v.Note)
+			}
+			continue
+		}
+		if plaintext, ok := value(v.ID); ok && plaintext != v.Plaintext {
+			fail(v.ID, "plaintext %q, want %q", plaintext, v.Plaintext)
+		}
+	}
+	for _, v := range suite.Encrypt {
+		if envelope, ok := value(v.ID); ok {
+			plaintext, err := interopDecrypt(v.Key, envelope)
+			switch {
+			case err != nil:
+				fail(v.ID, "envelope not readable: %v", err)
+			case plaintext != v.Plaintext:
+				fail(v.ID, "envelope holds %q, want %q", plaintext, v.Plaintext)
+			}
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].ID < failures[j].ID })
+	return failures, nil
+}
+
+// parseInteropSuite reads a vector file.
+func parseInteropSuite(data []byte) (*interopSuite, error) {
+	var suite interopSuite
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("%s", t("config.interop_invalid", err))
+	}
+	if suite.Format != interopFormat {
+		return nil, fmt.Errorf("%s", t("config.interop_invalid", fmt.Errorf("format %q", suite.Format)))
+	}
+	return &suite, nil
+}
+
+// interopHardwareID computes the hardware ID of identifiers like
+// collectHardwareID, and returns the joined identifiers as well.
+func interopHardwareID(identifiers []string) (uint64, string) {
+	sorted := append([]string(nil), identifiers...)
+	sortIdentifiers(sorted)
+	combined := strings.Join(sorted, "|")
+	return hashHardwareID(sha256.Sum256([]byte(combined))), combined
+}
+
+// interopKDF derives the key of a KDF vector.
+func interopKDF(kdf KDF, hardwareID string) ([]byte, error) {
+	derive, ok := kdfs[kdf]
+	if !ok {
+		return nil, fmt.Errorf("%s", t("config.kdf_unknown", kdf))
+	}
+	id, err := strconv.ParseUint(hardwareID, 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	return derive(id)
+}
+
+// interopKeyScope derives the key of a key scope vector.
+func interopKeyScope(v keyScopeVector) ([]byte, error) {
+	base, err := hex.DecodeString(v.BaseKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range []KeyScope{KeyScopeUser, KeyScopeApplication, KeyScopeExecutable} {
+		if scope.String() == v.Scope {
+			return scopeKey(base, scope, v.Identity), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown scope %q", v.Scope)
+}
+
+// interopDecrypt decrypts an envelope with the hex key.
+func interopDecrypt(key, envelope string) (string, error) {
+	k, err := hex.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	return decryptWithKey(k, envelope)
+}
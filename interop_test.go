@@ -0,0 +1,150 @@
+package sconfig
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// interopKey returns the hex key number n of the vectors.
+func interopKey(n int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("sconfig interop key %d", n)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateInteropSuite builds the vectors from this implementation.
+func generateInteropSuite(ts *testing.T) *interopSuite {
+	suite := &interopSuite{Format: interopFormat, Version: 1}
+	for _, lang := range []string{"en", "de"} {
+		suite.Markers = append(suite.Markers, markerVector{ID: "marker-" + lang, Lang: lang, Marker: translateIn(lang, "config.password_message")})
+	}
+	for i, identifiers := range [][]string{
+		{"00:1a:2b:3c:4d:5e"},
+		{"machine-id:4c4c4544004d3510", "00:1a:2b:3c:4d:5e", "disk:S3Z9NB0K812345"},
+		{"cpu:GenuineIntel", "board:Größe-Ü1", "00:1a:2b:3c:4d:5e", "00:1a:2b:3c:4d:5f"},
+	} {
+		id, combined := interopHardwareID(identifiers)
+		suite.HardwareIDs = append(suite.HardwareIDs, hardwareIDVector{
+			ID: fmt.Sprintf("hardware-id-%d", i+1), Identifiers: identifiers, Combined: combined, HardwareID: fmt.Sprintf("0x%016x", id),
+		})
+	}
+	for _, kdf := range []KDF{KDFLegacyRand, KDFHKDFv1, KDFPBKDF2v1} {
+		for i, id := range []string{"0x0000000000000001", "0x0123456789abcdef", "0xfedcba9876543210"} {
+			key, err := interopKDF(kdf, id)
+			if err != nil {
+				ts.Fatal(err)
+			}
+			suite.KDFs = append(suite.KDFs, kdfVector{ID: fmt.Sprintf("kdf-%s-%d", kdf, i+1), KDF: kdf, HardwareID: id, Key: hex.EncodeToString(key)})
+		}
+	}
+	for _, v := range []keyScopeVector{
+		{ID: "key-scope-user", Scope: "user", Identity: "1000"},
+		{ID: "key-scope-application", Scope: "application", Identity: "billing"},
+		{ID: "key-scope-executable", Scope: "executable", Identity: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	} {
+		v.BaseKey = interopKey(1)
+		key, err := interopKeyScope(v)
+		if err != nil {
+			ts.Fatal(err)
+		}
+		v.Key = hex.EncodeToString(key)
+		suite.KeyScopes = append(suite.KeyScopes, v)
+	}
+	key1, _ := hex.DecodeString(interopKey(1))
+	seal := func(key []byte, plaintext string) string {
+		sealed, err := encryptWithKey(key, plaintext)
+		if err != nil {
+			ts.Fatal(err)
+		}
+		return sealed
+	}
+	for i, plaintext := range []string{"secret", "", "Pässwörd €✓", "line1\nline2\t\"quoted\" \\", strings.Repeat("0123456789abcdef", 64)} {
+		suite.Envelopes = append(suite.Envelopes, envelopeVector{ID: fmt.Sprintf("envelope-aes-gcm-%d", i+1), Key: interopKey(1), Envelope: seal(key1, plaintext), Valid: true, Plaintext: plaintext})
+	}
+	aged, err := encryptAge(key1, nil, "age secret")
+	if err != nil {
+		ts.Fatal(err)
+	}
+	suite.Envelopes = append(suite.Envelopes, envelopeVector{ID: "envelope-age-1", Key: interopKey(1), Envelope: aged, Valid: true, Plaintext: "age secret", Note: "age envelope, file key wrapped with the config key"})
+	tampered, _ := base64.StdEncoding.DecodeString(seal(key1, "secret"))
+	tampered[len(tampered)-1] ^= 0x01
+	suite.Envelopes = append(suite.Envelopes,
+		envelopeVector{ID: "envelope-tampered", Key: interopKey(1), Envelope: base64.StdEncoding.EncodeToString(tampered), Note: "last byte of the tag flipped"},
+		envelopeVector{ID: "envelope-wrong-key", Key: interopKey(2), Envelope: seal(key1, "secret"), Note: "encrypted with another key"},
+		envelopeVector{ID: "envelope-truncated", Key: interopKey(1), Envelope: base64.StdEncoding.EncodeToString([]byte("short")), Note: "shorter than the nonce"},
+		envelopeVector{ID: "envelope-bad-base64", Key: interopKey(1), Envelope: "not base64!", Note: "not standard base64"},
+	)
+	for i, plaintext := range []string{"secret", "Pässwörd €✓", ""} {
+		suite.Encrypt = append(suite.Encrypt, encryptVector{ID: fmt.Sprintf("encrypt-%d", i+1), Key: interopKey(1), Plaintext: plaintext})
+	}
+	return suite
+}
+
+func TestInteropVectors(ts *testing.T) {
+	if os.Getenv("SCONFIG_UPDATE_GOLDEN") != "" {
+		data, err := json.MarshalIndent(generateInteropSuite(ts), "", "  ")
+		if err != nil {
+			ts.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("interop", "vectors.json"), append(data, '\n'), 0644); err != nil {
+			ts.Fatal(err)
+		}
+		ts.Skip("interop/vectors.json regenerated; run the tests again")
+	}
+	suite, err := parseInteropSuite(InteropVectors())
+	if err != nil {
+		ts.Fatal(err)
+	}
+	if len(suite.Markers) == 0 || len(suite.KDFs) == 0 || len(suite.Envelopes) == 0 || len(suite.Encrypt) == 0 {
+		ts.Fatalf("incomplete vectors: %+v", suite)
+	}
+	results, err := InteropResults()
+	if err != nil {
+		ts.Fatal(err)
+	}
+	failures, err := VerifyInterop(results)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	for _, f := range failures {
+		ts.Errorf("reference implementation failed %s", f)
+	}
+}
+
+func TestVerifyInterop_Failures(ts *testing.T) {
+	results, err := InteropResults()
+	if err != nil {
+		ts.Fatal(err)
+	}
+	var doc interopResults
+	if err := json.Unmarshal(results, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	delete(doc.Results, "marker-de")
+	doc.Results["kdf-hkdf-v1-1"] = interopValue{Value: strings.Repeat("00", 32)}
+	doc.Results["envelope-tampered"] = interopValue{Value: "secret"}
+	doc.Results["encrypt-1"] = interopValue{Value: "bm90IGFuIGVudmVsb3Bl"}
+	doc.Results["hardware-id-2"] = interopValue{Error: "not implemented"}
+	broken, _ := json.Marshal(doc)
+	failures, err := VerifyInterop(broken)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	var ids []string
+	for _, f := range failures {
+		ids = append(ids, f.ID)
+	}
+	if got := strings.Join(ids, " "); got != "encrypt-1 envelope-tampered hardware-id-2 kdf-hkdf-v1-1 marker-de" {
+		ts.Errorf("failures = %v", failures)
+	}
+
+	if _, err := VerifyInterop([]byte("{")); err == nil {
+		ts.Error("expected an error for an unreadable result file")
+	}
+}
@@ -0,0 +1,197 @@
+package sconfig
+
+import (
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+ * Key derivation versions.
+ *
+ * The hardware-bound key is derived from the 64-bit hardware ID by a key
+ * derivation function (KDF). The original derivation expands the ID with the
+ * Go 1.23 math/rand generator (key_rand_go123.go); better derivations are
+ * added as new KDF versions instead of replacing it. The version a file was
+ * written with is stored in its metadata block ("kdf"); files without it were
+ * written with the original derivation.
+ *
+ * SetKDF selects the version for writing, KDFHKDFv1 by default. When
+ * LoadConfig reads a file written with another version, it derives that key
+ * as well, re-encrypts the passwords with the current key and writes the file
+ * back, so installations move to a new derivation file by file without a flag
+ * day. With a key provider the key is not derived and no version is recorded.
+ */
+
+// KDF names a key derivation version.
+type KDF string
+
+const (
+	// KDFLegacyRand expands the hardware ID with the Go 1.23 math/rand
+	// generator. Files without a recorded version use it; they are read and
+	// migrated to the write version.
+	KDFLegacyRand KDF = "rand-legacy"
+	// KDFHKDFv1 derives the key with HKDF-SHA256 from the hardware ID
+	// (default).
+	KDFHKDFv1 KDF = "hkdf-v1"
+	// KDFPBKDF2v1 derives the key with PBKDF2-HMAC-SHA256, for interop with
+	// targets that only provide PBKDF2 (PHP, embedded systems); see
+	// derivePBKDF2v1Key for the parameters.
+	KDFPBKDF2v1 KDF = "pbkdf2-v1"
+)
+
+// kdfs maps the known versions to their derivation from the hardware ID.
+var kdfs = map[KDF]func(hardwareID uint64) ([]byte, error){
+	KDFLegacyRand: deriveLegacyRandKey,
+	KDFHKDFv1:     deriveHKDFv1Key,
+	KDFPBKDF2v1:   derivePBKDF2v1Key,
+}
+
+var (
+	kdfMu       sync.Mutex
+	writeKDF    = KDFHKDFv1
+	kdfHardware func() (uint64, error) // hardware ID source of the current key
+)
+
+// SetKDF selects the key derivation for the next LoadConfig and for writing.
+// Files written with another known version are still read and migrated. Like
+// SetKeyProvider it must be called before the first LoadConfig.
+func SetKDF(kdf KDF) error {
+	if _, ok := kdfs[kdf]; !ok {
+		return fmt.Errorf("%s", t("config.kdf_unknown", kdf))
+	}
+	kdfMu.Lock()
+	writeKDF = kdf
+	kdfMu.Unlock()
+	invalidateKey()
+	return nil
+}
+
+// currentKDF returns the version of the current key, or "" if the key comes
+// from a key provider.
+func currentKDF() KDF {
+	if getKeyProvider() != nil {
+		return ""
+	}
+	return selectedKDF()
+}
+
+// selectedKDF returns the key derivation version selected by SetKDF, also
+// while a key provider is set.
+func selectedKDF() KDF {
+	kdfMu.Lock()
+	defer kdfMu.Unlock()
+	return writeKDF
+}
+
+// deriveHardwareKey derives the unscoped key of the given version and
+// remembers hardwareID as the source for deriveKDFKey.
+func deriveHardwareKey(kdf KDF, hardwareID func() (uint64, error)) ([]byte, uint64, error) {
+	id, err := hardwareID()
+	if err != nil {
+		return nil, 0, err
+	}
+	kdfMu.Lock()
+	kdfHardware = hardwareID
+	kdfMu.Unlock()
+	key, err := kdfs[kdf](id)
+	return key, id, err
+}
+
+// deriveKDFKey derives the scoped key of another version from the hardware
+// ID the current key was derived from.
+func deriveKDFKey(kdf KDF) ([]byte, error) {
+	kdfMu.Lock()
+	hardwareID := kdfHardware
+	kdfMu.Unlock()
+	if hardwareID == nil {
+		if _, ok := kdfs[kdf]; !ok {
+			return nil, fmt.Errorf("%s", t("config.kdf_unknown", kdf))
+		}
+		return nil, fmt.Errorf("%s", t("config.load_first"))
+	}
+	return scopedHardwareKey(kdf, hardwareID)
+}
+
+// scopedHardwareKey derives the scoped key of the given version from the
+// hardware ID hardwareID returns, without touching the current key.
+func scopedHardwareKey(kdf KDF, hardwareID func() (uint64, error)) ([]byte, error) {
+	derive, ok := kdfs[kdf]
+	if !ok {
+		return nil, fmt.Errorf("%s", t("config.kdf_unknown", kdf))
+	}
+	id, err := hardwareID()
+	if err != nil {
+		return nil, err
+	}
+	base, err := derive(id)
+	if err != nil {
+		return nil, err
+	}
+	scoped, err := applyKeyScope(base)
+	if err != nil {
+		wipe(base)
+		return nil, err
+	}
+	return scoped, nil
+}
+
+func deriveLegacyRandKey(hardwareID uint64) ([]byte, error) {
+	// Deterministic expansion: same seed => same key (required for same-machine decrypt).
+	// Use Go-1.23-compatible RNG (key_rand_go123.go) so key is stable across Go versions.
+	keyRNG := newGo123KeySource(int64(hardwareID & 0x7fffffffffffffff))
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(keyRNG.Int63() >> 16 & 0xff)
+	}
+	return key, nil
+}
+
+func deriveHKDFv1Key(hardwareID uint64) ([]byte, error) {
+	var ikm [8]byte
+	binary.BigEndian.PutUint64(ikm[:], hardwareID)
+	return hkdf.Key(sha256.New, ikm[:], []byte("sconfig-kdf-v1"), "sconfig hardware key", 32)
+}
+
+// pbkdf2v1Iterations is fixed by the pbkdf2-v1 version; a different count is
+// a new version.
+const pbkdf2v1Iterations = 100000
+
+// derivePBKDF2v1Key derives the key as PBKDF2-HMAC-SHA256 with the hardware
+// ID as 8 bytes big-endian for password, "sconfig-kdf-v1" for salt, 100000
+// iterations and 32 bytes output. In PHP:
+//
+//	hash_pbkdf2('sha256', pack('J', $hardwareId), 'sconfig-kdf-v1', 100000, 32, true)
+func derivePBKDF2v1Key(hardwareID uint64) ([]byte, error) {
+	var password [8]byte
+	binary.BigEndian.PutUint64(password[:], hardwareID)
+	return pbkdf2.Key(sha256.New, string(password[:]), []byte("sconfig-kdf-v1"), pbkdf2v1Iterations, 32)
+}
+
+// migrateKDF re-encrypts the passwords of a config read from a file written
+// with another key derivation version; it reports whether anything changed.
+func migrateKDF(v reflect.Value, meta *fileMetadata) (bool, error) {
+	current := currentKDF()
+	if current == "" {
+		return false, nil
+	}
+	fileKDF := KDFLegacyRand
+	if meta != nil && meta.KDF != "" {
+		fileKDF = KDF(meta.KDF)
+	}
+	if fileKDF == current {
+		return false, nil
+	}
+	oldKey, err := deriveKDFKey(fileKDF)
+	if err != nil {
+		return false, err
+	}
+	defer wipe(oldKey)
+	w := &walker{phases: phaseRekey, oldKey: oldKey}
+	err = w.walk(v)
+	return w.changed, err
+}
@@ -0,0 +1,96 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKDFVersions(ts *testing.T) {
+	legacy, _ := deriveLegacyRandKey(4711)
+	hkdfKey, _ := deriveHKDFv1Key(4711)
+	other, _ := deriveHKDFv1Key(4712)
+	if len(hkdfKey) != 32 || bytes.Equal(legacy, hkdfKey) || bytes.Equal(hkdfKey, other) {
+		ts.Error("key derivation versions must yield different 32-byte keys")
+	}
+	// Known answer, e.g. from PHP hash_pbkdf2('sha256', pack('J', 4711),
+	// 'sconfig-kdf-v1', 100000, 32): interop targets must get the same key.
+	pbkdf2Key, _ := derivePBKDF2v1Key(4711)
+	if got := hex.EncodeToString(pbkdf2Key); got != "2ebb8f672b76f19fbdbe1e1d66a0436b91268ef596af7924893735c58246afdf" {
+		ts.Errorf("unexpected pbkdf2-v1 key %s", got)
+	}
+	if err := SetKDF("argon-v0"); err == nil || !contains(err.Error(), t("config.kdf_unknown", "argon-v0")) {
+		ts.Errorf("expected unknown KDF error, got %v", err)
+	}
+}
+
+func TestLoadConfig_KDFMigration(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer SetKDF(KDFHKDFv1)
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "kdf.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_password": "kdf-secret"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), `"kdf": "hkdf-v1"`) {
+		ts.Fatalf("KDF version not recorded:\n%s", raw)
+	}
+
+	// A file of the previous version is read and migrated to the new one.
+	for _, kdf := range []KDF{KDFLegacyRand, KDFPBKDF2v1, KDFHKDFv1} {
+		if err := SetKDF(kdf); err != nil {
+			ts.Fatalf("SetKDF failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+			ts.Fatalf("LoadConfig with %s failed: %v", kdf, err)
+		}
+		if cfg.DatabasePassword != "kdf-secret" {
+			ts.Errorf("%s: expected decrypted password, got %q", kdf, cfg.DatabasePassword)
+		}
+		migrated, _ := os.ReadFile(configPath)
+		if !strings.Contains(string(migrated), `"kdf": "`+string(kdf)+`"`) || string(migrated) == string(raw) {
+			ts.Errorf("%s: file not migrated:\n%s", kdf, migrated)
+		}
+		raw = migrated
+	}
+}
+
+func TestLoadConfig_KDFMigrationWithoutMetadata(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer SetKDF(KDFHKDFv1)
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "kdf-old.json")
+
+	// A file from before the metadata block: encrypted with the math/rand
+	// derivation and without a "kdf" field.
+	legacy, _ := deriveLegacyRandKey(4711)
+	ciphertext, err := encryptWithKey(legacy, "old-secret")
+	if err != nil {
+		ts.Fatalf("encryptWithKey failed: %v", err)
+	}
+	data := `{"database_password": "` + translateIn("en", "config.password_message") + `", "database_secure_password": "` + ciphertext + `"}`
+	if err := os.WriteFile(configPath, []byte(data), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// The default write version is hkdf-v1.
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabasePassword != "old-secret" {
+		ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), `"kdf": "hkdf-v1"`) || strings.Contains(string(raw), ciphertext) {
+		ts.Errorf("file not migrated to hkdf-v1:\n%s", raw)
+	}
+}
@@ -0,0 +1,126 @@
+package sconfig
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+/*
+ * Key providers.
+ *
+ * By default the encryption key is derived from the hardware ID, which binds
+ * a config to one machine. Containers and NAS deployments often keep their
+ * data directory across hardware changes instead; for them a KeyProvider
+ * supplies the key from somewhere else, e.g. a key file in the data directory.
+ */
+
+// KeyProvider supplies the 32-byte AES key used for the password fields. The
+// returned slice is wiped after use, so Key must return a fresh copy.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+var (
+	keyProviderMu sync.Mutex
+	keyProvider   KeyProvider
+)
+
+// SetKeyProvider makes the next LoadConfig take its key from p instead of the
+// hardware ID; nil restores the hardware-bound key. The key is derived once,
+// so call SetKeyProvider before the first LoadConfig.
+func SetKeyProvider(p KeyProvider) {
+	keyProviderMu.Lock()
+	keyProvider = p
+	keyProviderMu.Unlock()
+	invalidateKey()
+}
+
+func getKeyProvider() KeyProvider {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	return keyProvider
+}
+
+// KeyFileProvider reads the key from a file holding exactly 32 raw bytes. If the
+// file does not exist yet, a random key is generated and written with mode
+// 0600 (missing directories are created with 0700). On Unix an existing key
+// file must not be accessible by group or others.
+//
+// Keep a backup of the key file: configs encrypted with it cannot be
+// decrypted without it.
+type KeyFileProvider struct {
+	Path string
+}
+
+// Key reads or creates the key file.
+func (p *KeyFileProvider) Key() ([]byte, error) {
+	key, err := readKeyFile(p.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = createKeyFile(p.Path)
+		if errors.Is(err, os.ErrExist) {
+			// Another process created it in the meantime.
+			key, err = readKeyFile(p.Path)
+		}
+	}
+	return key, err
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s", t("config.keyfile_permissions", path, info.Mode().Perm()))
+	}
+	// Read one byte more than needed to detect oversized files.
+	key := make([]byte, 33)
+	n, err := io.ReadFull(f, key)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	if n != 32 {
+		return nil, fmt.Errorf("%s", t("config.keyfile_invalid", path))
+	}
+	return key[:32], nil
+}
+
+func createKeyFile(path string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	_, err = f.Write(key)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("%s", t("config.keyfile_failed", path, err))
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+package sconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestKeyFileProvider(ts *testing.T) {
+	dir := ts.TempDir()
+
+	ts.Run("Creates key on first use and reuses it", func(ts *testing.T) {
+		path := filepath.Join(dir, "data", "sconfig.key")
+		p := &KeyFileProvider{Path: path}
+		key, err := p.Key()
+		if err != nil {
+			ts.Fatalf("Key failed: %v", err)
+		}
+		if len(key) != 32 {
+			ts.Fatalf("expected 32 byte key, got %d", len(key))
+		}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			if err != nil {
+				ts.Fatalf("Stat failed: %v", err)
+			}
+			if info.Mode().Perm() != 0600 {
+				ts.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+			}
+		}
+		again, err := p.Key()
+		if err != nil || !bytes.Equal(key, again) {
+			ts.Errorf("expected the same key on second use, err=%v", err)
+		}
+	})
+
+	ts.Run("Rejects wrong size", func(ts *testing.T) {
+		path := filepath.Join(dir, "short.key")
+		if err := os.WriteFile(path, []byte("too short"), 0600); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := (&KeyFileProvider{Path: path}).Key(); err == nil {
+			ts.Error("expected error for short key file")
+		}
+		if err := os.WriteFile(path, make([]byte, 33), 0600); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := (&KeyFileProvider{Path: path}).Key(); err == nil {
+			ts.Error("expected error for oversized key file")
+		}
+	})
+
+	ts.Run("Rejects readable by others", func(ts *testing.T) {
+		if runtime.GOOS == "windows" {
+			ts.Skip("permission bits are not enforced on Windows")
+		}
+		path := filepath.Join(dir, "open.key")
+		if err := os.WriteFile(path, make([]byte, 32), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := os.Chmod(path, 0644); err != nil {
+			ts.Fatalf("Chmod failed: %v", err)
+		}
+		if _, err := (&KeyFileProvider{Path: path}).Key(); err == nil {
+			ts.Error("expected error for key file readable by others")
+		}
+	})
+}
+
+func TestLoadConfig_KeyFileProvider(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer SetKeyProvider(nil)
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_password": "keyfile-secret"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+
+	SetKeyProvider(&KeyFileProvider{Path: filepath.Join(tempDir, "a.key")})
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	// The same key file decrypts the config again, e.g. after a hardware change.
+	SetKeyProvider(&KeyFileProvider{Path: filepath.Join(tempDir, "a.key")})
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig (reload) failed: %v", err)
+	}
+	if cfg.DatabasePassword != "keyfile-secret" {
+		ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+	}
+
+	// A different key file cannot decrypt it.
+	SetKeyProvider(&KeyFileProvider{Path: filepath.Join(tempDir, "b.key")})
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false); err == nil {
+		ts.Error("expected decryption error with another key file")
+	}
+
+	// The hardware-bound key cannot decrypt it either.
+	SetKeyProvider(nil)
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, func() (uint64, error) { return 42, nil }); err == nil {
+		ts.Error("expected decryption error with the hardware key")
+	}
+}
@@ -0,0 +1,138 @@
+package sconfig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+ * Key scopes.
+ *
+ * The hardware-bound key is the same for every user and every program on a
+ * machine. A key scope narrows it: the base key (hardware ID or KeyProvider)
+ * is combined with the OS user (UID on Unix, SID on Windows), with an
+ * application identifier or with the hash of the running binary through
+ * HMAC-SHA256, so configs of other users or other programs on the same host
+ * cannot be decrypted with it.
+ */
+
+// KeyScope selects who can decrypt a config on the same machine.
+type KeyScope int
+
+const (
+	// KeyScopeMachine shares the key among all users and applications of the
+	// machine (default, compatible with existing configs).
+	KeyScopeMachine KeyScope = iota
+	// KeyScopeUser binds the key to the current OS user.
+	KeyScopeUser
+	// KeyScopeApplication binds the key to an application identifier.
+	KeyScopeApplication
+	// KeyScopeExecutable binds the key to the SHA-256 of the running binary.
+	// Every new build changes the key, so secrets have to be re-entered (or
+	// the config converted with cleanConfig) after an update.
+	KeyScopeExecutable
+)
+
+// String returns the lower-case name of the scope.
+func (s KeyScope) String() string {
+	switch s {
+	case KeyScopeMachine:
+		return "machine"
+	case KeyScopeUser:
+		return "user"
+	case KeyScopeApplication:
+		return "application"
+	case KeyScopeExecutable:
+		return "executable"
+	}
+	return "unknown"
+}
+
+var (
+	keyScopeMu    sync.Mutex
+	keyScope      KeyScope
+	keyScopeAppID string
+)
+
+// SetKeyScope selects the key scope for the next LoadConfig. appID is only
+// used with KeyScopeApplication; if empty, the executable name is used. Like
+// SetKeyProvider it must be called before the first LoadConfig, and configs
+// written with one scope can only be read with the same scope.
+func SetKeyScope(scope KeyScope, appID string) {
+	keyScopeMu.Lock()
+	keyScope = scope
+	keyScopeAppID = appID
+	keyScopeMu.Unlock()
+	invalidateKey()
+}
+
+// applyKeyScope derives the scoped key from the machine-wide base key.
+func applyKeyScope(base []byte) ([]byte, error) {
+	keyScopeMu.Lock()
+	scope, appID := keyScope, keyScopeAppID
+	keyScopeMu.Unlock()
+
+	var identity string
+	switch scope {
+	case KeyScopeMachine:
+		return base, nil
+	case KeyScopeUser:
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf(t("config.key_scope_failed"), scope, err)
+		}
+		identity = u.Uid // the SID on Windows
+	case KeyScopeApplication:
+		if appID == "" {
+			exe, err := os.Executable()
+			if err != nil {
+				return nil, fmt.Errorf(t("config.key_scope_failed"), scope, err)
+			}
+			appID = strings.TrimSuffix(filepath.Base(exe), ".exe")
+		}
+		identity = appID
+	case KeyScopeExecutable:
+		sum, err := executableHash()
+		if err != nil {
+			return nil, fmt.Errorf(t("config.key_scope_failed"), scope, err)
+		}
+		identity = sum
+	default:
+		return nil, fmt.Errorf(t("config.key_scope_failed"), scope, errors.New("unknown scope"))
+	}
+	return scopeKey(base, scope, identity), nil
+}
+
+// scopeKey derives the key of scope and identity from the base key.
+func scopeKey(base []byte, scope KeyScope, identity string) []byte {
+	mac := hmac.New(sha256.New, base)
+	mac.Write([]byte("sconfig-key-scope\x00" + scope.String() + "\x00" + identity))
+	return mac.Sum(nil)
+}
+
+// executableHash returns the hex SHA-256 of the running binary.
+func executableHash() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
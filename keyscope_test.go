@@ -0,0 +1,77 @@
+package sconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyKeyScope(ts *testing.T) {
+	defer SetKeyScope(KeyScopeMachine, "")
+	base := bytes.Repeat([]byte{7}, 32)
+
+	SetKeyScope(KeyScopeMachine, "")
+	machine, err := applyKeyScope(base)
+	if err != nil || !bytes.Equal(machine, base) {
+		ts.Fatalf("machine scope must keep the base key, err=%v", err)
+	}
+	SetKeyScope(KeyScopeUser, "")
+	userKey, err := applyKeyScope(base)
+	if err != nil {
+		ts.Fatalf("user scope failed: %v", err)
+	}
+	SetKeyScope(KeyScopeApplication, "billing")
+	appA, _ := applyKeyScope(base)
+	appA2, _ := applyKeyScope(base)
+	SetKeyScope(KeyScopeApplication, "crm")
+	appB, _ := applyKeyScope(base)
+	SetKeyScope(KeyScopeExecutable, "")
+	exeKey, err := applyKeyScope(base)
+	if err != nil {
+		ts.Fatalf("executable scope failed: %v", err)
+	}
+	if len(userKey) != 32 || len(appA) != 32 {
+		ts.Fatalf("scoped keys must have 32 bytes")
+	}
+	if !bytes.Equal(appA, appA2) {
+		ts.Error("scoped key must be deterministic")
+	}
+	if bytes.Equal(userKey, base) || bytes.Equal(appA, base) || bytes.Equal(appA, appB) || bytes.Equal(userKey, appA) ||
+		bytes.Equal(exeKey, base) || bytes.Equal(exeKey, appA) {
+		ts.Error("different scopes must yield different keys")
+	}
+}
+
+func TestLoadConfig_KeyScope(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	defer SetKeyScope(KeyScopeMachine, "")
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_password": "scoped-secret"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+
+	SetKeyScope(KeyScopeApplication, "billing")
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	SetKeyScope(KeyScopeApplication, "billing")
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig (same scope) failed: %v", err)
+	}
+	if cfg.DatabasePassword != "scoped-secret" {
+		ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+	}
+
+	for _, scope := range []struct {
+		scope KeyScope
+		appID string
+	}{{KeyScopeApplication, "crm"}, {KeyScopeUser, ""}, {KeyScopeExecutable, ""}, {KeyScopeMachine, ""}} {
+		SetKeyScope(scope.scope, scope.appID)
+		if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, hw); err == nil {
+			ts.Errorf("scope %v %q must not decrypt a config of application billing", scope.scope, scope.appID)
+		}
+	}
+}
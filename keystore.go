@@ -0,0 +1,93 @@
+package sconfig
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+/*
+ * OS keystores.
+ *
+ * A hardware-bound key is lost with the hardware; a key file can be copied
+ * away with the data. OSKeyStore keeps a random key in the keystore of the
+ * operating system instead, bound to the user account: the login Keychain
+ * on macOS (security), the Secret Service (GNOME Keyring, KWallet) on Linux
+ * and the BSDs (secret-tool from libsecret) and a DPAPI-protected file on
+ * Windows. Configs then survive hardware changes but cannot be read by other
+ * accounts or, with DPAPI and the Keychain, on other machines. The key is
+ * created on first use.
+ */
+
+// errKeyNotStored is returned by keystoreLoad if the keystore has no key yet.
+var errKeyNotStored = errors.New("key not stored")
+
+// The keystore of the platform (keystore_*.go); replaced in tests.
+var (
+	keystoreLoad = osKeystoreLoad
+	keystoreSave = osKeystoreSave
+)
+
+// OSKeyStore is a KeyProvider that keeps the key in the keystore of the
+// operating system under Service and Account. Use one Service per
+// application; configs encrypted with the key cannot be decrypted without the
+// keystore entry.
+type OSKeyStore struct {
+	Service string // default "sconfig"
+	Account string // default "config-key"
+}
+
+// Key reads the key from the keystore, creating it on first use.
+func (s OSKeyStore) Key() ([]byte, error) {
+	service, account := s.Service, s.Account
+	if service == "" {
+		service = "sconfig"
+	}
+	if account == "" {
+		account = "config-key"
+	}
+	key, err := keystoreLoad(service, account)
+	if errors.Is(err, errKeyNotStored) {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("%s", t("config.keystore_failed", service, err))
+		}
+		if err := keystoreSave(service, account, key); err != nil {
+			wipe(key)
+			return nil, fmt.Errorf("%s", t("config.keystore_failed", service, err))
+		}
+		wipe(key)
+		// Read back: a concurrent first use may have stored another key.
+		key, err = keystoreLoad(service, account)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.keystore_failed", service, err))
+	}
+	if len(key) != 32 {
+		wipe(key)
+		return nil, fmt.Errorf("%s", t("config.keystore_failed", service, fmt.Errorf("key has %d bytes, need 32", len(key))))
+	}
+	return key, nil
+}
+
+// WithKeyStore makes Load take the key from p, e.g. OSKeyStore{Service:
+// "myapp"}, as SetKeyProvider(p) before the call would; p stays in effect for
+// later calls.
+func WithKeyStore(p KeyProvider) LoadOption {
+	return func(o *loadOptions) { o.keyStore = p }
+}
+
+// useKeyStore makes p the key provider if it is not already. Callers hold
+// stateMu.
+func useKeyStore(p KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	current := keyProvider
+	if current != nil && reflect.TypeOf(current) == reflect.TypeOf(p) && reflect.TypeOf(p).Comparable() && current == p {
+		return
+	}
+	keyProvider = p
+	initialized = false
+}
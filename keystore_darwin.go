@@ -0,0 +1,55 @@
+//go:build darwin
+
+package sconfig
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Exit code of security(1) for errSecItemNotFound.
+const securityItemNotFound = 44
+
+// osKeystoreLoad reads the key from the login Keychain.
+func osKeystoreLoad(service, account string) ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+		return nil, errKeyNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// osKeystoreSave adds the key to the login Keychain. security takes the
+// password only as an argument, so the command is fed to security -i on
+// stdin rather than run with the key in the process list.
+func osKeystoreSave(service, account string, key []byte) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -s %s -a %s -w %s\n",
+		securityQuote(service), securityQuote(account), hex.EncodeToString(key)))
+	out, err := cmd.CombinedOutput()
+	// In interactive mode, security reports a failed command on its output
+	// and may still exit with 0.
+	msg := strings.TrimSpace(strings.ReplaceAll(string(out), "security> ", ""))
+	if err == nil && msg == "" {
+		return nil
+	}
+	if _, loadErr := osKeystoreLoad(service, account); loadErr == nil {
+		return nil // stored concurrently; the caller reads it back
+	}
+	if err == nil {
+		err = errors.New("security add-generic-password failed")
+	}
+	return fmt.Errorf("%v: %s", err, msg)
+}
+
+// securityQuote quotes s as one argument of a security -i command line.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
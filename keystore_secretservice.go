@@ -0,0 +1,39 @@
+//go:build !windows && !darwin
+
+package sconfig
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// osKeystoreLoad reads the key from the Secret Service with secret-tool.
+func osKeystoreLoad(service, account string) ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(strings.TrimSpace(string(exitErr.Stderr))) == 0 {
+		// secret-tool exits with 1 and no message if there is no such item.
+		return nil, errKeyNotStored
+	}
+	if exitErr != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// osKeystoreSave stores the key in the Secret Service with secret-tool, which
+// reads it from stdin.
+func osKeystoreSave(service, account string, key []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "sconfig key ("+service+")", "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
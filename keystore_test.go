@@ -0,0 +1,63 @@
+package sconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOSKeyStore_WithKeyStore(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	stored := map[string][]byte{}
+	saves := 0
+	keystoreLoad = func(service, account string) ([]byte, error) {
+		key, ok := stored[service+"/"+account]
+		if !ok {
+			return nil, errKeyNotStored
+		}
+		return append([]byte(nil), key...), nil
+	}
+	keystoreSave = func(service, account string, key []byte) error {
+		saves++
+		stored[service+"/"+account] = append([]byte(nil), key...)
+		return nil
+	}
+	defer func() {
+		keystoreLoad, keystoreSave = osKeystoreLoad, osKeystoreSave
+		SetKeyProvider(nil)
+	}()
+
+	configPath := filepath.Join(tempDir, "keystore.json")
+	store := OSKeyStore{Service: "sconfig-test"}
+	if err := Load(&TestConfig{DatabasePassword: "in-keystore"}, configPath, WithKeyStore(store)); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if saves != 1 || len(stored["sconfig-test/config-key"]) != 32 {
+		ts.Fatalf("key not created in the keystore: %d saves, %v", saves, stored)
+	}
+
+	// Another hardware ID does not matter; the key comes from the keystore.
+	invalidateKey()
+	cfg := &TestConfig{}
+	if err := Load(cfg, configPath, WithKeyStore(store), WithHardwareIDFunc(func() (uint64, error) { return 1, nil })); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if cfg.DatabasePassword != "in-keystore" || saves != 1 {
+		ts.Errorf("expected the stored key, got %q after %d saves", cfg.DatabasePassword, saves)
+	}
+
+	// Another entry is another key.
+	if err := Load(&TestConfig{}, configPath, WithKeyStore(OSKeyStore{Service: "other-app"})); err == nil {
+		ts.Error("another keystore entry decrypted the config")
+	}
+	if raw, _ := os.ReadFile(configPath); strings.Contains(string(raw), "in-keystore") {
+		ts.Errorf("password written in plain text:\n%s", raw)
+	}
+
+	keystoreLoad = func(string, string) ([]byte, error) { return nil, errors.New("no D-Bus session") }
+	if _, err := (OSKeyStore{}).Key(); err == nil || !contains(err.Error(), t("config.keystore_failed", "sconfig", "no D-Bus session")) {
+		ts.Errorf("expected keystore error, got %v", err)
+	}
+}
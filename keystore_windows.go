@@ -0,0 +1,111 @@
+//go:build windows
+
+package sconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+)
+
+const cryptProtectUIForbidden = 0x1
+
+// dataBlob is the DATA_BLOB of the DPAPI.
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newDataBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// dpapi calls CryptProtectData or CryptUnprotectData, which take the same
+// arguments, with the entry name as additional entropy.
+func dpapi(proc *syscall.LazyProc, in, entropy []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(newDataBlob(in))), 0, uintptr(unsafe.Pointer(newDataBlob(entropy))),
+		0, 0, cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer syscall.LocalFree(syscall.Handle(uintptr(unsafe.Pointer(out.data))))
+	buf := unsafe.Slice(out.data, out.size)
+	result := append([]byte(nil), buf...)
+	wipe(buf)
+	return result, nil
+}
+
+// keystoreFile returns the DPAPI-protected key file of an entry.
+func keystoreFile(service, account string) (string, []byte, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", nil, err
+	}
+	entry := []byte("sconfig\x00" + service + "\x00" + account)
+	sum := sha256.Sum256(entry)
+	return filepath.Join(dir, "sconfig", "keystore", hex.EncodeToString(sum[:16])+".key"), entry, nil
+}
+
+// osKeystoreLoad reads and unprotects the key file of the user.
+func osKeystoreLoad(service, account string) ([]byte, error) {
+	path, entropy, err := keystoreFile(service, account)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errKeyNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dpapi(procCryptUnprotectData, protected, entropy)
+}
+
+// osKeystoreSave protects the key for the current user and writes the key
+// file, readable only by the user.
+func osKeystoreSave(service, account string, key []byte) error {
+	path, entropy, err := keystoreFile(service, account)
+	if err != nil {
+		return err
+	}
+	protected, err := dpapi(procCryptProtectData, key, entropy)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := restrictDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil // stored concurrently; the caller reads it back
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(protected)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package sconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+/*
+ * Last known good config.
+ *
+ * A bad edit of the config file (a syntax error, a mangled ciphertext, a
+ * value the validation rejects) makes LoadConfig fail, and a service that
+ * restarts at that moment does not come up. WithLastGood keeps a copy of the
+ * file as it was at the last successful load, encrypted with the config key,
+ * in <path>.last-good. When a later load fails, the config is loaded from the
+ * copy instead (defaults, passwords, overrides, secret references and
+ * validation as usual), the failure is passed to the warning handler (see
+ * SetWarningHandler) and counted (LastGoodFallbacks), and the load succeeds.
+ *
+ * The broken file is left as it is; it is not rewritten from the copy.
+ * UpdateConfig treats the edit like a change by another writer
+ * (concurrency.go) and fails as long as the file cannot be read. The copy is
+ * only readable with the key of this machine, like the passwords in the
+ * file.
+ */
+
+// lastGoodSuffix names the copy of a config file kept by WithLastGood.
+const lastGoodSuffix = ".last-good"
+
+// WithLastGood keeps an encrypted copy of the config file after each
+// successful load and falls back to it when the file cannot be loaded.
+func WithLastGood() LoadOption {
+	return func(o *loadOptions) { o.lastGood = true }
+}
+
+// saveLastGood writes the content config was loaded from to the copy of its
+// file. A failure is only warned about.
+func saveLastGood(config interface{}) {
+	state := loadedFileOf(config)
+	if state == nil {
+		return
+	}
+	sealed, err := encryptWithKey(encryptionKey, string(state.content))
+	if err == nil {
+		err = writeFileAtomic(state.path+lastGoodSuffix, 0600, func(w io.Writer) error {
+			_, err := io.WriteString(w, sealed)
+			return err
+		})
+	}
+	if err != nil {
+		warn(t("config.last_good_write_failed", state.path, err))
+	}
+}
+
+// loadLastGood loads config, reset to its state before the load, from the
+// copy of the config file at path, like load would have loaded the file.
+func loadLastGood(config interface{}, path string, o loadOptions) error {
+	path, err := resolveConfigPath(path)
+	if err != nil {
+		return err
+	}
+	if evaluatorFor(path) != nil {
+		path = evaluatedCachePath(path)
+	}
+	if !initialized {
+		return fmt.Errorf("%s", t("config.load_first"))
+	}
+	sealed, err := os.ReadFile(path + lastGoodSuffix)
+	if err != nil {
+		return err
+	}
+	content, err := decrypt(string(sealed))
+	if err != nil {
+		return err
+	}
+	if err := decodeConfigBytes(path, o.namespace, []byte(content), config); err != nil {
+		return err
+	}
+	if v := reflect.ValueOf(config).Elem(); o.versionSet {
+		if i := getStructInfo(v.Type()).versionIndex; i >= 0 {
+			v.Field(i).SetInt(int64(o.version))
+		}
+	}
+	// UpdateConfig merges the broken file against the copy.
+	loadedFilesMu.Lock()
+	loadedFiles[config] = &loadedFile{path: path, sum: sha256.Sum256([]byte(content)), content: []byte(content)}
+	loadedFilesMu.Unlock()
+	if err := applyOverrideFile(path, config); err != nil {
+		return err
+	}
+	if err := applyEnvOverrides(path, config); err != nil {
+		return err
+	}
+	if err := applyFlagOverrides(path, config); err != nil {
+		return err
+	}
+	if err := resolveSecretRefs(config); err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
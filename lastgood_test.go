@@ -0,0 +1,74 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_LastGood(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	var warnings []string
+	SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	defer SetWarningHandler(defaultWarningHandler)
+	hw := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	configPath := filepath.Join(tempDir, "service.json")
+	if err := os.WriteFile(configPath, []byte(`{"currency": "USD", "db_password": "good-secret"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := Load(&billingTestConfig{}, configPath, hw, WithVersion(2), WithLastGood()); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	sealed, err := os.ReadFile(configPath + lastGoodSuffix)
+	if err != nil {
+		ts.Fatalf("no last good copy: %v", err)
+	}
+	if strings.Contains(string(sealed), "USD") || strings.Contains(string(sealed), "currency") {
+		ts.Errorf("last good copy not encrypted: %s", sealed)
+	}
+
+	// A bad edit: the last good copy is used.
+	if err := os.WriteFile(configPath, []byte(`{"currency": "EUR",`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := Load(&billingTestConfig{}, configPath, hw); err == nil {
+		ts.Fatal("broken file loaded without WithLastGood")
+	}
+	fallbacks := LastGoodFallbacks()
+	cfg := &billingTestConfig{}
+	if err := Load(cfg, configPath, hw, WithVersion(2), WithLastGood()); err != nil {
+		ts.Fatalf("Load with last good copy failed: %v", err)
+	}
+	if cfg.Currency != "USD" || cfg.DBPassword != "good-secret" || cfg.Version != 2 {
+		ts.Errorf("config from last good copy = %+v", cfg)
+	}
+	if LastGoodFallbacks() != fallbacks+1 || len(warnings) == 0 || !strings.Contains(warnings[len(warnings)-1], configPath) {
+		ts.Errorf("fallback not reported: %d, %q", LastGoodFallbacks()-fallbacks, warnings)
+	}
+	if raw, _ := os.ReadFile(configPath); string(raw) != `{"currency": "EUR",` {
+		ts.Errorf("broken file rewritten:\n%s", raw)
+	}
+	cfg.Currency = "CHF"
+	if err := UpdateConfig(cfg, configPath); err == nil {
+		ts.Error("UpdateConfig overwrote the broken file")
+	}
+
+	// Fixed again: the file is used and becomes the last good copy.
+	if err := os.WriteFile(configPath, []byte(`{"currency": "GBP"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg = &billingTestConfig{}
+	if err := Load(cfg, configPath, hw, WithLastGood()); err != nil || cfg.Currency != "GBP" {
+		ts.Fatalf("Load of the fixed file = %+v, %v", cfg, err)
+	}
+	if err := os.WriteFile(configPath, []byte(`broken`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg = &billingTestConfig{}
+	if err := Load(cfg, configPath, hw, WithLastGood()); err != nil || cfg.Currency != "GBP" || cfg.DBPassword != "" {
+		ts.Errorf("second fallback = %+v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,161 @@
+package sconfig
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Secret leases.
+ *
+ * Secrets from Vault or AWS are often short-lived (dynamic database
+ * credentials): the resolver hands them out with a lease that expires. A
+ * resolver that implements LeaseResolver reports the lease duration with
+ * each value. A Loader created with WithSecretLeases renews every leased
+ * value after two thirds of its lease in the background: it resolves the
+ * reference again, sets the new value in the config (unless the program has
+ * changed the field), updates the derived fields (rotate.go), publishes a
+ * new snapshot (Snapshot, WithStore) and calls the notify function with the
+ * field name. A failed renewal is reported through the warning handler and
+ * retried after leaseRetry.
+ */
+
+// LeaseResolver is a SecretResolver whose values expire. ResolveLease returns
+// the value and its lease duration; 0 means the value does not expire.
+type LeaseResolver interface {
+	SecretResolver
+	ResolveLease(ref string) (value string, ttl time.Duration, err error)
+}
+
+var (
+	// leaseRetry is the delay before a failed renewal is tried again.
+	leaseRetry = 10 * time.Second
+	// leaseIdle is how often a Loader without leases checks for new ones.
+	leaseIdle = time.Minute
+)
+
+// leaseRenewal returns when a value with the given lease is renewed.
+func leaseRenewal(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl * 2 / 3)
+}
+
+// leaser runs the lease renewal of one Loader.
+type leaser struct {
+	notify func(field string)
+	start  sync.Once
+	stop   sync.Once
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// WithSecretLeases makes the Loader renew leased secret references (see
+// LeaseResolver) in the background after the first successful Load. notify,
+// if not nil, is called with the field name after each renewed value. The
+// config struct is then modified in the background; read it through
+// Snapshot or a Store.
+func WithSecretLeases(notify func(field string)) LoaderOption {
+	return func(l *Loader) {
+		l.leases = &leaser{notify: notify, stopCh: make(chan struct{}), done: make(chan struct{})}
+	}
+}
+
+// startLeases starts the renewal goroutine once. Called with l.mu held.
+func (l *Loader) startLeases() {
+	if r := l.leases; r != nil {
+		r.start.Do(func() { go l.leaseLoop(r) })
+	}
+}
+
+func (l *Loader) leaseLoop(r *leaser) {
+	defer close(r.done)
+	for {
+		wait := leaseIdle
+		if next := l.nextRenewal(); !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+			l.renewLeases(r)
+		}
+	}
+}
+
+// nextRenewal returns the earliest renewal time of the config's leases.
+func (l *Loader) nextRenewal() time.Time {
+	resolvedRefsMu.Lock()
+	defer resolvedRefsMu.Unlock()
+	var next time.Time
+	for _, b := range resolvedRefs[l.config] {
+		if !b.renewAt.IsZero() && (next.IsZero() || b.renewAt.Before(next)) {
+			next = b.renewAt
+		}
+	}
+	return next
+}
+
+// renewLeases resolves the due leases again and applies the new values.
+func (l *Loader) renewLeases(r *leaser) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	var renewed []string
+	addrs := make(map[uintptr]bool)
+	now := time.Now()
+	resolvedRefsMu.Lock()
+	bindings := resolvedRefs[l.config]
+	for i := range bindings {
+		b := &bindings[i]
+		if b.renewAt.IsZero() || b.renewAt.After(now) {
+			continue
+		}
+		value, ttl, err := resolveSecretLease(b.ref)
+		if err != nil {
+			warn(t("config.lease_failed", b.name, err))
+			b.renewAt = now.Add(leaseRetry)
+			continue
+		}
+		if b.field.String() == b.resolved {
+			b.field.SetString(value)
+			rememberSecret(value)
+			renewed = append(renewed, b.name)
+			addrs[b.field.UnsafeAddr()] = true
+		}
+		b.resolved, b.renewAt = value, leaseRenewal(ttl)
+	}
+	resolvedRefsMu.Unlock()
+	var callbacks []func()
+	if len(renewed) > 0 {
+		callbacks = l.rotated(addrs)
+		l.publish()
+	}
+	l.mu.Unlock()
+	if r.notify != nil {
+		for _, name := range renewed {
+			r.notify(name)
+		}
+	}
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// stopLeases stops the renewal goroutine, if any, and waits for it. It must
+// not be called with l.mu held.
+func (l *Loader) stopLeases() {
+	r := l.leases
+	if r == nil {
+		return
+	}
+	r.stop.Do(func() { close(r.stopCh) })
+	r.start.Do(func() { close(r.done) }) // never started: nothing to wait for
+	<-r.done
+}
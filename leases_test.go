@@ -0,0 +1,135 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingLeaseResolver hands out a new credential on every call.
+type countingLeaseResolver struct {
+	calls atomic.Int32
+	ttl   time.Duration
+}
+
+func (r *countingLeaseResolver) Resolve(ref string) (string, error) {
+	value, _, err := r.ResolveLease(ref)
+	return value, err
+}
+
+func (r *countingLeaseResolver) ResolveLease(ref string) (string, time.Duration, error) {
+	return fmt.Sprintf("%s-%d", ref, r.calls.Add(1)), r.ttl, nil
+}
+
+func TestLoader_SecretLeases(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	resolver := &countingLeaseResolver{ttl: 30 * time.Millisecond}
+	RegisterSecretResolver("leasetest", resolver)
+	defer RegisterSecretResolver("leasetest", nil)
+
+	renewed := make(chan string, 16)
+	var store Store[TestConfig]
+	cfg := &TestConfig{APIKey: "secretref:leasetest:dbcred"}
+	l := NewLoader(cfg, filepath.Join(tempDir, "lease.json"), 1, WithStore(&store), WithSecretLeases(func(field string) {
+		select {
+		case renewed <- field:
+		default:
+		}
+	}))
+	defer l.Close()
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if store.Load().APIKey != "dbcred-1" {
+		ts.Fatalf("unexpected first value %q", store.Load().APIKey)
+	}
+
+	select {
+	case field := <-renewed:
+		if field != "APIKey" {
+			ts.Errorf("unexpected field %q", field)
+		}
+	case <-time.After(5 * time.Second):
+		ts.Fatal("lease was not renewed")
+	}
+	if got := store.Load().APIKey; got == "dbcred-1" {
+		ts.Errorf("snapshot not updated: %q", got)
+	}
+
+	// The reference, not the leased value, is written back.
+	if err := l.Save(); err != nil {
+		ts.Fatalf("Save failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(tempDir, "lease.json")); !strings.Contains(string(raw), "secretref:leasetest:dbcred") {
+		ts.Errorf("reference not written back: %s", raw)
+	}
+	if err := l.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	calls := resolver.calls.Load()
+	time.Sleep(100 * time.Millisecond)
+	if resolver.calls.Load() != calls {
+		ts.Error("leases renewed after Close")
+	}
+}
+
+// DSNTestConfig derives a connection string from a leased credential.
+type DSNTestConfig struct {
+	DBUser  string `json:"db_user"`
+	DBToken string `json:"db_token"`
+	DSN     string `json:"-"`
+}
+
+func TestLoader_DerivedFieldOnRotation(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	resolver := &countingLeaseResolver{ttl: 30 * time.Millisecond}
+	RegisterSecretResolver("leasetest", resolver)
+	defer RegisterSecretResolver("leasetest", nil)
+
+	cfg := &DSNTestConfig{DBUser: "app", DBToken: "secretref:leasetest:token"}
+	l := NewLoader(cfg, filepath.Join(tempDir, "dsn.json"), 1, WithSecretLeases(nil),
+		WithDerivedField("DSN", func(config interface{}) (string, error) {
+			c := config.(*DSNTestConfig)
+			return "postgres://" + c.DBUser + ":" + c.DBToken + "@db/app", nil
+		}, "DBToken"))
+	defer l.Close()
+	changes := make(chan string, 16)
+	for _, field := range []string{"DSN", "DBToken", "DBUser"} {
+		l.OnChange(field, func(field string) {
+			select {
+			case changes <- field:
+			default:
+			}
+		})
+	}
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if dsn := l.Snapshot().(*DSNTestConfig).DSN; dsn != "postgres://app:token-1@db/app" {
+		ts.Fatalf("derived field not set after Load: %q", dsn)
+	}
+
+	got := map[string]bool{}
+	deadline := time.After(5 * time.Second)
+	for !got["DSN"] || !got["DBToken"] {
+		select {
+		case field := <-changes:
+			got[field] = true
+		case <-deadline:
+			ts.Fatalf("missing change callbacks, got %v", got)
+		}
+	}
+	if got["DBUser"] {
+		ts.Error("callback for a field that did not change")
+	}
+	if snapshot := l.Snapshot().(*DSNTestConfig); !strings.HasPrefix(snapshot.DSN, "postgres://app:token-") || snapshot.DSN == "postgres://app:token-1@db/app" {
+		ts.Errorf("snapshot has stale DSN %q", snapshot.DSN)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(tempDir, "dsn.json")); strings.Contains(string(raw), "postgres://") {
+		ts.Errorf("derived field written to the file: %s", raw)
+	}
+}
@@ -0,0 +1,125 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+/*
+ * License files bound to the hardware fingerprint.
+ *
+ * Products embedding sconfig can bind their licenses to the same machine
+ * identity as the config key. The customer sends the MachineFingerprint of
+ * the target machine (a hash of the hardware ID, not the ID itself) to the
+ * vendor, who signs a License for it with an Ed25519 private key
+ * (IssueLicense). The product embeds the public key and checks the license
+ * file at startup (VerifyLicense): signature, machine and expiry.
+ *
+ * A license file is JSON: the license and the base64 signature over its
+ * compact encoding as written by IssueLicense. Only whitespace may change;
+ * no other canonical form is needed.
+ */
+
+// License is the content of a license file.
+type License struct {
+	Product   string    `json:"product"`
+	Licensee  string    `json:"licensee"`
+	Machine   string    `json:"machine"` // MachineFingerprint of the licensed machine
+	Features  []string  `json:"features,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // zero: does not expire
+}
+
+// HasFeature reports whether the license includes feature.
+func (l *License) HasFeature(feature string) bool {
+	return slices.Contains(l.Features, feature)
+}
+
+// licenseFile is the JSON form of a signed license.
+type licenseFile struct {
+	License   json.RawMessage `json:"license"`
+	Signature []byte          `json:"signature"`
+}
+
+// MachineFingerprint returns the fingerprint of this machine for License.Machine.
+// It is derived from the hardware ID, which it does not reveal.
+func MachineFingerprint() (string, error) {
+	id, err := secure_config_getHardwareID()
+	if err != nil {
+		return "", err
+	}
+	return machineFingerprint(id), nil
+}
+
+func machineFingerprint(hardwareID uint64) string {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], hardwareID)
+	sum := sha256.Sum256(append([]byte("sconfig-license-v1"), id[:]...))
+	return hex.EncodeToString(sum[:16])
+}
+
+// IssueLicense signs lic with the vendor's private key and returns the license
+// file. lic.Machine must be set; a zero IssuedAt is set to now.
+func IssueLicense(key ed25519.PrivateKey, lic License) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s", t("config.license_key"))
+	}
+	if lic.Machine == "" {
+		return nil, fmt.Errorf("%s", t("config.license_no_machine"))
+	}
+	if lic.IssuedAt.IsZero() {
+		lic.IssuedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	data, err := json.Marshal(lic)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(licenseFile{License: data, Signature: ed25519.Sign(key, data)}, "", "\t")
+}
+
+// VerifyLicense checks the license file data against the vendor's public key
+// and this machine and returns the license. It fails for a bad signature, a
+// license of another machine, a license issued in the future and an expired
+// license; both times are checked with the skew set by SetClockSkew.
+func VerifyLicense(key ed25519.PublicKey, data []byte) (*License, error) {
+	return verifyLicense(key, data, secure_config_getHardwareID, time.Now())
+}
+
+func verifyLicense(key ed25519.PublicKey, data []byte, hardwareID func() (uint64, error), now time.Time) (*License, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s", t("config.license_key"))
+	}
+	var file licenseFile
+	if err := json.Unmarshal(data, &file); err != nil || len(file.License) == 0 {
+		return nil, fmt.Errorf("%s", t("config.license_invalid"))
+	}
+	var signed bytes.Buffer
+	if err := json.Compact(&signed, file.License); err != nil || !ed25519.Verify(key, signed.Bytes(), file.Signature) {
+		return nil, fmt.Errorf("%s", t("config.license_signature"))
+	}
+	lic := &License{}
+	if err := json.Unmarshal(file.License, lic); err != nil {
+		return nil, fmt.Errorf("%s", t("config.license_invalid"))
+	}
+	id, err := hardwareID()
+	if err != nil {
+		return nil, err
+	}
+	if lic.Machine != machineFingerprint(id) {
+		return nil, fmt.Errorf("%s", t("config.license_machine"))
+	}
+	if notYetValid(lic.IssuedAt, now) {
+		return nil, fmt.Errorf("%s", t("config.license_not_yet_valid", lic.IssuedAt.UTC().Format(time.DateTime)))
+	}
+	if expiredAt(lic.ExpiresAt, now) {
+		return nil, fmt.Errorf("%s", t("config.license_expired", lic.ExpiresAt.Format(time.DateOnly)))
+	}
+	return lic, nil
+}
@@ -0,0 +1,92 @@
+package sconfig
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLicense_IssueVerify(ts *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		ts.Fatalf("GenerateKey failed: %v", err)
+	}
+	machine := func() (uint64, error) { return 4711, nil }
+	other := func() (uint64, error) { return 4712, nil }
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := IssueLicense(priv, License{Product: "app"}); err == nil || !contains(err.Error(), t("config.license_no_machine")) {
+		ts.Fatalf("expected missing machine error, got %v", err)
+	}
+	data, err := IssueLicense(priv, License{
+		Product:   "app",
+		Licensee:  "ACME",
+		Machine:   machineFingerprint(4711),
+		Features:  []string{"export"},
+		IssuedAt:  now,
+		ExpiresAt: now.AddDate(1, 0, 0),
+	})
+	if err != nil {
+		ts.Fatalf("IssueLicense failed: %v", err)
+	}
+
+	lic, err := verifyLicense(pub, data, machine, now)
+	if err != nil {
+		ts.Fatalf("verifyLicense failed: %v", err)
+	}
+	if lic.Licensee != "ACME" || !lic.HasFeature("export") || lic.HasFeature("admin") || !lic.IssuedAt.Equal(now) {
+		ts.Errorf("unexpected license %+v", lic)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	tampered := []byte(strings.Replace(string(data), "ACME", "EVIL", 1))
+	tests := []struct {
+		name string
+		key  ed25519.PublicKey
+		data []byte
+		hw   func() (uint64, error)
+		now  time.Time
+		want string
+	}{
+		{"other vendor key", otherPub, data, machine, now, t("config.license_signature")},
+		{"tampered", pub, tampered, machine, now, t("config.license_signature")},
+		{"other machine", pub, data, other, now, t("config.license_machine")},
+		{"expired", pub, data, machine, now.AddDate(2, 0, 0), t("config.license_expired", "2027-05-01")},
+		{"issued later", pub, data, machine, now.AddDate(0, 0, -1), t("config.license_not_yet_valid", "2026-05-01 00:00:00")},
+		{"damaged", pub, []byte("{"), machine, now, t("config.license_invalid")},
+	}
+	for _, tt := range tests {
+		ts.Run(tt.name, func(ts *testing.T) {
+			if _, err := verifyLicense(tt.key, tt.data, tt.hw, tt.now); err == nil || !contains(err.Error(), tt.want) {
+				ts.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLicense_ClockSkew(ts *testing.T) {
+	defer SetClockSkew(defaultClockSkew)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	machine := func() (uint64, error) { return 4711, nil }
+	issued := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := IssueLicense(priv, License{Machine: machineFingerprint(4711), IssuedAt: issued, ExpiresAt: issued.AddDate(0, 1, 0)})
+	if err != nil {
+		ts.Fatalf("IssueLicense failed: %v", err)
+	}
+
+	// A clock a few minutes behind the vendor's or past the expiry is tolerated.
+	for _, now := range []time.Time{issued.Add(-3 * time.Minute), issued.AddDate(0, 1, 0).Add(3 * time.Minute)} {
+		if _, err := verifyLicense(pub, data, machine, now); err != nil {
+			ts.Errorf("%v: expected the license within the skew, got %v", now, err)
+		}
+	}
+	if _, err := verifyLicense(pub, data, machine, issued.Add(-time.Hour)); err == nil || !contains(err.Error(), t("config.license_not_yet_valid", "2026-05-01 12:00:00")) {
+		ts.Errorf("expected not yet valid error, got %v", err)
+	}
+
+	SetClockSkew(0)
+	if _, err := verifyLicense(pub, data, machine, issued.Add(-time.Second)); err == nil {
+		ts.Error("license issued in the future accepted without skew")
+	}
+}
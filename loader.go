@@ -0,0 +1,227 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+ * Loader lifecycle.
+ *
+ * LoadConfig and UpdateConfig work on package state that lives until the
+ * process ends. A Loader binds one config struct to its file and gives that
+ * state an explicit end: Close writes a pending write-back, removes the
+ * decrypted secrets from the struct and, once the last Loader is closed,
+ * wipes the encryption key. Close is safe to defer in main(): it is
+ * idempotent and a panic while flushing is returned as an error instead of
+ * skipping the cleanup.
+ */
+
+// Loader loads and writes one config file. A Loader is safe for concurrent
+// use; its methods are serialized, and Snapshot never blocks.
+type Loader struct {
+	config    interface{}
+	path      string
+	version   int
+	namespace string // set by WithLoaderNamespace
+	lastGood  bool   // set by WithLoaderLastGood
+
+	fileMode    os.FileMode // set by WithLoaderFileMode
+	fileModeSet bool
+	strict      bool // set by WithLoaderStrict
+
+	freeze  bool           // set by WithFreeze
+	refresh *refresher     // snapshots and WithAutoRefresh
+	leases  *leaser        // WithSecretLeases, nil without
+	derived []derivedField // WithDerivedField
+
+	mu        sync.Mutex
+	dirty     bool // a write-back is pending
+	closed    bool
+	frozen    interface{} // snapshot of a frozen config
+	frozenSum [32]byte
+	loadedAt  time.Time    // last successful Load or refresh
+	mounts    secretMounts // files written by MountSecrets
+	onChange  map[string][]func(field string)
+}
+
+// LoaderOption configures a Loader.
+type LoaderOption func(*Loader)
+
+// WithLoaderNamespace loads the config from the namespace name of a shared
+// file, like WithNamespace for Load.
+func WithLoaderNamespace(name string) LoaderOption {
+	return func(l *Loader) { l.namespace = name }
+}
+
+// WithLoaderLastGood keeps a last good copy of the file and falls back to it
+// when a Load or refresh fails, like WithLastGood for Load.
+func WithLoaderLastGood() LoaderOption {
+	return func(l *Loader) { l.lastGood = true }
+}
+
+// WithLoaderFileMode sets the permissions of the rewritten file, like
+// WithFileMode for Load.
+func WithLoaderFileMode(mode os.FileMode) LoaderOption {
+	return func(l *Loader) {
+		l.fileMode = mode
+		l.fileModeSet = true
+	}
+}
+
+// WithLoaderStrict checks the file against the struct at every Load and
+// refresh, like WithStrict for Load.
+func WithLoaderStrict() LoaderOption {
+	return func(l *Loader) { l.strict = true }
+}
+
+var (
+	openLoadersMu sync.Mutex
+	openLoaders   int
+)
+
+// NewLoader returns a Loader for config (a pointer to a struct) stored at path
+// with the given config version. Call Close when the program shuts down.
+func NewLoader(config interface{}, path string, version int, opts ...LoaderOption) *Loader {
+	l := &Loader{config: config, path: path, version: version, refresh: newRefresher()}
+	for _, opt := range opts {
+		opt(l)
+	}
+	openLoadersMu.Lock()
+	openLoaders++
+	openLoadersMu.Unlock()
+	return l
+}
+
+// loadInto reads the file into config like LoadConfig.
+func (l *Loader) loadInto(config interface{}) error {
+	opts := []LoadOption{WithVersion(l.version), WithNamespace(l.namespace)}
+	if l.lastGood {
+		opts = append(opts, WithLastGood())
+	}
+	if l.fileModeSet {
+		opts = append(opts, WithFileMode(l.fileMode))
+	}
+	if l.strict {
+		opts = append(opts, WithStrict())
+	}
+	return Load(config, l.path, opts...)
+}
+
+// Load reads the file into the config like LoadConfig.
+func (l *Loader) Load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("%s", t("config.loader_closed"))
+	}
+	if err := l.loadInto(l.config); err != nil {
+		return err
+	}
+	l.updateDerived(nil)
+	if l.freeze {
+		l.freezeSnapshot()
+	}
+	l.loadedAt = time.Now()
+	l.publish()
+	l.startLeases()
+	return nil
+}
+
+// Save writes the config to the file now like UpdateConfig.
+func (l *Loader) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("%s", t("config.loader_closed"))
+	}
+	if l.freeze {
+		return fmt.Errorf("%s", t("config.frozen"))
+	}
+	return l.save()
+}
+
+// MarkChanged records that the config has been changed and must be written
+// by the next Flush or by Close. It has no effect on a frozen Loader.
+func (l *Loader) MarkChanged() {
+	l.mu.Lock()
+	l.dirty = !l.freeze
+	l.mu.Unlock()
+}
+
+// Flush writes the config if MarkChanged was called since the last write.
+func (l *Loader) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("%s", t("config.loader_closed"))
+	}
+	if !l.dirty {
+		return nil
+	}
+	return l.save()
+}
+
+func (l *Loader) save() error {
+	if err := UpdateConfig(l.config, l.path); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// Close flushes a pending write-back, removes the files written by
+// MountSecrets and removes the decrypted passwords and resolved secret
+// references from the config, which keeps only ciphertexts and markers. When the last open Loader is closed, the encryption key is
+// wiped (see Close). Further calls return nil.
+func (l *Loader) Close() (err error) {
+	l.stopRefresh()
+	l.stopLeases()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	defer func() {
+		l.unmountSecrets()
+		restoreSecretRefs(l.config)
+		forgetOverrides(l.config)
+		forgetLoadedFile(l.config)
+		// Scrubbing encrypts with the package key; a Manager may swap it.
+		stateMu.Lock()
+		_ = (&walker{phases: phaseScrub}).walk(reflect.ValueOf(l.config))
+		if l.frozen != nil {
+			_ = (&walker{phases: phaseScrub}).walk(reflect.ValueOf(l.frozen))
+			l.frozen = nil
+		}
+		if snapshot := l.refresh.snapshot.Load(); snapshot != nil {
+			_ = (&walker{phases: phaseScrub}).walk(reflect.ValueOf(snapshot))
+		}
+		stateMu.Unlock()
+		openLoadersMu.Lock()
+		openLoaders--
+		last := openLoaders == 0
+		openLoadersMu.Unlock()
+		if last {
+			_ = Close()
+		}
+	}()
+	if l.dirty {
+		err = l.flushRecovering()
+	}
+	return err
+}
+
+// flushRecovering writes the pending change and turns a panic into an error.
+func (l *Loader) flushRecovering() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s", t("config.flush_panic", r))
+		}
+	}()
+	return l.save()
+}
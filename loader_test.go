@@ -0,0 +1,112 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// panicOnMarshal panics in MarshalJSON when armed, to exercise Close during a failing flush.
+type panicOnMarshal struct{ armed bool }
+
+func (p panicOnMarshal) MarshalJSON() ([]byte, error) {
+	if p.armed {
+		panic("marshal exploded")
+	}
+	return []byte(`null`), nil
+}
+
+type LoaderPanicConfig struct {
+	Version          int            `json:"version"`
+	DBPassword       string         `json:"db_password"`
+	DBSecurePassword string         `json:"db_secure_password"`
+	Trap             panicOnMarshal `json:"trap"`
+}
+
+func TestLoader_Lifecycle(ts *testing.T) {
+	ResetForTest()
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "config.json")
+	cfg := &TestConfig{DatabasePassword: "loader-secret"}
+	l := NewLoader(cfg, configPath, 1)
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if cfg.DatabasePassword != "loader-secret" {
+		ts.Fatalf("expected decrypted password, got %q", cfg.DatabasePassword)
+	}
+
+	cfg.DatabaseHost = "changed-host"
+	l.MarkChanged()
+	if err := l.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), "changed-host") {
+		ts.Errorf("expected pending change to be flushed on Close:\n%s", raw)
+	}
+	if cfg.DatabasePassword != PASSWORD_IS_SECURE || cfg.DatabaseSecurePassword == "" {
+		ts.Errorf("expected decrypted password to be removed, got %q", cfg.DatabasePassword)
+	}
+	if initialized || encryptionKey != nil {
+		ts.Error("expected key to be wiped after the last Loader was closed")
+	}
+	if err := l.Close(); err != nil {
+		ts.Errorf("second Close failed: %v", err)
+	}
+	if err := l.Save(); err == nil || !contains(err.Error(), t("config.loader_closed")) {
+		ts.Errorf("expected loader_closed error, got: %v", err)
+	}
+
+	// A fresh Loader reads the flushed file again.
+	cfg2 := &TestConfig{}
+	l2 := NewLoader(cfg2, configPath, 1)
+	defer l2.Close()
+	if err := l2.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if cfg2.DatabasePassword != "loader-secret" || cfg2.DatabaseHost != "changed-host" {
+		ts.Errorf("unexpected values after reload: %+v", cfg2)
+	}
+}
+
+func TestLoader_KeyKeptWhileOtherLoaderOpen(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	a := NewLoader(&TestConfig{}, filepath.Join(tempDir, "a.json"), 1)
+	b := NewLoader(&TestConfig{}, filepath.Join(tempDir, "b.json"), 1)
+	defer b.Close()
+	if err := a.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if err := b.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	if !initialized {
+		ts.Error("key must stay available while another Loader is open")
+	}
+	if err := b.Save(); err != nil {
+		ts.Errorf("Save on the remaining Loader failed: %v", err)
+	}
+}
+
+func TestLoader_CloseRecoversFlushPanic(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	cfg := &LoaderPanicConfig{DBPassword: "panic-secret"}
+	l := NewLoader(cfg, filepath.Join(tempDir, "panic.json"), 1)
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	cfg.Trap.armed = true
+	l.MarkChanged()
+	err := l.Close()
+	if err == nil || !strings.Contains(err.Error(), "marshal exploded") {
+		ts.Errorf("expected flush panic as error, got: %v", err)
+	}
+	if cfg.DBPassword == "panic-secret" {
+		ts.Error("decrypted password must be removed even when the flush panics")
+	}
+}
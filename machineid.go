@@ -0,0 +1,157 @@
+package sconfig
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Machine ID file.
+ *
+ * The hardware ID changes with any of its identifiers: a replaced network
+ * card or disk makes every stored password undecryptable. SetMachineIDFile
+ * computes the hardware ID once and keeps it in a file readable only by its
+ * owner; later runs take the ID from the file and probe no hardware. The key
+ * then survives hardware changes as long as the file does, and moves with a
+ * copy of it. MachineIDRandom stores a random ID instead, which ties the key
+ * to the file alone from the start.
+ *
+ * The file holds one line, the ID as 0x-prefixed hex like sconfig
+ * hardware-id prints it. Keep a backup: without it the configs cannot be
+ * decrypted once the hardware has changed.
+ */
+
+// MachineIDMode selects the ID SetMachineIDFile stores when the file does not
+// exist yet.
+type MachineIDMode int
+
+const (
+	// MachineIDHardware stores the hardware ID computed on first use, so
+	// configs written before keep their key.
+	MachineIDHardware MachineIDMode = iota
+	// MachineIDRandom stores a random ID, independent of the hardware.
+	MachineIDRandom
+)
+
+var (
+	machineIDMu   sync.Mutex
+	machineIDPath string // "" if no machine ID file is used
+	machineIDMode MachineIDMode
+)
+
+// DefaultMachineIDFile returns the conventional machine ID file,
+// /etc/sconfig/machine-id, on Windows %ProgramData%\sconfig\machine-id.
+func DefaultMachineIDFile() string {
+	if runtime.GOOS == "windows" {
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			dir = `C:\ProgramData`
+		}
+		return filepath.Join(dir, "sconfig", "machine-id")
+	}
+	return "/etc/sconfig/machine-id"
+}
+
+// SetMachineIDFile makes the hardware ID come from the file at path (e.g.
+// DefaultMachineIDFile()), which is created with the ID selected by mode
+// (mode 0600, missing directories 0700) if it does not exist. "" turns the
+// file off again. On Unix the file must not be accessible by group or others.
+// HardwareIdentifiers still reports the identifiers of the hardware. Like
+// SetKeyProvider it must be called before the first LoadConfig.
+func SetMachineIDFile(path string, mode MachineIDMode) {
+	machineIDMu.Lock()
+	machineIDPath, machineIDMode = path, mode
+	machineIDMu.Unlock()
+	invalidateKey()
+}
+
+// machineIDFile returns the machine ID file and its mode; path is "" if none
+// is used.
+func machineIDFile() (string, MachineIDMode) {
+	machineIDMu.Lock()
+	defer machineIDMu.Unlock()
+	return machineIDPath, machineIDMode
+}
+
+// readOrCreateMachineID returns the ID in the machine ID file at path. If the
+// file does not exist, it is created with the ID of collect (for
+// MachineIDHardware) or a random one.
+func readOrCreateMachineID(path string, mode MachineIDMode, collect func() (uint64, error)) (uint64, error) {
+	id, err := readMachineID(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return id, err
+	}
+	if mode == MachineIDRandom {
+		var b [8]byte
+		if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+			return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+		}
+		id = binary.LittleEndian.Uint64(b[:])
+	} else if id, err = collect(); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another process created it in the meantime.
+		return readMachineID(path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	_, err = fmt.Fprintf(f, "0x%016x\n", id)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	return id, nil
+}
+
+// readMachineID reads the machine ID file at path. A missing file is
+// reported as os.ErrNotExist.
+func readMachineID(path string) (uint64, error) {
+	if !allowProbe(path) {
+		return 0, errProbeNotAllowed
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return 0, fmt.Errorf("%s", t("config.machineid_permissions", path, info.Mode().Perm()))
+	}
+	data, err := io.ReadAll(io.LimitReader(f, 64))
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_failed", path, err))
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.machineid_invalid", path))
+	}
+	return id, nil
+}
@@ -0,0 +1,63 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMachineIDFile(ts *testing.T) {
+	tempDir := ts.TempDir()
+	defer SetMachineIDFile("", MachineIDHardware)
+
+	// Hardware mode stores the computed ID once and reuses it.
+	path := filepath.Join(tempDir, "sconfig", "machine-id")
+	calls := 0
+	collect := func() (uint64, error) {
+		calls++
+		return 0x1a2b3c4d5e6f7788 + uint64(calls), nil
+	}
+	first, err := readOrCreateMachineID(path, MachineIDHardware, collect)
+	if err != nil || first != 0x1a2b3c4d5e6f7789 {
+		ts.Fatalf("first ID = 0x%x, %v", first, err)
+	}
+	if again, err := readOrCreateMachineID(path, MachineIDHardware, collect); err != nil || again != first || calls != 1 {
+		ts.Fatalf("second ID = 0x%x, %v after %d collections", again, err, calls)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "0x1a2b3c4d5e6f7789\n" {
+		ts.Errorf("file content %q", raw)
+	}
+	if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		ts.Errorf("file mode %v, %v", info.Mode().Perm(), err)
+	}
+
+	// The hardware ID of the package comes from the file.
+	randomPath := filepath.Join(tempDir, "random-id")
+	SetMachineIDFile(randomPath, MachineIDRandom)
+	id, err := secure_config_getHardwareID()
+	if err != nil {
+		ts.Fatalf("hardware ID with a random machine ID file: %v", err)
+	}
+	if stored, err := readMachineID(randomPath); err != nil || stored != id {
+		ts.Errorf("stored ID 0x%x, %v; hardware ID 0x%x", stored, err, id)
+	}
+	if again, _ := secure_config_getHardwareID(); again != id {
+		ts.Errorf("hardware ID changed from 0x%x to 0x%x", id, again)
+	}
+
+	if err := os.WriteFile(randomPath, []byte("not an id\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if _, err := secure_config_getHardwareID(); err == nil || !contains(err.Error(), t("config.machineid_invalid", randomPath)) {
+		ts.Errorf("damaged file: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(path, 0644); err != nil {
+			ts.Fatal(err)
+		}
+		if _, err := readMachineID(path); err == nil {
+			ts.Error("a machine ID file readable by others was accepted")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sconfig
+
+import "sync"
+
+/*
+ * Independent key managers.
+ *
+ * The package functions share one encryption key, one hardware ID source and
+ * one language for the whole process, so two configs loaded with different
+ * hardware ID functions would silently share the key of the first. A Manager
+ * holds its own key, hardware ID source and marker language. Its methods swap
+ * that state in for the duration of the call; stateMu serializes them with
+ * the package functions, so the package state is never seen half swapped.
+ * The language of messages is never swapped: other goroutines translate
+ * without stateMu. Settings made with the Set* functions (key provider, key
+ * scope, KDF) are still shared.
+ */
+
+// stateMu guards the key state used by Load, UpdateConfig and the Manager.
+var stateMu sync.Mutex
+
+// markerLang is the language config_init writes PASSWORD_IS_SECURE in; empty
+// for the current language. Guarded by markerMu.
+var markerLang string
+
+// keyState is the part of the package state a Manager owns.
+type keyState struct {
+	key         []byte
+	initialized bool
+	kdfHardware func() (uint64, error)
+	markers     [3]string // PASSWORD_IS_SECURE, _en and _de
+	lang        string    // markerLang
+}
+
+// saveKeyState returns the current key state. Callers hold stateMu.
+func saveKeyState() keyState {
+	keyMemMu.Lock()
+	key := encryptionKey
+	keyMemMu.Unlock()
+	kdfMu.Lock()
+	hardwareID := kdfHardware
+	kdfMu.Unlock()
+	markerMu.RLock()
+	defer markerMu.RUnlock()
+	return keyState{
+		key:         key,
+		initialized: initialized,
+		kdfHardware: hardwareID,
+		markers:     [3]string{PASSWORD_IS_SECURE, PASSWORD_IS_SECURE_en, PASSWORD_IS_SECURE_de},
+		lang:        markerLang,
+	}
+}
+
+// restore makes s the current key state. Callers hold stateMu.
+func (s keyState) restore() {
+	keyMemMu.Lock()
+	encryptionKey = s.key
+	keyMemMu.Unlock()
+	kdfMu.Lock()
+	kdfHardware = s.kdfHardware
+	kdfMu.Unlock()
+	initialized = s.initialized
+	markerMu.Lock()
+	PASSWORD_IS_SECURE, PASSWORD_IS_SECURE_en, PASSWORD_IS_SECURE_de = s.markers[0], s.markers[1], s.markers[2]
+	markerLang = s.lang
+	markerMu.Unlock()
+}
+
+// Manager loads and writes configs with its own encryption key, hardware ID
+// source and marker language, independent of the package functions and of
+// other Managers. Messages stay in the package language. A Manager is safe
+// for concurrent use.
+type Manager struct {
+	hardwareID func() (uint64, error)
+	state      keyState
+}
+
+// NewManager returns a Manager that derives its key from hardwareID (nil for
+// the hardware ID of this machine) and writes the secure marker in lang ("en"
+// or "de"; empty for the language of the environment).
+func NewManager(hardwareID func() (uint64, error), lang string) *Manager {
+	if lang == "" {
+		lang = detectLanguage()
+	}
+	return &Manager{hardwareID: hardwareID, state: keyState{lang: lang}}
+}
+
+// with runs fn with the state of m swapped in.
+func (m *Manager) with(fn func() error) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	saved := saveKeyState()
+	m.state.restore()
+	defer func() {
+		m.state = saveKeyState()
+		saved.restore()
+	}()
+	return fn()
+}
+
+// Load is Load with the key of m. A WithHardwareIDFunc option overrides the
+// hardware ID source of m for this call only.
+func (m *Manager) Load(config interface{}, path string, opts ...LoadOption) error {
+	if m.hardwareID != nil {
+		opts = append([]LoadOption{WithHardwareIDFunc(m.hardwareID)}, opts...)
+	}
+	return m.with(func() error { return load(config, path, opts) })
+}
+
+// LoadConfig is LoadConfig with the key of m.
+func (m *Manager) LoadConfig(config interface{}, version int, path string, cleanConfig bool, debugOutput bool) error {
+	return m.Load(config, path, loadConfigOptions(version, cleanConfig, debugOutput, nil)...)
+}
+
+// UpdateConfig is UpdateConfig with the key of m; m must have loaded a config
+// before.
+func (m *Manager) UpdateConfig(config interface{}, path string, cleanConfig ...bool) error {
+	return m.with(func() error { return updateConfig(config, path, cleanConfig...) })
+}
+
+// Close wipes the key of m. The next Load derives it again.
+func (m *Manager) Close() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	releaseKeyBuffer(m.state.key)
+	m.state = keyState{lang: m.state.lang}
+	return nil
+}
@@ -0,0 +1,71 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManager_IndependentKeys(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	pathA := filepath.Join(tempDir, "a.json")
+	pathB := filepath.Join(tempDir, "b.json")
+	a := NewManager(func() (uint64, error) { return 1111, nil }, "en")
+	// The language of messages stays the package language, also inside calls
+	// of a Manager with another language.
+	defer setLanguage(getCurrentLanguage())
+	setLanguage("en")
+	var langInB string
+	b := NewManager(func() (uint64, error) { langInB = getCurrentLanguage(); return 2222, nil }, "de")
+	defer a.Close()
+	defer b.Close()
+
+	// The package key is derived from yet another hardware ID.
+	if err := LoadConfig(&TestConfig{}, 1, filepath.Join(tempDir, "global.json"), false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	globalKey := keyFingerprint()
+
+	if err := a.LoadConfig(&TestConfig{DatabasePassword: "secret-a"}, 1, pathA, false, false); err != nil {
+		ts.Fatalf("manager a: %v", err)
+	}
+	if err := b.LoadConfig(&TestConfig{DatabasePassword: "secret-b"}, 1, pathB, false, false); err != nil {
+		ts.Fatalf("manager b: %v", err)
+	}
+	if keyFingerprint() != globalKey {
+		ts.Fatalf("package key changed by a Manager")
+	}
+	if raw, _ := os.ReadFile(pathB); !strings.Contains(string(raw), "Hier neues Passwort eintragen") {
+		ts.Errorf("manager b did not use its language: %s", raw)
+	}
+	if langInB != "en" || getCurrentLanguage() != "en" {
+		ts.Errorf("manager b changed the package language: %q during, %q after", langInB, getCurrentLanguage())
+	}
+
+	cfgA, cfgB := &TestConfig{}, &TestConfig{}
+	if err := a.LoadConfig(cfgA, 1, pathA, false, false); err != nil || cfgA.DatabasePassword != "secret-a" {
+		ts.Fatalf("manager a reload: %v, %q", err, cfgA.DatabasePassword)
+	}
+	if err := b.Load(cfgB, pathB, WithVersion(1)); err != nil || cfgB.DatabasePassword != "secret-b" {
+		ts.Fatalf("manager b reload: %v, %q", err, cfgB.DatabasePassword)
+	}
+	if err := a.LoadConfig(&TestConfig{}, 1, pathB, false, false); err == nil {
+		ts.Error("manager a decrypted the file of manager b")
+	}
+
+	cfgA.DatabasePassword = "rotated-a"
+	if err := a.UpdateConfig(cfgA, pathA); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	if err := a.UpdateConfig(cfgA, pathA); err == nil {
+		ts.Error("UpdateConfig after Close succeeded")
+	}
+	cfgA = &TestConfig{}
+	if err := a.LoadConfig(cfgA, 1, pathA, false, false); err != nil || cfgA.DatabasePassword != "rotated-a" {
+		ts.Fatalf("manager a after Close: %v, %q", err, cfgA.DatabasePassword)
+	}
+}
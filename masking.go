@@ -0,0 +1,156 @@
+package sconfig
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ * Secret masking.
+ *
+ * Decrypted passwords and resolved secret references end up in panics and
+ * error strings through code sconfig does not control (a failing database
+ * driver printing its DSN, a %+v of the config). With SetSecretMasking(true)
+ * every value decrypted or resolved afterwards is remembered, and
+ * MaskSecrets, MaskError, NewMaskingWriter and RecoverMasked replace those
+ * values in text before it reaches a log. Values shorter than
+ * minMaskedSecret characters are not masked: they would garble unrelated
+ * text. Close forgets the values.
+ */
+
+// secretMask replaces a masked secret.
+const secretMask = "******"
+
+// minMaskedSecret is the shortest value that is masked.
+const minMaskedSecret = 4
+
+var (
+	maskMu      sync.RWMutex
+	maskEnabled bool
+	maskSecrets map[string]struct{}
+	maskOrder   []string // maskSecrets, longest first
+)
+
+// SetSecretMasking enables or disables remembering decrypted values for
+// masking. Enable it before LoadConfig; disabling it forgets all values.
+func SetSecretMasking(enabled bool) {
+	maskMu.Lock()
+	defer maskMu.Unlock()
+	maskEnabled = enabled
+	if !enabled {
+		maskSecrets, maskOrder = nil, nil
+	}
+}
+
+// rememberSecret records value for masking if masking is enabled.
+func rememberSecret(value string) {
+	if len(value) < minMaskedSecret {
+		return
+	}
+	maskMu.Lock()
+	defer maskMu.Unlock()
+	if !maskEnabled {
+		return
+	}
+	if _, ok := maskSecrets[value]; ok {
+		return
+	}
+	if maskSecrets == nil {
+		maskSecrets = make(map[string]struct{})
+	}
+	maskSecrets[value] = struct{}{}
+	// Longer values first, so a secret containing another one is masked whole.
+	maskOrder = append(maskOrder, value)
+	sort.Slice(maskOrder, func(i, j int) bool { return len(maskOrder[i]) > len(maskOrder[j]) })
+}
+
+// rememberSecretBytes is rememberSecret for a binary value; it only copies
+// the value into a string if masking is enabled.
+func rememberSecretBytes(value []byte) {
+	maskMu.RLock()
+	enabled := maskEnabled
+	maskMu.RUnlock()
+	if enabled {
+		rememberSecret(string(value))
+	}
+}
+
+// forgetSecrets drops all remembered values.
+func forgetSecrets() {
+	maskMu.Lock()
+	maskSecrets, maskOrder = nil, nil
+	maskMu.Unlock()
+}
+
+// MaskSecrets returns s with every remembered secret value replaced.
+func MaskSecrets(s string) string {
+	maskMu.RLock()
+	defer maskMu.RUnlock()
+	for _, secret := range maskOrder {
+		s = strings.ReplaceAll(s, secret, secretMask)
+	}
+	return s
+}
+
+// maskedError is an error whose message has the secrets masked.
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string { return e.msg }
+func (e *maskedError) Unwrap() error { return e.err }
+
+// MaskError returns err with the secrets masked in its message; errors.Is and
+// errors.As still see the original error. A nil err stays nil.
+func MaskError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	masked := MaskSecrets(msg)
+	if masked == msg {
+		return err
+	}
+	return &maskedError{msg: masked, err: err}
+}
+
+// maskingWriter masks the secrets in everything written to w.
+type maskingWriter struct {
+	w io.Writer
+}
+
+// NewMaskingWriter returns a writer that masks the secrets in each Write
+// before passing it to w, e.g. log.SetOutput(sconfig.NewMaskingWriter(os.Stderr)).
+// A secret split across two writes is not masked; the log package writes
+// each entry at once.
+func NewMaskingWriter(w io.Writer) io.Writer {
+	return &maskingWriter{w: w}
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, MaskSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// RecoverMasked re-panics with the secrets masked in the panic value. Defer it
+// at the top of main and of goroutines:
+//
+//	defer sconfig.RecoverMasked()
+//
+// The runtime prints the masked value with the stack of the re-panic.
+func RecoverMasked() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if err, ok := r.(error); ok {
+		panic(MaskError(err))
+	}
+	panic(MaskSecrets(fmt.Sprint(r)))
+}
@@ -0,0 +1,55 @@
+package sconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretMasking(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	SetSecretMasking(true)
+	defer SetSecretMasking(false)
+	ts.Setenv("SCONFIG_MASK_TEST_TOKEN", "token-from-env")
+
+	cfg := &TestConfig{DatabasePassword: "masked-secret", APIKey: "secretref:env:SCONFIG_MASK_TEST_TOKEN"}
+	if err := LoadConfig(cfg, 1, filepath.Join(tempDir, "mask.json"), false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	dsn := fmt.Sprintf("postgres://user:%s@db?token=%s", cfg.DatabasePassword, cfg.APIKey)
+	if got := MaskSecrets(dsn); got != "postgres://user:******@db?token=******" {
+		ts.Errorf("MaskSecrets = %q", got)
+	}
+
+	base := errors.New("connect failed")
+	err := MaskError(fmt.Errorf("%s: %w", dsn, base))
+	if strings.Contains(err.Error(), "masked-secret") || !errors.Is(err, base) {
+		ts.Errorf("MaskError = %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := log.New(NewMaskingWriter(&buf), "", 0)
+	logger.Printf("dsn %s", dsn)
+	if strings.Contains(buf.String(), "masked-secret") || strings.Contains(buf.String(), "token-from-env") {
+		ts.Errorf("log not masked: %q", buf.String())
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || strings.Contains(fmt.Sprint(r), "masked-secret") {
+				ts.Errorf("panic not masked: %v", r)
+			}
+		}()
+		defer RecoverMasked()
+		panic("cannot open " + dsn)
+	}()
+
+	SetSecretMasking(false)
+	if got := MaskSecrets(dsn); got != dsn {
+		ts.Errorf("values not forgotten: %q", got)
+	}
+}
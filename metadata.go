@@ -0,0 +1,361 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+ * Metadata block.
+ *
+ * Every written config carries a small block of sconfig metadata next to the
+ * application's values: the "_sconfig" key (written first) in JSON, YAML and
+ * TOML files, and a <?sconfig ...?> processing instruction in XML files. The
+ * struct never sees it; decoding ignores the unknown key.
+ *
+ * The block records the schema hash of the struct type that wrote the file, a
+ * fingerprint of the encryption key and the key derivation version (kdf.go). When a program loads a file written by
+ * a program with a different config struct (an upgrade or downgrade in the
+ * field), a warning is issued; the key fingerprint lets the health report
+ * tell whether the current key matches the one the passwords were encrypted
+ * with.
+ */
+
+// metadataKey is the reserved top-level key of the metadata block.
+const metadataKey = "_sconfig"
+
+// fileMetadata is the content of the metadata block.
+type fileMetadata struct {
+	Schema string `json:"schema,omitempty"` // schemaHash of the writing struct type
+	Key    string `json:"key,omitempty"`    // keyFingerprint of the key the passwords are encrypted with
+	KDF    string `json:"kdf,omitempty"`    // key derivation version of the hardware key (kdf.go)
+
+	Passphrase string `json:"passphrase,omitempty"` // derivation version of a passphrase key (passphrase.go)
+	Salt       string `json:"salt,omitempty"`       // base64 salt of the passphrase key
+}
+
+var schemaHashCache sync.Map // reflect.Type -> string
+
+// schemaHash returns a short hash over the field names, types and tags of the
+// struct type t and of all types reachable from it.
+func schemaHash(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if cached, ok := schemaHashCache.Load(t); ok {
+		return cached.(string)
+	}
+	h := sha256.New()
+	writeSchema(h, t, map[reflect.Type]bool{})
+	sum := hex.EncodeToString(h.Sum(nil))[:16]
+	schemaHashCache.Store(t, sum)
+	return sum
+}
+
+func writeSchema(w io.Writer, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		fmt.Fprintf(w, "%s[", t.Kind())
+		writeSchema(w, t.Elem(), seen)
+		fmt.Fprint(w, "]")
+	case reflect.Map:
+		fmt.Fprint(w, "map[")
+		writeSchema(w, t.Key(), seen)
+		fmt.Fprint(w, "]")
+		writeSchema(w, t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			fmt.Fprintf(w, "ref(%s)", t.Name())
+			return
+		}
+		seen[t] = true
+		fmt.Fprint(w, "struct{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(w, "%s %q ", f.Name, f.Tag)
+			writeSchema(w, f.Type, seen)
+			fmt.Fprint(w, ";")
+		}
+		fmt.Fprint(w, "}")
+	default:
+		fmt.Fprint(w, t.String())
+	}
+}
+
+// newFileMetadata returns the metadata block for writing config.
+func newFileMetadata(config interface{}) *fileMetadata {
+	meta := &fileMetadata{Schema: schemaHash(reflect.TypeOf(config)), Key: keyFingerprint(), KDF: string(currentKDF())}
+	meta.Passphrase, meta.Salt = passphraseMetadata()
+	return meta
+}
+
+// keyFingerprint returns a short HMAC of a fixed label under the current
+// encryption key, or "" if no key is derived. It identifies the key without
+// revealing it.
+func keyFingerprint() string {
+	keyMemMu.Lock()
+	defer keyMemMu.Unlock()
+	if len(encryptionKey) == 0 {
+		return ""
+	}
+	return fingerprintKey(encryptionKey)
+}
+
+// fingerprintKey returns the fingerprint of key (see keyFingerprint).
+func fingerprintKey(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sconfig-key-fingerprint"))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+var fileMetadataByPath sync.Map // absolute path -> *fileMetadata
+
+// recordMetadata remembers the metadata block last read from or written to
+// path; nil is recorded as an empty block.
+func recordMetadata(path string, meta *fileMetadata) {
+	if meta == nil {
+		meta = &fileMetadata{}
+	}
+	fileMetadataByPath.Store(path, meta)
+}
+
+// loadedMetadata returns the metadata recorded for path, or nil.
+func loadedMetadata(path string) *fileMetadata {
+	if meta, ok := fileMetadataByPath.Load(path); ok {
+		return meta.(*fileMetadata)
+	}
+	return nil
+}
+
+/*
+ * Writing
+ */
+
+// metadataWriter inserts the metadata block as the first member of the JSON
+// object written through it, without buffering the rest of the document.
+type metadataWriter struct {
+	w      io.Writer
+	member []byte // `"_sconfig": {...}`
+	indent bool   // the JSON is indented with tabs
+	state  int    // 0: before '{', 1: after '{', 2: inserted
+	ws     []byte // whitespace held back in state 1
+}
+
+func newMetadataWriter(w io.Writer, meta *fileMetadata, indent bool) (*metadataWriter, error) {
+	var value []byte
+	var err error
+	if indent {
+		value, err = json.MarshalIndent(meta, "\t", "\t")
+	} else {
+		value, err = json.Marshal(meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := ":"
+	if indent {
+		sep = ": "
+	}
+	member := append([]byte(`"`+metadataKey+`"`+sep), value...)
+	return &metadataWriter{w: w, member: member, indent: indent}, nil
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for m.state < 2 && len(p) > 0 {
+		if m.state == 0 {
+			i := bytes.IndexByte(p, '{')
+			if i < 0 {
+				break
+			}
+			if _, err := m.w.Write(p[:i+1]); err != nil {
+				return 0, err
+			}
+			p = p[i+1:]
+			m.state = 1
+			continue
+		}
+		i := 0
+		for i < len(p) && isJSONSpace(p[i]) {
+			i++
+		}
+		m.ws = append(m.ws, p[:i]...)
+		p = p[i:]
+		if len(p) == 0 {
+			break
+		}
+		var head bytes.Buffer
+		if m.indent {
+			head.WriteString("\n\t")
+		}
+		head.Write(m.member)
+		if p[0] == '}' {
+			if m.indent {
+				head.WriteString("\n")
+			}
+		} else {
+			head.WriteString(",")
+			head.Write(m.ws)
+		}
+		if _, err := m.w.Write(head.Bytes()); err != nil {
+			return 0, err
+		}
+		m.state = 2
+	}
+	if len(p) > 0 {
+		if _, err := m.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// withMetadata returns the JSON object data with the metadata block inserted.
+func withMetadata(data []byte, meta *fileMetadata) ([]byte, error) {
+	var buf bytes.Buffer
+	mw, err := newMetadataWriter(&buf, meta, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlMetadataPI renders the metadata block as an XML processing instruction.
+func xmlMetadataPI(meta *fileMetadata) string {
+	attrs := fmt.Sprintf("schema=%q", meta.Schema)
+	if meta.Key != "" {
+		attrs += fmt.Sprintf(" key=%q", meta.Key)
+	}
+	if meta.KDF != "" {
+		attrs += fmt.Sprintf(" kdf=%q", meta.KDF)
+	}
+	if meta.Salt != "" {
+		attrs += fmt.Sprintf(" passphrase=%q salt=%q", meta.Passphrase, meta.Salt)
+	}
+	return fmt.Sprintf("<?%s %s?>\n", strings.TrimPrefix(metadataKey, "_"), attrs)
+}
+
+/*
+ * Reading
+ */
+
+// readJSONMetadata returns the metadata block if it is the first member of the
+// JSON object in r, else nil.
+func readJSONMetadata(r io.Reader) *fileMetadata {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	if key, err := dec.Token(); err != nil || key != metadataKey {
+		return nil
+	}
+	var meta fileMetadata
+	if err := dec.Decode(&meta); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+// findJSONMetadata returns the metadata block anywhere in the top-level JSON
+// object data (transcoded formats may reorder keys), else nil.
+func findJSONMetadata(data []byte) *fileMetadata {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	raw, ok := doc[metadataKey]
+	if !ok {
+		return nil
+	}
+	var meta fileMetadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+var xmlMetadataAttr = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+
+// parseXMLMetadata reads the metadata block from a <?sconfig ...?> instruction.
+func parseXMLMetadata(pi xml.ProcInst) *fileMetadata {
+	if pi.Target != strings.TrimPrefix(metadataKey, "_") {
+		return nil
+	}
+	meta := &fileMetadata{}
+	for _, m := range xmlMetadataAttr.FindAllStringSubmatch(string(pi.Inst), -1) {
+		switch m[1] {
+		case "schema":
+			meta.Schema = m[2]
+		case "key":
+			meta.Key = m[2]
+		case "kdf":
+			meta.KDF = m[2]
+		case "passphrase":
+			meta.Passphrase = m[2]
+		case "salt":
+			meta.Salt = m[2]
+		}
+	}
+	return meta
+}
+
+/*
+ * Schema drift
+ */
+
+var (
+	warningHandlerMu sync.Mutex
+	warningHandler   = defaultWarningHandler
+)
+
+func defaultWarningHandler(msg string) {
+	log.Printf("sconfig: %s", msg)
+}
+
+// SetWarningHandler replaces the function that receives non-fatal warnings,
+// such as schema drift. By default warnings go to the standard logger; nil
+// discards them.
+func SetWarningHandler(h func(msg string)) {
+	if h == nil {
+		h = func(string) {}
+	}
+	warningHandlerMu.Lock()
+	warningHandler = h
+	warningHandlerMu.Unlock()
+}
+
+func warn(msg string) {
+	warningHandlerMu.Lock()
+	h := warningHandler
+	warningHandlerMu.Unlock()
+	h(msg)
+}
+
+// checkSchemaDrift warns when the file at path was written by a program whose
+// config struct differs from the type of config.
+func checkSchemaDrift(path string, meta *fileMetadata, config interface{}) {
+	if meta == nil || meta.Schema == "" {
+		return
+	}
+	if current := schemaHash(reflect.TypeOf(config)); current != meta.Schema {
+		warn(t("config.schema_drift", path, meta.Schema, current))
+	}
+}
@@ -0,0 +1,104 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestConfigV2 is TestConfig as a newer program version would define it.
+type TestConfigV2 struct {
+	TestConfig
+	Timeout int `json:"timeout" default:"30"`
+}
+
+func TestMetadataWriter(ts *testing.T) {
+	meta := &fileMetadata{Schema: "abc"}
+	cases := map[string]string{
+		`{}`:            `{"_sconfig":{"schema":"abc"}}`,
+		`{"a":1}`:       `{"_sconfig":{"schema":"abc"},"a":1}`,
+		" { \n\"a\":1}": ` {"_sconfig":{"schema":"abc"}, ` + "\n" + `"a":1}`,
+	}
+	for in, want := range cases {
+		got, err := withMetadata([]byte(in), meta)
+		if err != nil || string(got) != want {
+			ts.Errorf("withMetadata(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	// Byte-wise writes give the same result as one write.
+	var buf strings.Builder
+	mw, _ := newMetadataWriter(&buf, meta, true)
+	for _, c := range []byte("{\n\t\"a\": 1\n}\n") {
+		mw.Write([]byte{c})
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(buf.String()), &doc); err != nil {
+		ts.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if m := readJSONMetadata(strings.NewReader(buf.String())); m == nil || m.Schema != "abc" {
+		ts.Errorf("metadata not readable from %q", buf.String())
+	}
+}
+
+func TestLoadConfig_SchemaDrift(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	var warnings []string
+	SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	defer SetWarningHandler(defaultWarningHandler)
+
+	for _, name := range []string{"drift.json", "drift.yaml", "drift.toml"} {
+		warnings = nil
+		configPath := filepath.Join(tempDir, name)
+		if err := LoadConfig(&TestConfig{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		if err := LoadConfig(&TestConfig{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		if len(warnings) != 0 {
+			ts.Errorf("%s: unexpected warnings for the same schema: %v", name, warnings)
+		}
+		if err := LoadConfig(&TestConfigV2{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("%s: LoadConfig (v2) failed: %v", name, err)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], configPath) {
+			ts.Errorf("%s: expected one schema drift warning, got %v", name, warnings)
+		}
+	}
+
+	ts.Run("XML", func(ts *testing.T) {
+		warnings = nil
+		configPath := filepath.Join(tempDir, "drift.xml")
+		if err := LoadConfig(&XMLTestConfig{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if !strings.Contains(string(raw), `<?sconfig schema="`+schemaHash(reflect.TypeOf(XMLTestConfig{}))+`"`) {
+			ts.Errorf("expected metadata instruction, got:\n%s", raw)
+		}
+		if err := LoadConfig(&XMLTestConfig{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if len(warnings) != 0 {
+			ts.Errorf("unexpected warnings: %v", warnings)
+		}
+	})
+
+	ts.Run("File without metadata", func(ts *testing.T) {
+		warnings = nil
+		configPath := filepath.Join(tempDir, "legacy.json")
+		if err := os.WriteFile(configPath, []byte(`{"version": 1}`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := LoadConfig(&TestConfigV2{}, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if len(warnings) != 0 {
+			ts.Errorf("unexpected warnings: %v", warnings)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package sconfig
+
+import "sync/atomic"
+
+/*
+ * Metrics.
+ *
+ * sconfig does not depend on a metrics library. Health reports the per-Loader
+ * values (version, last load, secret count); the process-wide counters are
+ * exported here. The sconfigprom module turns both into a Prometheus
+ * collector.
+ */
+
+var (
+	decryptFailures   atomic.Uint64
+	lastGoodFallbacks atomic.Uint64
+)
+
+// DecryptFailures returns the number of password fields that could not be
+// decrypted since the program started, e.g. after a hardware or key change.
+func DecryptFailures() uint64 {
+	return decryptFailures.Load()
+}
+
+// LastGoodFallbacks returns the number of loads since the program started
+// that failed and used the last good copy of the file instead (WithLastGood).
+func LastGoodFallbacks() uint64 {
+	return lastGoodFallbacks.Load()
+}
@@ -0,0 +1,163 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+)
+
+/*
+ * Namespaces in shared config files.
+ *
+ * Several applications can share one config file, each with its own struct
+ * under a top-level key of its own:
+ *
+ *	{
+ *		"billing": {"_sconfig": {...}, "version": 3, "db_password": "...", ...},
+ *		"reports": {"_sconfig": {...}, "version": 1, ...}
+ *	}
+ *
+ * Load with WithNamespace("billing") decodes only the "billing" object into
+ * the struct. Everything sconfig does per file happens per namespace: the
+ * Version field, the password encryption and the metadata block, which sits
+ * inside the namespace object. A rewrite (the write-back of LoadConfig,
+ * UpdateConfig) reads the file again, replaces only its own namespace and
+ * keeps the other keys as they are; it holds the file lock (filelock.go)
+ * from reading to renaming, so applications rewriting the same file at the
+ * same time do not drop each other's changes. The namespace is remembered
+ * for the struct, so UpdateConfig needs no option. A missing namespace is
+ * like a missing file: only the defaults apply. Top-level keys of a new file
+ * are written sorted; a rewrite keeps the order, formatting and comments of
+ * the file (rewrite.go). XML files and evaluated sources (CUE, Jsonnet) have
+ * no namespaces.
+ */
+
+var (
+	namespacesMu sync.Mutex
+	namespaces   = map[interface{}]string{} // config -> namespace given to Load
+)
+
+// WithNamespace maps the config struct to the top-level key name of a config
+// file shared by several applications.
+func WithNamespace(name string) LoadOption {
+	return func(o *loadOptions) { o.namespace = name }
+}
+
+// setNamespace remembers the namespace of config for its rewrites; "" forgets
+// it.
+func setNamespace(config interface{}, name string) {
+	namespacesMu.Lock()
+	defer namespacesMu.Unlock()
+	if name == "" {
+		delete(namespaces, config)
+	} else {
+		namespaces[config] = name
+	}
+}
+
+// namespaceOf returns the namespace config was loaded from, "" for a whole
+// file.
+func namespaceOf(config interface{}) string {
+	namespacesMu.Lock()
+	defer namespacesMu.Unlock()
+	return namespaces[config]
+}
+
+// checkNamespace rejects names that cannot be a namespace of the file at path.
+func checkNamespace(path, name string) error {
+	if name == metadataKey {
+		return fmt.Errorf("%s", t("config.namespace_invalid", name))
+	}
+	if evaluatorFor(path) != nil || formatFromExtension(path) == FormatXML {
+		return fmt.Errorf("%s", t("config.namespace_unsupported", path))
+	}
+	return nil
+}
+
+// readNamespaces reads the file at path as its top-level keys. A missing file
+// has none.
+func readNamespaces(path string) (Format, map[string]json.RawMessage, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return formatForWrite(path), map[string]json.RawMessage{}, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	format := formatForRead(path, content)
+	if format == FormatXML {
+		return 0, nil, fmt.Errorf("%s", t("config.namespace_unsupported", path))
+	}
+	data, err := toJSON(format, content)
+	if err != nil {
+		return 0, nil, fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return 0, nil, fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if doc == nil {
+		doc = map[string]json.RawMessage{}
+	}
+	return format, doc, nil
+}
+
+// decodeNamespace decodes the namespace name of the config file at path into
+// config and returns the metadata block of the namespace. It reports whether
+// the namespace exists.
+func decodeNamespace(path, name string, config interface{}) (*fileMetadata, bool, error) {
+	_, doc, err := readNamespaces(path)
+	if err != nil {
+		return nil, false, err
+	}
+	raw, ok := doc[name]
+	if !ok {
+		return nil, false, nil
+	}
+	if err := decodeConfigStream(bytes.NewReader(raw), config); err != nil {
+		return nil, false, err
+	}
+	return findJSONMetadata(raw), true, nil
+}
+
+// writeNamespace replaces the namespace name of the config file at path by
+// config with the metadata block meta, keeping the other top-level keys. The
+// caller holds the file lock.
+func writeNamespace(path, name string, config interface{}, mode os.FileMode, meta *fileMetadata) error {
+	format, doc, err := readNamespaces(path)
+	if err != nil {
+		return err
+	}
+	data, err := marshalConfig(config)
+	if err == nil {
+		data, err = withMetadata(data, meta)
+	}
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	doc[name] = data
+	if format == FormatJSON {
+		data, err = json.MarshalIndent(doc, "", "\t")
+		data = append(data, '\n')
+	} else {
+		data, err = json.Marshal(doc)
+	}
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	if current := readForRewrite(path); current != nil {
+		// The other namespaces are in both documents and keep their text.
+		data = keepUnknown(path, format, current, data, reflect.MapOf(reflect.TypeOf(""), reflect.TypeOf(config)))
+	}
+	if data, err = fromJSON(format, data); err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	return writeFileAtomic(path, mode, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,244 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type billingTestConfig struct {
+	Version          int    `json:"version"`
+	Currency         string `json:"currency" default:"EUR"`
+	DBPassword       string `json:"db_password"`
+	DBSecurePassword string `json:"db_secure_password"`
+}
+
+type reportsTestConfig struct {
+	Version int    `json:"version"`
+	Title   string `json:"title" default:"Monthly"`
+}
+
+func TestLoad_Namespaces(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	configPath := filepath.Join(tempDir, "shared.json")
+	if err := os.WriteFile(configPath, []byte("{\n\t\"billing\": {\"currency\": \"USD\", \"db_password\": \"bill-secret\"},\n\t\"other\": {\"keep\": [1, 2]} // not ours\n}\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+
+	billing := &billingTestConfig{}
+	if err := Load(billing, configPath, hw, WithVersion(3), WithNamespace("billing")); err != nil {
+		ts.Fatalf("Load billing failed: %v", err)
+	}
+	if billing.Currency != "USD" || billing.DBPassword != "bill-secret" || billing.Version != 3 {
+		ts.Errorf("billing = %+v", billing)
+	}
+	reports := &reportsTestConfig{}
+	if err := Load(reports, configPath, hw, WithVersion(1), WithNamespace("reports")); err != nil {
+		ts.Fatalf("Load reports failed: %v", err)
+	}
+	if reports.Title != "Monthly" || reports.Version != 1 {
+		ts.Errorf("reports = %+v", reports)
+	}
+
+	reports.Title = "Weekly"
+	if err := UpdateConfig(reports, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]map[string]interface{}
+	if err := json.Unmarshal(stripJSONC(raw), &doc); err != nil {
+		ts.Fatalf("invalid file: %v\n%s", err, raw)
+	}
+	if doc["billing"]["version"] != 3.0 || doc["billing"]["db_password"] != PASSWORD_IS_SECURE || doc["billing"][metadataKey] == nil {
+		ts.Errorf("billing namespace changed:\n%s", raw)
+	}
+	if doc["reports"]["title"] != "Weekly" || doc["reports"]["version"] != 1.0 {
+		ts.Errorf("reports namespace not written:\n%s", raw)
+	}
+	if !strings.Contains(string(raw), `"other": {"keep": [1, 2]}, // not ours`) || strings.Contains(string(raw), "bill-secret") {
+		ts.Errorf("unexpected file:\n%s", raw)
+	}
+
+	// Reloading billing still finds its password.
+	billing = &billingTestConfig{}
+	if err := Load(billing, configPath, hw, WithNamespace("billing")); err != nil || billing.DBPassword != "bill-secret" {
+		ts.Errorf("reload of billing = %+v, %v", billing, err)
+	}
+	if err := Load(&reportsTestConfig{}, configPath, hw, WithNamespace(metadataKey)); err == nil {
+		ts.Error("the metadata key was accepted as a namespace")
+	}
+}
+
+func TestLockConfigFile(ts *testing.T) {
+	path := filepath.Join(ts.TempDir(), "config.json")
+	unlock, err := lockConfigFile(path)
+	if err != nil {
+		ts.Fatalf("lockConfigFile failed: %v", err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := lockConfigFile(path)
+		if err == nil {
+			unlock2()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		ts.Fatal("second lock acquired while the first is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		ts.Fatal("second lock not acquired after unlock")
+	}
+}
+
+func TestLockConfigFile_Sentinel(ts *testing.T) {
+	path := filepath.Join(ts.TempDir(), "config.json")
+	setLockStrategy(path, LockSentinel)
+	defer setLockStrategy(path, LockAuto)
+	unlock, err := lockConfigFile(path)
+	if err != nil {
+		ts.Fatalf("lockConfigFile failed: %v", err)
+	}
+	if owner, err := os.ReadFile(path + ".lck"); err != nil || !strings.Contains(string(owner), " ") {
+		ts.Errorf("sentinel = %q, %v", owner, err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := lockConfigFile(path)
+		if err == nil {
+			unlock2()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		ts.Fatal("second lock acquired while the first is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		ts.Fatal("second lock not acquired after unlock")
+	}
+	if _, err := os.Stat(path + ".lck"); !os.IsNotExist(err) {
+		ts.Errorf("sentinel not removed: %v", err)
+	}
+
+	// A sentinel left by a crashed writer is taken over.
+	if err := os.WriteFile(path+".lck", []byte("elsewhere 1\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	old := time.Now().Add(-2 * sentinelStale)
+	if err := os.Chtimes(path+".lck", old, old); err != nil {
+		ts.Fatal(err)
+	}
+	unlock, err = lockConfigFile(path)
+	if err != nil {
+		ts.Fatalf("stale sentinel not taken over: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		ts.Errorf("flock sidecar created with the sentinel strategy: %v", err)
+	}
+}
+
+func TestLockConfigFile_StaleSentinelWaiters(ts *testing.T) {
+	path := filepath.Join(ts.TempDir(), "config.json")
+	setLockStrategy(path, LockSentinel)
+	defer setLockStrategy(path, LockAuto)
+	if err := os.WriteFile(path+".lck", []byte("elsewhere 1\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	old := time.Now().Add(-2 * sentinelStale)
+	if err := os.Chtimes(path+".lck", old, old); err != nil {
+		ts.Fatal(err)
+	}
+
+	// Both waiters find the sentinel stale; the second takes it over only
+	// after the first holds the lock.
+	var calls atomic.Int32
+	bothStale, firstHolds := make(chan struct{}), make(chan struct{})
+	staleSentinelFound = func() {
+		switch calls.Add(1) {
+		case 1:
+			<-bothStale
+		case 2:
+			close(bothStale)
+			<-firstHolds
+		}
+	}
+	defer func() { staleSentinelFound = func() {} }()
+
+	var holders, overlaps atomic.Int32
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockConfigFile(path)
+			if err != nil {
+				ts.Errorf("lockConfigFile failed: %v", err)
+				return
+			}
+			if holders.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			once.Do(func() { close(firstHolds) })
+			time.Sleep(2 * sentinelRetry)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if overlaps.Load() > 0 {
+		ts.Error("both waiters held the lock")
+	}
+	if moved, _ := filepath.Glob(path + ".lck.stale-*"); len(moved) > 0 {
+		ts.Errorf("moved sentinels left behind: %v", moved)
+	}
+}
+
+func TestLoad_LockStrategy(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	configPath := filepath.Join(tempDir, "share.json")
+	defer setLockStrategy(configPath, LockAuto)
+	cfg := &reportsTestConfig{}
+	hw := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	if err := Load(cfg, configPath, hw, WithVersion(1), WithLockStrategy(LockSentinel)); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if lockStrategyFor(configPath) != LockSentinel {
+		ts.Error("strategy not remembered")
+	}
+	// A later load without the option keeps it.
+	if err := Load(cfg, configPath, hw); err != nil || lockStrategyFor(configPath) != LockSentinel {
+		ts.Errorf("strategy after reload = %v, %v", lockStrategyFor(configPath), err)
+	}
+	cfg.Title = "Yearly"
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	entries, _ := os.ReadDir(tempDir)
+	for _, entry := range entries {
+		if name := entry.Name(); name != "share.json" {
+			ts.Errorf("unexpected file %s", name)
+		}
+	}
+}
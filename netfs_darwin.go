@@ -0,0 +1,17 @@
+package sconfig
+
+import "golang.org/x/sys/unix"
+
+// isNetworkFS reports whether the directory or file at path lies on an NFS,
+// SMB, AFP or WebDAV mount.
+func isNetworkFS(path string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false
+	}
+	switch unix.ByteSliceToString(st.Fstypename[:]) {
+	case "nfs", "smbfs", "afpfs", "webdav":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package sconfig
+
+import "golang.org/x/sys/unix"
+
+// Magic numbers of statfs(2) for network filesystems.
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517b
+	cifsMagic      = 0xff534d42
+	smb2MagicLinux = 0xfe534d42
+	afsSuperMagic  = 0x5346414f
+	cephSuperMagic = 0x00c36400
+)
+
+// isNetworkFS reports whether the directory or file at path lies on an NFS,
+// SMB/CIFS, AFS or Ceph mount.
+func isNetworkFS(path string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false
+	}
+	switch uint32(st.Type) {
+	case nfsSuperMagic, smbSuperMagic, cifsMagic, smb2MagicLinux, afsSuperMagic, cephSuperMagic:
+		return true
+	}
+	return false
+}
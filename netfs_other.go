@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package sconfig
+
+// isNetworkFS is only implemented on Linux, macOS and Windows.
+func isNetworkFS(path string) bool {
+	return false
+}
@@ -0,0 +1,21 @@
+package sconfig
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// isNetworkFS reports whether the directory or file at path lies on a
+// network drive or a UNC share.
+func isNetworkFS(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(abs) + `\`)
+	if err != nil {
+		return false
+	}
+	return windows.GetDriveType(root) == windows.DRIVE_REMOTE
+}
@@ -0,0 +1,80 @@
+package sconfig
+
+import (
+	"io"
+	"os"
+)
+
+/*
+ * Options for Load.
+ *
+ * LoadConfig takes its settings as positional arguments, which cannot grow
+ * without breaking callers. Load takes the same settings as options; new
+ * settings are added as new options. LoadConfig is a thin wrapper around it.
+ */
+
+// LoadOption configures Load.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	version    int
+	versionSet bool
+	clean      bool
+	debug      io.Writer
+	hardwareID func() (uint64, error)
+	frozen     []byte           // WithFrozenManifest
+	keyStore   KeyProvider      // WithKeyStore
+	passphrase PassphraseSource // WithPassphrase
+
+	ageRecipients    []string // WithAgeRecipients
+	ageRecipientsSet bool
+
+	namespace       string       // WithNamespace
+	lockStrategy    LockStrategy // WithLockStrategy
+	lockStrategySet bool
+	lastGood        bool        // WithLastGood
+	fileMode        os.FileMode // WithFileMode
+	fileModeSet     bool
+	strict          bool // WithStrict
+
+	containerIdentity []ContainerIdentitySource // WithContainerIdentity
+}
+
+// WithVersion sets the config version written to the Version fields (the
+// version argument of LoadConfig).
+func WithVersion(version int) LoadOption {
+	return func(o *loadOptions) {
+		o.version = version
+		o.versionSet = true
+	}
+}
+
+// WithCleanOutput writes the file back with plaintext passwords (the
+// cleanConfig argument of LoadConfig). It is subject to the two-person rule.
+func WithCleanOutput() LoadOption {
+	return func(o *loadOptions) { o.clean = true }
+}
+
+// WithDebugWriter enables the diagnostic output (hardware ID, key, paths) of
+// the debugOutput argument of LoadConfig and writes it to w instead of
+// stderr. The output contains sensitive data.
+func WithDebugWriter(w io.Writer) LoadOption {
+	return func(o *loadOptions) { o.debug = w }
+}
+
+// WithHardwareIDFunc replaces the hardware ID the key is derived from,
+// mainly for tests.
+func WithHardwareIDFunc(f func() (uint64, error)) LoadOption {
+	return func(o *loadOptions) { o.hardwareID = f }
+}
+
+// debugWriter receives the debug output, see debugMode.
+var debugWriter io.Writer = os.Stderr
+
+// setDebugWriter directs the debug output to w, or to stderr if w is nil.
+func setDebugWriter(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+	debugWriter = w
+}
@@ -0,0 +1,46 @@
+package sconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Options(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "options.json")
+	if err := os.WriteFile(configPath, []byte(`{"version": 2, "database_password": "opt-secret"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	hardwareID := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+
+	// Without WithVersion the version from the file is kept.
+	cfg := &TestConfig{}
+	if err := Load(cfg, configPath, hardwareID); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Version != 2 || cfg.DatabasePassword != "opt-secret" {
+		ts.Fatalf("unexpected config %+v", cfg)
+	}
+
+	var debug bytes.Buffer
+	cfg = &TestConfig{}
+	if err := Load(cfg, configPath, WithVersion(3), WithDebugWriter(&debug), hardwareID); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Version != 3 || cfg.DatabasePassword != "opt-secret" {
+		ts.Fatalf("unexpected config %+v", cfg)
+	}
+	if !strings.Contains(debug.String(), t("config.debug_file_abs_path")) {
+		ts.Errorf("debug output not written to the writer: %q", debug.String())
+	}
+
+	if err := Load(&TestConfig{}, configPath, WithVersion(3), WithCleanOutput(), hardwareID); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(configPath); !strings.Contains(string(raw), `"opt-secret"`) {
+		ts.Errorf("WithCleanOutput did not write plaintext: %s", raw)
+	}
+}
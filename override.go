@@ -0,0 +1,120 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Override files.
+ *
+ * Next to the committed base config (config.json) a developer or operator may
+ * keep a gitignored local override file (config.local.json) with the values
+ * that differ on this machine. LoadConfig applies it on top of the base config
+ * after the base file has been checked, encrypted and written, so the override
+ * file is only read, never rewritten, and its values never end up in the base
+ * file: UpdateConfig writes the base value of every overridden field the
+ * program did not change since loading.
+ *
+ * Passwords in an override file are used as given; they are neither encrypted
+ * nor decrypted.
+ */
+
+// overridePath returns the override file for path: config.json becomes
+// config.local.json, a file without extension gets ".local" appended.
+func overridePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".local" + ext
+}
+
+// overrideBinding remembers a field set by the override file.
+type overrideBinding struct {
+	field reflect.Value // addressable field in the config
+	base  reflect.Value // value from the base file
+	local reflect.Value // value from the override file
+}
+
+// overrideState is the override applied to one config pointer.
+type overrideState struct {
+	path     string // base file the override belongs to
+	bindings []overrideBinding
+}
+
+var (
+	overridesMu sync.Mutex
+	overrides   = map[interface{}]*overrideState{}
+)
+
+// applyOverrideFile decodes the override file of path, if there is one, on
+// top of config and remembers the overridden fields.
+func applyOverrideFile(path string, config interface{}) error {
+	forgetOverrides(config)
+	local := overridePath(path)
+	if _, err := os.Stat(local); err != nil {
+		return nil
+	}
+	v := reflect.ValueOf(config).Elem()
+	base := deepCopy(v)
+	if _, err := decodeConfigFile(local, config); err != nil {
+		return err
+	}
+	var bindings []overrideBinding
+	diffFields(v, base, &bindings)
+	if len(bindings) > 0 {
+		overridesMu.Lock()
+		overrides[config] = &overrideState{path: path, bindings: bindings}
+		overridesMu.Unlock()
+	}
+	return nil
+}
+
+// diffFields collects the leaf fields of cur that differ from base.
+func diffFields(cur, base reflect.Value, bindings *[]overrideBinding) {
+	for i := 0; i < cur.NumField(); i++ {
+		f, b := cur.Field(i), base.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		if f.Kind() == reflect.Struct && allExported(f.Type()) {
+			diffFields(f, b, bindings)
+			continue
+		}
+		if !reflect.DeepEqual(f.Interface(), b.Interface()) {
+			*bindings = append(*bindings, overrideBinding{field: f, base: b, local: deepCopy(f)})
+		}
+	}
+}
+
+// stripOverrides puts the base values back into the overridden fields of
+// config that still hold the override value, for writing config to path. The
+// returned function restores the override values.
+func stripOverrides(config interface{}, path string) (restore func()) {
+	overridesMu.Lock()
+	state := overrides[config]
+	overridesMu.Unlock()
+	if state == nil || state.path != path {
+		return func() {}
+	}
+	var stripped []overrideBinding
+	for _, b := range state.bindings {
+		if reflect.DeepEqual(b.field.Interface(), b.local.Interface()) {
+			b.field.Set(deepCopy(b.base))
+			stripped = append(stripped, b)
+		}
+	}
+	return func() {
+		for _, b := range stripped {
+			b.field.Set(deepCopy(b.local))
+		}
+	}
+}
+
+// forgetOverrides drops the override state of config.
+func forgetOverrides(config interface{}) {
+	overridesMu.Lock()
+	delete(overrides, config)
+	overridesMu.Unlock()
+}
@@ -0,0 +1,82 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverridePath(ts *testing.T) {
+	cases := map[string]string{
+		"config.json":         "config.local.json",
+		"/etc/app/app.yaml":   "/etc/app/app.local.yaml",
+		"settings":            "settings.local",
+		"dir.d/settings.toml": "dir.d/settings.local.toml",
+	}
+	for in, want := range cases {
+		if got := overridePath(in); got != want {
+			ts.Errorf("overridePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadConfig_OverrideFile(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "config.json")
+	localPath := filepath.Join(tempDir, "config.local.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "db.prod", "database_port": 5432}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	local := `{"database_host": "localhost", "database_password": "dev", "debug": true}`
+	if err := os.WriteFile(localPath, []byte(local), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabaseHost != "localhost" || cfg.DatabasePassword != "dev" || cfg.DatabasePort != 5432 {
+		ts.Errorf("override not applied: %+v", cfg)
+	}
+
+	readBase := func() map[string]interface{} {
+		raw, err := os.ReadFile(configPath)
+		if err != nil {
+			ts.Fatalf("ReadFile failed: %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			ts.Fatalf("invalid JSON: %v", err)
+		}
+		return doc
+	}
+
+	cfg.DatabaseName = "changed"
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	doc := readBase()
+	if doc["database_host"] != "db.prod" || doc["database_name"] != "changed" {
+		ts.Errorf("unexpected base file: %v", doc)
+	}
+	if doc["database_password"] == "dev" {
+		ts.Errorf("override password written to base file: %v", doc)
+	}
+	if cfg.DatabaseHost != "localhost" || cfg.DatabasePassword != "dev" {
+		ts.Errorf("override lost in memory after UpdateConfig: %+v", cfg)
+	}
+	if raw, _ := os.ReadFile(localPath); string(raw) != local {
+		ts.Errorf("override file rewritten:\n%s", raw)
+	}
+
+	// A field the program changes after loading is written like any other.
+	cfg.DatabaseHost = "db.staging"
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if doc := readBase(); doc["database_host"] != "db.staging" {
+		ts.Errorf("changed override field not written: %v", doc)
+	}
+}
@@ -0,0 +1,198 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+/*
+ * Passphrase keys.
+ *
+ * A hardware-bound key ties a config to one machine. Teams that can manage a
+ * passphrase get portable configs instead: with WithPassphrase the key is
+ * derived from the passphrase with Argon2id and a random per-file salt, which
+ * is stored in the metadata block together with the derivation version
+ * ("passphrase"). The same passphrase opens the file on every machine. The
+ * key fingerprint in the metadata block makes a wrong passphrase fail before
+ * any password is decrypted.
+ *
+ * The passphrase comes from a PassphraseSource: an environment variable, a
+ * file or a prompt on the terminal. It is read once per salt; later loads of
+ * the same file reuse the derived key, and new files take the salt of the
+ * current passphrase key. Close forgets the key.
+ */
+
+// passphraseKDFv1 names the Argon2id parameters of RFC 9106 (second
+// recommended option): 3 passes, 64 MiB, 4 lanes, 16-byte salt, 32-byte key.
+// Other parameters are a new version.
+const passphraseKDFv1 = "argon2id-v1"
+
+// PassphraseSource returns the passphrase for WithPassphrase.
+type PassphraseSource func() ([]byte, error)
+
+// PassphraseFromEnv reads the passphrase from the environment variable name.
+func PassphraseFromEnv(name string) PassphraseSource {
+	return func() ([]byte, error) {
+		return []byte(os.Getenv(name)), nil
+	}
+}
+
+// PassphraseFromFile reads the passphrase from the file at path; a trailing
+// line break is removed. On Unix the file must not be accessible by group or
+// others.
+func PassphraseFromFile(path string) PassphraseSource {
+	return func() ([]byte, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("%s", t("config.keyfile_permissions", path, info.Mode().Perm()))
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		trimmed := bytes.TrimRight(data, "\r\n")
+		passphrase := append([]byte(nil), trimmed...)
+		wipe(data)
+		return passphrase, nil
+	}
+}
+
+// PassphrasePrompt asks for the passphrase on the terminal, without echo.
+// It fails if standard input is not a terminal.
+func PassphrasePrompt() PassphraseSource {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("%s", t("config.passphrase_no_terminal"))
+		}
+		fmt.Fprint(os.Stderr, t("config.passphrase_prompt"))
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(fd)
+	}
+}
+
+// WithPassphrase makes Load derive the key from the passphrase src returns
+// instead of the hardware ID (see PassphraseSource). Like WithKeyStore it
+// stays in effect for later calls until Close or SetKeyProvider.
+func WithPassphrase(src PassphraseSource) LoadOption {
+	return func(o *loadOptions) { o.passphrase = src }
+}
+
+// passphraseKey is the KeyProvider of a passphrase-derived key.
+type passphraseKey struct {
+	salt []byte
+	key  []byte // locked buffer (secmem.go)
+}
+
+// Key returns a copy of the derived key.
+func (p *passphraseKey) Key() ([]byte, error) {
+	return append([]byte(nil), p.key...), nil
+}
+
+// currentPassphraseKey returns the key provider if it is a passphrase key.
+func currentPassphraseKey() *passphraseKey {
+	p, _ := getKeyProvider().(*passphraseKey)
+	return p
+}
+
+// passphraseMetadata returns the derivation version and salt to record for
+// the current key, or "" if it is not a passphrase key.
+func passphraseMetadata() (kdf, salt string) {
+	if p := currentPassphraseKey(); p != nil {
+		return passphraseKDFv1, base64.StdEncoding.EncodeToString(p.salt)
+	}
+	return "", ""
+}
+
+// usePassphrase makes the key derived from src and the salt of the config
+// file at path the key provider. Callers hold stateMu.
+func usePassphrase(path string, src PassphraseSource) error {
+	var meta *fileMetadata
+	if _, err := os.Stat(path); err == nil {
+		if meta, err = decodeConfigFile(path, &struct{}{}); err != nil {
+			return err
+		}
+	}
+	current := currentPassphraseKey()
+	var salt []byte
+	if meta != nil && meta.Salt != "" {
+		if meta.Passphrase != passphraseKDFv1 {
+			return fmt.Errorf("%s", t("config.kdf_unknown", meta.Passphrase))
+		}
+		var err error
+		if salt, err = base64.StdEncoding.DecodeString(meta.Salt); err != nil || len(salt) < 16 {
+			return fmt.Errorf("%s", t("config.passphrase_salt_invalid", path))
+		}
+		if current != nil && bytes.Equal(current.salt, salt) {
+			return nil
+		}
+	} else if current != nil {
+		return nil // a new file takes the current salt
+	} else {
+		salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return fmt.Errorf("%s", t("config.passphrase_failed", err))
+		}
+	}
+
+	passphrase, err := src()
+	if err != nil {
+		return fmt.Errorf("%s", t("config.passphrase_failed", err))
+	}
+	if len(strings.TrimSpace(string(passphrase))) == 0 {
+		return fmt.Errorf("%s", t("config.passphrase_empty"))
+	}
+	key := derivePassphraseKey(passphrase, salt)
+	wipe(passphrase)
+	if meta != nil && meta.Key != "" && meta.Salt != "" {
+		scoped, err := applyKeyScope(append([]byte(nil), key...))
+		if err != nil {
+			wipe(key)
+			return err
+		}
+		matches := fingerprintKey(scoped) == meta.Key
+		wipe(scoped)
+		if !matches {
+			wipe(key)
+			return fmt.Errorf("%s", t("config.passphrase_wrong", path))
+		}
+	}
+
+	keyProviderMu.Lock()
+	if old, ok := keyProvider.(*passphraseKey); ok {
+		releaseKeyBuffer(old.key)
+	}
+	keyProvider = &passphraseKey{salt: salt, key: newKeyBuffer(key)}
+	keyProviderMu.Unlock()
+	initialized = false
+	return nil
+}
+
+// derivePassphraseKey derives the key of passphraseKDFv1 from passphrase and
+// salt.
+func derivePassphraseKey(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, 3, 64*1024, 4, 32)
+}
+
+// forgetPassphraseKey wipes a passphrase key and removes it as the key
+// provider.
+func forgetPassphraseKey() {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	if p, ok := keyProvider.(*passphraseKey); ok {
+		releaseKeyBuffer(p.key)
+		keyProvider = nil
+	}
+}
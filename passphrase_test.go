@@ -0,0 +1,74 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Passphrase(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer func() {
+		Close()
+		invalidateKey()
+	}()
+	ts.Setenv("SCONFIG_TEST_PASSPHRASE", "correct horse battery staple")
+	passphrase := WithPassphrase(PassphraseFromEnv("SCONFIG_TEST_PASSPHRASE"))
+	machineA := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	machineB := WithHardwareIDFunc(func() (uint64, error) { return 9999, nil })
+	configPath := filepath.Join(tempDir, "portable.json")
+
+	if err := Load(&TestConfig{DatabasePassword: "team-secret"}, configPath, WithVersion(1), machineA, passphrase); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "team-secret") || !strings.Contains(string(raw), `"passphrase": "argon2id-v1"`) || !strings.Contains(string(raw), `"salt": "`) {
+		ts.Fatalf("unexpected file:\n%s", raw)
+	}
+
+	// Another machine opens the file with the same passphrase.
+	Close()
+	cfg := &TestConfig{}
+	if err := Load(cfg, configPath, WithVersion(1), machineB, passphrase); err != nil {
+		ts.Fatalf("Load on another machine failed: %v", err)
+	}
+	if cfg.DatabasePassword != "team-secret" {
+		ts.Errorf("DatabasePassword = %q", cfg.DatabasePassword)
+	}
+	again, _ := os.ReadFile(configPath)
+	if saltOf(string(again)) != saltOf(string(raw)) {
+		ts.Errorf("salt changed on write-back")
+	}
+
+	Close()
+	ts.Setenv("SCONFIG_TEST_PASSPHRASE", "wrong")
+	if err := Load(&TestConfig{}, configPath, WithVersion(1), passphrase); err == nil || !contains(err.Error(), t("config.passphrase_wrong", configPath)) {
+		ts.Errorf("expected wrong passphrase error, got %v", err)
+	}
+	ts.Setenv("SCONFIG_TEST_PASSPHRASE", "")
+	if err := Load(&TestConfig{}, configPath, WithVersion(1), passphrase); err == nil || !contains(err.Error(), t("config.passphrase_empty")) {
+		ts.Errorf("expected empty passphrase error, got %v", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		passFile := filepath.Join(tempDir, "passphrase")
+		os.WriteFile(passFile, []byte("correct horse battery staple\n"), 0644)
+		if _, err := PassphraseFromFile(passFile)(); err == nil || !contains(err.Error(), t("config.keyfile_permissions", passFile, os.FileMode(0644))) {
+			ts.Errorf("expected permission error, got %v", err)
+		}
+		os.Chmod(passFile, 0600)
+		if err := Load(&TestConfig{}, configPath, WithVersion(1), WithPassphrase(PassphraseFromFile(passFile))); err != nil {
+			ts.Errorf("Load with passphrase file failed: %v", err)
+		}
+	}
+}
+
+// saltOf returns the passphrase salt of a JSON config file.
+func saltOf(content string) string {
+	_, rest, _ := strings.Cut(content, `"salt": "`)
+	salt, _, _ := strings.Cut(rest, `"`)
+	return salt
+}
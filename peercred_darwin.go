@@ -0,0 +1,48 @@
+package sconfig
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the credentials of the peer of the unix socket
+// conn from LOCAL_PEERCRED and LOCAL_PEERPID, and its executable from
+// kern.procargs2.
+func peerCredentials(conn net.Conn) (PeerCredentials, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCredentials{}, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	var cred *unix.Xucred
+	var pid int
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if credErr == nil {
+			pid, credErr = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+		}
+	}); err != nil {
+		return PeerCredentials{}, err
+	}
+	if credErr != nil {
+		return PeerCredentials{}, credErr
+	}
+	if cred.Ngroups < 1 {
+		return PeerCredentials{}, errors.New("peer without group")
+	}
+	peer := PeerCredentials{PID: pid, UID: int(cred.Uid), GID: int(cred.Groups[0])}
+	// kern.procargs2 starts with argc (4 bytes) and the executable path.
+	if args, err := unix.SysctlRaw("kern.procargs2", pid); err == nil && len(args) > 4 {
+		if end := bytes.IndexByte(args[4:], 0); end > 0 {
+			peer.Executable = string(args[4 : 4+end])
+		}
+	}
+	return peer, nil
+}
@@ -0,0 +1,37 @@
+package sconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the peer of the unix socket
+// conn from SO_PEERCRED, and its executable from /proc.
+func peerCredentials(conn net.Conn) (PeerCredentials, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCredentials{}, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCredentials{}, err
+	}
+	if credErr != nil {
+		return PeerCredentials{}, credErr
+	}
+	peer := PeerCredentials{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid)); err == nil {
+		peer.Executable = exe
+	}
+	return peer, nil
+}
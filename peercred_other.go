@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package sconfig
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only implemented on Linux and macOS; elsewhere the
+// agent cannot identify its peers and refuses them.
+func peerCredentials(conn net.Conn) (PeerCredentials, error) {
+	return PeerCredentials{}, errors.New("peer credentials not supported on this system")
+}
@@ -0,0 +1,101 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+ * macOS platform identifiers.
+ *
+ * On macOS the hardware ID has been the MAC address of the active interface
+ * alone. SetMacPlatformIdentifiers adds the serial number and the hardware
+ * UUID of the Mac (IOPlatformSerialNumber, IOPlatformUUID), read from the
+ * IOKit registry with ioreg and, if that fails, from system_profiler. Both
+ * stay with the machine through network changes and OS reinstalls. Adding
+ * them changes the hardware ID, so it is off by default; move existing
+ * configs with sconfig rekey. Once enabled, a Mac whose identifiers cannot
+ * be read fails instead of getting a different key.
+ */
+
+var (
+	macPlatformMu      sync.Mutex
+	macPlatformEnabled bool
+)
+
+// SetMacPlatformIdentifiers adds the platform serial number and hardware
+// UUID of the Mac to the hardware ID on macOS. Like SetKeyProvider it must be
+// called before the first LoadConfig.
+func SetMacPlatformIdentifiers(enabled bool) {
+	macPlatformMu.Lock()
+	macPlatformEnabled = enabled
+	macPlatformMu.Unlock()
+	invalidateKey()
+}
+
+func macPlatformIdentifiers() bool {
+	macPlatformMu.Lock()
+	defer macPlatformMu.Unlock()
+	return macPlatformEnabled
+}
+
+// darwinPlatformIDs returns the platform serial number and hardware UUID of
+// the Mac.
+func darwinPlatformIDs() (serial, uuid string, err error) {
+	out, err := probeCommand("ioreg", "-rd1", "-c", "IOPlatformExpertDevice")
+	if err == nil {
+		if serial, uuid = parseIORegPlatform(out); serial != "" && uuid != "" {
+			return serial, uuid, nil
+		}
+	}
+	if err == errProbeNotAllowed || err == errExecProbesDisabled {
+		return "", "", err
+	}
+	out, err = probeCommand("system_profiler", "-json", "SPHardwareDataType")
+	if err != nil {
+		return "", "", err
+	}
+	serial, uuid, err = parseSystemProfilerHardware(out)
+	if err == nil && (serial == "" || uuid == "") {
+		err = fmt.Errorf("system_profiler reports no serial number or hardware UUID")
+	}
+	return serial, uuid, err
+}
+
+var ioregProperty = regexp.MustCompile(`"(IOPlatformSerialNumber|IOPlatformUUID)"\s*=\s*"([^"]*)"`)
+
+// parseIORegPlatform returns IOPlatformSerialNumber and IOPlatformUUID from
+// the output of ioreg -rd1 -c IOPlatformExpertDevice.
+func parseIORegPlatform(out []byte) (serial, uuid string) {
+	for _, m := range ioregProperty.FindAllSubmatch(out, -1) {
+		value := strings.TrimSpace(string(m[2]))
+		if string(m[1]) == "IOPlatformSerialNumber" {
+			serial = value
+		} else {
+			uuid = value
+		}
+	}
+	return serial, uuid
+}
+
+// parseSystemProfilerHardware returns the serial number and hardware UUID
+// from the output of system_profiler -json SPHardwareDataType, whose keys do
+// not depend on the system language.
+func parseSystemProfilerHardware(out []byte) (serial, uuid string, err error) {
+	var report struct {
+		Hardware []struct {
+			Serial string `json:"serial_number"`
+			UUID   string `json:"platform_UUID"`
+		} `json:"SPHardwareDataType"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return "", "", err
+	}
+	if len(report.Hardware) == 0 {
+		return "", "", nil
+	}
+	return strings.TrimSpace(report.Hardware[0].Serial), strings.TrimSpace(report.Hardware[0].UUID), nil
+}
@@ -0,0 +1,40 @@
+package sconfig
+
+import "testing"
+
+func TestParseMacPlatformIDs(ts *testing.T) {
+	ioreg := `+-o J314sAP  <class IOPlatformExpertDevice, id 0x100000211, registered, matched, active, busy 0 (2 ms), retain 42>
+    {
+      "IOPlatformSystemSleepPolicy" = <534c505402000d00>
+      "IOPlatformSerialNumber" = "C02ZK1ABMD6T"
+      "compatible" = <"J314sAP","MacBookPro18,3","AppleARM">
+      "IOPlatformUUID" = "8A6F1C3E-52B4-5D7A-9E0F-2C1B3A4D5E6F"
+      "IOPolledInterface" = "AppleARMWatchdogTimerHibernateHandler is not serializable"
+    }
+`
+	serial, uuid := parseIORegPlatform([]byte(ioreg))
+	if serial != "C02ZK1ABMD6T" || uuid != "8A6F1C3E-52B4-5D7A-9E0F-2C1B3A4D5E6F" {
+		ts.Errorf("ioreg: serial %q, UUID %q", serial, uuid)
+	}
+	if serial, uuid := parseIORegPlatform([]byte("ioreg: no such class\n")); serial != "" || uuid != "" {
+		ts.Errorf("empty ioreg: serial %q, UUID %q", serial, uuid)
+	}
+
+	profiler := `{
+  "SPHardwareDataType" : [
+    {
+      "_name" : "hardware_overview",
+      "machine_model" : "MacBookPro18,3",
+      "platform_UUID" : "8A6F1C3E-52B4-5D7A-9E0F-2C1B3A4D5E6F",
+      "serial_number" : "C02ZK1ABMD6T"
+    }
+  ]
+}`
+	serial, uuid, err := parseSystemProfilerHardware([]byte(profiler))
+	if err != nil || serial != "C02ZK1ABMD6T" || uuid != "8A6F1C3E-52B4-5D7A-9E0F-2C1B3A4D5E6F" {
+		ts.Errorf("system_profiler: serial %q, UUID %q, %v", serial, uuid, err)
+	}
+	if _, _, err := parseSystemProfilerHardware([]byte("Hardware:\n")); err == nil {
+		ts.Error("text output of system_profiler accepted as JSON")
+	}
+}
@@ -0,0 +1,236 @@
+package sconfig
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * Portable export of the secrets.
+ *
+ * A hardware-bound key has no backup: when the machine is gone, so are the
+ * passwords. ExportPortable decrypts the secrets of a config file (the
+ * "…secure_password" values and the ciphertexts of secure fields, on the
+ * document like DumpForRecovery) and seals them with a key derived from a
+ * passphrase, with Argon2id like WithPassphrase and a random salt.
+ * ImportPortable opens such an export on the new host and writes the secrets
+ * into the config file there, encrypted with the key of that host, which
+ * binds them to its hardware (or to its key provider). The export holds the
+ * secrets only, by their document path; the other values come with the config
+ * file itself, e.g. from the deployment. Both write audit lines to the
+ * standard logger. XML configs are not supported.
+ *
+ * An export is the line portableHeader, the line with the derivation version
+ * and the base64 salt, and the base64 AES-256-GCM ciphertext of the JSON
+ * portableSecrets.
+ */
+
+const portableHeader = "sconfig-portable-v1"
+
+// portableSecrets is the sealed content of a portable export.
+type portableSecrets struct {
+	Version int              `json:"version"`
+	Created time.Time        `json:"created"`
+	Host    string           `json:"host,omitempty"`
+	Source  string           `json:"source"` // base name of the exported config file
+	Secrets []portableSecret `json:"secrets"`
+}
+
+// portableSecret is one secret of a portable export.
+type portableSecret struct {
+	Path  string `json:"path"` // document path of the password key, see DocumentField.Path
+	Value string `json:"value"`
+}
+
+// ExportPortable returns the secrets of the config file at path, decrypted
+// with the key of this machine and sealed with a key derived from
+// passphrase, for ImportPortable on another host. Keep the passphrase apart
+// from the export; whoever has both has the secrets.
+func ExportPortable(path string, passphrase []byte) ([]byte, error) {
+	if len(strings.TrimSpace(string(passphrase))) == 0 {
+		return nil, fmt.Errorf("%s", t("config.passphrase_empty"))
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	in, _, doc, err := readDocument(path, "config.portable_unsupported")
+	if err != nil {
+		return nil, err
+	}
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, err
+	}
+	key, err := documentKey(doc)
+	if err != nil {
+		return nil, err
+	}
+	export := portableSecrets{Version: 1, Created: time.Now().UTC(), Source: filepath.Base(in)}
+	export.Host, _ = os.Hostname()
+	err = collectSecrets(doc, "", key, &export.Secrets)
+	wipe(key)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(export)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		wipe(content)
+		return nil, err
+	}
+	sealKey := derivePassphraseKey(passphrase, salt)
+	sealed, err := encryptWithKey(sealKey, string(content))
+	wipe(sealKey)
+	wipe(content)
+	if err != nil {
+		return nil, err
+	}
+	auditLog(t("config.portable_audit_export", in, len(export.Secrets)))
+	return []byte(fmt.Sprintf("%s\n%s %s\n%s\n", portableHeader, passphraseKDFv1, base64.StdEncoding.EncodeToString(salt), sealed)), nil
+}
+
+// collectSecrets appends the decrypted secrets of the decoded JSON value v,
+// found at path, to secrets. Passwords not yet encrypted are taken as they
+// are.
+func collectSecrets(v interface{}, path string, key []byte, secrets *[]portableSecret) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if path == "" && name == metadataKey {
+				continue
+			}
+			if err := collectSecrets(value, joinDocumentPath(path, name), key, secrets); err != nil {
+				return err
+			}
+			sealed, _ := value.(string)
+			secretKey := ""
+			if plainKey, ok := plainPasswordKey(v, name); ok {
+				secretKey = plainKey
+				if password, _ := v[plainKey].(string); sealed == "" && isPlaintextPassword(password) {
+					*secrets = append(*secrets, portableSecret{Path: joinDocumentPath(path, plainKey), Value: password})
+					continue
+				}
+			} else if base, ok := strings.CutSuffix(name, shadowSuffix); ok {
+				if _, hasBase := v[base]; hasBase {
+					secretKey = base
+				}
+			}
+			if secretKey == "" || sealed == "" {
+				continue
+			}
+			plaintext, err := decryptWithKey(key, sealed)
+			if err != nil {
+				decryptFailures.Add(1)
+				return fmt.Errorf("%s", t("config.decrypt_failed", name, err))
+			}
+			*secrets = append(*secrets, portableSecret{Path: joinDocumentPath(path, secretKey), Value: plaintext})
+		}
+	case []interface{}:
+		for i, elem := range v {
+			if err := collectSecrets(elem, fmt.Sprintf("%s[%d]", path, i), key, secrets); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportPortable opens the export data of ExportPortable with passphrase and
+// writes its secrets into the config file at path, encrypted with the key of
+// this machine, replacing the passwords there. It returns the number of
+// secrets. If the file lacks the key of a secret, it is left unchanged.
+func ImportPortable(path string, data, passphrase []byte) (int, error) {
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 3)
+	if len(lines) != 3 || strings.TrimSpace(lines[0]) != portableHeader {
+		return 0, fmt.Errorf("%s", t("config.portable_invalid"))
+	}
+	kdf, encodedSalt, _ := strings.Cut(strings.TrimSpace(lines[1]), " ")
+	if kdf != passphraseKDFv1 {
+		return 0, fmt.Errorf("%s", t("config.kdf_unknown", kdf))
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil || len(salt) < 16 {
+		return 0, fmt.Errorf("%s", t("config.portable_invalid"))
+	}
+	sealKey := derivePassphraseKey(passphrase, salt)
+	content, err := decryptBytesWithKey(sealKey, strings.TrimSpace(lines[2]))
+	wipe(sealKey)
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.portable_decrypt_failed"))
+	}
+	var export portableSecrets
+	err = json.Unmarshal(content, &export)
+	wipe(content)
+	if err != nil {
+		return 0, fmt.Errorf("%s", t("config.portable_invalid"))
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	in, format, doc, err := readDocument(path, "config.portable_unsupported")
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(in)
+	if err != nil {
+		return 0, fmt.Errorf(t("config.read_failed"), err)
+	}
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return 0, err
+	}
+	target := &Document{root: doc}
+	for _, secret := range export.Secrets {
+		parent, key, _ := target.locate(secret.Path)
+		if _, ok := parent[key].(string); !ok {
+			return 0, fmt.Errorf("%s", t("config.portable_unknown_field", secret.Path, in))
+		}
+		if _, ok := parent[key+shadowSuffix]; ok {
+			sealed, err := encrypt(secret.Value)
+			if err != nil {
+				return 0, err
+			}
+			parent[key], parent[key+shadowSuffix] = secureMarker(), sealed
+			continue
+		}
+		// The plaintext replaces the ciphertext of the old host.
+		parent[key] = secret.Value
+		paired := false
+		for name := range parent {
+			if plainKey, ok := plainPasswordKey(parent, name); ok && plainKey == key {
+				parent[name], paired = "", true
+			}
+		}
+		if !paired {
+			secureKey, ok := securePasswordKey(key)
+			if !ok {
+				return 0, fmt.Errorf("%s", t("config.portable_unknown_field", secret.Path, in))
+			}
+			parent[secureKey] = ""
+		}
+	}
+	if _, err := encryptDocument(doc); err != nil {
+		return 0, err
+	}
+	setDocumentKeyMetadata(doc)
+	result, err := encodeDocument(format, doc)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomic(in, info.Mode().Perm(), func(w io.Writer) error {
+		_, err := w.Write(result)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	auditLog(t("config.portable_audit_import", in, len(export.Secrets)))
+	return len(export.Secrets), nil
+}
@@ -0,0 +1,69 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportPortable(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	defer SetKeyProvider(nil)
+	configPath := filepath.Join(tempDir, "portable.json")
+	cfg := &rewriteTestConfig{Host: "db", Token: "portable-token"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	pairPath := filepath.Join(tempDir, "portable-pair.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "portable-secret"}, 1, pairPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if _, err := ExportPortable(configPath, []byte(" ")); err == nil {
+		ts.Error("export with an empty passphrase succeeded")
+	}
+	passphrase := []byte("correct horse battery staple")
+	export, err := ExportPortable(configPath, passphrase)
+	if err != nil {
+		ts.Fatalf("ExportPortable failed: %v", err)
+	}
+	pairExport, err := ExportPortable(pairPath, passphrase)
+	if err != nil {
+		ts.Fatalf("ExportPortable failed: %v", err)
+	}
+	if strings.Contains(string(export), "portable-token") || !strings.HasPrefix(string(export), portableHeader+"\n") {
+		ts.Fatalf("unexpected export:\n%s", export)
+	}
+
+	// The new host has its own key; the copied files hold the old ciphertexts.
+	invalidateKey()
+	SetKeyProvider(&KeyFileProvider{Path: filepath.Join(tempDir, "new-host.key")})
+	before, _ := os.ReadFile(configPath)
+	if _, err := ImportPortable(configPath, export, []byte("wrong")); err == nil || !contains(err.Error(), t("config.portable_decrypt_failed")) {
+		ts.Errorf("import with the wrong passphrase: %v", err)
+	}
+	if _, err := ImportPortable(configPath, pairExport, passphrase); err == nil || !contains(err.Error(), "database_password") {
+		ts.Errorf("import into a file without the key: %v", err)
+	}
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		ts.Fatal("file changed after a failed import")
+	}
+	if count, err := ImportPortable(configPath, export, passphrase); err != nil || count != 1 {
+		ts.Fatalf("ImportPortable = %d, %v", count, err)
+	}
+	if count, err := ImportPortable(pairPath, pairExport, passphrase); err != nil || count != 1 {
+		ts.Fatalf("ImportPortable = %d, %v", count, err)
+	}
+	invalidateKey()
+	cfg = &rewriteTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil || cfg.Token != "portable-token" || cfg.Host != "db" {
+		ts.Fatalf("LoadConfig on the new host: %v, %+v", err, cfg)
+	}
+	pair := &TestConfig{}
+	if err := LoadConfig(pair, 1, pairPath, false, false); err != nil || pair.DatabasePassword != "portable-secret" {
+		ts.Fatalf("LoadConfig on the new host: %v, %q", err, pair.DatabasePassword)
+	}
+}
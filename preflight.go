@@ -0,0 +1,169 @@
+package sconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+ * Startup preflight.
+ *
+ * Preflight checks the external resources a config depends on before the
+ * service starts using it, for health-check endpoints and init containers:
+ * the key source (key provider or hardware ID, key scope), every secret
+ * reference, and the string fields tagged with `preflight:"..."`:
+ *
+ *	path  the path exists
+ *	file  the path exists and is a regular file
+ *	dir   the path exists and is a directory
+ *	tcp   a TCP connection to the host:port value can be opened
+ *
+ * Secret values are resolved and discarded; the report never contains them.
+ */
+
+// preflightDialTimeout bounds each tcp check.
+var preflightDialTimeout = 3 * time.Second
+
+// PreflightCheck is the result of one check.
+type PreflightCheck struct {
+	Kind        string `json:"kind"`                  // "key", "secretref", "path", "file", "dir" or "tcp"
+	Field       string `json:"field,omitempty"`       // struct field, empty for the key check
+	Description string `json:"description,omitempty"` // `desc:"..."` of the field
+	Target      string `json:"target"`                // checked path, address or reference
+	OK          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
+}
+
+// PreflightReport lists all checks; Ready is true if every check passed.
+type PreflightReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Failed returns the checks that did not pass.
+func (r *PreflightReport) Failed() []PreflightCheck {
+	var failed []PreflightCheck
+	for _, c := range r.Checks {
+		if !c.OK {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+func (r *PreflightReport) add(kind, field, desc, target string, err error) {
+	c := PreflightCheck{Kind: kind, Field: field, Description: desc, Target: target, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// Preflight checks the key source and the external resources referenced by
+// config (a pointer to a struct, loaded or not) and returns a readiness
+// report.
+func Preflight(config interface{}) *PreflightReport {
+	report := &PreflightReport{}
+	report.add("key", "", "", keySourceName(), checkKeySource())
+	w := &walker{phases: phasePreflight, report: report}
+	w.refs = bindingsOf(config)
+	_ = w.walk(reflect.ValueOf(config))
+	report.Ready = len(report.Failed()) == 0
+	return report
+}
+
+// keySourceName describes the configured key source.
+func keySourceName() string {
+	keyScopeMu.Lock()
+	scope := keyScope
+	keyScopeMu.Unlock()
+	if p := getKeyProvider(); p != nil {
+		return fmt.Sprintf("%T (scope %s)", p, scope)
+	}
+	return fmt.Sprintf("hardware ID (scope %s)", scope)
+}
+
+// checkKeySource derives the key like config_init does and wipes it again.
+func checkKeySource() error {
+	var base []byte
+	if p := getKeyProvider(); p != nil {
+		key, err := p.Key()
+		if err != nil {
+			return err
+		}
+		if len(key) != 32 {
+			wipe(key)
+			return fmt.Errorf("key provider returned %d bytes, want 32", len(key))
+		}
+		base = key
+	} else {
+		if _, err := secure_config_getHardwareID(); err != nil {
+			return err
+		}
+		base = make([]byte, 32)
+	}
+	scoped, err := applyKeyScope(base)
+	wipe(base)
+	if err != nil {
+		return err
+	}
+	wipe(scoped)
+	return nil
+}
+
+/*
+ * Check the secret references and preflight-tagged fields of one struct.
+ */
+func (w *walker) preflight(v reflect.Value, info *structInfo) {
+	for _, i := range info.strs {
+		fieldValue := v.Field(i)
+		value := fieldValue.String()
+		// A loaded config holds the resolved secret; check its reference.
+		for _, b := range w.refs {
+			if fieldValue.CanAddr() && b.field.UnsafeAddr() == fieldValue.UnsafeAddr() && value == b.resolved {
+				value = b.ref
+			}
+		}
+		if isSecretRef(value) {
+			_, err := resolveSecretRef(value)
+			w.report.add("secretref", v.Type().Field(i).Name, info.descs[i], value, err)
+		}
+	}
+	for _, c := range info.checks {
+		value := v.Field(c.index).String()
+		w.report.add(c.kind, v.Type().Field(c.index).Name, info.descs[c.index], value, checkResource(c.kind, value))
+	}
+}
+
+// checkResource runs one preflight check of the given kind on value.
+func checkResource(kind, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("not set")
+	}
+	switch kind {
+	case "path", "file", "dir":
+		info, err := os.Stat(value)
+		if err != nil {
+			return err
+		}
+		if kind == "file" && !info.Mode().IsRegular() {
+			return errors.New("not a regular file")
+		}
+		if kind == "dir" && !info.IsDir() {
+			return errors.New("not a directory")
+		}
+		return nil
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", value, preflightDialTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	return fmt.Errorf("unknown preflight check %q", kind)
+}
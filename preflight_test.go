@@ -0,0 +1,69 @@
+package sconfig
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type PreflightTestConfig struct {
+	DataDir  string `json:"data_dir" preflight:"dir"`
+	CertFile string `json:"cert_file" preflight:"file"`
+	Upstream string `json:"upstream" preflight:"tcp"`
+	Token    string `json:"token"`
+}
+
+func TestPreflight(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		ts.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	certFile := filepath.Join(tempDir, "cert.pem")
+	if err := os.WriteFile(certFile, []byte("cert"), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	ts.Setenv("SCONFIG_TEST_PREFLIGHT_TOKEN", "token-secret")
+
+	configPath := filepath.Join(tempDir, "preflight.json")
+	content := `{"data_dir": "` + filepath.ToSlash(tempDir) + `", "cert_file": "` + filepath.ToSlash(certFile) +
+		`", "upstream": "` + ln.Addr().String() + `", "token": "secretref:env:SCONFIG_TEST_PREFLIGHT_TOKEN"}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &PreflightTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	report := Preflight(cfg)
+	if !report.Ready {
+		ts.Fatalf("expected ready, failed checks: %+v", report.Failed())
+	}
+	if len(report.Checks) != 5 {
+		ts.Errorf("expected 5 checks, got %+v", report.Checks)
+	}
+	for _, c := range report.Checks {
+		if strings.Contains(c.Target, "token-secret") || strings.Contains(c.Error, "token-secret") {
+			ts.Errorf("secret value in report: %+v", c)
+		}
+	}
+
+	cfg.DataDir = certFile
+	cfg.CertFile = filepath.Join(tempDir, "missing.pem")
+	os.Unsetenv("SCONFIG_TEST_PREFLIGHT_TOKEN")
+	report = Preflight(cfg)
+	if report.Ready {
+		ts.Fatal("expected not ready")
+	}
+	failed := map[string]bool{}
+	for _, c := range report.Failed() {
+		failed[c.Field] = true
+	}
+	if !failed["DataDir"] || !failed["CertFile"] || !failed["Token"] || failed["Upstream"] {
+		ts.Errorf("unexpected failed checks: %+v", report.Failed())
+	}
+}
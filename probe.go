@@ -0,0 +1,174 @@
+package sconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Hardware probes.
+ *
+ * The hardware ID is collected from files (/etc/machine-id, DMI), on Windows
+ * from the registry, SMBIOS and the IP helper API (hwprobe_windows.go), and
+ * otherwise from external commands (route, systemd-detect-virt); the route on
+ * Linux comes from netlink (route_linux.go). Services confined by SELinux,
+ * AppArmor or seccomp are often denied exec, which only shows up as slow,
+ * failing probes. SetExecProbes(false) turns all commands off: on Linux the
+ * hardware ID is then collected from netlink and files only (VM detection
+ * from DMI), Windows needs no commands, on other systems the key must come
+ * from a key provider (SetKeyProvider), and the hardware ID fails fast
+ * otherwise.
+ *
+ * For hardened environments SetProbeAllowlist declares exactly which commands
+ * and files may be used, e.g. to match a seccomp or AppArmor profile. Other
+ * probes are not run, and the hardware ID fails with an error naming them
+ * instead of silently leading to a different key.
+ */
+
+var (
+	probeMu            sync.Mutex
+	execProbesDisabled bool
+	probeAllowlist     map[string]bool // nil: every probe is allowed
+	probesDenied       []string        // probes refused while collecting the hardware ID
+
+	// hardwareIDMu serializes the collection of the hardware ID, so
+	// probesDenied belongs to one collection.
+	hardwareIDMu sync.Mutex
+)
+
+var (
+	errExecProbesDisabled = errors.New("exec probes are disabled")
+	errProbeNotAllowed    = errors.New("probe not in the allowlist")
+)
+
+// SetExecProbes enables (default) or disables running external commands to
+// collect the hardware ID. Like SetKeyProvider it must be called before the
+// first LoadConfig.
+func SetExecProbes(enabled bool) {
+	probeMu.Lock()
+	execProbesDisabled = !enabled
+	probeMu.Unlock()
+	invalidateKey()
+}
+
+// SetProbeAllowlist restricts the hardware ID collection to the given probes:
+// command names ("route"), complete command lines ("route -n get 8.8.8.8"),
+// file paths ("/etc/machine-id") or the names of the Windows probes. If a probe outside the list would be
+// needed, the hardware ID fails with an error naming it. Without arguments
+// every probe is allowed again (default). Like SetKeyProvider it must be
+// called before the first LoadConfig.
+func SetProbeAllowlist(probes ...string) {
+	probeMu.Lock()
+	probeAllowlist = nil
+	if len(probes) > 0 {
+		probeAllowlist = make(map[string]bool, len(probes))
+		for _, p := range probes {
+			probeAllowlist[p] = true
+		}
+	}
+	probeMu.Unlock()
+	invalidateKey()
+}
+
+// allowProbe reports whether one of names is allowed and records the first
+// name as denied otherwise.
+func allowProbe(names ...string) bool {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	if probeAllowlist == nil {
+		return true
+	}
+	for _, name := range names {
+		if probeAllowlist[name] {
+			return true
+		}
+	}
+	probesDenied = append(probesDenied, names[0])
+	return false
+}
+
+// withProbeAllowlist runs collect and fails if it needed a probe outside the
+// allowlist.
+func withProbeAllowlist(collect func() (uint64, error)) (uint64, error) {
+	if err := hardwareIDFault(); err != nil {
+		return 0, err
+	}
+	hardwareIDMu.Lock()
+	defer hardwareIDMu.Unlock()
+	probeMu.Lock()
+	probesDenied = nil
+	probeMu.Unlock()
+
+	id, err := collect()
+
+	probeMu.Lock()
+	denied := probesDenied
+	probesDenied = nil
+	probeMu.Unlock()
+	if len(denied) > 0 {
+		return 0, fmt.Errorf("%s", t("config.probe_not_allowed", strings.Join(denied, "; ")))
+	}
+	return id, err
+}
+
+func execProbesEnabled() bool {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	return !execProbesDisabled
+}
+
+// probeCommand runs an external hardware probe and returns its output.
+func probeCommand(name string, args ...string) ([]byte, error) {
+	if !execProbesEnabled() {
+		return nil, errExecProbesDisabled
+	}
+	if !allowProbe(strings.Join(append([]string{name}, args...), " "), name) {
+		return nil, errProbeNotAllowed
+	}
+	probeDelay()
+	return exec.Command(name, args...).Output()
+}
+
+// probeFile reads a file used as hardware probe.
+func probeFile(path string) ([]byte, error) {
+	if !allowProbe(path) {
+		return nil, errProbeNotAllowed
+	}
+	probeDelay()
+	return os.ReadFile(path)
+}
+
+// defaultRouteInterface returns the interface of the default route from
+// /proc/net/route (Linux), the fallback of the netlink route lookup.
+func defaultRouteInterface() string {
+	data, err := probeFile("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	return parseDefaultRoute(data)
+}
+
+// parseDefaultRoute returns the interface of the default route with the
+// lowest metric in the /proc/net/route table data.
+func parseDefaultRoute(data []byte) string {
+	best, bestMetric := "", -1
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err == nil && (bestMetric < 0 || metric < bestMetric) {
+			best, bestMetric = fields[0], metric
+		}
+	}
+	return best
+}
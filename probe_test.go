@@ -0,0 +1,76 @@
+package sconfig
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseDefaultRoute(ts *testing.T) {
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+		"eth0\t00000000\t0100A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+		"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n"
+	if got := parseDefaultRoute([]byte(table)); got != "eth0" {
+		ts.Errorf("expected eth0, got %q", got)
+	}
+	if got := parseDefaultRoute([]byte("Iface\tDestination\n")); got != "" {
+		ts.Errorf("expected no interface, got %q", got)
+	}
+}
+
+func TestSetExecProbes(ts *testing.T) {
+	SetExecProbes(false)
+	defer SetExecProbes(true)
+	if _, err := probeCommand("true"); err != errExecProbesDisabled {
+		ts.Fatalf("expected disabled probes, got %v", err)
+	}
+	_, err := secure_config_getHardwareID()
+	if runtime.GOOS == "linux" {
+		// Linux collects the hardware ID from files only.
+		if err != nil && err.Error() != "no hardware identifiers found" {
+			ts.Errorf("unexpected error: %v", err)
+		}
+	} else if err == nil || !contains(err.Error(), t("config.exec_probes_required", runtime.GOOS)) {
+		ts.Errorf("expected fail-fast error, got %v", err)
+	}
+}
+
+func TestSetProbeAllowlist(ts *testing.T) {
+	if runtime.GOOS != "linux" {
+		ts.Skip("probe set differs per OS")
+	}
+	defer SetProbeAllowlist()
+	SetProbeAllowlist("/etc/machine-id")
+	_, err := secure_config_getHardwareID()
+	if err == nil || !contains(err.Error(), "/proc/cpuinfo") {
+		ts.Fatalf("expected an error naming the denied probes, got %v", err)
+	}
+	probeMu.Lock()
+	denied := len(probesDenied)
+	probeMu.Unlock()
+	if denied != 0 {
+		ts.Error("denied probes not reset after the collection")
+	}
+
+	// Allowing the probes named in the error lets the collection succeed
+	// (a newly allowed probe may lead to further probes, e.g. on VMs).
+	SetProbeAllowlist()
+	want, wantErr := secure_config_getHardwareID()
+	probes := []string{"/etc/machine-id"}
+	for range 5 {
+		SetProbeAllowlist(probes...)
+		if _, err = secure_config_getHardwareID(); err == nil || !contains(err.Error(), "SetProbeAllowlist") {
+			break
+		}
+		msg := err.Error()
+		probes = append(probes, strings.Split(msg[strings.Index(msg, "): ")+3:], "; ")...)
+	}
+	got, err := secure_config_getHardwareID()
+	if (err == nil) != (wantErr == nil) {
+		ts.Fatalf("unexpected error with all probes allowed: %v", err)
+	}
+	if got != want {
+		ts.Errorf("hardware ID differs with the allowlist: %x != %x", got, want)
+	}
+}
@@ -0,0 +1,261 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * Concurrency suite.
+ *
+ * These tests run the documented concurrent uses side by side; they check
+ * results, but their main purpose is to give `go test -race` something to
+ * find. Keep them fast: they run in every test run, with and without -race.
+ */
+
+// raceWorkers is the number of goroutines of each kind.
+const raceWorkers = 8
+
+func TestRace_LoadAndUpdateConfig(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	var wg sync.WaitGroup
+	errs := make(chan error, raceWorkers)
+	for i := 0; i < raceWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			configPath := filepath.Join(tempDir, fmt.Sprintf("race-%d.json", i))
+			cfg := &TestConfig{DatabasePassword: fmt.Sprintf("secret-%d", i)}
+			for round := 0; round < 5; round++ {
+				if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+					errs <- err
+					return
+				}
+				cfg.DatabasePort = round
+				if err := UpdateConfig(cfg, configPath); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if cfg.DatabasePassword != fmt.Sprintf("secret-%d", i) {
+				errs <- fmt.Errorf("worker %d: password %q", i, cfg.DatabasePassword)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		ts.Error(err)
+	}
+}
+
+func TestRace_SharedFile(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "shared.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "shared"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	// Every worker changes a different field; the merge keeps all of them.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cfg := &TestConfig{}
+			if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+				ts.Error(err)
+				return
+			}
+			switch i {
+			case 0:
+				cfg.DatabaseHost = "db.race"
+			case 1:
+				cfg.DatabaseName = "racedb"
+			case 2:
+				cfg.DatabaseUser = "racer"
+			}
+			if err := UpdateConfig(cfg, configPath); err != nil {
+				ts.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if cfg.DatabaseHost != "db.race" || cfg.DatabaseName != "racedb" || cfg.DatabaseUser != "racer" || cfg.DatabasePassword != "shared" {
+		ts.Errorf("merged config = %+v", cfg)
+	}
+}
+
+func TestRace_Loader(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	configPath := filepath.Join(tempDir, "loader-race.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "loader"}, 1, configPath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	var current Store[TestConfig]
+	l := NewLoader(&TestConfig{}, configPath, 1, WithAutoRefresh(5*time.Millisecond), WithStore(&current))
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < raceWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < 10; round++ {
+				switch (i + round) % 5 {
+				case 0:
+					if err := l.Load(); err != nil {
+						ts.Error(err)
+					}
+				case 1:
+					l.MarkChanged()
+					if err := l.Flush(); err != nil {
+						ts.Error(err)
+					}
+				case 2:
+					if err := l.Save(); err != nil {
+						ts.Error(err)
+					}
+				case 3:
+					if snapshot := l.Snapshot().(*TestConfig); snapshot.DatabasePassword != "loader" {
+						ts.Errorf("snapshot password %q", snapshot.DatabasePassword)
+					}
+				case 4:
+					if cfg := current.Load(); cfg == nil || cfg.DatabasePassword != "loader" {
+						ts.Errorf("stored config %+v", cfg)
+					}
+					_ = l.Health()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		ts.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestRace_Watch(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "watch-race.json")
+	cfg := &TestConfig{DatabasePassword: "watched"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	w, err := WatchConfig(cfg, configPath, nil, WithPolling(2*time.Millisecond, time.Millisecond))
+	if err != nil {
+		ts.Fatalf("WatchConfig failed: %v", err)
+	}
+	defer w.Close()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < raceWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if got := w.Config().(*TestConfig); got.DatabasePassword != "watched" {
+					ts.Errorf("watched password %q", got.DatabasePassword)
+					return
+				}
+			}
+		}()
+	}
+	for port := 1; port <= 10; port++ {
+		raw, _ := os.ReadFile(configPath)
+		var doc map[string]interface{}
+		json.Unmarshal(raw, &doc)
+		doc["database_port"] = port
+		raw, _ = json.Marshal(doc)
+		if err := os.WriteFile(configPath, raw, 0600); err != nil {
+			ts.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRace_ManagersAndLanguages(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	origLang := getCurrentLanguage()
+	defer setLanguage(origLang)
+	var wg sync.WaitGroup
+	for i := 0; i < raceWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lang := []string{"en", "de"}[i%2]
+			m := NewManager(func() (uint64, error) { return uint64(1000 + i), nil }, lang)
+			defer m.Close()
+			configPath := filepath.Join(tempDir, fmt.Sprintf("manager-%d.json", i))
+			cfg := &TestConfig{DatabasePassword: "managed"}
+			for round := 0; round < 3; round++ {
+				if err := m.LoadConfig(cfg, 1, configPath, false, false); err != nil {
+					ts.Error(err)
+					return
+				}
+				if err := m.UpdateConfig(cfg, configPath); err != nil {
+					ts.Error(err)
+					return
+				}
+				_ = t("config.password_message")
+			}
+			raw, _ := os.ReadFile(configPath)
+			var doc map[string]interface{}
+			json.Unmarshal(raw, &doc)
+			if want := translateIn(lang, "config.password_message"); doc["database_password"] != want {
+				ts.Errorf("%s manager wrote marker %v, want %q", lang, doc["database_password"], want)
+			}
+		}(i)
+	}
+	// A Loader closed meanwhile scrubs its config with the package key.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		configPath := filepath.Join(tempDir, "closing.json")
+		for round := 0; round < 3; round++ {
+			l := NewLoader(&TestConfig{DatabasePassword: "closing"}, configPath, 1)
+			if err := l.Load(); err != nil {
+				ts.Error(err)
+				return
+			}
+			if err := l.Close(); err != nil {
+				ts.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		setLanguage([]string{"en", "de"}[i%2])
+		_ = t("config.load_first")
+		_ = Merge(&TestConfig{}, &TestConfig{DatabaseSecurePassword: "sealed"}, 0)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,312 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * Emergency plaintext export.
+ *
+ * For disaster recovery an operator may need the passwords of a config in
+ * plaintext, e.g. before replacing the hardware the key is bound to. Using
+ * cleanConfig for that rewrites the live config; DumpForRecovery instead
+ * writes a separate copy with all passwords decrypted, readable only by the
+ * owner (0600). It needs an explicit confirmation token and writes audit lines
+ * to the standard logger before and after the export, which SetWarningHandler
+ * cannot silence.
+ *
+ * The export works on the document, like Convert, so no config struct is
+ * needed: in every object a key whose name ends in "secure_password" (case,
+ * "_" and "-" ignored) is decrypted into its sibling "…password" key. An
+ * interrupt (Ctrl-C, SIGTERM) during the export is held back until the file
+ * has been written and then removes it again, so no partial or unattended
+ * plaintext copy is left behind. WriteRecovery writes the plaintext to a
+ * stream instead, e.g. stdout for sconfig decrypt, with the same checks and
+ * audit lines ("-" standing for the stream).
+ */
+
+// RecoveryConfirmation must be passed to DumpForRecovery to confirm that a
+// plaintext copy of the secrets is wanted.
+const RecoveryConfirmation = "I understand that this writes plaintext secrets"
+
+// DumpForRecovery decrypts all passwords of the config file at path into a new
+// file next to it, named <name>.recovery-<timestamp><ext>, and returns the
+// path of that file. confirmToken must equal RecoveryConfirmation. XML configs
+// are not supported. Delete the copy as soon as it is no longer needed.
+func DumpForRecovery(path, confirmToken string) (string, error) {
+	if confirmToken != RecoveryConfirmation {
+		return "", fmt.Errorf("%s", t("config.recovery_unconfirmed"))
+	}
+	if err := requirePlaintextApproval(); err != nil {
+		return "", err
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	in, format, doc, err := readDocument(path, "config.recovery_unsupported")
+	if err != nil {
+		return "", err
+	}
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(in)
+	out := strings.TrimSuffix(in, ext) + ".recovery-" + time.Now().Format("20060102-150405") + ext
+	auditRecovery("config.recovery_audit_start", in, out)
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, interruptSignals...)
+	defer signal.Stop(interrupts)
+
+	result, count, err := decryptedDocument(format, doc)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(out, 0600, func(w io.Writer) error {
+		_, err := w.Write(result)
+		return err
+	})
+	wipe(result)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case sig := <-interrupts:
+		os.Remove(out)
+		auditRecovery("config.recovery_audit_interrupted", in, out)
+		return "", fmt.Errorf("%s", t("config.recovery_interrupted", sig))
+	default:
+	}
+	auditRecovery("config.recovery_audit_done", in, out, count)
+	return out, nil
+}
+
+// WriteRecovery writes the config file at path with all passwords decrypted
+// to w, e.g. stdout, and returns the number of decrypted passwords. Like
+// DumpForRecovery it needs confirmToken to equal RecoveryConfirmation and
+// writes audit lines; XML configs are not supported.
+func WriteRecovery(w io.Writer, path, confirmToken string) (int, error) {
+	if confirmToken != RecoveryConfirmation {
+		return 0, fmt.Errorf("%s", t("config.recovery_unconfirmed"))
+	}
+	if err := requirePlaintextApproval(); err != nil {
+		return 0, err
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	in, format, doc, err := readDocument(path, "config.recovery_unsupported")
+	if err != nil {
+		return 0, err
+	}
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return 0, err
+	}
+	auditRecovery("config.recovery_audit_start", in, "-")
+	result, count, err := decryptedDocument(format, doc)
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.Write(result)
+	wipe(result)
+	if err != nil {
+		return 0, err
+	}
+	auditRecovery("config.recovery_audit_done", in, "-", count)
+	return count, nil
+}
+
+// decryptedDocument decrypts the passwords of the decoded document doc and
+// encodes it in format. It returns the encoded document and the number of
+// decrypted passwords.
+func decryptedDocument(format Format, doc map[string]interface{}) ([]byte, int, error) {
+	key, err := documentKey(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := decryptDocument(doc, key)
+	wipe(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := encodeDocument(format, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, count, nil
+}
+
+// readDocument reads and decodes the config file at path for the exports that
+// work on the document. unsupportedKey is the message for XML and unknown
+// formats, which cannot be decoded without the config struct.
+func readDocument(path, unsupportedKey string) (string, Format, map[string]interface{}, error) {
+	in, err := resolveConfigPath(path)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	content, err := os.ReadFile(in)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	format, doc, err := decodeDocument(in, content, unsupportedKey)
+	return in, format, doc, err
+}
+
+// decodeDocument decodes content, read from the file name, into a document.
+func decodeDocument(name string, content []byte, unsupportedKey string) (Format, map[string]interface{}, error) {
+	format := formatForRead(name, content)
+	if format == FormatXML || format == FormatUnknown {
+		return 0, nil, fmt.Errorf("%s", t(unsupportedKey, name, format))
+	}
+	data, err := toJSON(format, content)
+	if err != nil {
+		return 0, nil, fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return 0, nil, fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	return format, doc, nil
+}
+
+// encodeDocument encodes the decoded document doc in format.
+func encodeDocument(format Format, doc map[string]interface{}) ([]byte, error) {
+	var result []byte
+	var err error
+	if format == FormatJSON {
+		result, err = json.MarshalIndent(doc, "", "\t")
+		result = append(result, '\n')
+	} else if data, marshalErr := json.Marshal(doc); marshalErr != nil {
+		err = marshalErr
+	} else {
+		result, err = fromJSON(format, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	return result, nil
+}
+
+// documentKey returns a copy of the key the passwords of the decoded document
+// were encrypted with, following the key derivation version in its metadata.
+func documentKey(doc map[string]interface{}) ([]byte, error) {
+	fileKDF := KDFLegacyRand
+	if meta, ok := doc[metadataKey].(map[string]interface{}); ok {
+		if kdf, ok := meta["kdf"].(string); ok && kdf != "" {
+			fileKDF = KDF(kdf)
+		}
+	}
+	if current := currentKDF(); current == "" || current == fileKDF {
+		return currentKeyCopy(), nil
+	}
+	return deriveKDFKey(fileKDF)
+}
+
+// currentKeyCopy returns a copy of the current key.
+func currentKeyCopy() []byte {
+	keyMemMu.Lock()
+	defer keyMemMu.Unlock()
+	return append([]byte(nil), encryptionKey...)
+}
+
+// decryptDocument decrypts the password pairs and secure fields in all
+// objects of the decoded JSON value v with key and returns their number.
+func decryptDocument(v interface{}, key []byte) (int, error) {
+	count := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			n, err := decryptDocument(value, key)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+			plainKey, ok := secretPlainKey(v, name)
+			if !ok {
+				continue
+			}
+			secure, _ := value.(string)
+			if secure == "" {
+				continue
+			}
+			password, err := decryptWithKey(key, secure)
+			if err != nil {
+				decryptFailures.Add(1)
+				return 0, fmt.Errorf("%s", t("config.decrypt_failed", name, err))
+			}
+			v[plainKey] = password
+			v[name] = ""
+			count++
+		}
+	case []interface{}:
+		for _, value := range v {
+			n, err := decryptDocument(value, key)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+	return count, nil
+}
+
+// plainPasswordKey returns the sibling "…password" key of the
+// "…secure_password" key in obj.
+func plainPasswordKey(obj map[string]interface{}, key string) (string, bool) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(s))
+	}
+	n := normalize(key)
+	if !strings.HasSuffix(n, "securepassword") {
+		return "", false
+	}
+	want := strings.TrimSuffix(n, "securepassword") + "password"
+	for other := range obj {
+		if normalize(other) == want {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// secretPlainKey returns the key holding the plaintext of the ciphertext key
+// in obj: the "…password" sibling of a "…secure_password" key, or the secure
+// field of its shadow key.
+func secretPlainKey(obj map[string]interface{}, key string) (string, bool) {
+	if plainKey, ok := plainPasswordKey(obj, key); ok {
+		return plainKey, true
+	}
+	if base, ok := strings.CutSuffix(key, shadowSuffix); ok {
+		if _, hasBase := obj[base]; hasBase {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// auditRecovery writes one audit line about the export of in to out.
+func auditRecovery(key, in, out string, args ...interface{}) {
+	auditLog(t(key, append([]interface{}{in, out}, args...)...))
+}
+
+// auditLog writes msg as an audit line to the standard logger, which
+// SetWarningHandler cannot silence.
+func auditLog(msg string) {
+	who := "unknown"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+	host, _ := os.Hostname()
+	log.Printf("sconfig AUDIT: %s (user %s, host %s, pid %d)", msg, who, host, os.Getpid())
+}
@@ -0,0 +1,11 @@
+//go:build !plan9
+
+package sconfig
+
+import (
+	"os"
+	"syscall"
+)
+
+// interruptSignals are held back during DumpForRecovery.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
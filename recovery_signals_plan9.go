@@ -0,0 +1,8 @@
+//go:build plan9
+
+package sconfig
+
+import "os"
+
+// interruptSignals are held back during DumpForRecovery.
+var interruptSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,119 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpForRecovery(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "recover.json")
+	cfg := &NestedTestConfig{}
+	cfg.MainConfig.DatabasePassword = "main-secret"
+	cfg.SecondaryConfig.DatabasePassword = "second-secret"
+	if err := LoadConfig(cfg, 2, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	before, _ := os.ReadFile(configPath)
+
+	if _, err := DumpForRecovery(configPath, "yes"); err == nil || !contains(err.Error(), t("config.recovery_unconfirmed")) {
+		ts.Fatalf("expected confirmation error, got %v", err)
+	}
+
+	out, err := DumpForRecovery(configPath, RecoveryConfirmation)
+	if err != nil {
+		ts.Fatalf("DumpForRecovery failed: %v", err)
+	}
+	defer os.Remove(out)
+	if !strings.HasPrefix(filepath.Base(out), "recover.recovery-") || filepath.Ext(out) != ".json" {
+		ts.Errorf("unexpected dump name %q", out)
+	}
+	if info, err := os.Stat(out); err != nil {
+		ts.Fatalf("Stat failed: %v", err)
+	} else if perm := info.Mode().Perm(); perm != 0600 && filepath.Separator == '/' {
+		ts.Errorf("expected mode 0600, got %v", perm)
+	}
+	raw, _ := os.ReadFile(out)
+	var doc struct {
+		Main struct {
+			Password string `json:"database_password"`
+			Secure   string `json:"database_secure_password"`
+		} `json:"main_config"`
+		Secondary struct {
+			Password string `json:"database_password"`
+		} `json:"secondary_config"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ts.Fatalf("invalid dump: %v", err)
+	}
+	if doc.Main.Password != "main-secret" || doc.Main.Secure != "" || doc.Secondary.Password != "second-secret" {
+		ts.Errorf("passwords not decrypted:\n%s", raw)
+	}
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		ts.Error("the live config was modified")
+	}
+
+	var stream strings.Builder
+	if _, err := WriteRecovery(&stream, configPath, "yes"); err == nil || stream.Len() != 0 {
+		ts.Errorf("unconfirmed WriteRecovery = %v, wrote %d bytes", err, stream.Len())
+	}
+	if count, err := WriteRecovery(&stream, configPath, RecoveryConfirmation); err != nil || count != 2 || !strings.Contains(stream.String(), "second-secret") {
+		ts.Errorf("WriteRecovery = %d, %v:\n%s", count, err, stream.String())
+	}
+}
+
+func TestPlainPasswordKey(ts *testing.T) {
+	obj := map[string]interface{}{"db_password": "", "db_secure_password": "", "SMTPPassword": "", "smtp-secure-password": ""}
+	for key, want := range map[string]string{"db_secure_password": "db_password", "smtp-secure-password": "SMTPPassword"} {
+		if got, ok := plainPasswordKey(obj, key); !ok || got != want {
+			ts.Errorf("plainPasswordKey(%q) = %q, %v; want %q", key, got, ok, want)
+		}
+	}
+	if _, ok := plainPasswordKey(obj, "db_password"); ok {
+		ts.Error("plain key treated as secure key")
+	}
+}
+
+func TestDumpForRecovery_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "secured.json")
+	writeSecuredConfig(ts, configPath, "token-secret", hw)
+
+	var stream strings.Builder
+	count, err := WriteRecovery(&stream, configPath, RecoveryConfirmation)
+	if err != nil || count != 2 {
+		ts.Fatalf("WriteRecovery = %d, %v", count, err)
+	}
+	var doc struct {
+		APIToken string `json:"api_token"`
+		Sealed   string `json:"api_token__enc"`
+		Upstream struct {
+			Secret string `json:"secret"`
+		} `json:"upstream"`
+	}
+	if err := json.Unmarshal([]byte(stream.String()), &doc); err != nil {
+		ts.Fatalf("invalid dump: %v", err)
+	}
+	if doc.APIToken != "token-secret" || doc.Sealed != "" || doc.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("secure fields not decrypted:\n%s", stream.String())
+	}
+
+	// The dump loads like a hand-edited file.
+	out := filepath.Join(tempDir, "restored.json")
+	if err := os.WriteFile(out, []byte(stream.String()), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, out, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig of the dump failed: %v", err)
+	}
+	if cfg.APIToken != "token-secret" || cfg.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("restored values = %+v", cfg)
+	}
+}
@@ -0,0 +1,246 @@
+package sconfig
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+/*
+ * Key rotation between hardware keys.
+ *
+ * When a machine is replaced or its network card changes, the hardware ID
+ * and with it the key change, and the passwords of the existing configs can
+ * no longer be decrypted. ReencryptConfig reads a config with the key of the
+ * old hardware ID, re-encrypts all passwords with the key of the new one and
+ * writes the file atomically. Both keys are derived like in LoadConfig: the
+ * old one with the derivation version recorded in the file, the new one with
+ * the version selected by SetKDF, both with the key scope. The package key is
+ * left as it was.
+ *
+ * RekeyFile does the same on the document, without the config struct, for
+ * the CLI (sconfig rekey) and for moves from or to a key file: its keys are
+ * KeyProviders, with HardwareKey for the key of a hardware ID. It re-encrypts
+ * every "…secure_password" value and the shadow key of every secure field;
+ * plaintext passwords and markers are left as they are.
+ */
+
+// ReencryptConfig re-encrypts the passwords of the config file at path from
+// the key of the hardware ID oldKeyFunc returns to the key of the hardware ID
+// newKeyFunc returns; nil stands for the hardware ID of this machine. The
+// file is read into config, which holds the decrypted passwords afterwards,
+// as after LoadConfig. If a password cannot be decrypted with the old key, the
+// file is left unchanged. It cannot be used with a key provider.
+func ReencryptConfig(config interface{}, path string, oldKeyFunc, newKeyFunc func() (uint64, error)) error {
+	if getKeyProvider() != nil {
+		return fmt.Errorf("%s", t("config.reencrypt_key_provider"))
+	}
+	path, err := resolveConfigPath(path)
+	if err != nil {
+		return err
+	}
+	if evaluatorFor(path) != nil {
+		path = evaluatedCachePath(path)
+	}
+	configValue := reflect.ValueOf(config)
+	if configValue.Kind() != reflect.Ptr || configValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	configValue = configValue.Elem()
+	if oldKeyFunc == nil {
+		oldKeyFunc = secure_config_getHardwareID
+	}
+	if newKeyFunc == nil {
+		newKeyFunc = secure_config_getHardwareID
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf(t("config.read_failed"), err)
+	}
+	meta, err := decodeConfigFile(path, config)
+	if err != nil {
+		return err
+	}
+	fileKDF := KDFLegacyRand
+	if meta != nil && meta.KDF != "" {
+		fileKDF = KDF(meta.KDF)
+	}
+	oldKey, err := scopedHardwareKey(fileKDF, oldKeyFunc)
+	if err != nil {
+		return err
+	}
+	defer wipe(oldKey)
+	newKey, err := scopedHardwareKey(currentKDF(), newKeyFunc)
+	if err != nil {
+		return err
+	}
+
+	// Swap the new key in for encrypting and writing, like Manager.with.
+	saved := saveKeyState()
+	defer saved.restore()
+	defer wipe(newKey)
+	keyMemMu.Lock()
+	encryptionKey = newKey
+	keyMemMu.Unlock()
+	initialized = true
+
+	w := &walker{phases: phaseRekey | phaseEncrypt, oldKey: oldKey}
+	if err := w.walk(configValue); err != nil {
+		return err
+	}
+	if err := writeConfigFile(path, config, fileInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := decodePasswords(configValue); err != nil {
+		return fmt.Errorf(t("config.failed_decode_pw"), err)
+	}
+	return nil
+}
+
+// HardwareKey is the key derived from a hardware ID, as a KeyProvider for
+// RekeyFile. ID returns the hardware ID; nil stands for this machine.
+type HardwareKey struct {
+	ID func() (uint64, error)
+}
+
+// Key derives the key with the derivation version selected by SetKDF and the
+// key scope.
+func (h HardwareKey) Key() ([]byte, error) {
+	return scopedHardwareKey(selectedKDF(), h.hardwareID())
+}
+
+func (h HardwareKey) hardwareID() func() (uint64, error) {
+	if h.ID == nil {
+		return secure_config_getHardwareID
+	}
+	return h.ID
+}
+
+// RekeyFile re-encrypts the passwords of the config file at path from the key
+// of from to the key of to, e.g. from the HardwareKey of the old machine to a
+// KeyFileProvider; nil stands for the hardware key of this machine. A
+// HardwareKey as from is derived with the version recorded in the file. It
+// returns the number of re-encrypted values. If one cannot be decrypted with
+// the old key, the file is left unchanged. XML configs are not supported.
+func RekeyFile(path string, from, to KeyProvider) (int, error) {
+	if from == nil {
+		from = HardwareKey{}
+	}
+	if to == nil {
+		to = HardwareKey{}
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	in, format, doc, err := readDocument(path, "config.document_unsupported")
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(in)
+	if err != nil {
+		return 0, fmt.Errorf(t("config.read_failed"), err)
+	}
+	meta, _ := doc[metadataKey].(map[string]interface{})
+	var oldKey []byte
+	if h, ok := from.(HardwareKey); ok {
+		fileKDF := KDFLegacyRand
+		if kdf, _ := meta["kdf"].(string); kdf != "" {
+			fileKDF = KDF(kdf)
+		}
+		oldKey, err = scopedHardwareKey(fileKDF, h.hardwareID())
+	} else {
+		oldKey, err = from.Key()
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer wipe(oldKey)
+	newKey, err := to.Key()
+	if err != nil {
+		return 0, err
+	}
+
+	// Swap the new key in for encrypting, like ReencryptConfig.
+	saved := saveKeyState()
+	defer saved.restore()
+	defer wipe(newKey)
+	keyMemMu.Lock()
+	encryptionKey = newKey
+	keyMemMu.Unlock()
+	initialized = true
+
+	count, err := rekeyDocument(doc, oldKey)
+	if err != nil {
+		return 0, err
+	}
+	if meta != nil {
+		meta["key"] = fingerprintKey(newKey)
+		delete(meta, "kdf")
+		if _, ok := to.(HardwareKey); ok {
+			meta["kdf"] = string(selectedKDF())
+		}
+		delete(meta, "passphrase")
+		delete(meta, "salt")
+	}
+	content, err := encodeDocument(format, doc)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomic(in, info.Mode().Perm(), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// rekeyDocument decrypts the ciphertexts in all objects of the decoded JSON
+// value v with oldKey, encrypts them with the current key and returns their
+// number.
+func rekeyDocument(v interface{}, oldKey []byte) (int, error) {
+	count := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			n, err := rekeyDocument(value, oldKey)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+			sealed, _ := value.(string)
+			if sealed == "" {
+				continue
+			}
+			if _, ok := plainPasswordKey(v, name); !ok {
+				base, isShadow := strings.CutSuffix(name, shadowSuffix)
+				if _, hasBase := v[base]; !isShadow || !hasBase {
+					continue
+				}
+			}
+			plaintext, err := decryptWithKey(oldKey, sealed)
+			if err != nil {
+				decryptFailures.Add(1)
+				return 0, fmt.Errorf("%s", t("config.decrypt_failed", name, err))
+			}
+			if v[name], err = encrypt(plaintext); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	case []interface{}:
+		for _, value := range v {
+			n, err := rekeyDocument(value, oldKey)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,101 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReencryptConfig(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	oldMachine := func() (uint64, error) { return 4711, nil }
+	newMachine := func() (uint64, error) { return 9999, nil }
+	configPath := filepath.Join(tempDir, "reencrypt.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "moved-secret"}, 1, configPath, false, false, oldMachine); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	before, _ := os.ReadFile(configPath)
+	packageKey := keyFingerprint()
+
+	// The wrong old key leaves the file alone.
+	if err := ReencryptConfig(&TestConfig{}, configPath, newMachine, oldMachine); err == nil {
+		ts.Fatal("ReencryptConfig with the wrong old key succeeded")
+	}
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		ts.Fatal("file changed after a failed re-encryption")
+	}
+
+	cfg := &TestConfig{}
+	if err := ReencryptConfig(cfg, configPath, oldMachine, newMachine); err != nil {
+		ts.Fatalf("ReencryptConfig failed: %v", err)
+	}
+	if cfg.DatabasePassword != "moved-secret" {
+		ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+	}
+	if keyFingerprint() != packageKey {
+		ts.Error("ReencryptConfig changed the package key")
+	}
+	after, _ := os.ReadFile(configPath)
+	if string(after) == string(before) || strings.Contains(string(after), "moved-secret") {
+		ts.Fatalf("file not re-encrypted:\n%s", after)
+	}
+
+	// The new machine reads the file, the old one no longer can.
+	invalidateKey()
+	cfg = &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, newMachine); err != nil || cfg.DatabasePassword != "moved-secret" {
+		ts.Fatalf("LoadConfig on the new machine: %v, %q", err, cfg.DatabasePassword)
+	}
+	invalidateKey()
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, oldMachine); err == nil {
+		ts.Error("the old key still decrypts the file")
+	}
+}
+
+func TestRekeyFile(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	defer SetKeyProvider(nil)
+	oldMachine := func() (uint64, error) { return 4711, nil }
+	newMachine := func() (uint64, error) { return 9999, nil }
+	configPath := filepath.Join(tempDir, "rekey.json")
+	if err := LoadConfig(&rewriteTestConfig{Host: "db", Token: "moved-token"}, 1, configPath, false, false, oldMachine); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	before, _ := os.ReadFile(configPath)
+
+	if _, err := RekeyFile(configPath, HardwareKey{ID: newMachine}, nil); err == nil {
+		ts.Fatal("RekeyFile with the wrong old key succeeded")
+	}
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		ts.Fatal("file changed after a failed rekey")
+	}
+
+	// Old machine -> key file -> new machine.
+	keyFile := &KeyFileProvider{Path: filepath.Join(tempDir, "rekey.key")}
+	if count, err := RekeyFile(configPath, HardwareKey{ID: oldMachine}, keyFile); err != nil || count != 1 {
+		ts.Fatalf("RekeyFile to the key file = %d, %v", count, err)
+	}
+	invalidateKey()
+	SetKeyProvider(keyFile)
+	cfg := &rewriteTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil || cfg.Token != "moved-token" {
+		ts.Fatalf("LoadConfig with the key file: %v, %q", err, cfg.Token)
+	}
+	SetKeyProvider(nil)
+	if count, err := RekeyFile(configPath, keyFile, HardwareKey{ID: newMachine}); err != nil || count != 1 {
+		ts.Fatalf("RekeyFile to the new machine = %d, %v", count, err)
+	}
+	invalidateKey()
+	cfg = &rewriteTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, newMachine); err != nil || cfg.Token != "moved-token" {
+		ts.Fatalf("LoadConfig on the new machine: %v, %q", err, cfg.Token)
+	}
+	if raw, _ := os.ReadFile(configPath); strings.Contains(string(raw), "moved-token") {
+		ts.Errorf("token written in plaintext:\n%s", raw)
+	}
+}
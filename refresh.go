@@ -0,0 +1,117 @@
+package sconfig
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Periodic re-read.
+ *
+ * Services that want eventual consistency without a file watcher create the
+ * Loader with WithAutoRefresh. After the first Load a goroutine re-reads the
+ * file every interval into a fresh struct and publishes it as the new
+ * snapshot; readers call Snapshot() and never see a half-applied config. The
+ * struct passed to NewLoader is not touched by the refresh, so the application
+ * can keep modifying and saving it. A failed re-read is reported through the
+ * warning handler and keeps the previous snapshot.
+ */
+
+// refresher runs the periodic re-read of one Loader.
+type refresher struct {
+	interval time.Duration
+	snapshot atomic.Value // latest config, same type as Loader.config
+	start    sync.Once
+	stop     sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+	notify   []func(snapshot interface{}) // called for every new snapshot (WithStore)
+}
+
+// WithAutoRefresh makes the Loader re-read its file every interval after the
+// first successful Load. The result is available through Snapshot.
+func WithAutoRefresh(interval time.Duration) LoaderOption {
+	return func(l *Loader) {
+		l.refresh.interval = interval
+	}
+}
+
+func newRefresher() *refresher {
+	return &refresher{stopCh: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Snapshot returns the most recently loaded config, a deep copy of the type
+// passed to NewLoader that is safe to read from any goroutine and must not be
+// modified. It is nil before the first Load. Snapshot does not lock and never
+// waits for a running re-read.
+func (l *Loader) Snapshot() interface{} {
+	return l.refresh.snapshot.Load()
+}
+
+// publish stores a deep copy of the config as the current snapshot and starts
+// the refresh goroutine once. Called with l.mu held.
+func (l *Loader) publish() {
+	r := l.refresh
+	r.store(deepCopy(reflect.ValueOf(l.config)).Interface())
+	if r.interval > 0 {
+		r.start.Do(func() { go l.refreshLoop(r) })
+	}
+}
+
+func (l *Loader) refreshLoop(r *refresher) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			l.reload(r)
+		}
+	}
+}
+
+// reload reads the file into a fresh struct and publishes it.
+func (l *Loader) reload(r *refresher) {
+	fresh := reflect.New(reflect.TypeOf(l.config).Elem()).Interface()
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	err := l.loadInto(fresh)
+	if err == nil {
+		l.loadedAt = time.Now()
+	}
+	l.mu.Unlock()
+	// The snapshot is never written back; its bindings are not needed.
+	forgetSecretRefs(fresh)
+	forgetOverrides(fresh)
+	setNamespace(fresh, "")
+	forgetLoadedFile(fresh)
+	if err != nil {
+		warn(t("config.refresh_failed", l.path, err))
+		return
+	}
+	r.store(fresh)
+}
+
+// store publishes a new snapshot.
+func (r *refresher) store(snapshot interface{}) {
+	r.snapshot.Store(snapshot)
+	for _, fn := range r.notify {
+		fn(snapshot)
+	}
+}
+
+// stopRefresh stops the refresh goroutine, if any, and waits for it. It must
+// not be called with l.mu held.
+func (l *Loader) stopRefresh() {
+	r := l.refresh
+	r.stop.Do(func() { close(r.stopCh) })
+	r.start.Do(func() { close(r.done) }) // never started: nothing to wait for
+	<-r.done
+}
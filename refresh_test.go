@@ -0,0 +1,82 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoader_AutoRefresh(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "refresh.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "first"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &TestConfig{}
+	l := NewLoader(cfg, configPath, 1, WithAutoRefresh(10*time.Millisecond))
+	defer l.Close()
+	if l.Snapshot() != nil {
+		ts.Error("expected no snapshot before Load")
+	}
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	first := l.Snapshot().(*TestConfig)
+	if first == cfg || first.DatabaseHost != "first" {
+		ts.Fatalf("unexpected first snapshot: %+v", first)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "second"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Snapshot().(*TestConfig).DatabaseHost != "second" {
+		if time.Now().After(deadline) {
+			ts.Fatal("snapshot was not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cfg.DatabaseHost != "first" || first.DatabaseHost != "first" {
+		ts.Errorf("refresh modified the loaded struct or an old snapshot: %q / %q", cfg.DatabaseHost, first.DatabaseHost)
+	}
+
+	// A broken file keeps the previous snapshot and issues a warning.
+	warnings := make(chan string, 16)
+	SetWarningHandler(func(msg string) {
+		select {
+		case warnings <- msg:
+		default:
+		}
+	})
+	defer SetWarningHandler(defaultWarningHandler)
+	if err := os.WriteFile(configPath, []byte(`{"database_host": `), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	select {
+	case <-warnings:
+	case <-time.After(5 * time.Second):
+		ts.Fatal("expected a warning for the broken file")
+	}
+	if host := l.Snapshot().(*TestConfig).DatabaseHost; host != "second" {
+		ts.Errorf("expected previous snapshot to stay, got %q", host)
+	}
+
+	if err := l.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestLoader_SnapshotWithoutRefresh(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	cfg := &TestConfig{}
+	l := NewLoader(cfg, filepath.Join(tempDir, "snapshot.json"), 1)
+	defer l.Close()
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	s, ok := l.Snapshot().(*TestConfig)
+	if !ok || s == cfg || s.DatabaseHost != "localhost" {
+		ts.Errorf("unexpected snapshot: %+v", s)
+	}
+}
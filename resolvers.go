@@ -0,0 +1,250 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ * Password manager resolvers for secret references.
+ *
+ * Small teams often keep their credentials in a password manager already.
+ * These resolvers talk to the manager's local REST API with net/http only,
+ * so no SDK is pulled in:
+ *
+ *   - OnePasswordConnect: a 1Password Connect server,
+ *     references "<vault>/<item>#<field>"
+ *   - BitwardenServe: the REST API of `bw serve` (Bitwarden and Vaultwarden),
+ *     references "<item>#<field>"
+ *
+ * Vaults and items may be given by ID or by name/title; <field> defaults to
+ * "password". Register them under a scheme of your choice, e.g.
+ *
+ *	sconfig.RegisterSecretResolver("op", &sconfig.OnePasswordConnect{Host: host, Token: token})
+ *	sconfig.RegisterSecretResolver("bw", &sconfig.BitwardenServe{URL: "http://localhost:8087"})
+ */
+
+// resolverTimeout bounds a single resolver request when no Client is set.
+const resolverTimeout = 10 * time.Second
+
+// splitItemRef splits "<item>#<field>" and defaults the field to "password".
+func splitItemRef(ref string) (item, field string) {
+	item, field, found := strings.Cut(ref, "#")
+	if !found || field == "" {
+		field = "password"
+	}
+	return item, field
+}
+
+// getJSON performs an authenticated GET and decodes the JSON response into out.
+func getJSON(client *http.Client, rawURL, token string, out interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: resolverTimeout}
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+/*
+ * 1Password Connect
+ */
+
+// OnePasswordConnect resolves "<vault>/<item>#<field>" references against a
+// 1Password Connect server. <field> matches the label or ID of an item field.
+type OnePasswordConnect struct {
+	Host   string       // base URL of the Connect server, e.g. "http://localhost:8080"
+	Token  string       // Connect access token
+	Client *http.Client // optional; a client with a 10s timeout is used if nil
+}
+
+// onePasswordID matches the 26 character IDs of vaults and items.
+var onePasswordID = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+type onePasswordObject struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+type onePasswordItem struct {
+	Fields []struct {
+		ID      string `json:"id"`
+		Label   string `json:"label"`
+		Purpose string `json:"purpose"`
+		Value   string `json:"value"`
+	} `json:"fields"`
+}
+
+// Resolve returns the value of the requested item field.
+func (c *OnePasswordConnect) Resolve(ref string) (string, error) {
+	path, field := splitItemRef(ref)
+	vault, item, found := strings.Cut(path, "/")
+	if !found || vault == "" || item == "" {
+		return "", fmt.Errorf("1password: reference %q is not <vault>/<item>#<field>", ref)
+	}
+	base := strings.TrimRight(c.Host, "/") + "/v1/vaults"
+	vaultID, err := c.lookupID(base, "name", vault)
+	if err != nil {
+		return "", err
+	}
+	itemsURL := base + "/" + url.PathEscape(vaultID) + "/items"
+	itemID, err := c.lookupID(itemsURL, "title", item)
+	if err != nil {
+		return "", err
+	}
+	var details onePasswordItem
+	if err := getJSON(c.Client, itemsURL+"/"+url.PathEscape(itemID), c.Token, &details); err != nil {
+		return "", fmt.Errorf("1password: %w", err)
+	}
+	for _, f := range details.Fields {
+		if strings.EqualFold(f.Label, field) || f.ID == field || strings.EqualFold(f.Purpose, field) {
+			return f.Value, nil
+		}
+	}
+	return "", fmt.Errorf("1password: item %q has no field %q", item, field)
+}
+
+// lookupID returns nameOrID if it already is an ID, else the ID of the only
+// object in listURL whose attribute equals nameOrID.
+func (c *OnePasswordConnect) lookupID(listURL, attribute, nameOrID string) (string, error) {
+	if onePasswordID.MatchString(nameOrID) {
+		return nameOrID, nil
+	}
+	filter := fmt.Sprintf("%s eq %q", attribute, nameOrID)
+	var objects []onePasswordObject
+	if err := getJSON(c.Client, listURL+"?filter="+url.QueryEscape(filter), c.Token, &objects); err != nil {
+		return "", fmt.Errorf("1password: %w", err)
+	}
+	switch len(objects) {
+	case 0:
+		return "", fmt.Errorf("1password: %s %q not found", attribute, nameOrID)
+	case 1:
+		return objects[0].ID, nil
+	}
+	return "", fmt.Errorf("1password: %s %q is ambiguous", attribute, nameOrID)
+}
+
+/*
+ * Bitwarden / Vaultwarden (bw serve)
+ */
+
+// BitwardenServe resolves "<item>#<field>" references through the REST API of
+// `bw serve`, which works with Bitwarden and Vaultwarden servers. <field> is
+// "password", "username", "notes" or the name of a custom field.
+type BitwardenServe struct {
+	URL    string       // base URL of bw serve, e.g. "http://localhost:8087"
+	Client *http.Client // optional; a client with a 10s timeout is used if nil
+}
+
+type bitwardenItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Notes string `json:"notes"`
+	Login *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+type bitwardenResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Resolve returns the value of the requested item field.
+func (b *BitwardenServe) Resolve(ref string) (string, error) {
+	name, field := splitItemRef(ref)
+	if name == "" {
+		return "", fmt.Errorf("bitwarden: reference %q is not <item>#<field>", ref)
+	}
+	item, err := b.findItem(name)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(field) {
+	case "password":
+		if item.Login != nil {
+			return item.Login.Password, nil
+		}
+	case "username":
+		if item.Login != nil {
+			return item.Login.Username, nil
+		}
+	case "notes":
+		return item.Notes, nil
+	}
+	for _, f := range item.Fields {
+		if f.Name == field {
+			return f.Value, nil
+		}
+	}
+	return "", fmt.Errorf("bitwarden: item %q has no field %q", name, field)
+}
+
+// findItem fetches the item by ID, else searches for an item with this exact name.
+func (b *BitwardenServe) findItem(nameOrID string) (*bitwardenItem, error) {
+	base := strings.TrimRight(b.URL, "/")
+	var resp bitwardenResponse
+	err := getJSON(b.Client, base+"/object/item/"+url.PathEscape(nameOrID), "", &resp)
+	if err == nil && resp.Success {
+		var item bitwardenItem
+		if err := json.Unmarshal(resp.Data, &item); err != nil {
+			return nil, fmt.Errorf("bitwarden: %w", err)
+		}
+		return &item, nil
+	}
+	resp = bitwardenResponse{}
+	if err := getJSON(b.Client, base+"/list/object/items?search="+url.QueryEscape(nameOrID), "", &resp); err != nil {
+		return nil, fmt.Errorf("bitwarden: %w", err)
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("bitwarden: %w", errors.New(resp.Message))
+	}
+	var list struct {
+		Data []bitwardenItem `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Data, &list); err != nil {
+		return nil, fmt.Errorf("bitwarden: %w", err)
+	}
+	// search is a substring match; only an exact name counts
+	var match *bitwardenItem
+	for i := range list.Data {
+		if list.Data[i].Name != nameOrID {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("bitwarden: item %q is ambiguous", nameOrID)
+		}
+		match = &list.Data[i]
+	}
+	if match == nil {
+		return nil, fmt.Errorf("bitwarden: item %q not found", nameOrID)
+	}
+	return match, nil
+}
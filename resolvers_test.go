@@ -0,0 +1,105 @@
+package sconfig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOnePasswordConnect(ts *testing.T) {
+	const vaultID, itemID = "vvvvvvvvvvvvvvvvvvvvvvvvvv", "iiiiiiiiiiiiiiiiiiiiiiiiii"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer op-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/vaults":
+			if r.URL.Query().Get("filter") == `name eq "Production"` {
+				w.Write([]byte(`[{"id": "` + vaultID + `", "name": "Production"}]`))
+				return
+			}
+			w.Write([]byte(`[]`))
+		case "/v1/vaults/" + vaultID + "/items":
+			if r.URL.Query().Get("filter") == `title eq "Database"` {
+				w.Write([]byte(`[{"id": "` + itemID + `", "title": "Database"}]`))
+				return
+			}
+			w.Write([]byte(`[]`))
+		case "/v1/vaults/" + vaultID + "/items/" + itemID:
+			w.Write([]byte(`{"fields": [
+				{"id": "username", "label": "username", "purpose": "USERNAME", "value": "dbuser"},
+				{"id": "password", "label": "password", "purpose": "PASSWORD", "value": "op-secret"},
+				{"id": "abc", "label": "API Key", "value": "op-api"}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	op := &OnePasswordConnect{Host: server.URL, Token: "op-token"}
+	cases := map[string]string{
+		"Production/Database":                "op-secret",
+		"Production/Database#username":       "dbuser",
+		"Production/Database#api key":        "op-api",
+		vaultID + "/" + itemID + "#password": "op-secret",
+		"Production/" + itemID + "#PASSWORD": "op-secret",
+	}
+	for ref, want := range cases {
+		got, err := op.Resolve(ref)
+		if err != nil || got != want {
+			ts.Errorf("Resolve(%q) = %q, %v; want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"Database", "Staging/Database", "Production/Other", "Production/Database#missing"} {
+		if _, err := op.Resolve(ref); err == nil {
+			ts.Errorf("Resolve(%q): expected error", ref)
+		}
+	}
+	if _, err := (&OnePasswordConnect{Host: server.URL, Token: "wrong"}).Resolve("Production/Database"); err == nil {
+		ts.Error("expected error for wrong token")
+	}
+}
+
+func TestBitwardenServe(ts *testing.T) {
+	item := `{"id": "0f5e", "name": "Database", "notes": "db notes",
+		"login": {"username": "dbuser", "password": "bw-secret"},
+		"fields": [{"name": "api_key", "value": "bw-api"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/object/item/0f5e":
+			w.Write([]byte(`{"success": true, "data": ` + item + `}`))
+		case r.URL.Path == "/list/object/items" && r.URL.Query().Get("search") == "Database":
+			w.Write([]byte(`{"success": true, "data": {"object": "list", "data": [` + item +
+				`, {"id": "1a2b", "name": "Database backup"}]}}`))
+		case r.URL.Path == "/list/object/items":
+			w.Write([]byte(`{"success": true, "data": {"object": "list", "data": []}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success": false, "message": "Not found."}`))
+		}
+	}))
+	defer server.Close()
+
+	bw := &BitwardenServe{URL: server.URL}
+	cases := map[string]string{
+		"Database":         "bw-secret",
+		"0f5e#username":    "dbuser",
+		"Database#notes":   "db notes",
+		"Database#api_key": "bw-api",
+	}
+	for ref, want := range cases {
+		got, err := bw.Resolve(ref)
+		if err != nil || got != want {
+			ts.Errorf("Resolve(%q) = %q, %v; want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"Missing", "Database#missing", "#password"} {
+		if _, err := bw.Resolve(ref); err == nil {
+			ts.Errorf("Resolve(%q): expected error", ref)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Preserving rewrites.
+ *
+ * A rewrite (the write-back of LoadConfig, UpdateConfig, ReencryptConfig)
+ * serializes the struct. Written as is, that would drop the comments of a
+ * JSONC file, its formatting and key order, and every key the struct does
+ * not know: a setting of a newer release, a key for another tool, a note.
+ * So the serialized document is patched into the file instead:
+ *
+ *   - values that did not change keep their text, comments included,
+ *   - changed values are replaced where they are, indented like the file,
+ *   - keys the struct has but the file lacks (the metadata block, a new
+ *     field, the shadow key of a secure field) are inserted after the key
+ *     that precedes them in the struct,
+ *   - keys of the struct that are no longer written (an omitempty field now
+ *     empty, a deleted map entry, an emptied shadow key, a key spelled in
+ *     another case) are removed,
+ *   - keys the struct does not know are kept.
+ *
+ * Arrays of another length are replaced as a whole; objects are compared key
+ * by key at every depth. Maps, interface{} values and types with their own
+ * UnmarshalJSON have no unknown keys: their content is what the struct holds.
+ *
+ * JSON files are patched as text. YAML and TOML files are patched as JSON and
+ * transcoded, which keeps their unknown keys but not their comments. XML files
+ * are written from the struct. If the file cannot be read or parsed, the
+ * serialized document is written as before.
+ */
+
+// keepUnknown returns the JSON document updated, the serialization of a value
+// of type t, patched into current, the content of the file at path. For a
+// file in another format than JSON the result is strict JSON, to be
+// transcoded to format.
+func keepUnknown(path string, format Format, current, updated []byte, t reflect.Type) []byte {
+	original := current
+	currentFormat := formatForRead(path, current)
+	if currentFormat != FormatJSON || format != FormatJSON {
+		if currentFormat == FormatXML {
+			return updated
+		}
+		var err error
+		if original, err = toJSON(currentFormat, current); err != nil {
+			return updated
+		}
+	}
+	patched, err := patchJSON(original, updated, t)
+	if err != nil {
+		return updated
+	}
+	if currentFormat != FormatJSON && format == FormatJSON {
+		var buf bytes.Buffer
+		if json.Indent(&buf, patched, "", "\t") != nil {
+			return updated
+		}
+		buf.WriteByte('\n')
+		patched = buf.Bytes()
+	}
+	return patched
+}
+
+// readForRewrite returns the content of the file at path that a rewrite
+// replaces, or nil if there is none.
+func readForRewrite(path string) []byte {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+var errPatchInvalid = errors.New("patched document is not valid JSON")
+
+// patchJSON patches the JSON document updated into the JSON or JSONC
+// document original as described above; t is the type updated was
+// serialized from.
+func patchJSON(original, updated []byte, t reflect.Type) ([]byte, error) {
+	o, err := parseJSONSpans(original)
+	if err != nil {
+		return nil, err
+	}
+	u, err := parseJSONSpans(updated)
+	if err != nil {
+		return nil, err
+	}
+	p := &jsonPatch{orig: original, upd: updated, unit: "\t", compact: !bytes.ContainsRune(original, '\n')}
+	if o.kind == '{' && len(o.members) > 0 {
+		indent := lineIndent(original, o.members[0].keyStart)
+		if unit, ok := strings.CutPrefix(indent, lineIndent(original, o.start)); ok && unit != "" && !p.sameLine(o.start, o.members[0].keyStart) {
+			p.unit = unit
+		}
+	}
+	p.value(o, u, t)
+	out := p.apply()
+	if !json.Valid(stripJSONC(out)) {
+		return nil, errPatchInvalid
+	}
+	if p.compact {
+		// A file in one line was written by a program, not formatted by
+		// hand: it gets the layout of a new file.
+		var buf bytes.Buffer
+		if json.Indent(&buf, out, "", "\t") == nil {
+			buf.WriteByte('\n')
+			out = buf.Bytes()
+		}
+	}
+	return out, nil
+}
+
+// jsonNode is a JSON value with its position in the document.
+type jsonNode struct {
+	kind       byte // '{', '[', '"', or 0 for numbers, true, false and null
+	start, end int
+	members    []jsonMember // of an object
+	elems      []*jsonNode  // of an array
+}
+
+type jsonMember struct {
+	key              string
+	keyStart, keyEnd int
+	value            *jsonNode
+}
+
+// parseJSONSpans parses a JSON document, which may have comments and trailing
+// commas, into its values and their positions.
+func parseJSONSpans(data []byte) (*jsonNode, error) {
+	s := &spanParser{data: data}
+	node, err := s.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.skip(); err != nil {
+		return nil, err
+	}
+	if s.pos != len(data) {
+		return nil, s.syntaxError()
+	}
+	return node, nil
+}
+
+type spanParser struct {
+	data []byte
+	pos  int
+}
+
+func (s *spanParser) syntaxError() error {
+	return errors.New("invalid JSON at offset " + strconv.Itoa(s.pos))
+}
+
+// skip skips blanks and comments.
+func (s *spanParser) skip() error {
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case isJSONSpace(c):
+			s.pos++
+		case c == '/' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '/':
+			end := bytes.IndexByte(s.data[s.pos:], '\n')
+			if end < 0 {
+				s.pos = len(s.data)
+			} else {
+				s.pos += end + 1
+			}
+		case c == '/' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '*':
+			end := bytes.Index(s.data[s.pos+2:], []byte("*/"))
+			if end < 0 {
+				return s.syntaxError()
+			}
+			s.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *spanParser) value() (*jsonNode, error) {
+	if err := s.skip(); err != nil {
+		return nil, err
+	}
+	if s.pos == len(s.data) {
+		return nil, s.syntaxError()
+	}
+	node := &jsonNode{start: s.pos}
+	switch c := s.data[s.pos]; c {
+	case '{', '[':
+		node.kind = c
+		s.pos++
+		for {
+			if err := s.skip(); err != nil {
+				return nil, err
+			}
+			if s.pos < len(s.data) && s.data[s.pos] == c+2 { // '}' or ']'
+				s.pos++
+				break
+			}
+			if c == '{' {
+				member, err := s.member()
+				if err != nil {
+					return nil, err
+				}
+				node.members = append(node.members, member)
+			} else {
+				elem, err := s.value()
+				if err != nil {
+					return nil, err
+				}
+				node.elems = append(node.elems, elem)
+			}
+			if err := s.skip(); err != nil {
+				return nil, err
+			}
+			if s.pos < len(s.data) && s.data[s.pos] == ',' {
+				s.pos++
+			} else if s.pos == len(s.data) || s.data[s.pos] != c+2 {
+				return nil, s.syntaxError()
+			}
+		}
+	case '"':
+		node.kind = '"'
+		if err := s.str(); err != nil {
+			return nil, err
+		}
+	default:
+		for s.pos < len(s.data) && !isJSONSpace(s.data[s.pos]) && !strings.ContainsRune(",]}/", rune(s.data[s.pos])) {
+			s.pos++
+		}
+		if s.pos == node.start {
+			return nil, s.syntaxError()
+		}
+	}
+	node.end = s.pos
+	return node, nil
+}
+
+func (s *spanParser) member() (jsonMember, error) {
+	if s.pos == len(s.data) || s.data[s.pos] != '"' {
+		return jsonMember{}, s.syntaxError()
+	}
+	m := jsonMember{keyStart: s.pos}
+	if err := s.str(); err != nil {
+		return m, err
+	}
+	m.keyEnd = s.pos
+	raw := s.data[m.keyStart:m.keyEnd]
+	if bytes.IndexByte(raw, '\\') < 0 {
+		m.key = string(raw[1 : len(raw)-1])
+	} else if err := json.Unmarshal(raw, &m.key); err != nil {
+		return m, err
+	}
+	if err := s.skip(); err != nil {
+		return m, err
+	}
+	if s.pos == len(s.data) || s.data[s.pos] != ':' {
+		return m, s.syntaxError()
+	}
+	s.pos++
+	value, err := s.value()
+	m.value = value
+	return m, err
+}
+
+// str skips the string starting at the current position.
+func (s *spanParser) str() error {
+	for i := s.pos + 1; i < len(s.data); i++ {
+		switch s.data[i] {
+		case '\\':
+			i++
+		case '"':
+			s.pos = i + 1
+			return nil
+		}
+	}
+	return s.syntaxError()
+}
+
+// jsonPatch collects the edits that turn orig into upd.
+type jsonPatch struct {
+	orig, upd []byte
+	unit      string // indentation per level of orig
+	compact   bool   // orig is written in one line
+	edits     []jsonEdit
+}
+
+// jsonEdit replaces orig[start:end] by text.
+type jsonEdit struct {
+	start, end int
+	text       string
+}
+
+// value patches the value u of type t into o.
+func (p *jsonPatch) value(o, u *jsonNode, t reflect.Type) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case o.kind == '{' && u.kind == '{':
+		p.object(o, u, t)
+	case o.kind == '[' && u.kind == '[' && len(o.elems) == len(u.elems):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i := range o.elems {
+			p.value(o.elems[i], u.elems[i], elem)
+		}
+	case !p.same(o, u):
+		p.edits = append(p.edits, jsonEdit{o.start, o.end, p.format(u, lineIndent(p.orig, o.start), p.compact)})
+	}
+}
+
+// same reports whether the scalars o and u are equal.
+func (p *jsonPatch) same(o, u *jsonNode) bool {
+	a, b := p.orig[o.start:o.end], p.upd[u.start:u.end]
+	if o.kind != u.kind || o.kind == '{' || o.kind == '[' {
+		return false
+	}
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if o.kind == '"' {
+		var x, y string
+		return json.Unmarshal(a, &x) == nil && json.Unmarshal(b, &y) == nil && x == y
+	}
+	x, errX := strconv.ParseFloat(string(a), 64)
+	y, errY := strconv.ParseFloat(string(b), 64)
+	return errX == nil && errY == nil && x == y
+}
+
+func (p *jsonPatch) object(o, u *jsonNode, t reflect.Type) {
+	inUpdated := make(map[string]bool, len(u.members))
+	for _, m := range u.members {
+		inUpdated[m.key] = true
+	}
+	index := make(map[string]int, len(o.members))
+	deleted := make([]bool, len(o.members))
+	first := -1 // first member kept
+	for i, m := range o.members {
+		index[m.key] = i
+		deleted[i] = !inUpdated[m.key] && knownKey(t, m.key)
+		if first < 0 && !deleted[i] {
+			first = i
+		}
+	}
+	if first < 0 {
+		if len(o.members) > 0 || len(u.members) > 0 {
+			p.edits = append(p.edits, jsonEdit{o.start, o.end, p.format(u, lineIndent(p.orig, o.start), p.compact)})
+		}
+		return
+	}
+	if first > 0 {
+		p.edits = append(p.edits, jsonEdit{o.members[0].keyStart, o.members[first].keyStart, ""})
+	}
+	for i := first + 1; i < len(o.members); i++ {
+		if deleted[i] {
+			p.edits = append(p.edits, jsonEdit{o.members[i-1].value.end, o.members[i].value.end, ""})
+		}
+	}
+
+	sep, indent, compact := " ", "", true
+	if !p.sameLine(o.start, o.members[first].keyStart) {
+		indent = lineIndent(p.orig, o.members[first].keyStart)
+		sep, compact = "\n"+indent, false
+	}
+	anchor := -1 // the member of o new members are inserted after
+	var inserted []string
+	insert := func() {
+		if len(inserted) == 0 {
+			return
+		}
+		var text strings.Builder
+		switch {
+		case anchor < 0:
+			for _, member := range inserted {
+				if compact {
+					text.WriteString(member + "," + sep)
+				} else {
+					text.WriteString(sep + member + ",")
+				}
+			}
+			p.edits = append(p.edits, jsonEdit{o.start + 1, o.start + 1, text.String()})
+		case compact || anchor+1 < len(o.members) && deleted[anchor+1]:
+			for _, member := range inserted {
+				text.WriteString("," + sep + member)
+			}
+			at := o.members[anchor].value.end
+			p.edits = append(p.edits, jsonEdit{at, at, text.String()})
+		default:
+			// The comma and a comment after the anchor stay on its line.
+			end := o.members[anchor].value.end
+			eol, comma := p.lineTail(end)
+			if !comma {
+				p.edits = append(p.edits, jsonEdit{end, end, ","})
+			}
+			for i, member := range inserted {
+				text.WriteString(sep + member)
+				if comma || i < len(inserted)-1 {
+					text.WriteByte(',')
+				}
+			}
+			p.edits = append(p.edits, jsonEdit{eol, eol, text.String()})
+		}
+		inserted = inserted[:0]
+	}
+	for _, m := range u.members {
+		i, ok := index[m.key]
+		if !ok {
+			inserted = append(inserted, string(p.upd[m.keyStart:m.keyEnd])+": "+p.format(m.value, indent, compact))
+			continue
+		}
+		insert()
+		anchor = i
+		p.value(o.members[i].value, m.value, fieldType(t, m.key))
+	}
+	insert()
+}
+
+// format returns the text of the value u of upd for a line indented by
+// indent, or in one line.
+func (p *jsonPatch) format(u *jsonNode, indent string, compact bool) string {
+	raw := p.upd[u.start:u.end]
+	if u.kind != '{' && u.kind != '[' {
+		return string(raw)
+	}
+	var buf bytes.Buffer
+	var err error
+	if compact {
+		err = json.Compact(&buf, raw)
+	} else {
+		err = json.Indent(&buf, raw, indent, p.unit)
+	}
+	if err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// sameLine reports whether no line break lies between the offsets from and to
+// of orig.
+func (p *jsonPatch) sameLine(from, to int) bool {
+	return bytes.IndexByte(p.orig[from:to], '\n') < 0
+}
+
+// lineTail returns the end of the line of orig that a value ends on at the
+// offset end, before the line break, and whether a comma follows the value.
+// Only blanks, the comma and comments may follow it on the line; otherwise
+// end is returned.
+func (p *jsonPatch) lineTail(end int) (int, bool) {
+	s := &spanParser{data: p.orig, pos: end}
+	comma := false
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case c == '\n' || c == '\r':
+			return s.pos, comma
+		case c == ' ' || c == '\t':
+			s.pos++
+		case c == ',' && !comma:
+			comma = true
+			s.pos++
+		case c == '/' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '/':
+			eol := bytes.IndexByte(s.data[s.pos:], '\n')
+			if eol < 0 {
+				return end, false
+			}
+			s.pos += eol
+			if s.data[s.pos-1] == '\r' {
+				s.pos--
+			}
+		case c == '/' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '*':
+			// Not skip: it would also pass the line break after the comment.
+			close := bytes.Index(s.data[s.pos+2:], []byte("*/"))
+			if close < 0 || !p.sameLine(s.pos, s.pos+2+close) {
+				return end, false
+			}
+			s.pos += close + 4
+		default:
+			return end, false
+		}
+	}
+	return end, false
+}
+
+// apply returns orig with the edits applied.
+func (p *jsonPatch) apply() []byte {
+	// Insertions go before a removal starting at the same offset.
+	sort.SliceStable(p.edits, func(i, j int) bool {
+		a, b := p.edits[i], p.edits[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.end == a.start && b.end != b.start
+	})
+	var out bytes.Buffer
+	out.Grow(len(p.orig))
+	pos := 0
+	for _, e := range p.edits {
+		out.Write(p.orig[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(p.orig[pos:])
+	return out.Bytes()
+}
+
+// lineIndent returns the blanks at the start of the line of data containing
+// the offset pos.
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < pos && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// knownKey reports whether key is written by the serialization of a value of
+// type t whenever the value holds it, so that a key missing from the
+// serialization is to be removed. Only the unknown keys of structs are kept.
+func knownKey(t reflect.Type, key string) bool {
+	if t == nil || t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return true
+	}
+	if key == metadataKey {
+		return true
+	}
+	if _, ok := jsonField(t, key); ok {
+		return true
+	}
+	base, ok := strings.CutSuffix(key, shadowSuffix)
+	return ok && isSecureField(t, base)
+}
+
+// fieldType returns the type of the value of key in a value of type t, nil if
+// unknown.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		if field, ok := jsonField(t, key); ok {
+			return field.Type
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type rewriteTestConfig struct {
+	Version int               `json:"version"`
+	Host    string            `json:"host"`
+	Port    int               `json:"port,omitempty"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Token   string            `json:"token" secure:"true"`
+}
+
+func TestPatchJSON(ts *testing.T) {
+	typ := reflect.TypeOf(rewriteTestConfig{})
+	cases := []struct {
+		name, original, updated, want string
+	}{
+		{
+			name:     "unchanged values keep their text",
+			original: "{\n  // the host\n  \"host\": \"a\",\n  \"port\": 8.0e1\n}\n",
+			updated:  `{"host":"a","port":80}`,
+			want:     "{\n  // the host\n  \"host\": \"a\",\n  \"port\": 8.0e1\n}\n",
+		},
+		{
+			name:     "changed value replaced in place",
+			original: "{\n\t\"host\": \"a\", // primary\n\t\"port\": 80\n}",
+			updated:  `{"host":"b","port":80}`,
+			want:     "{\n\t\"host\": \"b\", // primary\n\t\"port\": 80\n}",
+		},
+		{
+			name:     "unknown keys kept, known missing keys removed",
+			original: "{\n\t\"host\": \"a\",\n\t\"extra\": {\"x\": 1},\n\t\"port\": 80,\n\t\"note\": \"keep\"\n}",
+			updated:  `{"host":"a"}`,
+			want:     "{\n\t\"host\": \"a\",\n\t\"extra\": {\"x\": 1},\n\t\"note\": \"keep\"\n}",
+		},
+		{
+			name:     "new keys inserted after their predecessor",
+			original: "{\n\t\"host\": \"a\",\n\t\"extra\": true\n}",
+			updated:  `{"_sconfig":{"schema":"s"},"version":2,"host":"a","tags":["x"]}`,
+			want:     "{\n\t\"_sconfig\": {\n\t\t\"schema\": \"s\"\n\t},\n\t\"version\": 2,\n\t\"host\": \"a\",\n\t\"tags\": [\n\t\t\"x\"\n\t],\n\t\"extra\": true\n}",
+		},
+		{
+			name:     "new key after a trailing line comment",
+			original: "{\n\t\"host\": \"a\", // primary\n\t\"extra\": true\n}",
+			updated:  `{"host":"a","port":80}`,
+			want:     "{\n\t\"host\": \"a\", // primary\n\t\"port\": 80,\n\t\"extra\": true\n}",
+		},
+		{
+			name:     "new key after a trailing block comment",
+			original: "{\n\t\"host\": \"a\", /* block */\n\t\"extra\": true\n}",
+			updated:  `{"host":"a","port":80}`,
+			want:     "{\n\t\"host\": \"a\", /* block */\n\t\"port\": 80,\n\t\"extra\": true\n}",
+		},
+		{
+			name:     "new last key after a block comment without comma",
+			original: "{\n\t\"host\": \"a\" /* block */ // line\n}",
+			updated:  `{"host":"a","port":80}`,
+			want:     "{\n\t\"host\": \"a\", /* block */ // line\n\t\"port\": 80\n}",
+		},
+		{
+			name:     "first members removed",
+			original: "{\n\t\"Host\": \"a\",\n\t\"port\": 1,\n\t\"extra\": true\n}",
+			updated:  `{"host":"a"}`,
+			want:     "{\n\t\"host\": \"a\",\n\t\"extra\": true\n}",
+		},
+		{
+			name:     "arrays of the same length patched per element",
+			original: "{\"tags\": [\n\t\"a\", // first\n\t\"b\"\n]}\n",
+			updated:  `{"tags":["a","c"]}`,
+			want:     "{\"tags\": [\n\t\"a\", // first\n\t\"c\"\n]}\n",
+		},
+		{
+			name:     "maps have no unknown keys",
+			original: "{\n\t\"labels\": {\n\t\t\"a\": \"1\",\n\t\t\"b\": \"2\"\n\t}\n}",
+			updated:  `{"labels":{"b":"2"}}`,
+			want:     "{\n\t\"labels\": {\n\t\t\"b\": \"2\"\n\t}\n}",
+		},
+		{
+			name:     "shadow key of a secure field removed",
+			original: "{\n\t\"token\": \"marker\",\n\t\"token__enc\": \"sealed\",\n\t\"host\": \"a\"\n}",
+			updated:  `{"token":"","host":"a"}`,
+			want:     "{\n\t\"token\": \"\",\n\t\"host\": \"a\"\n}",
+		},
+		{
+			name:     "one line is reformatted",
+			original: `{"extra": 1, "port": 80}`,
+			updated:  `{"version":1,"port":81}`,
+			want:     "{\n\t\"version\": 1,\n\t\"extra\": 1,\n\t\"port\": 81\n}\n",
+		},
+	}
+	for _, c := range cases {
+		got, err := patchJSON([]byte(c.original), []byte(c.updated), typ)
+		if err != nil || string(got) != c.want {
+			ts.Errorf("%s: got %q, %v\nwant %q", c.name, got, err, c.want)
+		}
+	}
+	if _, err := patchJSON([]byte(`{"host": "a"`), []byte(`{"host":"b"}`), typ); err == nil {
+		ts.Error("a broken original was patched")
+	}
+}
+
+func TestLoadConfig_KeepsCommentsAndUnknownFields(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "keep.json")
+	content := `{
+    // Managed by the deployment; see the runbook.
+    "database_host": "db.internal",
+    "database_password": "keep-secret", // rotated monthly
+    "feature_flags": {"beta": true},    // read by the frontend
+    "database_port": 5432
+}
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	for _, kept := range []string{
+		"    // Managed by the deployment; see the runbook.\n    \"database_host\": \"db.internal\",\n",
+		`, // rotated monthly`,
+		`"feature_flags": {"beta": true},    // read by the frontend`,
+		"    \"_sconfig\": {\n        \"schema\"",
+	} {
+		if !strings.Contains(string(raw), kept) {
+			ts.Errorf("rewrite lost %q:\n%s", kept, raw)
+		}
+	}
+	if strings.Contains(string(raw), "keep-secret") {
+		ts.Errorf("password not encrypted:\n%s", raw)
+	}
+
+	// A change and a version bump touch only their lines.
+	cfg.DatabaseHost = "db2.internal"
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if err := LoadConfig(cfg, 2, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	updated, _ := os.ReadFile(configPath)
+	// Every encryption gives a new ciphertext.
+	sealed := regexp.MustCompile(`("database_secure_password": )"[^"]+"`)
+	want := strings.Replace(strings.Replace(string(raw), `"db.internal"`, `"db2.internal"`, 1), `"version": 1`, `"version": 2`, 1)
+	if sealed.ReplaceAllString(string(updated), "$1") != sealed.ReplaceAllString(want, "$1") {
+		ts.Errorf("rewrite changed more than the host and version:\n%s\nwant\n%s", updated, want)
+	}
+	if cfg.DatabasePassword != "keep-secret" {
+		ts.Errorf("password = %q", cfg.DatabasePassword)
+	}
+}
+
+func TestLoadConfig_KeepsUnknownFieldsYAML(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	configPath := filepath.Join(tempDir, "keep.yaml")
+	content := "database_host: yaml.internal\ndatabase_password: yaml-secret\nowner:\n  team: platform\n"
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := LoadConfig(&TestConfig{}, 1, configPath, false, false, func() (uint64, error) { return 4711, nil }); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), "owner:\n  team: platform\n") || strings.Contains(string(raw), "yaml-secret") {
+		ts.Errorf("rewrite lost the unknown key or kept the password:\n%s", raw)
+	}
+}
@@ -0,0 +1,110 @@
+package sconfig
+
+import (
+	"reflect"
+)
+
+/*
+ * Rotated secrets.
+ *
+ * Programs often compute values from secrets once after loading, e.g. a DSN
+ * that embeds the database password. When a leased secret is renewed
+ * (leases.go) such a value would keep the old secret until the process is
+ * restarted. A derived field declared with WithDerivedField is computed after
+ * every Load and again whenever one of the fields it is derived from
+ * rotates; OnChange registers a callback for one field, called after the
+ * rotated and derived values are in place. Derived fields usually carry
+ * `json:"-"`, so the computed value is never written to the file.
+ */
+
+// derivedField is a string field computed from other fields of the config.
+type derivedField struct {
+	path   string   // Go path of the derived field
+	from   []string // Go paths of the fields it is derived from
+	derive func(config interface{}) (string, error)
+}
+
+// WithDerivedField makes the Loader set the string field path (a Go field
+// path like "Database.DSN") to derive(config) after every Load and whenever
+// one of the fields named in from is rotated by a lease renewal. An error
+// from derive keeps the previous value and is reported through the warning
+// handler.
+func WithDerivedField(path string, derive func(config interface{}) (string, error), from ...string) LoaderOption {
+	return func(l *Loader) {
+		l.derived = append(l.derived, derivedField{path: path, from: from, derive: derive})
+	}
+}
+
+// OnChange registers fn to be called with the Go path of field when its value
+// is rotated by a lease renewal or recomputed as a derived field. The
+// callback runs without the Loader's lock held, so it may call Snapshot,
+// Save or Flush.
+func (l *Loader) OnChange(field string, fn func(field string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.onChange == nil {
+		l.onChange = make(map[string][]func(string))
+	}
+	l.onChange[field] = append(l.onChange[field], fn)
+}
+
+// updateDerived recomputes the derived fields; with a non-nil rotated set only
+// those derived from one of the rotated field addresses. It returns the paths
+// of the fields whose value changed. Called with l.mu held.
+func (l *Loader) updateDerived(rotated map[uintptr]bool) []string {
+	root := reflect.ValueOf(l.config)
+	var changed []string
+	for _, d := range l.derived {
+		if rotated != nil && !l.anyRotated(d.from, rotated) {
+			continue
+		}
+		field, ok := fieldByPath(root, d.path)
+		if !ok {
+			warn(t("config.derive_unknown_field", d.path))
+			continue
+		}
+		value, err := d.derive(l.config)
+		if err != nil {
+			warn(t("config.derive_failed", d.path, err))
+			continue
+		}
+		if field.String() != value {
+			field.SetString(value)
+			changed = append(changed, d.path)
+		}
+	}
+	return changed
+}
+
+// anyRotated reports whether one of the fields named by paths is in rotated.
+func (l *Loader) anyRotated(paths []string, rotated map[uintptr]bool) bool {
+	root := reflect.ValueOf(l.config)
+	for _, path := range paths {
+		if f, ok := fieldByPath(root, path); ok && f.CanAddr() && rotated[f.UnsafeAddr()] {
+			return true
+		}
+	}
+	return false
+}
+
+// rotated updates the derived fields after the fields at the addresses in
+// addrs got new values and returns the OnChange callbacks to run once l.mu is
+// released. Called with l.mu held.
+func (l *Loader) rotated(addrs map[uintptr]bool) []func() {
+	changed := make(map[string]bool)
+	for path := range l.onChange {
+		if l.anyRotated([]string{path}, addrs) {
+			changed[path] = true
+		}
+	}
+	for _, path := range l.updateDerived(addrs) {
+		changed[path] = true
+	}
+	var callbacks []func()
+	for path := range changed {
+		for _, fn := range l.onChange[path] {
+			callbacks = append(callbacks, func() { fn(path) })
+		}
+	}
+	return callbacks
+}
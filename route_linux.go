@@ -0,0 +1,95 @@
+//go:build linux
+
+package sconfig
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// routeInterface returns the interface the kernel routes dst (IPv4) through,
+// asked over netlink like "ip route get" does, so no iproute2 is needed. The
+// former route probes in SetProbeAllowlist allow it as well as "netlink".
+func routeInterface(dst net.IP) (string, error) {
+	ip4 := dst.To4()
+	if ip4 == nil {
+		return "", fmt.Errorf("not an IPv4 address: %v", dst)
+	}
+	if !allowProbe("netlink", "/proc/net/route", "ip route get 8.8.8.8", "ip") {
+		return "", errProbeNotAllowed
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.Close(fd)
+	timeout := syscall.Timeval{Sec: 2}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return "", err
+	}
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", err
+	}
+
+	// RTM_GETROUTE: nlmsghdr, rtmsg, RTA_DST attribute.
+	const seq = 1
+	req := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofRtMsg+syscall.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], syscall.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(req[8:12], seq)
+	rtm := req[syscall.NLMSG_HDRLEN:]
+	rtm[0], rtm[1] = syscall.AF_INET, 32 // family, dst_len
+	attr := rtm[syscall.SizeofRtMsg:]
+	binary.NativeEndian.PutUint16(attr[0:2], syscall.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.RTA_DST)
+	copy(attr[syscall.SizeofRtAttr:], ip4)
+	if err := syscall.Sendto(fd, req, 0, kernel); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return "", err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return "", err
+		}
+		for i := range msgs {
+			m := &msgs[i]
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if code := int32(binary.NativeEndian.Uint32(m.Data[0:4])); code < 0 {
+						return "", syscall.Errno(-code)
+					}
+				}
+				return "", fmt.Errorf("no route to %v", dst)
+			case syscall.RTM_NEWROUTE:
+				attrs, err := syscall.ParseNetlinkRouteAttr(m)
+				if err != nil {
+					return "", err
+				}
+				for _, a := range attrs {
+					if a.Attr.Type == syscall.RTA_OIF && len(a.Value) >= 4 {
+						iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(a.Value)))
+						if err != nil {
+							return "", err
+						}
+						return iface.Name, nil
+					}
+				}
+				return "", fmt.Errorf("no route to %v", dst)
+			}
+		}
+	}
+}
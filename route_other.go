@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sconfig
+
+import (
+	"errors"
+	"net"
+)
+
+// routeInterface is only implemented on Linux.
+func routeInterface(dst net.IP) (string, error) {
+	return "", errors.New("netlink is only available on Linux")
+}
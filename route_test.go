@@ -0,0 +1,41 @@
+package sconfig
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestRouteInterface(ts *testing.T) {
+	if runtime.GOOS != "linux" {
+		ts.Skip("netlink is only used on Linux")
+	}
+	defaultRoute := defaultRouteInterface()
+	if defaultRoute == "" {
+		ts.Skip("no default route")
+	}
+	name, err := routeInterface(net.IPv4(8, 8, 8, 8))
+	if err != nil {
+		ts.Skipf("netlink not available: %v", err)
+	}
+	if name != defaultRoute {
+		ts.Logf("netlink routes through %s, /proc/net/route has %s (policy routing?)", name, defaultRoute)
+	}
+	if _, err := net.InterfaceByName(name); err != nil {
+		ts.Errorf("routeInterface = %q: %v", name, err)
+	}
+	if _, err := routeInterface(net.ParseIP("2001:db8::1")); err == nil {
+		ts.Error("IPv6 destination accepted")
+	}
+
+	// Allowlists written for ip route get keep working.
+	defer SetProbeAllowlist()
+	SetProbeAllowlist("ip route get 8.8.8.8")
+	if again, err := routeInterface(net.IPv4(8, 8, 8, 8)); err != nil || again != name {
+		ts.Errorf("with the former allowlist: %q, %v", again, err)
+	}
+	SetProbeAllowlist("/etc/machine-id")
+	if _, err := routeInterface(net.IPv4(8, 8, 8, 8)); err != errProbeNotAllowed {
+		ts.Errorf("outside the allowlist: %v", err)
+	}
+}
@@ -46,17 +46,14 @@ package sconfig
  */
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
@@ -131,8 +128,9 @@ func pathUnderBase(base, target string) (bool, error) {
 }
 
 // resolveConfigPath cleans the path, resolves it to an absolute path, and ensures
-// it lies under the executable directory (or executableRootForTest in tests) or
-// under the process current working directory. Relative paths are resolved with
+// it lies under the executable directory (or executableRootForTest in tests),
+// under the process current working directory or in a directory returned by
+// EnsureConfigDir. Relative paths are resolved with
 // filepath.Abs (i.e. relative to the CWD), matching usual CLI expectations.
 func resolveConfigPath(path string) (string, error) {
 	clean := filepath.Clean(path)
@@ -156,7 +154,7 @@ func resolveConfigPath(path string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("%s", t("config.path_invalid", err))
 	}
-	if !underExe && !underCwd {
+	if !underExe && !underCwd && !underConfigDir(abs) {
 		return "", fmt.Errorf("%s", t("config.path_outside_executable", abs))
 	}
 	return abs, nil
@@ -182,7 +180,7 @@ func getExecutableDir() (string, error) {
 func writeDebugLog(hardwareID uint64, identifiers string, onlyOnInit bool) {
 	dir, err := getExecutableDir()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] cannot get executable dir for debug log: %v\n", err)
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] cannot get executable dir for debug log: %v\n", err)
 		return
 	}
 	path := filepath.Join(dir, debugLogFilename)
@@ -196,7 +194,7 @@ func writeDebugLog(hardwareID uint64, identifiers string, onlyOnInit bool) {
 	line := now.Format("2006-01-02 15:04:05") + "\t" + fmt.Sprintf("0x%016x", hardwareID) + "\t" + identifiers + "\n"
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] cannot open debug log %s: %v\n", path, err)
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] cannot open debug log %s: %v\n", path, err)
 		return
 	}
 	_, _ = f.WriteString(line)
@@ -209,19 +207,11 @@ func writeDebugLog(hardwareID uint64, identifiers string, onlyOnInit bool) {
  */
 func isVirtualMachine() bool {
 	if runtime.GOOS == "windows" {
-		// Windows VM detection using WMI
-		out, err := exec.Command("wmic", "computersystem", "get", "Manufacturer,Model", "/value").Output()
+		// Windows VM detection from the system manufacturer and model
+		info, err := windowsSMBIOS()
 		if err == nil {
-			manufacturer := ""
-			model := ""
-			lines := strings.Split(string(out), "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "Manufacturer=") {
-					manufacturer = strings.ToLower(strings.TrimSpace(line[13:]))
-				} else if strings.HasPrefix(line, "Model=") {
-					model = strings.ToLower(strings.TrimSpace(line[6:]))
-				}
-			}
+			manufacturer := strings.ToLower(info.manufacturer)
+			model := strings.ToLower(info.product)
 
 			vmIndicators := []string{
 				"vmware", "virtualbox", "microsoft corporation", "xen",
@@ -247,7 +237,7 @@ func isVirtualMachine() bool {
 	}
 
 	// Method 1: systemd-detect-virt (most reliable)
-	out, err := exec.Command("systemd-detect-virt").Output()
+	out, err := probeCommand("systemd-detect-virt")
 	if err == nil {
 		virt := strings.TrimSpace(string(out))
 		// Returns "none" on bare metal, or VM type (kvm, vmware, qemu, etc.)
@@ -269,7 +259,7 @@ func isVirtualMachine() bool {
 	}
 
 	for _, file := range checks {
-		content, err := os.ReadFile(file)
+		content, err := probeFile(file)
 		if err == nil {
 			contentStr := strings.ToLower(strings.TrimSpace(string(content)))
 			for _, indicator := range vmIndicators {
@@ -283,148 +273,6 @@ func isVirtualMachine() bool {
 	return false
 }
 
-/*
- * getActiveNetworkInterface finds the network interface that has the active internet connection
- * by checking the default route. Returns the interface name or empty string if not found.
- */
-func getActiveNetworkInterface(debugOutput bool) string {
-	switch runtime.GOOS {
-	case "windows":
-		// On Windows, use "route print" to find the interface with default route (0.0.0.0)
-		// First, get the interface index from the route table
-		out, err := exec.Command("cmd", "/C", "route print 0.0.0.0").Output()
-		if err == nil {
-			output := string(out)
-			lines := strings.Split(output, "\n")
-			var interfaceIndex string
-			// Look for the active route line (0.0.0.0 with gateway)
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				fields := strings.Fields(line)
-				// Format: "0.0.0.0 0.0.0.0 <gateway> <gateway> <metric> <interface_index>"
-				if len(fields) >= 6 && fields[0] == "0.0.0.0" && fields[1] == "0.0.0.0" && strings.Contains(fields[2], ".") {
-					interfaceIndex = fields[len(fields)-1]
-					break
-				}
-			}
-
-			if interfaceIndex != "" {
-				// Now find the interface name by index using "netsh interface show interface"
-				out2, err2 := exec.Command("cmd", "/C", "netsh interface show interface").Output()
-				if err2 == nil {
-					lines2 := strings.Split(string(out2), "\n")
-					for _, line := range lines2 {
-						fields := strings.Fields(line)
-						// Format: "Enabled/Disabled <connection_type> <interface_name>"
-						// Or we can use wmic to get interface by index
-						if len(fields) >= 3 {
-							// Try to match by checking if this line contains relevant info
-						}
-					}
-				}
-
-				// Alternative: use wmic to get interface name by index
-				cmd := fmt.Sprintf("wmic path Win32_NetworkAdapter where \"InterfaceIndex=%s\" get Name", interfaceIndex)
-				out3, err3 := exec.Command("cmd", "/C", cmd).Output()
-				if err3 == nil {
-					lines3 := strings.Split(string(out3), "\n")
-					for _, line := range lines3 {
-						line = strings.TrimSpace(line)
-						if line != "" && line != "Name" && !strings.HasPrefix(line, "---") {
-							if debugOutput {
-								fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found active network adapter (index %s): %s\n", interfaceIndex, line)
-							}
-							return line
-						}
-					}
-				}
-			}
-		}
-
-		// Fallback: use ipconfig to find the adapter with default gateway
-		out, err = exec.Command("cmd", "/C", "ipconfig").Output()
-		if err == nil {
-			lines := strings.Split(string(out), "\n")
-			var currentAdapter string
-			hasGateway := false
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				// Check for adapter name (ends with ":")
-				if strings.HasSuffix(line, ":") && !strings.Contains(line, "Windows IP") && !strings.Contains(line, "Configuration") {
-					// Reset when we find a new adapter
-					if hasGateway && currentAdapter != "" {
-						// We already found one with gateway, return it
-						if debugOutput {
-							fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found active network adapter: %s\n", currentAdapter)
-						}
-						return currentAdapter
-					}
-					currentAdapter = strings.TrimSuffix(line, ":")
-					hasGateway = false
-				}
-				// Check for default gateway
-				if strings.HasPrefix(line, "Default Gateway") && strings.Contains(line, ".") {
-					hasGateway = true
-				}
-			}
-			// Check if the last adapter had a gateway
-			if hasGateway && currentAdapter != "" {
-				if debugOutput {
-					fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found active network adapter: %s\n", currentAdapter)
-				}
-				return currentAdapter
-			}
-		}
-
-	case "linux", "darwin":
-		// On Linux/Mac, use "ip route get" or "route get" to find the interface for default route
-		var cmd *exec.Cmd
-		if runtime.GOOS == "linux" {
-			cmd = exec.Command("ip", "route", "get", "8.8.8.8")
-		} else {
-			// macOS
-			cmd = exec.Command("route", "-n", "get", "8.8.8.8")
-		}
-		out, err := cmd.Output()
-		if err == nil {
-			output := string(out)
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if runtime.GOOS == "linux" {
-					// Linux "ip route" output format: "8.8.8.8 via ... dev <interface> ..."
-					if strings.Contains(line, "dev ") {
-						parts := strings.Fields(line)
-						for i, part := range parts {
-							if part == "dev" && i+1 < len(parts) {
-								iface := parts[i+1]
-								if debugOutput {
-									fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found active network interface: %s\n", iface)
-								}
-								return iface
-							}
-						}
-					}
-				} else {
-					// macOS "route get" output format: "interface: <interface>"
-					if strings.HasPrefix(line, "interface:") {
-						parts := strings.Fields(line)
-						if len(parts) >= 2 {
-							iface := parts[1]
-							if debugOutput {
-								fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found active network interface: %s\n", iface)
-							}
-							return iface
-						}
-					}
-				}
-			}
-		}
-	}
-
-	return ""
-}
-
 /*
  * This function is a default function that can be overridden and generates a 64-bit number
  * that uniquely identifies a system in such a way that it is unlikely that someone can simply build
@@ -438,18 +286,119 @@ func secure_config_getHardwareID() (uint64, error) {
 }
 
 func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
+	return withProbeAllowlist(func() (uint64, error) {
+		collect := func() (uint64, error) {
+			id, _, err := collectHardwareID(debugOutput)
+			return id, err
+		}
+		path, mode := machineIDFile()
+		if path == "" {
+			return collect()
+		}
+		id, err := readOrCreateMachineID(path, mode, collect)
+		if err == nil && debugOutput {
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Hardware ID from machine ID file %s: 0x%016x\n", path, id)
+		}
+		return id, err
+	})
+}
+
+// collectHardwareID returns the hardware ID and the identifiers it was
+// computed from.
+func collectHardwareID(debugOutput bool) (uint64, []HardwareIdentifier, error) {
 	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] ========================================\n")
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] sconfig Version: %s\n", Version)
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] sconfig BuildTime: %s\n", BuildTime)
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] ========================================\n")
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] ========================================\n")
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] sconfig Version: %s\n", Version)
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] sconfig BuildTime: %s\n", BuildTime)
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] ========================================\n")
 	}
 
 	var identifiers []string
+	var sources []HardwareIdentifier
+	add := func(source, value string) {
+		identifiers = append(identifiers, value)
+		sources = append(sources, HardwareIdentifier{Source: source, Value: value})
+	}
+	if container := getContainerIdentity(); container != nil && InContainer() {
+		// The hardware of a container belongs to the node, not to it.
+		if err := containerIdentity(container, add); err != nil {
+			return 0, nil, err
+		}
+		if debugOutput {
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Container identity: %v\n", container)
+		}
+	} else if err := collectHostIdentifiers(debugOutput, add); err != nil {
+		return 0, nil, err
+	}
+
+	if len(identifiers) == 0 {
+		return 0, nil, fmt.Errorf("no hardware identifiers found")
+	}
+
+	sortIdentifiers(identifiers)
+
+	if debugOutput {
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] Hardware identifiers found: %d (sorted)\n", len(identifiers))
+		for i, id := range identifiers {
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG]   Identifier %d: %s\n", i+1, id)
+		}
+	}
+
+	// Combine all identifiers and create a hash
+	combined := strings.Join(identifiers, "|")
+	if debugOutput {
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] Combined identifiers: %s\n", combined)
+	}
+	hash := sha256.Sum256([]byte(combined))
+	if debugOutput {
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] SHA256 hash: %x\n", hash)
+	}
+	hardwareID := hashHardwareID(hash)
+	if debugOutput {
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] Hardware ID (uint64): %d (0x%016x)\n", hardwareID, hardwareID)
+		if f, ok := debugWriter.(*os.File); ok {
+			_ = f.Sync() // flush so debug is visible even if process exits after error
+		}
+		writeDebugLog(hardwareID, combined, true)
+		lastDebugHardwareID = hardwareID
+		lastDebugIdentifiers = combined
+	}
+	return hardwareID, sources, nil
+}
+
+// collectHostIdentifiers passes the identifiers of the machine, the cloud
+// instance ID and the hardware, to add.
+func collectHostIdentifiers(debugOutput bool, add func(source, value string)) error {
+	cloud := getCloudIdentity()
+	if cloud != CloudIdentityOff {
+		instanceID, err := cloudInstanceID()
+		if err != nil {
+			return err
+		}
+		add("cloud-instance-id", instanceID)
+		if debugOutput {
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Cloud instance ID: %s\n", instanceID)
+		}
+	}
+	if cloud != CloudIdentityExclusive {
+		if err := probeHardwareIdentifiers(debugOutput, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeHardwareIdentifiers collects the identifiers of the hardware (MAC
+// address, machine and board IDs, serials) and passes them to add.
+func probeHardwareIdentifiers(debugOutput bool, add func(source, value string)) error {
+	if !execProbesEnabled() && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		return fmt.Errorf("%s", t("config.exec_probes_required", runtime.GOOS))
+	}
+
 	isVM := isVirtualMachine()
 
 	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] VM detection: %v\n", isVM)
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] VM detection: %v\n", isVM)
 	}
 
 	// MAC address of the network interface with active internet connection
@@ -461,127 +410,35 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 		// Try to find MAC address of the active interface by interface index
 		switch runtime.GOOS {
 		case "windows":
-			// On Windows, use ipconfig /all to find the adapter with default gateway
-			// This is more reliable than parsing route tables with varying formats
-			if debugOutput {
-				fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Using ipconfig /all to find active adapter\n")
-			}
-			out, err := exec.Command("cmd", "/C", "ipconfig /all").Output()
-			if err == nil {
-				output := string(out)
-				lines := strings.Split(output, "\n")
-				var currentAdapterName string
-				var hasGateway bool
-				var adapterMAC string
-				var bestAdapterMAC string
-				var bestAdapterName string
-
-				for i, line := range lines {
-					line = strings.TrimSpace(line)
-
-					// Check for adapter name (ends with ":")
-					if strings.HasSuffix(line, ":") && !strings.Contains(line, "Windows IP") && !strings.Contains(line, "Configuration") {
-						// If previous adapter had gateway, save it as candidate
-						if hasGateway && currentAdapterName != "" && adapterMAC != "" {
-							bestAdapterMAC = adapterMAC
-							bestAdapterName = currentAdapterName
-							if debugOutput {
-								fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found adapter with gateway: %s (MAC: %s)\n", currentAdapterName, adapterMAC)
-							}
-						}
-						// Start new adapter
-						currentAdapterName = strings.TrimSuffix(line, ":")
-						hasGateway = false
-						adapterMAC = ""
-					}
-
-					// Check for physical address (MAC)
-					if strings.HasPrefix(line, "Physical Address") || strings.HasPrefix(line, "Physische Adresse") || strings.HasPrefix(line, "Physikalische Adresse") {
-						parts := strings.Split(line, ":")
-						if len(parts) >= 2 {
-							adapterMAC = strings.TrimSpace(parts[1])
-							// Normalize MAC address format
-							adapterMAC = strings.ToLower(strings.ReplaceAll(adapterMAC, "-", ":"))
-						}
-					}
-
-					// Check for default gateway
-					if strings.HasPrefix(line, "Default Gateway") || strings.HasPrefix(line, "Standardgateway") {
-						// Check if it contains an IP address (has dots)
-						if strings.Contains(line, ".") || (strings.Contains(line, ":") && i < len(lines) && strings.Contains(lines[i+1], ".")) {
-							hasGateway = true
-							if debugOutput {
-								fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Adapter %s has default gateway\n", currentAdapterName)
-							}
-						}
-					}
-				}
-
-				// Check last adapter
-				if hasGateway && currentAdapterName != "" && adapterMAC != "" {
-					bestAdapterMAC = adapterMAC
-					bestAdapterName = currentAdapterName
-					if debugOutput {
-						fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Last adapter has gateway: %s (MAC: %s)\n", currentAdapterName, adapterMAC)
-					}
-				}
-
-				// Use the MAC address directly if found
-				if bestAdapterMAC != "" {
-					macAddress = bestAdapterMAC
-					if debugOutput {
-						fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Using MAC address from active adapter '%s': %s\n", bestAdapterName, macAddress)
-					}
-				} else {
-					// Fallback: try to match adapter name with net.Interfaces()
-					if bestAdapterName != "" {
-						for _, iface := range interfaces {
-							ifaceNameLower := strings.ToLower(iface.Name)
-							adapterNameLower := strings.ToLower(bestAdapterName)
-							if strings.Contains(adapterNameLower, ifaceNameLower) || strings.Contains(ifaceNameLower, adapterNameLower) {
-								if iface.HardwareAddr != nil && iface.HardwareAddr.String() != "" {
-									macAddress = iface.HardwareAddr.String()
-									if debugOutput {
-										fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Matched adapter name to interface, using MAC: %s\n", macAddress)
-									}
-									break
-								}
-							}
-						}
-					}
+			// On Windows, the adapter with a default gateway from the IP helper API
+			mac, err := windowsGatewayMAC()
+			if err == nil && mac != "" {
+				macAddress = mac
+				if debugOutput {
+					fmt.Fprintf(debugWriter, "[sconfig DEBUG] Using MAC address from adapter with gateway: %s\n", macAddress)
 				}
 			}
 
 		case "linux":
-			// On Linux, get interface name from route, then find MAC
-			cmd := exec.Command("ip", "route", "get", "8.8.8.8")
-			out, err := cmd.Output()
-			if err == nil {
-				output := string(out)
-				lines := strings.Split(output, "\n")
-				var ifaceName string
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if strings.Contains(line, "dev ") {
-						parts := strings.Fields(line)
-						for i, part := range parts {
-							if part == "dev" && i+1 < len(parts) {
-								ifaceName = parts[i+1]
-								break
-							}
-						}
-					}
+			// On Linux, get the interface of the route to the internet from the
+			// kernel (netlink, like ip route get 8.8.8.8), then find its MAC.
+			// Without netlink the default route comes from /proc/net/route.
+			ifaceName, err := routeInterface(net.IPv4(8, 8, 8, 8))
+			if err != nil && err != errProbeNotAllowed {
+				if debugOutput {
+					fmt.Fprintf(debugWriter, "[sconfig DEBUG] Route lookup over netlink failed, using /proc/net/route: %v\n", err)
 				}
-				if ifaceName != "" {
-					for _, iface := range interfaces {
-						if iface.Name == ifaceName {
-							if iface.HardwareAddr != nil && iface.HardwareAddr.String() != "" {
-								macAddress = iface.HardwareAddr.String()
-								if debugOutput {
-									fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found MAC from active interface '%s': %s\n", ifaceName, macAddress)
-								}
-								break
+				ifaceName = defaultRouteInterface()
+			}
+			if ifaceName != "" {
+				for _, iface := range interfaces {
+					if iface.Name == ifaceName {
+						if iface.HardwareAddr != nil && iface.HardwareAddr.String() != "" {
+							macAddress = iface.HardwareAddr.String()
+							if debugOutput {
+								fmt.Fprintf(debugWriter, "[sconfig DEBUG] Found MAC from active interface '%s': %s\n", ifaceName, macAddress)
 							}
+							break
 						}
 					}
 				}
@@ -589,8 +446,7 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 
 		case "darwin":
 			// On macOS, get interface name from route, then find MAC
-			cmd := exec.Command("route", "-n", "get", "8.8.8.8")
-			out, err := cmd.Output()
+			out, err := probeCommand("route", "-n", "get", "8.8.8.8")
 			if err == nil {
 				output := string(out)
 				lines := strings.Split(output, "\n")
@@ -611,7 +467,7 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 							if iface.HardwareAddr != nil && iface.HardwareAddr.String() != "" {
 								macAddress = iface.HardwareAddr.String()
 								if debugOutput {
-									fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Found MAC from active interface '%s': %s\n", ifaceName, macAddress)
+									fmt.Fprintf(debugWriter, "[sconfig DEBUG] Found MAC from active interface '%s': %s\n", ifaceName, macAddress)
 								}
 								break
 							}
@@ -640,7 +496,7 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 				}
 				macAddress = macAddresses[0]
 				if debugOutput {
-					fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Active interface not found, using first MAC (sorted): %s\n", macAddress)
+					fmt.Fprintf(debugWriter, "[sconfig DEBUG] Active interface not found, using first MAC (sorted): %s\n", macAddress)
 				}
 			}
 		}
@@ -650,9 +506,9 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 			macAddress = strings.ToLower(strings.ReplaceAll(macAddress, "-", ":"))
 			// Ensure it's in standard format (xx:xx:xx:xx:xx:xx)
 			macAddress = strings.ReplaceAll(macAddress, " ", "")
-			identifiers = append(identifiers, macAddress)
+			add("mac", macAddress)
 			if debugOutput {
-				fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Using MAC address (normalized): %s\n", macAddress)
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] Using MAC address (normalized): %s\n", macAddress)
 			}
 		}
 	}
@@ -660,139 +516,54 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 	// CPU ID and other hardware information depending on the operating system
 	switch runtime.GOOS {
 	case "windows":
+		smbios, smbiosErr := windowsSMBIOS()
 		if isVM {
 			// For Windows VMs: prioritize stable identifiers
 			// 1. MachineGuid from Registry (very stable on Windows)
-			out, err := exec.Command("reg", "query", "HKLM\\SOFTWARE\\Microsoft\\Cryptography", "/v", "MachineGuid").Output()
-			if err == nil {
-				lines := strings.Split(string(out), "\n")
-				for _, line := range lines {
-					if strings.Contains(line, "MachineGuid") {
-						parts := strings.Fields(line)
-						for i, part := range parts {
-							if part == "REG_SZ" && i+1 < len(parts) {
-								machineGuid := strings.TrimSpace(parts[i+1])
-								if machineGuid != "" {
-									identifiers = append(identifiers, machineGuid)
-									break
-								}
-							}
-						}
-					}
-				}
+			if machineGuid, err := windowsMachineGUID(); err == nil && machineGuid != "" {
+				add("machine-guid", machineGuid)
 			}
 
 			// 2. SMBIOS UUID (usually stable on VMs)
-			out, err = exec.Command("wmic", "csproduct", "get", "UUID", "/value").Output()
-			if err == nil {
-				lines := strings.Split(string(out), "\n")
-				for _, line := range lines {
-					if strings.HasPrefix(line, "UUID=") {
-						uuid := strings.TrimSpace(line[5:])
-						if uuid != "" {
-							// Normalize UUID: replace underscores with hyphens for consistency
-							uuid = strings.ReplaceAll(uuid, "_", "-")
-							// Remove trailing dots or other characters
-							uuid = strings.TrimRight(uuid, ".! ")
-							identifiers = append(identifiers, uuid)
-							if debugOutput {
-								fmt.Fprintf(os.Stderr, "[sconfig DEBUG] SMBIOS UUID (normalized): %s\n", uuid)
-							}
-							break
-						}
-					}
+			if smbiosErr == nil && smbios.uuid != "" {
+				add("smbios-uuid", smbios.uuid)
+				if debugOutput {
+					fmt.Fprintf(debugWriter, "[sconfig DEBUG] SMBIOS UUID: %s\n", smbios.uuid)
 				}
 			}
 		}
 
 		// Common identifiers (for both VM and physical)
-		// Windows-specific hardware IDs
-		// Baseboard and Product (usually single values)
-		// Parse them separately to handle empty values and ensure stability
-		baseboardCmds := []struct {
-			cmd  string
-			name string
-		}{
-			{"wmic baseboard get SerialNumber", "Baseboard SerialNumber"},
-			{"wmic baseboard get Product", "Baseboard Product"},
-		}
-
-		for _, cmdInfo := range baseboardCmds {
-			out, err := exec.Command("cmd", "/C", cmdInfo.cmd).Output()
-			if err == nil {
-				lines := strings.Split(string(out), "\n")
-				if len(lines) > 1 {
-					value := strings.TrimSpace(lines[1])
-					if value != "" && value != cmdInfo.name {
-						identifiers = append(identifiers, value)
-						if debugOutput {
-							fmt.Fprintf(os.Stderr, "[sconfig DEBUG] %s: %s\n", cmdInfo.name, value)
-						}
-					}
-				}
+		// Baseboard serial number and product from SMBIOS
+		if smbiosErr == nil {
+			if smbios.boardSerial != "" {
+				add("baseboard-serialnumber", smbios.boardSerial)
+			}
+			if smbios.boardProduct != "" {
+				add("baseboard-product", smbios.boardProduct)
+			}
+			if debugOutput {
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] Baseboard SerialNumber: %s, Product: %s\n", smbios.boardSerial, smbios.boardProduct)
 			}
 		}
 
-		// Handle diskdrive SerialNumber separately to ensure stable ordering
-		out, err := exec.Command("cmd", "/C", "wmic diskdrive get SerialNumber").Output()
-		if err == nil {
-			lines := strings.Split(string(out), "\n")
-			var diskSerials []string
-			for i, line := range lines {
-				if i == 0 {
-					continue // Skip header
-				}
-				value := strings.TrimSpace(line)
-				if value != "" && value != "SerialNumber" {
-					diskSerials = append(diskSerials, value)
-				}
-			}
-			// Sort disk serials to ensure consistent ordering
-			if len(diskSerials) > 0 {
-				for i := 0; i < len(diskSerials)-1; i++ {
-					for j := i + 1; j < len(diskSerials); j++ {
-						if diskSerials[i] < diskSerials[j] {
-							diskSerials[i], diskSerials[j] = diskSerials[j], diskSerials[i]
-						}
-					}
-				}
-				identifiers = append(identifiers, diskSerials[0])
-				if debugOutput {
-					fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Disk SerialNumbers found: %d, using first (sorted): %s\n", len(diskSerials), diskSerials[0])
-				}
+		// Disk serial numbers, the highest one (sorted) for stable ordering
+		diskSerials, err := windowsDiskSerials()
+		if err == nil && len(diskSerials) > 0 {
+			sort.Sort(sort.Reverse(sort.StringSlice(diskSerials)))
+			add("disk-serial", diskSerials[0])
+			if debugOutput {
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] Disk SerialNumbers found: %d, using first (sorted): %s\n", len(diskSerials), diskSerials[0])
 			}
 		}
 
 		// On VMs, skip CPU ProcessorId as it's often unreliable
-		if !isVM {
-			// For CPU ProcessorId, collect all values and use the first one (sorted) for stability
-			out, err := exec.Command("cmd", "/C", "wmic cpu get ProcessorId").Output()
-			if err == nil {
-				lines := strings.Split(string(out), "\n")
-				var cpuIds []string
-				for i, line := range lines {
-					if i == 0 {
-						continue // Skip header
-					}
-					value := strings.TrimSpace(line)
-					if value != "" && value != "ProcessorId" {
-						cpuIds = append(cpuIds, value)
-					}
-				}
-				// Sort CPU IDs to ensure consistent ordering across runs
-				if len(cpuIds) > 0 {
-					for i := 0; i < len(cpuIds)-1; i++ {
-						for j := i + 1; j < len(cpuIds); j++ {
-							if cpuIds[i] > cpuIds[j] {
-								cpuIds[i], cpuIds[j] = cpuIds[j], cpuIds[i]
-							}
-						}
-					}
-					identifiers = append(identifiers, cpuIds[0])
-					if debugOutput {
-						fmt.Fprintf(os.Stderr, "[sconfig DEBUG] CPU ProcessorIds found: %d, using first (sorted): %s\n", len(cpuIds), cpuIds[0])
-					}
-				}
+		if !isVM && smbiosErr == nil && len(smbios.processorIDs) > 0 {
+			cpuIds := append([]string(nil), smbios.processorIDs...)
+			sort.Strings(cpuIds)
+			add("cpu-id", cpuIds[0])
+			if debugOutput {
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] CPU ProcessorIds found: %d, using first (sorted): %s\n", len(cpuIds), cpuIds[0])
 			}
 		}
 
@@ -800,33 +571,33 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 		if isVM {
 			// For VMs: prioritize stable identifiers
 			// 1. machine-id (very stable on VMs)
-			machineId, err := os.ReadFile("/etc/machine-id")
+			machineId, err := probeFile("/etc/machine-id")
 			if err == nil {
 				machineIdStr := strings.TrimSpace(string(machineId))
 				if machineIdStr != "" {
-					identifiers = append(identifiers, machineIdStr)
+					add("machine-id", machineIdStr)
 				}
 			}
 
 			// 2. product_uuid (usually stable on VMs)
-			productUuid, err := os.ReadFile("/sys/class/dmi/id/product_uuid")
+			productUuid, err := probeFile("/sys/class/dmi/id/product_uuid")
 			if err == nil {
 				productUuidStr := strings.TrimSpace(string(productUuid))
 				if productUuidStr != "" {
-					identifiers = append(identifiers, productUuidStr)
+					add("product-uuid", productUuidStr)
 				}
 			}
 		}
 
 		// Common identifiers (for both VM and physical)
-		cmds := []string{
-			"cat /sys/class/dmi/id/board_serial",
+		files := []string{
+			"/sys/class/dmi/id/board_serial",
 		}
 
 		// Only add CPU serial if not on VM (often unreliable on VMs)
 		if !isVM {
 			// For CPU serial, collect all values and use the first one (sorted) for stability
-			out, err := exec.Command("sh", "-c", "cat /proc/cpuinfo | grep 'Serial'").Output()
+			out, err := probeFile("/proc/cpuinfo")
 			if err == nil {
 				lines := strings.Split(string(out), "\n")
 				var cpuSerials []string
@@ -862,32 +633,46 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 						}
 					}
 					if len(unique) > 0 {
-						identifiers = append(identifiers, unique[0])
+						add("cpu-serial", unique[0])
 						if debugOutput {
-							fmt.Fprintf(os.Stderr, "[sconfig DEBUG] CPU Serial numbers found: %d (unique: %d), using first (sorted): %s\n", len(cpuSerials), len(unique), unique[0])
+							fmt.Fprintf(debugWriter, "[sconfig DEBUG] CPU Serial numbers found: %d (unique: %d), using first (sorted): %s\n", len(cpuSerials), len(unique), unique[0])
 						}
 					}
 				}
 			}
 		}
 
-		for _, cmd := range cmds {
-			out, err := exec.Command("sh", "-c", cmd).Output()
+		for _, file := range files {
+			out, err := probeFile(file)
 			if err == nil {
 				value := strings.TrimSpace(string(out))
 				if value != "" {
-					identifiers = append(identifiers, value)
+					add(strings.ReplaceAll(filepath.Base(file), "_", "-"), value)
 				}
 			}
 		}
-	}
 
-	if len(identifiers) == 0 {
-		return 0, fmt.Errorf("no hardware identifiers found")
+	case "darwin":
+		// Serial number and hardware UUID of the Mac (platformid.go)
+		if macPlatformIdentifiers() {
+			serial, uuid, err := darwinPlatformIDs()
+			if err != nil {
+				return fmt.Errorf("%s", t("config.platform_ids_failed", err))
+			}
+			add("platform-serial", serial)
+			add("platform-uuid", uuid)
+			if debugOutput {
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] Platform serial: %s, hardware UUID: %s\n", serial, uuid)
+			}
+		}
 	}
+	return nil
+}
 
-	// Sort identifiers to ensure consistent ordering regardless of collection order
-	// This is critical because the order affects the hash
+// sortIdentifiers sorts the identifiers to ensure consistent ordering
+// regardless of collection order. This is critical because the order affects
+// the hash.
+func sortIdentifiers(identifiers []string) {
 	for i := 0; i < len(identifiers)-1; i++ {
 		for j := i + 1; j < len(identifiers); j++ {
 			if identifiers[i] > identifiers[j] {
@@ -895,33 +680,13 @@ func secure_config_getHardwareID_debug(debugOutput bool) (uint64, error) {
 			}
 		}
 	}
+}
 
-	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Hardware identifiers found: %d (sorted)\n", len(identifiers))
-		for i, id := range identifiers {
-			fmt.Fprintf(os.Stderr, "[sconfig DEBUG]   Identifier %d: %s\n", i+1, id)
-		}
-	}
-
-	// Combine all identifiers and create a hash
-	combined := strings.Join(identifiers, "|")
-	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Combined identifiers: %s\n", combined)
-	}
-	hash := sha256.Sum256([]byte(combined))
-	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] SHA256 hash: %x\n", hash)
-	}
-	// Return first 64 bits as an uint64 ==> this is the pseudo-unique identifier of the system
-	hardwareID := uint64(hash[7])<<56 + uint64(hash[6])<<48 + uint64(hash[5])<<40 + uint64(hash[4])<<32 + uint64(hash[3])<<24 + uint64(hash[2])<<16 + uint64(hash[1])<<8 + uint64(hash[0])
-	if debugOutput {
-		fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Hardware ID (uint64): %d (0x%016x)\n", hardwareID, hardwareID)
-		_ = os.Stderr.Sync() // flush so debug is visible even if process exits after error
-		writeDebugLog(hardwareID, combined, true)
-		lastDebugHardwareID = hardwareID
-		lastDebugIdentifiers = combined
-	}
-	return hardwareID, nil
+// hashHardwareID returns the first 64 bits of the identifier hash as an
+// uint64 (little-endian) ==> this is the pseudo-unique identifier of the
+// system.
+func hashHardwareID(hash [32]byte) uint64 {
+	return uint64(hash[7])<<56 + uint64(hash[6])<<48 + uint64(hash[5])<<40 + uint64(hash[4])<<32 + uint64(hash[3])<<24 + uint64(hash[2])<<16 + uint64(hash[1])<<8 + uint64(hash[0])
 }
 
 // DebugHardwareID computes the current hardware ID and prints all intermediate
@@ -959,48 +724,163 @@ func DebugHardwareID() (uint64, error) {
 //
 // The optional `getHardwareID_func` allows overriding the hardware-ID based key
 // derivation used for encryption, which is primarily intended for testing.
+//
+// LoadConfig, Load and UpdateConfig are safe for concurrent use; the calls
+// are serialized, so each one sees a fully derived key.
 func LoadConfig(config interface{}, version int, path string, cleanConfig bool, debugOutput bool, getHardwareID_func ...func() (uint64, error)) error {
+	return Load(config, path, loadConfigOptions(version, cleanConfig, debugOutput, getHardwareID_func)...)
+}
+
+// loadConfigOptions translates the positional arguments of LoadConfig.
+func loadConfigOptions(version int, cleanConfig, debugOutput bool, getHardwareID_func []func() (uint64, error)) []LoadOption {
+	opts := []LoadOption{WithVersion(version)}
+	if cleanConfig {
+		opts = append(opts, WithCleanOutput())
+	}
+	if debugOutput {
+		opts = append(opts, WithDebugWriter(os.Stderr))
+	}
+	if len(getHardwareID_func) > 0 {
+		opts = append(opts, WithHardwareIDFunc(getHardwareID_func[0]))
+	}
+	return opts
+}
+
+// Load reads the config file at path into config like LoadConfig, configured
+// by options instead of positional arguments:
+//
+//	err := sconfig.Load(&cfg, "config.json", sconfig.WithVersion(3))
+//
+// Without WithVersion the Version field keeps the value from the file (or
+// its default).
+func Load(config interface{}, path string, opts ...LoadOption) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return load(config, path, opts)
+}
+
+// load runs loadOnce until the config file did not change between reading
+// and rewriting it, starting each try from the struct as it was passed in.
+// WithLastGood falls back to the last good copy of the file.
+func load(config interface{}, path string, opts []LoadOption) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return loadOnce(config, path, opts)
+	}
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	initial := deepCopy(v.Elem())
+	err := loadOnce(config, path, opts)
+	for attempt := 1; err == errFileChanged; attempt++ {
+		if attempt == maxLoadRetries {
+			err = fmt.Errorf("%s", t("config.load_changed", path))
+			break
+		}
+		v.Elem().Set(deepCopy(initial))
+		err = loadOnce(config, path, opts)
+	}
+	if !o.lastGood {
+		return err
+	}
+	if err == nil {
+		saveLastGood(config)
+		return nil
+	}
+	v.Elem().Set(deepCopy(initial))
+	if lastGoodErr := loadLastGood(config, path, o); lastGoodErr != nil {
+		return err
+	}
+	lastGoodFallbacks.Add(1)
+	warn(t("config.last_good_used", path, err))
+	return nil
+}
+
+func loadOnce(config interface{}, path string, opts []LoadOption) error {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cleanConfig, debugOutput := o.clean, o.debug != nil
+	setDebugWriter(o.debug)
 
 	path, err := resolveConfigPath(path)
 	if err != nil {
 		return err
 	}
+	if o.namespace != "" {
+		if err := checkNamespace(path, o.namespace); err != nil {
+			return err
+		}
+	}
+	setNamespace(config, o.namespace)
+	// An evaluated source (CUE, Jsonnet) is only read; its output cache is written.
+	source := ""
+	if evaluatorFor(path) != nil {
+		source, path = path, evaluatedCachePath(path)
+	}
+	if o.lockStrategySet {
+		setLockStrategy(path, o.lockStrategy)
+	}
+	if o.fileModeSet {
+		setFileMode(path, o.fileMode)
+	}
 
-	var file []byte
-
+	if o.keyStore != nil {
+		useKeyStore(o.keyStore)
+	}
+	if o.containerIdentity != nil {
+		useContainerIdentity(o.containerIdentity)
+	}
+	if o.passphrase != nil {
+		if err := usePassphrase(path, o.passphrase); err != nil {
+			return err
+		}
+	}
+	if o.ageRecipientsSet {
+		if err := SetAgeRecipients(o.ageRecipients...); err != nil {
+			return err
+		}
+	}
 	// Create wrapper function for hardware ID retrieval with debug support
-	var hardwareIDFunc func() (uint64, error)
-	if len(getHardwareID_func) > 0 {
-		hardwareIDFunc = getHardwareID_func[0]
+	hardwareIDFunc := o.hardwareID
+	if custom := hardwareIDFunc; custom != nil {
+		hardwareIDFunc = func() (uint64, error) {
+			if err := hardwareIDFault(); err != nil {
+				return 0, err
+			}
+			return custom()
+		}
 	} else {
 		// Create wrapper that calls the debug version
 		hardwareIDFunc = func() (uint64, error) {
 			return secure_config_getHardwareID_debug(debugOutput)
 		}
 	}
-	config_init(hardwareIDFunc, debugOutput)
+	if err := config_init(hardwareIDFunc, debugOutput); err != nil {
+		return err
+	}
+	if o.frozen != nil {
+		if err := verifyFrozenFile(path, o.frozen); err != nil {
+			return err
+		}
+	}
 
 	writeMode := os.FileMode(0644)
 	fileInfo, statErr := os.Stat(path)
 	if statErr == nil {
 		writeMode = fileInfo.Mode().Perm()
 	}
-	if !os.IsNotExist(statErr) {
-		file, err = os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf(t("config.read_failed"), err)
-		}
-		if debugOutput {
-			// Den absoluten Pfad aus path ermitteln (das ist identisch zu der gelesenen Datei)
-			absPath, absErr := filepath.Abs(path)
-			if absErr != nil {
-				fmt.Fprintf(os.Stderr, "%s %v\n", t("config.debug_file_abs_path_error"), absErr)
-			} else {
-				fmt.Fprintf(os.Stderr, "%s %s\n", t("config.debug_file_abs_path"), absPath)
-			}
+	fileExists := !os.IsNotExist(statErr)
+	if fileExists && debugOutput {
+		// Den absoluten Pfad aus path ermitteln (das ist identisch zu der gelesenen Datei)
+		absPath, absErr := filepath.Abs(path)
+		if absErr != nil {
+			fmt.Fprintf(debugWriter, "%s %v\n", t("config.debug_file_abs_path_error"), absErr)
+		} else {
+			fmt.Fprintf(debugWriter, "%s %s\n", t("config.debug_file_abs_path"), absPath)
 		}
-	} else {
-		file = []byte("{}")
 	}
 
 	// Analyze config type
@@ -1018,14 +898,52 @@ func LoadConfig(config interface{}, version int, path string, cleanConfig bool,
 		return fmt.Errorf(t("config.failed_defaulting"), err)
 	}
 
-	if err := json.Unmarshal(file, config); err != nil {
-		return fmt.Errorf(t("config.failed_parsing"), err)
-	}
+	// A missing file is an empty configuration: only defaults and the values
+	// already present in the struct apply.
 	changed := false
+	var loadedSum [32]byte
+	if fileExists && source == "" {
+		loadedSum, _ = fileSum(path)
+	}
+	if o.strict && fileExists && source == "" {
+		if err := checkStrictFile(path, o.namespace, config); err != nil {
+			return err
+		}
+	}
+	if fileExists || source != "" {
+		var meta *fileMetadata
+		found := true // a missing namespace is like a missing file
+		if source != "" {
+			meta, changed, err = decodeEvaluated(source, path, config)
+		} else if o.namespace != "" {
+			meta, found, err = decodeNamespace(path, o.namespace, config)
+		} else {
+			meta, err = decodeConfigFile(path, config)
+		}
+		if err != nil {
+			return err
+		}
+		if found {
+			checkSchemaDrift(path, meta, config)
+			recordMetadata(path, meta)
+			migrated, err := migrateKDF(configValue, meta)
+			if err != nil {
+				return err
+			}
+			changed = changed || migrated
+		}
+	}
+	version := o.version
+	if !o.versionSet {
+		version = getStructVersion(configValue)
+	}
 	if err := updateVersionAndPasswords(configValue, version, &changed); err != nil {
 		return fmt.Errorf(t("config.failed_checking"), err)
 	}
 	if cleanConfig {
+		if err := requirePlaintextApproval(); err != nil {
+			return err
+		}
 		/* Decrypt passwords before writing */
 		if err := decodePasswords(configValue); err != nil {
 			return fmt.Errorf(t("config.failed_decode_pw"), err)
@@ -1033,21 +951,44 @@ func LoadConfig(config interface{}, version int, path string, cleanConfig bool,
 		changed = true
 	}
 	if changed {
-		configJSON, err := json.MarshalIndent(config, "", "\t")
+		unlock, err := lockConfigFile(path)
 		if err != nil {
-			return fmt.Errorf(t("config.failed_build_json"), err)
+			return err
 		}
-		if err := os.WriteFile(path, configJSON, writeMode); err != nil {
-			return fmt.Errorf(t("config.failed_writing"), path, err)
+		// Another writer changed the file since it was read: read it again.
+		if sum, exists := fileSum(path); source == "" && (exists != fileExists || sum != loadedSum) {
+			unlock()
+			return errFileChanged
+		}
+		err = writeConfigFileLocked(path, config, writeMode)
+		unlock()
+		if err != nil {
+			return err
 		}
 	}
+	recordLoadedFile(config, path)
 	if !cleanConfig {
 		/* Decrypt passwords after writing */
 		if err := decodePasswords(configValue); err != nil {
 			return fmt.Errorf(t("config.failed_decode_pw"), err)
 		}
 	}
-	return nil
+	/* The local override file is applied in memory only */
+	if err := applyOverrideFile(path, config); err != nil {
+		return err
+	}
+	/* So are the environment variables of env-tagged fields and the flags */
+	if err := applyEnvOverrides(path, config); err != nil {
+		return err
+	}
+	if err := applyFlagOverrides(path, config); err != nil {
+		return err
+	}
+	/* Secret references are resolved in memory only */
+	if err := resolveSecretRefs(config); err != nil {
+		return err
+	}
+	return validateConfig(config)
 }
 
 // UpdateConfig writes the config struct to the given path. Secure password
@@ -1055,14 +996,25 @@ func LoadConfig(config interface{}, version int, path string, cleanConfig bool,
 // LoadConfig must have been called at least once before; otherwise UpdateConfig
 // returns an error. The path may differ from the one used in LoadConfig (e.g.
 // write to a backup file) but must likewise lie under the executable directory
-// or the current working directory.
+// or the current working directory. Changes another writer made to the file
+// since it was loaded are kept; fields changed on both sides differently make
+// UpdateConfig return a *ConflictError without writing (see concurrency.go).
 // Example: after the user changes the theme from "dark"
 // to "light" in the UI, set cfg.Theme = "light" and call UpdateConfig(cfg, "config.json").
 func UpdateConfig(config interface{}, path string, cleanConfig ...bool) error {
-	path, err := resolveConfigPath(path)
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return updateConfig(config, path, cleanConfig...)
+}
+
+func updateConfig(config interface{}, path string, cleanConfig ...bool) (err error) {
+	path, err = resolveConfigPath(path)
 	if err != nil {
 		return err
 	}
+	if evaluatorFor(path) != nil {
+		path = evaluatedCachePath(path)
+	}
 	cleanConfigVal := false
 	if len(cleanConfig) > 0 {
 		cleanConfigVal = cleanConfig[0]
@@ -1083,7 +1035,28 @@ func UpdateConfig(config interface{}, path string, cleanConfig ...bool) error {
 	if fileInfo, err := os.Stat(path); err == nil {
 		writeMode = fileInfo.Mode().Perm()
 	}
+	unlock, err := lockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Write the secret references, not the secrets they were resolved to.
+	restoreSecretRefs(config)
+	defer func() {
+		if resolveErr := resolveSecretRefs(config); err == nil {
+			err = resolveErr
+		}
+	}()
+	// Overridden fields are written with their value from the base file.
+	defer stripOverrides(config, path)()
+	// Keep what other writers changed in the file since it was loaded.
+	if err := mergeConcurrentChanges(config, path); err != nil {
+		return err
+	}
 	if cleanConfigVal {
+		if err := requirePlaintextApproval(); err != nil {
+			return err
+		}
 		if err := decodePasswords(reflect.ValueOf(config)); err != nil {
 			return fmt.Errorf(t("config.failed_decode_pw"), err)
 		}
@@ -1094,12 +1067,11 @@ func UpdateConfig(config interface{}, path string, cleanConfig ...bool) error {
 			return err
 		}
 	}
-	configJSON, err := json.MarshalIndent(config, "", "\t")
-	if err != nil {
-		return fmt.Errorf(t("config.failed_build_json"), err)
+	if err := writeConfigFileLocked(path, config, writeMode); err != nil {
+		return err
 	}
-	if err := os.WriteFile(path, configJSON, writeMode); err != nil {
-		return fmt.Errorf(t("config.failed_writing"), path, err)
+	if state := loadedFileOf(config); state != nil && state.path == path {
+		recordLoadedFile(config, path)
 	}
 	if !cleanConfigVal {
 		if err := decodePasswords(reflect.ValueOf(config)); err != nil {
@@ -1109,6 +1081,14 @@ func UpdateConfig(config interface{}, path string, cleanConfig ...bool) error {
 	return nil
 }
 
+// SaveConfig persists runtime changes of config to path: passwords changed in
+// memory are encrypted, the file gets the ciphertexts and secure markers, and
+// the struct keeps the decrypted values. It is UpdateConfig without
+// cleanConfig and has the same requirements.
+func SaveConfig(config interface{}, path string) error {
+	return UpdateConfig(config, path)
+}
+
 // getStructVersion returns the value of the first "Version" field found in the
 // struct (top-level only), or 0 if not found or not an integer type.
 func getStructVersion(v reflect.Value) int {
@@ -1118,19 +1098,11 @@ func getStructVersion(v reflect.Value) int {
 	if v.Kind() != reflect.Struct {
 		return 0
 	}
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Name == "Version" {
-			fv := v.Field(i)
-			switch fv.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				return int(fv.Int())
-			}
-			return 0
-		}
+	info := getStructInfo(v.Type())
+	if info.versionIndex < 0 {
+		return 0
 	}
-	return 0
+	return int(v.Field(info.versionIndex).Int())
 }
 
 /*
@@ -1147,235 +1119,95 @@ func getStructVersion(v reflect.Value) int {
  * existing config files remain decryptable. Security is provided by the
  * hardware-derived input being unknowable without full machine access.
  * See securityreport.md and SECURITY.md.
+ *
+ * A KeyProvider set with SetKeyProvider replaces the hardware binding
+ * (keyprovider.go); SetKeyScope narrows the key to a user or an application
+ * (keyscope.go).
  */
-func config_init(getHardwareID_func func() (uint64, error), debugOutput bool) {
+func config_init(getHardwareID_func func() (uint64, error), debugOutput bool) error {
 	debugMode = debugOutput
 	if !initialized {
-		// Generate encryption key based on Hardware ID (deterministic by design)
-		hardwareID, err := getHardwareID_func()
+		var baseKey []byte
+		if provider := getKeyProvider(); provider != nil {
+			// An explicitly configured key provider replaces the hardware binding.
+			key, err := provider.Key()
+			if err != nil {
+				return fmt.Errorf(t("config.key_provider_failed"), err)
+			}
+			if len(key) != 32 {
+				return fmt.Errorf(t("config.key_provider_failed"), fmt.Errorf("key has %d bytes, need 32", len(key)))
+			}
+			baseKey = key
+		} else {
+			// Generate encryption key based on Hardware ID (deterministic by design)
+			// with the selected key derivation version (kdf.go).
+			key, hardwareID, err := deriveHardwareKey(currentKDF(), getHardwareID_func)
+			if err != nil {
+				return fmt.Errorf("%s: %w", t("config.hardware_id_failed"), err)
+			}
+			if debugOutput {
+				fmt.Fprintf(debugWriter, "[sconfig DEBUG] Hardware ID used for key generation: %d (0x%016x), KDF %s\n", hardwareID, hardwareID, currentKDF())
+			}
+			baseKey = key
+		}
+		scoped, err := applyKeyScope(baseKey)
 		if err != nil {
-			log.Fatalf("%s", t("config.hardware_id_failed"))
+			wipe(baseKey)
+			return err
 		}
+		// Keep only the final key, in locked memory (secmem.go).
+		keyMemMu.Lock()
+		releaseKeyBuffer(encryptionKey)
+		encryptionKey = newKeyBuffer(scoped)
+		keyMemMu.Unlock()
+		wipe(baseKey)
 		if debugOutput {
-			fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Hardware ID used for key generation: %d (0x%016x)\n", hardwareID, hardwareID)
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Encryption key (32 bytes): %x\n", encryptionKey)
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Encryption key (hex string): %s\n", fmt.Sprintf("%x", encryptionKey))
 		}
-		// Deterministic expansion: same seed => same key (required for same-machine decrypt).
-		// Use Go-1.23-compatible RNG (key_rand_go123.go) so key is stable across Go versions.
-		keyRNG := newGo123KeySource(int64(hardwareID & 0x7fffffffffffffff))
-		encryptionKey = make([]byte, 32)
-		for i := range encryptionKey {
-			encryptionKey[i] = byte(keyRNG.Int63() >> 16 & 0xff)
-		}
-		if debugOutput {
-			fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Encryption key (32 bytes): %x\n", encryptionKey)
-			fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Encryption key (hex string): %s\n", fmt.Sprintf("%x", encryptionKey))
+		markerMu.Lock()
+		PASSWORD_IS_SECURE_de = translateIn("de", "config.password_message")
+		PASSWORD_IS_SECURE_en = translateIn("en", "config.password_message")
+		lang := markerLang
+		if lang == "" {
+			lang = getCurrentLanguage()
 		}
-		curr_lang := getCurrentLanguage()
-		setLanguage("de")
-		PASSWORD_IS_SECURE_de = t("config.password_message")
-		setLanguage("en")
-		PASSWORD_IS_SECURE_en = t("config.password_message")
-		setLanguage(curr_lang)
-		PASSWORD_IS_SECURE = t("config.password_message")
+		PASSWORD_IS_SECURE = translateIn(lang, "config.password_message")
+		markerMu.Unlock()
 		if debugOutput {
-			fmt.Fprintf(os.Stderr, "[sconfig DEBUG] Password secure marker: %s\n", PASSWORD_IS_SECURE)
+			fmt.Fprintf(debugWriter, "[sconfig DEBUG] Password secure marker: %s\n", PASSWORD_IS_SECURE)
 		}
 	}
 	initialized = true
+	return nil
 }
 
 // ResetForTest clears the package-initialized state so the next LoadConfig
 // will derive the key again from the given hardware-ID function. For tests only.
 func ResetForTest() {
-	initialized = false
+	invalidateKey()
 }
 
-/*
- * Go through the structure and set the default values present
- * in the annotations
- */
-func updateDefaultValues(v reflect.Value) error {
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	if v.Kind() != reflect.Struct {
-		return nil
-	}
-	type_info := v.Type()
-	// Iterate through all fields
-	for i := 0; i < type_info.NumField(); i++ {
-		field := type_info.Field(i)
-		fieldValue := v.Field(i)
-		if field.Type.Kind() == reflect.Struct {
-			if err := updateDefaultValues(fieldValue); err != nil {
-				return fmt.Errorf(t("config.default_error"), err)
-			}
-		} else if field.Type.Kind() == reflect.Slice {
-			for i := 0; i < fieldValue.Len(); i++ {
-				if fieldValue.Index(i).Kind() == reflect.Struct {
-					if err := updateDefaultValues(fieldValue.Index(i)); err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			defaultValue, found := field.Tag.Lookup("default")
-			if found {
-				switch fieldValue.Kind() {
-				case reflect.String:
-					fieldValue.SetString(defaultValue)
-				case reflect.Int, reflect.Int64:
-					value, err := strconv.Atoi(defaultValue)
-					if err != nil {
-						return fmt.Errorf(t("config.default_error"), err)
-					}
-					fieldValue.SetInt(int64(value))
-				case reflect.Bool:
-					boolValue, err := strconv.ParseBool(defaultValue)
-					if err != nil {
-						return fmt.Errorf(t("config.default_error"), err)
-					}
-					fieldValue.SetBool(boolValue)
-				default:
-					return fmt.Errorf(t("config.default_unsupported"), fieldValue.Kind())
-				}
-			}
-		}
-	}
-	return nil
+// invalidateKey makes the next LoadConfig derive the key again.
+func invalidateKey() {
+	stateMu.Lock()
+	initialized = false
+	stateMu.Unlock()
 }
 
-/*
- * Check new content and update encrypted passwords and version as needed
- * If changes are made, the modified file will be written back at the end
- */
-func updateVersionAndPasswords(v reflect.Value, version int, changed *bool) error {
-	if v.Kind() == reflect.Ptr {
-		//fmt.Printf("Pointer\n")
-		v = v.Elem()
-	}
-	if v.Kind() != reflect.Struct {
-		return nil
-	}
-	t := v.Type()
-	// Iterate through all fields
-	for i := 0; i < t.NumField(); i++ {
-
-		field := t.Field(i)
-		fieldValue := v.Field(i)
-
-		// Process nested structures recursively
-		if field.Type.Kind() == reflect.Struct {
-			if err := updateVersionAndPasswords(fieldValue, version, changed); err != nil {
-				return err
-			}
-		} else if field.Type.Kind() == reflect.Slice {
-			//fmt.Printf("Slice[0..%d]\n", fieldValue.Len()-1)
-			for i := 0; i < fieldValue.Len(); i++ {
-				//fmt.Printf("Slice-Element %d:\n", i)
-				if fieldValue.Index(i).Kind() == reflect.Struct {
-					if err := updateVersionAndPasswords(fieldValue.Index(i), version, changed); err != nil {
-						return err
-					}
-				} else {
-					//fmt.Printf(" is '%v' (%s)\n", fieldValue.Index(i), fieldValue.Index(i).Kind().String())
-				}
-			}
-		} else {
-			// Version check
-			if field.Name == "Version" {
-				if fieldValue.Int() != int64(version) {
-					fieldValue.SetInt(int64(version))
-					*changed = true
-				}
-			}
-			// Password handling
-			if strings.HasSuffix(field.Name, "SecurePassword") {
-				pw_prefix := strings.TrimSuffix(field.Name, "SecurePassword")
-				for j := 0; j < t.NumField(); j++ {
-					if t.Field(j).Name == pw_prefix+"Password" {
-						field2Value := v.Field(j)
-						if field2Value.String() != PASSWORD_IS_SECURE_de && field2Value.String() != PASSWORD_IS_SECURE_en {
-							// New password found in plain text
-							// New Secure_Password is calculated
-							password, err := encrypt(field2Value.String())
-							if err != nil {
-								return err
-							}
-							fieldValue.SetString(password)
-							field2Value.SetString(PASSWORD_IS_SECURE)
-							//fmt.Printf(" new value %s\n", password)
-							*changed = true
-						}
-						break
-					}
-				}
-			}
-		}
+func encrypt(text string) (string, error) {
+	if recipients := getAgeRecipients(); len(recipients) > 0 {
+		return encryptAge(encryptionKey, recipients, text)
 	}
-	return nil
+	return encryptWithKey(encryptionKey, text)
 }
 
-/*
- * Decrypt the encrypted passwords so that the encryption is transparent in the main program.
- */
-func decodePasswords(v reflect.Value) error {
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	if v.Kind() != reflect.Struct {
-		return nil
-	}
-	type_info := v.Type()
-	// Iterate through all fields
-	for i := 0; i < type_info.NumField(); i++ {
-		field := type_info.Field(i)
-		fieldValue := v.Field(i)
-
-		// Process recursively nested structures
-		if field.Type.Kind() == reflect.Struct {
-			if err := decodePasswords(fieldValue); err != nil {
-				return err
-			}
-		} else if field.Type.Kind() == reflect.Slice {
-			for i := 0; i < fieldValue.Len(); i++ {
-				if fieldValue.Index(i).Kind() == reflect.Struct {
-					if err := decodePasswords(fieldValue.Index(i)); err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			// Password processing
-			if strings.HasSuffix(field.Name, "SecurePassword") {
-				pw_prefix := strings.TrimSuffix(field.Name, "SecurePassword")
-				for j := 0; j < type_info.NumField(); j++ {
-					if type_info.Field(j).Name == pw_prefix+"Password" {
-						field2Value := v.Field(j)
-						password, err := decrypt(fieldValue.String())
-						if err != nil {
-							if debugMode {
-								writeDebugLog(lastDebugHardwareID, lastDebugIdentifiers, false)
-							}
-							// Always show a field name (use translated fallback if prefix empty)
-							fieldName := pw_prefix
-							if fieldName == "" {
-								fieldName = t("config.unknown_password_field")
-							}
-							return fmt.Errorf("%s", t("config.decrypt_failed", fieldName, err))
-						}
-						field2Value.SetString(password)
-						break
-					}
-				}
-			}
-		}
-	}
-	return nil
+func decrypt(text string) (string, error) {
+	return decryptWithKey(encryptionKey, text)
 }
 
-func encrypt(text string) (string, error) {
-	block, err := aes.NewCipher(encryptionKey)
+func encryptWithKey(key []byte, text string) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("encrypt: cipher init: %w", err)
 	}
@@ -1389,24 +1221,42 @@ func encrypt(text string) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-func decrypt(text string) (string, error) {
-	block, err := aes.NewCipher(encryptionKey)
+func decryptWithKey(key []byte, text string) (string, error) {
+	plaintext, err := decryptBytesWithKey(key, text)
+	defer wipe(plaintext)
+	return string(plaintext), err
+}
+
+// decryptBytes decrypts text into a new buffer owned by the caller, so that
+// the plaintext never exists as a string (unless text is an age ciphertext).
+func decryptBytes(text string) ([]byte, error) {
+	return decryptBytesWithKey(encryptionKey, text)
+}
+
+func decryptBytesWithKey(key []byte, text string) ([]byte, error) {
+	if isAgeValue(text) {
+		plaintext, err := decryptAge(key, text)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(plaintext), nil
+	}
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", fmt.Errorf("decrypt: cipher init: %w", err)
+		return nil, fmt.Errorf("decrypt: cipher init: %w", err)
 	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", fmt.Errorf("decrypt: GCM init: %w", err)
+		return nil, fmt.Errorf("decrypt: GCM init: %w", err)
 	}
 	data, err := base64.StdEncoding.DecodeString(text)
 	if err != nil {
-		return "", fmt.Errorf("decrypt: invalid base64: %w", err)
+		return nil, fmt.Errorf("decrypt: invalid base64: %w", err)
 	}
 	nonceSize := gcm.NonceSize()
 	if len(data) < nonceSize {
-		return "", fmt.Errorf("decrypt: ciphertext too short (need at least %d bytes)", nonceSize)
+		return nil, fmt.Errorf("decrypt: ciphertext too short (need at least %d bytes)", nonceSize)
 	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	return string(plaintext), err
+	return gcm.Open(nil, nonce, ciphertext, nil)
 }
@@ -0,0 +1,220 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newWideConfigType builds a struct type with a Version field, the given number
+// of plain fields (string/int/bool in turn, all with default tags) and the given
+// number of <Name>Password/<Name>SecurePassword pairs. It stands in for the
+// generated configs with thousands of fields seen in the field.
+func newWideConfigType(fields, pairs int) reflect.Type {
+	sf := []reflect.StructField{{
+		Name: "Version",
+		Type: reflect.TypeOf(0),
+		Tag:  `json:"version"`,
+	}}
+	for i := 0; i < fields; i++ {
+		f := reflect.StructField{Name: fmt.Sprintf("Field%d", i)}
+		switch i % 3 {
+		case 0:
+			f.Type = reflect.TypeOf("")
+			f.Tag = reflect.StructTag(fmt.Sprintf(`json:"field_%d" default:"value-%d"`, i, i))
+		case 1:
+			f.Type = reflect.TypeOf(0)
+			f.Tag = reflect.StructTag(fmt.Sprintf(`json:"field_%d" default:"%d"`, i, i))
+		default:
+			f.Type = reflect.TypeOf(false)
+			f.Tag = reflect.StructTag(fmt.Sprintf(`json:"field_%d" default:"true"`, i))
+		}
+		sf = append(sf, f)
+	}
+	for i := 0; i < pairs; i++ {
+		sf = append(sf,
+			reflect.StructField{
+				Name: fmt.Sprintf("Secret%dPassword", i),
+				Type: reflect.TypeOf(""),
+				Tag:  reflect.StructTag(fmt.Sprintf(`json:"secret_%d_password"`, i)),
+			},
+			reflect.StructField{
+				Name: fmt.Sprintf("Secret%dSecurePassword", i),
+				Type: reflect.TypeOf(""),
+				Tag:  reflect.StructTag(fmt.Sprintf(`json:"secret_%d_secure_password"`, i)),
+			})
+	}
+	return reflect.StructOf(sf)
+}
+
+// newDeepConfigType builds a type in which every level holds a password pair
+// and a slice of the next level, depth levels deep.
+func newDeepConfigType(depth int) reflect.Type {
+	level := reflect.StructOf([]reflect.StructField{
+		{Name: "Name", Type: reflect.TypeOf(""), Tag: `json:"name" default:"leaf"`},
+		{Name: "DBPassword", Type: reflect.TypeOf(""), Tag: `json:"db_password"`},
+		{Name: "DBSecurePassword", Type: reflect.TypeOf(""), Tag: `json:"db_secure_password"`},
+	})
+	for d := 1; d < depth; d++ {
+		level = reflect.StructOf([]reflect.StructField{
+			{Name: "Name", Type: reflect.TypeOf(""), Tag: `json:"name" default:"node"`},
+			{Name: "DBPassword", Type: reflect.TypeOf(""), Tag: `json:"db_password"`},
+			{Name: "DBSecurePassword", Type: reflect.TypeOf(""), Tag: `json:"db_secure_password"`},
+			{Name: "Children", Type: reflect.SliceOf(level), Tag: `json:"children"`},
+		})
+	}
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "Version", Type: reflect.TypeOf(0), Tag: `json:"version"`},
+		{Name: "Root", Type: level, Tag: `json:"root"`},
+	})
+}
+
+// newWideConfig returns a pointer to a new wide config with all passwords set
+// to plaintext values.
+func newWideConfig(fields, pairs int) interface{} {
+	cfg := reflect.New(newWideConfigType(fields, pairs))
+	for i := 0; i < pairs; i++ {
+		cfg.Elem().FieldByName(fmt.Sprintf("Secret%dPassword", i)).SetString(fmt.Sprintf("secret-%d", i))
+	}
+	return cfg.Interface()
+}
+
+// newDeepConfig returns a pointer to a new deep config with width children on
+// every level and plaintext passwords everywhere.
+func newDeepConfig(depth, width int) interface{} {
+	cfg := reflect.New(newDeepConfigType(depth))
+	fillDeepLevel(cfg.Elem().Field(1), width)
+	return cfg.Interface()
+}
+
+func fillDeepLevel(v reflect.Value, width int) {
+	v.Field(1).SetString("deep-secret")
+	if v.NumField() < 4 {
+		return
+	}
+	children := reflect.MakeSlice(v.Field(3).Type(), width, width)
+	for i := 0; i < width; i++ {
+		fillDeepLevel(children.Index(i), width)
+	}
+	v.Field(3).Set(children)
+}
+
+// countDeepPasswords walks a deep config and counts the decrypted passwords.
+func countDeepPasswords(v reflect.Value, want string) int {
+	n := 0
+	if v.Field(1).String() == want {
+		n++
+	}
+	if v.NumField() < 4 {
+		return n
+	}
+	for i := 0; i < v.Field(3).Len(); i++ {
+		n += countDeepPasswords(v.Field(3).Index(i), want)
+	}
+	return n
+}
+
+func TestLoadConfig_HugeConfig(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+
+	ts.Run("Thousands of fields", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "wide.json")
+		cfg := newWideConfig(3000, 300)
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		v := reflect.ValueOf(cfg).Elem()
+		for i := 0; i < 300; i++ {
+			got := v.FieldByName(fmt.Sprintf("Secret%dPassword", i)).String()
+			if got != fmt.Sprintf("secret-%d", i) {
+				ts.Fatalf("Secret%dPassword: expected decrypted value, got %q", i, got)
+			}
+		}
+		raw, err := os.ReadFile(configPath)
+		if err != nil {
+			ts.Fatalf("ReadFile failed: %v", err)
+		}
+		if strings.Contains(string(raw), "secret-1\"") {
+			ts.Error("plaintext password written to file")
+		}
+
+		// Reload from the written file: all defaults are overwritten by the file
+		// content and every secret must decrypt again.
+		cfg2 := reflect.New(v.Type()).Interface()
+		if err := LoadConfig(cfg2, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig (reload) failed: %v", err)
+		}
+		if got := reflect.ValueOf(cfg2).Elem().FieldByName("Secret299Password").String(); got != "secret-299" {
+			ts.Errorf("reload: expected secret-299, got %q", got)
+		}
+	})
+
+	ts.Run("Deeply nested slices", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "deep.json")
+		cfg := newDeepConfig(5, 4)
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		// 1 + 4 + 16 + 64 + 256 entries over five levels
+		if n := countDeepPasswords(reflect.ValueOf(cfg).Elem().Field(1), "deep-secret"); n != 341 {
+			ts.Errorf("expected 341 decrypted passwords, got %d", n)
+		}
+	})
+}
+
+func BenchmarkLoadConfig_ThousandsOfFields(b *testing.B) {
+	tempDir := testExeRoot(b)
+	configPath := filepath.Join(tempDir, "benchmark_wide.json")
+	if err := LoadConfig(newWideConfig(5000, 500), 1, configPath, false, false); err != nil {
+		b.Fatalf("LoadConfig failed: %v", err)
+	}
+	typ := newWideConfigType(5000, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := reflect.New(typ).Interface()
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			b.Fatalf("LoadConfig failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkLoadConfig_DeepSlices(b *testing.B) {
+	tempDir := testExeRoot(b)
+	configPath := filepath.Join(tempDir, "benchmark_deep.json")
+	if err := LoadConfig(newDeepConfig(5, 4), 1, configPath, false, false); err != nil {
+		b.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := reflect.New(newDeepConfigType(5)).Interface()
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			b.Fatalf("LoadConfig failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkWalkers_ThousandsOfFields measures the reflection walkers alone
+// (no file I/O, no JSON, no crypto) on a config with thousands of fields.
+func BenchmarkWalkers_ThousandsOfFields(b *testing.B) {
+	cfg := newWideConfig(5000, 0)
+	v := reflect.ValueOf(cfg)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := updateDefaultValues(v); err != nil {
+			b.Fatal(err)
+		}
+		changed := false
+		if err := updateVersionAndPasswords(v, 1, &changed); err != nil {
+			b.Fatal(err)
+		}
+		if err := decodePasswords(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,14 +1,19 @@
 package sconfig
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // testExeRoot returns a temp directory and pins it as the executable root for
@@ -47,6 +52,28 @@ type TestSliceConfig struct {
 	Servers []TestConfig `json:"servers"`
 }
 
+// TestMapConfig holds structs in maps, by value and by pointer.
+type TestMapConfig struct {
+	Version int                    `json:"version" default:"3"`
+	Servers map[string]TestConfig  `json:"servers"`
+	Mirrors map[string]*TestConfig `json:"mirrors"`
+}
+
+// TestPointerConfig holds structs behind pointers.
+type TestPointerConfig struct {
+	Version int           `json:"version" default:"3"`
+	Primary *TestConfig   `json:"primary"`
+	Replica *TestConfig   `json:"replica"`
+	Chain   *TestPtrNode  `json:"chain"`
+	Pool    []*TestConfig `json:"pool"`
+}
+
+// TestPtrNode is a recursive type with defaults.
+type TestPtrNode struct {
+	Name string       `json:"name" default:"node"`
+	Next *TestPtrNode `json:"next"`
+}
+
 // UpdateConfigTestConfig is used for UpdateConfig tests (Theme, different path).
 type UpdateConfigTestConfig struct {
 	Version          int    `json:"version"`
@@ -323,6 +350,86 @@ func TestLoadConfig_SliceStructures(ts *testing.T) {
 	})
 }
 
+func TestLoadConfig_MapStructures(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "map_config.json")
+	config := &TestMapConfig{
+		Servers: map[string]TestConfig{"primary": {DatabasePassword: "primary-password"}},
+		Mirrors: map[string]*TestConfig{"backup": {DatabasePassword: "backup-password"}},
+	}
+	if err := LoadConfig(config, 3, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	if strings.Contains(string(raw), "primary-password") || strings.Contains(string(raw), "backup-password") {
+		ts.Errorf("plaintext password of a map entry written:\n%s", raw)
+	}
+
+	reloaded := &TestMapConfig{}
+	if err := LoadConfig(reloaded, 3, configPath, false, false); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	primary, backup := reloaded.Servers["primary"], reloaded.Mirrors["backup"]
+	if primary.DatabasePassword != "primary-password" || primary.DatabaseSecurePassword == "" {
+		ts.Errorf("Servers[primary] = %+v", primary)
+	}
+	if backup == nil || backup.DatabasePassword != "backup-password" {
+		ts.Fatalf("Mirrors[backup] = %+v", backup)
+	}
+	if primary.Version != 3 || backup.Version != 3 {
+		ts.Errorf("Version of map entries not synced: %d, %d", primary.Version, backup.Version)
+	}
+	if primary.DatabaseHost != "localhost" || backup.DatabasePort != 5432 {
+		ts.Errorf("defaults not applied to map entries: %+v, %+v", primary, backup)
+	}
+}
+
+func TestLoadConfig_PointerStructures(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "pointer_config.json")
+	config := &TestPointerConfig{
+		Primary: &TestConfig{DatabasePassword: "primary-password"},
+		Pool:    []*TestConfig{{DatabasePassword: "pool-password"}, nil},
+	}
+	if err := LoadConfig(config, 3, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	if strings.Contains(string(raw), "primary-password") || strings.Contains(string(raw), "pool-password") {
+		ts.Errorf("plaintext password behind a pointer written:\n%s", raw)
+	}
+	// Nil pointers to structs with defaults are allocated, recursive types once.
+	if config.Replica == nil || config.Replica.DatabaseHost != "localhost" {
+		ts.Errorf("Replica = %+v", config.Replica)
+	}
+	if config.Chain == nil || config.Chain.Name != "node" || config.Chain.Next != nil {
+		ts.Errorf("Chain = %+v", config.Chain)
+	}
+
+	reloaded := &TestPointerConfig{}
+	if err := LoadConfig(reloaded, 3, configPath, false, false); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.Primary.DatabasePassword != "primary-password" || reloaded.Primary.DatabaseSecurePassword == "" {
+		ts.Errorf("Primary = %+v", reloaded.Primary)
+	}
+	if reloaded.Primary.Version != 3 {
+		ts.Errorf("Version behind a pointer not synced: %d", reloaded.Primary.Version)
+	}
+	if len(reloaded.Pool) != 2 || reloaded.Pool[1] != nil {
+		ts.Fatalf("Pool = %+v", reloaded.Pool)
+	}
+	if pool := reloaded.Pool[0]; pool.DatabasePassword != "pool-password" || pool.DatabaseSecurePassword == "" || pool.Version != 3 {
+		ts.Errorf("Pool[0] = %+v", pool)
+	}
+}
+
 func TestLoadConfig_ErrorCases(ts *testing.T) {
 	tempDir := testExeRoot(ts)
 
@@ -1065,3 +1172,189 @@ func BenchmarkLoadConfig_WithExistingFile(b *testing.B) {
 		}
 	}
 }
+
+func TestSaveConfig_RuntimePasswordChange(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "save_config.json")
+	cfg := &UpdateConfigTestConfig{Version: 1, Theme: "dark", DBPassword: "old-secret"}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	cfg.Theme = "light"
+	cfg.DBPassword = "new-secret"
+	if err := SaveConfig(cfg, configPath); err != nil {
+		ts.Fatalf("SaveConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "new-secret") || !strings.Contains(string(raw), `"theme": "light"`) {
+		ts.Errorf("unexpected file content:\n%s", raw)
+	}
+	if cfg.DBPassword != "new-secret" {
+		ts.Errorf("expected in-memory password to stay decrypted, got %q", cfg.DBPassword)
+	}
+	reloaded := &UpdateConfigTestConfig{}
+	if err := LoadConfig(reloaded, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if reloaded.DBPassword != "new-secret" || reloaded.Theme != "light" {
+		ts.Errorf("runtime changes not persisted: %+v", reloaded)
+	}
+}
+
+func TestLoadConfig_Concurrent(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	hardwareID := func() (uint64, error) { return 4711, nil }
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := filepath.Join(tempDir, fmt.Sprintf("parallel-%d.json", i))
+			cfg := &TestConfig{DatabasePassword: fmt.Sprintf("secret-%d", i)}
+			for round := 0; round < 3; round++ {
+				if err := LoadConfig(cfg, 1, path, false, false, hardwareID); err != nil {
+					errs <- err
+					return
+				}
+				if cfg.DatabasePassword != fmt.Sprintf("secret-%d", i) {
+					errs <- fmt.Errorf("file %d: got password %q", i, cfg.DatabasePassword)
+					return
+				}
+				if err := UpdateConfig(cfg, path); err != nil {
+					errs <- err
+					return
+				}
+				_ = Clone(cfg)
+				_ = t("config.password_message")
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		ts.Error(err)
+	}
+}
+
+func TestUpdateDefaultValues_Numbers(ts *testing.T) {
+	type numbers struct {
+		Ratio  float64 `default:"0.75"`
+		Scale  float32 `default:"1.5"`
+		Port   uint16  `default:"8080"`
+		Limit  uint64  `default:"18446744073709551615"`
+		Small  int8    `default:"-12"`
+		Offset int32   `default:"-70000"`
+		Count  int     `default:"42"`
+	}
+	cfg := &numbers{}
+	if err := updateDefaultValues(reflect.ValueOf(cfg).Elem()); err != nil {
+		ts.Fatalf("updateDefaultValues failed: %v", err)
+	}
+	want := numbers{Ratio: 0.75, Scale: 1.5, Port: 8080, Limit: 1<<64 - 1, Small: -12, Offset: -70000, Count: 42}
+	if *cfg != want {
+		ts.Errorf("defaults = %+v, want %+v", *cfg, want)
+	}
+
+	type overflow struct {
+		Port uint16 `default:"70000"`
+	}
+	if err := updateDefaultValues(reflect.ValueOf(&overflow{}).Elem()); err == nil || !strings.Contains(err.Error(), "Port") {
+		ts.Errorf("expected an error naming the field, got %v", err)
+	}
+}
+
+func TestUpdateDefaultValues_Slices(ts *testing.T) {
+	type entry struct {
+		Name string `default:"unnamed"`
+	}
+	type lists struct {
+		Hosts   []string        `default:"a.example, b.example,c.example"`
+		Ports   []int           `default:"80,443"`
+		Weights []float64       `default:"0.5;1.5" sep:";"`
+		Waits   []time.Duration `default:"1s,5s"`
+		Empty   []string        `default:""`
+		Entries []entry
+		Plain   []string
+	}
+	cfg := &lists{Entries: []entry{{}}}
+	if err := updateDefaultValues(reflect.ValueOf(cfg).Elem()); err != nil {
+		ts.Fatalf("updateDefaultValues failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Hosts, []string{"a.example", "b.example", "c.example"}) ||
+		!reflect.DeepEqual(cfg.Ports, []int{80, 443}) ||
+		!reflect.DeepEqual(cfg.Weights, []float64{0.5, 1.5}) ||
+		!reflect.DeepEqual(cfg.Waits, []time.Duration{time.Second, 5 * time.Second}) {
+		ts.Errorf("slice defaults = %+v", cfg)
+	}
+	if cfg.Empty == nil || len(cfg.Empty) != 0 || cfg.Plain != nil {
+		ts.Errorf("empty default = %#v, untagged = %#v", cfg.Empty, cfg.Plain)
+	}
+	if len(cfg.Entries) != 1 || cfg.Entries[0].Name != "unnamed" {
+		ts.Errorf("struct slice changed: %+v", cfg.Entries)
+	}
+
+	type invalid struct {
+		Ports []int `default:"80,http"`
+	}
+	if err := updateDefaultValues(reflect.ValueOf(&invalid{}).Elem()); err == nil || !strings.Contains(err.Error(), "Ports") {
+		ts.Errorf("expected an error naming the field, got %v", err)
+	}
+	type unsupported struct {
+		Refs []*int `default:"1"`
+	}
+	if err := updateDefaultValues(reflect.ValueOf(&unsupported{}).Elem()); err == nil {
+		ts.Error("a default on []*int was accepted")
+	}
+}
+
+type binaryPairTestConfig struct {
+	Version           int    `json:"version"`
+	KeyPassword       []byte `json:"key_password"`
+	KeySecurePassword string `json:"key_secure_password"`
+}
+
+func TestLoadConfig_BinaryPair(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "binary.json")
+	der := []byte{0x30, 0x82, 0x01, 0x0a, 0x02, 0x82, 0x01, 0x01, 0x00, 0xff}
+	cfg := &binaryPairTestConfig{KeyPassword: der}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !bytes.Equal(cfg.KeyPassword, der) {
+		ts.Errorf("KeyPassword = %x", cfg.KeyPassword)
+	}
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	if doc["key_password"] != nil || doc["key_secure_password"] == "" {
+		ts.Errorf("binary password not encrypted:\n%s", raw)
+	}
+
+	reloaded := &binaryPairTestConfig{}
+	if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if !bytes.Equal(reloaded.KeyPassword, der) {
+		ts.Errorf("reloaded KeyPassword = %x", reloaded.KeyPassword)
+	}
+	unchanged, _ := os.ReadFile(configPath)
+	if !bytes.Equal(unchanged, raw) {
+		ts.Error("loading an encrypted binary password rewrote the file")
+	}
+
+	clone := Clone(reloaded).(*binaryPairTestConfig)
+	if clone.KeyPassword != nil || clone.KeySecurePassword != reloaded.KeySecurePassword {
+		ts.Errorf("clone = %+v", clone)
+	}
+	plain := Clone(reloaded, MergePlaintext).(*binaryPairTestConfig)
+	if !bytes.Equal(plain.KeyPassword, der) {
+		ts.Errorf("plaintext clone = %+v", plain)
+	}
+}
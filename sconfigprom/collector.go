@@ -0,0 +1,57 @@
+// Package sconfigprom exports the state of sconfig Loaders as Prometheus
+// metrics. It is a separate module so that sconfig itself does not depend on
+// the Prometheus client library.
+package sconfigprom
+
+import (
+	"github.com/janmz/sconfig/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	versionDesc = prometheus.NewDesc("config_version",
+		"Version of the loaded config.", []string{"path"}, nil)
+	lastReloadDesc = prometheus.NewDesc("config_last_reload_timestamp",
+		"Unix time of the last successful load or refresh of the config.", []string{"path"}, nil)
+	secretCountDesc = prometheus.NewDesc("config_secret_count",
+		"Number of encrypted passwords and secret references in the config.", []string{"path"}, nil)
+	decryptFailuresDesc = prometheus.NewDesc("config_decrypt_failures_total",
+		"Password fields that could not be decrypted since the process started.", nil, nil)
+	lastGoodFallbacksDesc = prometheus.NewDesc("config_last_good_fallbacks_total",
+		"Loads that failed and used the last good copy of the config file since the process started.", nil, nil)
+)
+
+// Collector is a prometheus.Collector for one or more sconfig Loaders.
+type Collector struct {
+	loaders []*sconfig.Loader
+}
+
+// NewCollector returns a collector reporting the given Loaders. Register it
+// once with prometheus.MustRegister.
+func NewCollector(loaders ...*sconfig.Loader) *Collector {
+	return &Collector{loaders: loaders}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- versionDesc
+	ch <- lastReloadDesc
+	ch <- secretCountDesc
+	ch <- decryptFailuresDesc
+	ch <- lastGoodFallbacksDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, l := range c.loaders {
+		status := l.Health()
+		ch <- prometheus.MustNewConstMetric(versionDesc, prometheus.GaugeValue, float64(status.Version), status.Path)
+		if status.LoadedAt != nil {
+			ch <- prometheus.MustNewConstMetric(lastReloadDesc, prometheus.GaugeValue,
+				float64(status.LoadedAt.UnixNano())/1e9, status.Path)
+		}
+		ch <- prometheus.MustNewConstMetric(secretCountDesc, prometheus.GaugeValue, float64(status.Secrets), status.Path)
+	}
+	ch <- prometheus.MustNewConstMetric(decryptFailuresDesc, prometheus.CounterValue, float64(sconfig.DecryptFailures()))
+	ch <- prometheus.MustNewConstMetric(lastGoodFallbacksDesc, prometheus.CounterValue, float64(sconfig.LastGoodFallbacks()))
+}
@@ -0,0 +1,51 @@
+package sconfigprom
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/janmz/sconfig/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testConfig struct {
+	Version          int    `json:"version"`
+	DBPassword       string `json:"db_password"`
+	DBSecurePassword string `json:"db_secure_password"`
+}
+
+func TestCollector(ts *testing.T) {
+	dir := ts.TempDir()
+	sconfig.SetExecutableRootForTest(dir)
+	defer sconfig.SetExecutableRootForTest("")
+	l := sconfig.NewLoader(&testConfig{DBPassword: "secret"}, filepath.Join(dir, "config.json"), 4)
+	defer l.Close()
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(l))
+	families, err := reg.Gather()
+	if err != nil {
+		ts.Fatalf("Gather failed: %v", err)
+	}
+	values := map[string]float64{}
+	for _, f := range families {
+		m := f.GetMetric()[0]
+		if m.GetGauge() != nil {
+			values[f.GetName()] = m.GetGauge().GetValue()
+		} else {
+			values[f.GetName()] = m.GetCounter().GetValue()
+		}
+	}
+	if values["config_version"] != 4 || values["config_secret_count"] != 1 || values["config_last_reload_timestamp"] == 0 {
+		ts.Errorf("unexpected metrics: %v", values)
+	}
+	if _, ok := values["config_decrypt_failures_total"]; !ok {
+		ts.Errorf("missing config_decrypt_failures_total: %v", values)
+	}
+	if _, ok := values["config_last_good_fallbacks_total"]; !ok {
+		ts.Errorf("missing config_last_good_fallbacks_total: %v", values)
+	}
+}
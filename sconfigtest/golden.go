@@ -0,0 +1,60 @@
+// Package sconfigtest provides helpers for testing programs that use sconfig.
+package sconfigtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/janmz/sconfig/v2"
+)
+
+// UpdateEnv is the environment variable that makes Golden write the golden
+// files instead of comparing with them, e.g. SCONFIG_UPDATE_GOLDEN=1 go test.
+const UpdateEnv = "SCONFIG_UPDATE_GOLDEN"
+
+// Golden compares config (a pointer to a struct) with the golden file at
+// path. The config is serialized with sconfig.RedactedJSON, so the file holds
+// stable placeholders instead of passwords and ciphertexts. A difference
+// fails t, naming the first line that differs. With UpdateEnv set, the file
+// is written instead.
+func Golden(t testing.TB, config interface{}, path string) {
+	t.Helper()
+	got, err := sconfig.RedactedJSON(config)
+	if err != nil {
+		t.Fatalf("sconfigtest: %v", err)
+	}
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("sconfigtest: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("sconfigtest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("sconfigtest: %v (set %s=1 to create it)", err, UpdateEnv)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines := strings.Split(string(got), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("sconfigtest: config differs from %s at line %d:\n got: %s\nwant: %s\n(set %s=1 to update)", path, i+1, g, w, UpdateEnv)
+			return
+		}
+	}
+}
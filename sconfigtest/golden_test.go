@@ -0,0 +1,56 @@
+package sconfigtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type goldenConfig struct {
+	Host             string `json:"host"`
+	DBPassword       string `json:"db_password"`
+	DBSecurePassword string `json:"db_secure_password"`
+}
+
+// recorder records the failures of Golden instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestGolden(ts *testing.T) {
+	path := filepath.Join(ts.TempDir(), "testdata", "config.golden.json")
+	cfg := &goldenConfig{Host: "db.example.com", DBPassword: "secret"}
+
+	ts.Setenv(UpdateEnv, "1")
+	Golden(ts, cfg, path)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		ts.Fatalf("golden file not written: %v", err)
+	}
+	if strings.Contains(string(raw), "secret\"") {
+		ts.Errorf("golden file contains the password:\n%s", raw)
+	}
+
+	ts.Setenv(UpdateEnv, "")
+	Golden(ts, cfg, path)
+
+	cfg.Host = "db.example.org"
+	r := &recorder{TB: ts}
+	Golden(r, cfg, path)
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "db.example.org") {
+		ts.Errorf("expected a difference on the host, got %q", r.errors)
+	}
+	cfg.Host, cfg.DBPassword = "db.example.com", "changed"
+	r = &recorder{TB: ts}
+	Golden(r, cfg, path)
+	if len(r.errors) != 1 {
+		ts.Errorf("a changed password was not reported: %q", r.errors)
+	}
+}
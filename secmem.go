@@ -0,0 +1,61 @@
+package sconfig
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+ * Protected key memory.
+ *
+ * The master key lives in a buffer whose pages are locked in RAM (mlock on
+ * Unix, VirtualLock on Windows) where the OS allows it, so it does not end up
+ * in swap. Locking is best effort: without the privilege or with a too small
+ * RLIMIT_MEMLOCK the key stays usable in normal memory. Close wipes and
+ * unlocks the key. Decrypted plaintext buffers are wiped right after they have
+ * been copied into the config; the resulting Go strings in the config struct
+ * are managed by the garbage collector and cannot be locked or wiped.
+ */
+
+var keyMemMu sync.Mutex
+
+// newKeyBuffer returns a copy of key in a locked buffer and wipes key.
+func newKeyBuffer(key []byte) []byte {
+	buf := make([]byte, len(key))
+	if err := lockMemory(buf); err != nil && debugMode {
+		fmt.Fprintf(debugWriter, "[sconfig DEBUG] Key memory not locked: %v\n", err)
+	}
+	copy(buf, key)
+	wipe(key)
+	return buf
+}
+
+// releaseKeyBuffer wipes and unlocks a buffer from newKeyBuffer.
+func releaseKeyBuffer(buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	wipe(buf)
+	_ = unlockMemory(buf)
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	clear(b)
+}
+
+// Close wipes the encryption key from memory and forgets the values remembered
+// for secret masking. The next LoadConfig derives the key again; UpdateConfig
+// returns an error until then. Intended for defer in main().
+func Close() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	forgetPassphraseKey()
+	keyMemMu.Lock()
+	defer keyMemMu.Unlock()
+	releaseKeyBuffer(encryptionKey)
+	encryptionKey = nil
+	initialized = false
+	forgetSecrets()
+	return nil
+}
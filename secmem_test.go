@@ -0,0 +1,54 @@
+package sconfig
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewKeyBuffer(ts *testing.T) {
+	key := bytes.Repeat([]byte{0xAB}, 32)
+	buf := newKeyBuffer(key)
+	if !bytes.Equal(buf, bytes.Repeat([]byte{0xAB}, 32)) {
+		ts.Error("key buffer must hold a copy of the key")
+	}
+	if !bytes.Equal(key, make([]byte, 32)) {
+		ts.Error("source key must be wiped")
+	}
+	releaseKeyBuffer(buf)
+	if !bytes.Equal(buf, make([]byte, 32)) {
+		ts.Error("released key buffer must be wiped")
+	}
+}
+
+func TestClose(ts *testing.T) {
+	ResetForTest()
+	tempDir := testExeRoot(ts)
+	hw := func() (uint64, error) { return 1226, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	cfg := &TestConfig{DatabasePassword: "close-secret"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	key := encryptionKey
+	if err := Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.Equal(key, make([]byte, len(key))) {
+		ts.Error("Close must wipe the encryption key")
+	}
+	if err := UpdateConfig(cfg, configPath); err == nil || !contains(err.Error(), t("config.load_first")) {
+		ts.Errorf("expected config.load_first after Close, got: %v", err)
+	}
+	// LoadConfig derives the key again.
+	cfg2 := &TestConfig{}
+	if err := LoadConfig(cfg2, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig after Close failed: %v", err)
+	}
+	if cfg2.DatabasePassword != "close-secret" {
+		ts.Errorf("expected decrypted password after Close, got %q", cfg2.DatabasePassword)
+	}
+	if err := Close(); err != nil {
+		ts.Errorf("second Close failed: %v", err)
+	}
+}
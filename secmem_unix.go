@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package sconfig
+
+import "golang.org/x/sys/unix"
+
+func lockMemory(b []byte) error {
+	return unix.Mlock(b)
+}
+
+func unlockMemory(b []byte) error {
+	return unix.Munlock(b)
+}
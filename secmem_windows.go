@@ -0,0 +1,36 @@
+//go:build windows
+
+package sconfig
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32          = syscall.NewLazyDLL("kernel32.dll")
+	procVirtualLock   = kernel32.NewProc("VirtualLock")
+	procVirtualUnlock = kernel32.NewProc("VirtualUnlock")
+)
+
+func lockMemory(b []byte) error {
+	return callMemProc(procVirtualLock, b)
+}
+
+func unlockMemory(b []byte) error {
+	return callMemProc(procVirtualUnlock, b)
+}
+
+func callMemProc(proc *syscall.LazyProc, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := proc.Find(); err != nil {
+		return err
+	}
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package sconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+/*
+ * Secret files.
+ *
+ * Some programs (database clients, sidecars, legacy tools) read credentials
+ * only from files. MountSecrets writes selected decrypted values of a loaded
+ * config into read-only files (0400) in a private directory (0700), by
+ * default on a memory-backed file system so the plaintext never reaches a
+ * disk: $XDG_RUNTIME_DIR or /dev/shm on Linux. Other systems have no such
+ * standard location and fall back to the temporary directory; pass a ramdisk
+ * explicitly there. Close removes the files again.
+ */
+
+// secretMounts are the files written by MountSecrets.
+type secretMounts struct {
+	dir   string // removed on Close if created by MountSecrets
+	files []string
+}
+
+// MountSecrets writes the values of the given string fields of the loaded
+// config to one file each in dir and returns the file path per field. Fields
+// are named by their Go path, e.g. "Password" or "Database.Password"; the
+// file is named after the path. An empty dir selects a new private directory
+// on a memory-backed file system. The files are removed by Close.
+func (l *Loader) MountSecrets(dir string, fields ...string) (map[string]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, fmt.Errorf("%s", t("config.loader_closed"))
+	}
+	if l.loadedAt.IsZero() {
+		return nil, fmt.Errorf("%s", t("config.load_first"))
+	}
+	values := make(map[string]reflect.Value, len(fields))
+	for _, name := range fields {
+		v, ok := fieldByPath(reflect.ValueOf(l.config), name)
+		if !ok {
+			return nil, fmt.Errorf("%s", t("config.mount_unknown_field", name))
+		}
+		values[name] = v
+	}
+	if dir == "" {
+		if l.mounts.dir == "" {
+			created, err := os.MkdirTemp(secretMountBase(), "sconfig-secrets-")
+			if err != nil {
+				return nil, fmt.Errorf("%s", t("config.mount_failed", err))
+			}
+			l.mounts.dir = created
+		}
+		dir = l.mounts.dir
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("%s", t("config.mount_failed", err))
+	}
+	paths := make(map[string]string, len(fields))
+	for _, name := range fields {
+		path := filepath.Join(dir, name)
+		if err := writeSecretFile(path, values[name].String()); err != nil {
+			return nil, fmt.Errorf("%s", t("config.mount_failed", err))
+		}
+		l.mounts.files = append(l.mounts.files, path)
+		paths[name] = path
+	}
+	return paths, nil
+}
+
+// unmountSecrets removes the files written by MountSecrets.
+func (l *Loader) unmountSecrets() {
+	for _, path := range l.mounts.files {
+		os.Remove(path)
+	}
+	if l.mounts.dir != "" {
+		os.Remove(l.mounts.dir)
+	}
+	l.mounts = secretMounts{}
+}
+
+// writeSecretFile replaces the file at path with a read-only file holding value.
+func writeSecretFile(path, value string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+	buf := []byte(value)
+	_, err = f.Write(buf)
+	wipe(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// secretMountBase returns the memory-backed directory for MountSecrets.
+func secretMountBase() string {
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			return dir
+		}
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			return "/dev/shm"
+		}
+	}
+	return os.TempDir()
+}
+
+// fieldByPath returns the string field of the struct v (or pointer to it)
+// named by the dot-separated Go field path.
+func fieldByPath(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok || !f.IsExported() {
+			return reflect.Value{}, false
+		}
+		v = v.FieldByIndex(f.Index)
+	}
+	return v, v.Kind() == reflect.String
+}
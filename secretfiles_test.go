@@ -0,0 +1,51 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoader_MountSecrets(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	cfg := &NestedTestConfig{}
+	cfg.MainConfig.DatabasePassword = "mounted-secret"
+	l := NewLoader(cfg, filepath.Join(tempDir, "mount.json"), 1)
+	defer l.Close()
+	if _, err := l.MountSecrets("", "MainConfig.DatabasePassword"); err == nil || !contains(err.Error(), t("config.load_first")) {
+		ts.Fatalf("expected load error, got %v", err)
+	}
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if _, err := l.MountSecrets("", "MainConfig.Missing"); err == nil || !contains(err.Error(), t("config.mount_unknown_field", "MainConfig.Missing")) {
+		ts.Fatalf("expected unknown field error, got %v", err)
+	}
+
+	paths, err := l.MountSecrets("", "MainConfig.DatabasePassword")
+	if err != nil {
+		ts.Fatalf("MountSecrets failed: %v", err)
+	}
+	path := paths["MainConfig.DatabasePassword"]
+	if data, err := os.ReadFile(path); err != nil || string(data) != "mounted-secret" {
+		ts.Fatalf("unexpected secret file %q: %q, %v", path, data, err)
+	}
+	if filepath.Separator == '/' {
+		info, _ := os.Stat(path)
+		dirInfo, _ := os.Stat(filepath.Dir(path))
+		if info.Mode().Perm() != 0400 || dirInfo.Mode().Perm() != 0700 {
+			ts.Errorf("unexpected modes %v, %v", info.Mode().Perm(), dirInfo.Mode().Perm())
+		}
+	}
+	// Mounting again replaces the read-only file.
+	if _, err := l.MountSecrets("", "MainConfig.DatabasePassword"); err != nil {
+		ts.Fatalf("second MountSecrets failed: %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		ts.Errorf("secret directory not removed: %v", err)
+	}
+}
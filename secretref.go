@@ -0,0 +1,207 @@
+package sconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Secret references.
+ *
+ * A string field may hold a reference to an externally managed secret instead
+ * of a value, e.g. `secretref:env:DB_PASS` or `secretref:aws-sm:prod/db#password`.
+ * After the file has been loaded and the local passwords are decrypted, every
+ * reference is replaced in memory by the value returned from the resolver
+ * registered for its scheme. The file keeps the reference: a reference in a
+ * <Name>Password field is not encrypted, and UpdateConfig writes the reference
+ * back instead of the resolved secret as long as the field was not changed.
+ *
+ * Only the "env" scheme is built in; resolvers for secret managers are
+ * registered by the application with RegisterSecretResolver so that sconfig
+ * does not depend on their SDKs.
+ */
+
+// secretRefPrefix starts every secret reference value.
+const secretRefPrefix = "secretref:"
+
+// SecretResolver resolves references of one scheme. Resolve receives the part
+// after "secretref:<scheme>:", e.g. "prod/db#password".
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver.
+type SecretResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"env": SecretResolverFunc(resolveEnvSecret),
+	}
+)
+
+// RegisterSecretResolver registers r for references of the form
+// "secretref:<scheme>:<ref>". A nil resolver removes the scheme. Register
+// resolvers before calling LoadConfig.
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	if r == nil {
+		delete(secretResolvers, scheme)
+		return
+	}
+	secretResolvers[scheme] = r
+}
+
+// resolveEnvSecret returns the environment variable named ref.
+func resolveEnvSecret(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+// isSecretRef reports whether value is a secret reference.
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix)
+}
+
+// resolveSecretRef resolves one "secretref:<scheme>:<ref>" value.
+func resolveSecretRef(value string) (string, error) {
+	resolved, _, err := resolveSecretLease(value)
+	return resolved, err
+}
+
+// resolveSecretLease resolves one "secretref:<scheme>:<ref>" value and returns
+// the lease duration if the resolver is a LeaseResolver (0 otherwise).
+func resolveSecretLease(value string) (string, time.Duration, error) {
+	scheme, ref, ok := strings.Cut(strings.TrimPrefix(value, secretRefPrefix), ":")
+	if !ok || scheme == "" {
+		return "", 0, errors.New(t("config.secretref_invalid"))
+	}
+	secretResolversMu.RLock()
+	r, found := secretResolvers[scheme]
+	secretResolversMu.RUnlock()
+	if !found {
+		return "", 0, errors.New(t("config.secretref_unknown_scheme", scheme))
+	}
+	if lr, ok := r.(LeaseResolver); ok {
+		return lr.ResolveLease(ref)
+	}
+	resolved, err := r.Resolve(ref)
+	return resolved, 0, err
+}
+
+// secretBinding remembers a field whose reference (or template, see
+// template.go) was replaced in memory.
+type secretBinding struct {
+	field    reflect.Value // addressable string field
+	name     string        // field name, for lease notifications
+	ref      string        // original "secretref:..." value
+	resolved string        // value set by the resolver
+	renewAt  time.Time     // when to renew the lease, zero without a lease
+}
+
+/*
+ * Replace the secret references of one struct by their resolved values.
+ */
+func (w *walker) resolveRefs(v reflect.Value, info *structInfo) error {
+	for _, i := range info.strs {
+		fieldValue := v.Field(i)
+		ref := fieldValue.String()
+		if !isSecretRef(ref) {
+			continue
+		}
+		resolved, ttl, err := resolveSecretLease(ref)
+		if err != nil {
+			return fmt.Errorf("%s", t("config.secretref_failed", v.Type().Field(i).Name, err))
+		}
+		fieldValue.SetString(resolved)
+		w.refs = append(w.refs, secretBinding{field: fieldValue, name: v.Type().Field(i).Name, ref: ref, resolved: resolved, renewAt: leaseRenewal(ttl)})
+	}
+	return nil
+}
+
+// resolvedRefs tracks the bindings per config pointer so that UpdateConfig can
+// restore the references before writing.
+var (
+	resolvedRefsMu sync.Mutex
+	resolvedRefs   = map[interface{}][]secretBinding{}
+)
+
+// resolveSecretRefs renders the templates and resolves all references in
+// config (a pointer to a struct) and remembers them for restoreSecretRefs.
+func resolveSecretRefs(config interface{}) error {
+	w := &walker{phases: phaseResolve, tmpl: getTemplateContext()}
+	if w.tmpl != nil {
+		w.phases |= phaseTemplate
+	}
+	err := w.walk(reflect.ValueOf(config))
+	for _, b := range w.refs {
+		rememberSecret(b.resolved)
+	}
+	resolvedRefsMu.Lock()
+	defer resolvedRefsMu.Unlock()
+	if len(w.refs) == 0 {
+		delete(resolvedRefs, config)
+	} else {
+		resolvedRefs[config] = w.refs
+	}
+	return err
+}
+
+// restoreSecretRefs puts the references back into all fields of config that
+// still hold the value they were resolved to. Fields changed by the program
+// since the last resolution keep their new value.
+func restoreSecretRefs(config interface{}) {
+	resolvedRefsMu.Lock()
+	bindings := resolvedRefs[config]
+	delete(resolvedRefs, config)
+	resolvedRefsMu.Unlock()
+	for _, b := range bindings {
+		if b.field.String() == b.resolved {
+			b.field.SetString(b.ref)
+		}
+	}
+}
+
+// resolvedRefAddrs returns the bindings of config by the address of their
+// field, so that copies can put the reference back instead of the secret.
+func resolvedRefAddrs(config interface{}) map[uintptr]secretBinding {
+	resolvedRefsMu.Lock()
+	defer resolvedRefsMu.Unlock()
+	bindings := resolvedRefs[config]
+	if len(bindings) == 0 {
+		return nil
+	}
+	addrs := make(map[uintptr]secretBinding, len(bindings))
+	for _, b := range bindings {
+		addrs[b.field.UnsafeAddr()] = b
+	}
+	return addrs
+}
+
+// forgetSecretRefs drops the bindings of config without restoring them.
+func forgetSecretRefs(config interface{}) {
+	resolvedRefsMu.Lock()
+	delete(resolvedRefs, config)
+	resolvedRefsMu.Unlock()
+}
+
+// bindingsOf returns the current bindings of config.
+func bindingsOf(config interface{}) []secretBinding {
+	resolvedRefsMu.Lock()
+	defer resolvedRefsMu.Unlock()
+	return resolvedRefs[config]
+}
@@ -0,0 +1,100 @@
+package sconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_SecretRefs(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	ts.Setenv("SCONFIG_TEST_DB_PASS", "env-secret")
+	RegisterSecretResolver("vault-test", SecretResolverFunc(func(ref string) (string, error) {
+		if ref == "prod/api#key" {
+			return "vault-secret", nil
+		}
+		return "", errors.New("not found")
+	}))
+	defer RegisterSecretResolver("vault-test", nil)
+
+	configPath := filepath.Join(tempDir, "refs.json")
+	content := `{
+		"database_host": "secretref:vault-test:prod/api#key",
+		"database_password": "secretref:env:SCONFIG_TEST_DB_PASS"
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabasePassword != "env-secret" || cfg.DatabaseHost != "vault-secret" {
+		ts.Errorf("unexpected values: password=%q host=%q", cfg.DatabasePassword, cfg.DatabaseHost)
+	}
+	raw, _ := os.ReadFile(configPath)
+	for _, want := range []string{"secretref:env:SCONFIG_TEST_DB_PASS", "secretref:vault-test:prod/api#key"} {
+		if !strings.Contains(string(raw), want) {
+			ts.Errorf("expected reference %q to stay in file:\n%s", want, raw)
+		}
+	}
+	if strings.Contains(string(raw), "env-secret") || strings.Contains(string(raw), "vault-secret") {
+		ts.Errorf("resolved secret written to file:\n%s", raw)
+	}
+
+	ts.Run("UpdateConfig writes references, not secrets", func(ts *testing.T) {
+		cfg.Debug = false
+		if err := UpdateConfig(cfg, configPath); err != nil {
+			ts.Fatalf("UpdateConfig failed: %v", err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if strings.Contains(string(raw), "env-secret") || strings.Contains(string(raw), "vault-secret") {
+			ts.Errorf("resolved secret written to file:\n%s", raw)
+		}
+		if !strings.Contains(string(raw), "secretref:env:SCONFIG_TEST_DB_PASS") {
+			ts.Errorf("reference lost on write:\n%s", raw)
+		}
+		if cfg.DatabasePassword != "env-secret" {
+			ts.Errorf("expected resolved value in memory after UpdateConfig, got %q", cfg.DatabasePassword)
+		}
+	})
+
+	ts.Run("Changed field replaces the reference", func(ts *testing.T) {
+		cfg.DatabasePassword = "new-local"
+		if err := UpdateConfig(cfg, configPath); err != nil {
+			ts.Fatalf("UpdateConfig failed: %v", err)
+		}
+		raw, _ := os.ReadFile(configPath)
+		if strings.Contains(string(raw), "secretref:env:") || strings.Contains(string(raw), "new-local") {
+			ts.Errorf("expected encrypted local password, got:\n%s", raw)
+		}
+		cfg2 := &TestConfig{}
+		if err := LoadConfig(cfg2, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg2.DatabasePassword != "new-local" {
+			ts.Errorf("expected new local password, got %q", cfg2.DatabasePassword)
+		}
+	})
+
+	ts.Run("Resolver errors", func(ts *testing.T) {
+		cases := map[string]string{
+			"unknown scheme": `{"database_host": "secretref:nope:x"}`,
+			"missing env":    `{"database_host": "secretref:env:SCONFIG_TEST_UNSET_VARIABLE"}`,
+			"no scheme":      `{"database_host": "secretref:env"}`,
+			"resolver fails": `{"database_host": "secretref:vault-test:other"}`,
+		}
+		for name, content := range cases {
+			path := filepath.Join(tempDir, "bad-ref.json")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				ts.Fatalf("WriteFile failed: %v", err)
+			}
+			err := LoadConfig(&TestConfig{}, 1, path, false, false)
+			if err == nil || !strings.Contains(err.Error(), "DatabaseHost") {
+				ts.Errorf("%s: expected resolver error for DatabaseHost, got: %v", name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+/*
+ * SecureString.
+ *
+ * A decrypted password in a string field stays in memory until the garbage
+ * collector reuses it, and every %+v of the config prints it. Declared as a
+ * SecureString, the <Name>Password field of a pair holds the password in a
+ * byte buffer instead:
+ *
+ *	DBPassword       sconfig.SecureString `json:"db_password"`
+ *	DBSecurePassword string               `json:"db_secure_password"`
+ *
+ * LoadConfig decrypts straight into the buffer. Printing and marshalling the
+ * field show secretMask, Reveal returns the password and Wipe zeroes the
+ * buffer; Loader.Close wipes the SecureString passwords of its config. The
+ * file holds an empty string, like a []byte password: a new password is
+ * written there as plain text and encrypted at the next load.
+ *
+ * Reveal copies the password into a Go string, which cannot be wiped; call it
+ * where the password is used, not to keep it.
+ */
+
+// SecureString holds a decrypted password; see the <Name>Password fields.
+// Its zero value is the empty password.
+type SecureString []byte
+
+var secureStringType = reflect.TypeOf(SecureString(nil))
+
+// NewSecureString returns a SecureString holding a copy of s.
+func NewSecureString(s string) SecureString {
+	if s == "" {
+		return nil
+	}
+	return SecureString(s)
+}
+
+// Reveal returns the password.
+func (s SecureString) Reveal() string {
+	return string(s)
+}
+
+// Wipe zeroes the buffer of the password and empties s. Copies of s that
+// share the buffer are zeroed as well.
+func (s *SecureString) Wipe() {
+	wipe(*s)
+	*s = nil
+}
+
+// String returns secretMask, or "" for the empty password.
+func (s SecureString) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return secretMask
+}
+
+// Format prints s as String does for every verb, also %x and %#v.
+func (s SecureString) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, s.String())
+}
+
+// MarshalJSON writes String as a JSON string.
+func (s SecureString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON reads a JSON string or null. secretMask, which MarshalJSON
+// writes, and the secure markers of a former string field read as empty. The
+// previous buffer is replaced, not wiped: a copy of s may still use it.
+func (s *SecureString) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*s = nil
+	if value != nil && *value != secretMask && !isMarkerText(*value) {
+		*s = NewSecureString(*value)
+	}
+	return nil
+}
+
+// isMarkerText is isMarker, also before the first LoadConfig has set the
+// markers.
+func isMarkerText(value string) bool {
+	return value == translateIn("en", "config.password_message") || value == translateIn("de", "config.password_message")
+}
@@ -0,0 +1,106 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type secureStringTestConfig struct {
+	Version          int          `json:"version"`
+	DBPassword       SecureString `json:"db_password"`
+	DBSecurePassword string       `json:"db_secure_password"`
+}
+
+func TestSecureString(ts *testing.T) {
+	s := NewSecureString("hunter22")
+	for _, format := range []string{"%v", "%s", "%q", "%x", "%d", "%#v", "%+v"} {
+		if out := fmt.Sprintf(format, s); strings.Contains(out, "hunter") || strings.Contains(out, "68756e746572") {
+			ts.Errorf("%s printed %q", format, out)
+		}
+	}
+	if out := fmt.Sprintf("%+v", struct{ P SecureString }{s}); out != "{P:"+secretMask+"}" {
+		ts.Errorf("struct printed %q", out)
+	}
+	if raw, _ := json.Marshal(s); string(raw) != `"`+secretMask+`"` {
+		ts.Errorf("MarshalJSON = %s", raw)
+	}
+	if s.Reveal() != "hunter22" {
+		ts.Errorf("Reveal = %q", s.Reveal())
+	}
+	shared := s
+	s.Wipe()
+	if s != nil || string(shared) != strings.Repeat("\x00", 8) {
+		ts.Errorf("after Wipe: %v, buffer %q", s == nil, []byte(shared))
+	}
+	if NewSecureString("").String() != "" {
+		ts.Error("the empty password printed a mask")
+	}
+
+	var read SecureString
+	for input, want := range map[string]string{`"plain"`: "plain", `null`: "", `"` + secretMask + `"`: "", `"` + translateIn("de", "config.password_message") + `"`: ""} {
+		if err := json.Unmarshal([]byte(input), &read); err != nil || read.Reveal() != want {
+			ts.Errorf("UnmarshalJSON(%s) = %q, %v", input, read.Reveal(), err)
+		}
+	}
+}
+
+func TestLoadConfig_SecureString(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "securestring.json")
+	if err := os.WriteFile(configPath, []byte(`{"db_password": "s3cret-db"}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &secureStringTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DBPassword.Reveal() != "s3cret-db" {
+		ts.Errorf("DBPassword = %q", cfg.DBPassword.Reveal())
+	}
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	if strings.Contains(string(raw), "s3cret-db") || doc["db_password"] != "" || doc["db_secure_password"] == "" {
+		ts.Errorf("password not encrypted:\n%s", raw)
+	}
+
+	reloaded := &secureStringTestConfig{}
+	if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.DBPassword.Reveal() != "s3cret-db" {
+		ts.Errorf("reloaded DBPassword = %q", reloaded.DBPassword.Reveal())
+	}
+	unchanged, _ := os.ReadFile(configPath)
+	if string(unchanged) != string(raw) {
+		ts.Error("loading an encrypted SecureString rewrote the file")
+	}
+	if out := fmt.Sprintf("%+v", reloaded); strings.Contains(out, "s3cret") {
+		ts.Errorf("config printed the password: %s", out)
+	}
+
+	l := NewLoader(&secureStringTestConfig{}, configPath, 1)
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Loader.Load failed: %v", err)
+	}
+	loaded := l.Snapshot().(*secureStringTestConfig)
+	held := l.config.(*secureStringTestConfig).DBPassword
+	if loaded.DBPassword.Reveal() != "s3cret-db" {
+		ts.Errorf("snapshot DBPassword = %q", loaded.DBPassword.Reveal())
+	}
+	if err := l.Close(); err != nil {
+		ts.Fatalf("Close failed: %v", err)
+	}
+	if strings.Trim(string(held), "\x00") != "" || loaded.DBPassword != nil {
+		ts.Errorf("Close left the password: %q, snapshot %q", []byte(held), []byte(loaded.DBPassword))
+	}
+}
@@ -0,0 +1,133 @@
+package sconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/*
+ * Secure tags.
+ *
+ * The <Name>Password/<Name>SecurePassword convention needs two fields per
+ * secret. A string field tagged `secure:"true"` is encrypted on its own:
+ *
+ *	APIToken string `json:"api_token" secure:"true"`
+ *
+ * In the file the key holds the secure marker (PASSWORD_IS_SECURE) and the
+ * ciphertext is kept in a shadow key next to it, "api_token__enc". As with a
+ * pair, a plaintext written into the key by hand is encrypted at the next
+ * load, and the struct holds the decrypted value.
+ *
+ * Between reading and decrypting, and between encrypting and writing, the
+ * field holds the ciphertext behind encryptedPrefix; the JSON conversion
+ * (duration.go) moves it from and to the shadow key. The shadow key exists
+ * in JSON, YAML and TOML files; XML files are not supported. Secret
+ * references in a secure field are resolved and stay readable, like in a
+ * <Name>Password field.
+ */
+
+// shadowSuffix is appended to the key of a secure field to name the key of
+// its ciphertext.
+const shadowSuffix = "__enc"
+
+// encryptedPrefix marks the ciphertext of a secure field in the struct.
+const encryptedPrefix = "sconfig:enc:"
+
+// isEncryptedForm reports whether value is the ciphertext form of a secure
+// field.
+func isEncryptedForm(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// isMarker reports whether value is the secure marker of a language.
+func isMarker(value string) bool {
+	markerMu.RLock()
+	defer markerMu.RUnlock()
+	return value == PASSWORD_IS_SECURE_de || value == PASSWORD_IS_SECURE_en
+}
+
+// encryptSecured encrypts the new plaintext values of the secure fields of
+// one struct.
+func (w *walker) encryptSecured(v reflect.Value, info *structInfo) error {
+	for _, i := range info.secured {
+		fieldValue := v.Field(i)
+		value := fieldValue.String()
+		if value == "" || isSecretRef(value) || isEncryptedForm(value) || isMarker(value) {
+			continue
+		}
+		sealed, err := encrypt(value)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetString(encryptedPrefix + sealed)
+		w.changed = true
+	}
+	return nil
+}
+
+// decryptSecured decrypts the secure fields of one struct.
+func decryptSecured(v reflect.Value, info *structInfo) error {
+	for _, i := range info.secured {
+		fieldValue := v.Field(i)
+		if !isEncryptedForm(fieldValue.String()) {
+			continue
+		}
+		value, err := decrypt(strings.TrimPrefix(fieldValue.String(), encryptedPrefix))
+		if fault := decryptFault(v.Type().Field(i).Name); fault != nil {
+			err = fault
+		}
+		if err != nil {
+			decryptFailures.Add(1)
+			return fmt.Errorf("%s", t("config.decrypt_failed", info.label(v.Type(), i), err))
+		}
+		fieldValue.SetString(value)
+		rememberSecret(value)
+	}
+	return nil
+}
+
+// rekeySecured re-encrypts the ciphertexts of the secure fields of one
+// struct from w.oldKey to the current key.
+func (w *walker) rekeySecured(v reflect.Value, info *structInfo) error {
+	for _, i := range info.secured {
+		fieldValue := v.Field(i)
+		if !isEncryptedForm(fieldValue.String()) {
+			continue
+		}
+		value, err := decryptWithKey(w.oldKey, strings.TrimPrefix(fieldValue.String(), encryptedPrefix))
+		if err != nil {
+			decryptFailures.Add(1)
+			return fmt.Errorf("%s", t("config.decrypt_failed", info.label(v.Type(), i), err))
+		}
+		sealed, err := encrypt(value)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetString(encryptedPrefix + sealed)
+		w.changed = true
+	}
+	return nil
+}
+
+// scrubSecured replaces the decrypted values of the secure fields of one
+// struct by their ciphertext, so the struct can still be written. A value
+// that cannot be encrypted is replaced by the secure marker, or dropped with
+// strip.
+func scrubSecured(v reflect.Value, info *structInfo, strip bool) {
+	for _, i := range info.secured {
+		fieldValue := v.Field(i)
+		value := fieldValue.String()
+		if value == "" || isSecretRef(value) || isEncryptedForm(value) || isMarker(value) {
+			continue
+		}
+		switch sealed, err := encrypt(value); {
+		case err == nil:
+			fieldValue.SetString(encryptedPrefix + sealed)
+		case strip:
+			fieldValue.SetString("")
+		default:
+			fieldValue.SetString(secureMarker())
+		}
+	}
+}
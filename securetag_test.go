@@ -0,0 +1,132 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type securedTestConfig struct {
+	Version  int    `json:"version"`
+	Host     string `json:"host"`
+	APIToken string `json:"api_token" secure:"true"`
+	Upstream struct {
+		Secret string `json:"secret" secure:"true"`
+	} `json:"upstream"`
+}
+
+func TestLoadConfig_SecureTag(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "secured.json")
+	content := `{"host": "db", "api_token": "token-secret", "upstream": {"secret": "upstream-secret"}}`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.APIToken != "token-secret" || cfg.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("decrypted values = %+v", cfg)
+	}
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ts.Fatal(err)
+	}
+	if strings.Contains(string(raw), "token-secret") || strings.Contains(string(raw), "upstream-secret") || strings.Contains(string(raw), encryptedPrefix) {
+		ts.Errorf("plaintext or in-memory form written:\n%s", raw)
+	}
+	if doc["api_token"] != PASSWORD_IS_SECURE || doc["api_token__enc"] == "" {
+		ts.Errorf("expected the marker and a shadow key:\n%s", raw)
+	}
+	if upstream, _ := doc["upstream"].(map[string]interface{}); upstream["secret__enc"] == nil {
+		ts.Errorf("nested secure field without a shadow key:\n%s", raw)
+	}
+
+	reloaded := &securedTestConfig{}
+	if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.APIToken != "token-secret" || reloaded.Upstream.Secret != "upstream-secret" {
+		ts.Errorf("reloaded values = %+v", reloaded)
+	}
+	unchanged, _ := os.ReadFile(configPath)
+	if string(unchanged) != string(raw) {
+		ts.Error("loading an encrypted file rewrote it")
+	}
+
+	// A new plaintext written into the key replaces the stale ciphertext.
+	doc["api_token"] = "new-token"
+	edited, _ := json.Marshal(doc)
+	if err := os.WriteFile(configPath, edited, 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.APIToken != "new-token" {
+		ts.Errorf("APIToken = %q, want the new plaintext", reloaded.APIToken)
+	}
+
+	// UpdateConfig encrypts a value changed at runtime.
+	reloaded.APIToken = "runtime-token"
+	if err := UpdateConfig(reloaded, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if reloaded.APIToken != "runtime-token" {
+		ts.Errorf("APIToken after UpdateConfig = %q", reloaded.APIToken)
+	}
+	raw, _ = os.ReadFile(configPath)
+	if strings.Contains(string(raw), "runtime-token") {
+		ts.Errorf("UpdateConfig wrote the plaintext:\n%s", raw)
+	}
+
+	// A clone carries the ciphertext only.
+	clone := Clone(reloaded).(*securedTestConfig)
+	if !isEncryptedForm(clone.APIToken) {
+		ts.Errorf("clone holds %q", clone.APIToken)
+	}
+}
+
+func TestLoadConfig_SecureTagYAML(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "secured.yaml")
+	if err := os.WriteFile(configPath, []byte("host: db\napi_token: yaml-token\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	cfg := &securedTestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if strings.Contains(string(raw), "yaml-token") || !strings.Contains(string(raw), "api_token__enc") {
+		ts.Errorf("YAML file not encrypted:\n%s", raw)
+	}
+	reloaded := &securedTestConfig{}
+	if err := LoadConfig(reloaded, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if reloaded.APIToken != "yaml-token" {
+		ts.Errorf("APIToken = %q", reloaded.APIToken)
+	}
+}
+
+// writeSecuredConfig writes a securedTestConfig with token to path,
+// encrypted with the key of hw.
+func writeSecuredConfig(ts *testing.T, path, token string, hw func() (uint64, error)) {
+	ts.Helper()
+	cfg := &securedTestConfig{Host: "db", APIToken: token}
+	cfg.Upstream.Secret = "upstream-secret"
+	if err := LoadConfig(cfg, 1, path, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+}
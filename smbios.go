@@ -0,0 +1,121 @@
+package sconfig
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+ * SMBIOS parsing.
+ *
+ * On Windows the hardware identifiers that used to come from wmic
+ * (csproduct, baseboard, cpu) are read from the raw SMBIOS table the
+ * firmware provides (GetSystemFirmwareTable "RSMB"), in the form WMI
+ * reports them, so the hardware ID stays the same.
+ */
+
+// smbiosInfo holds the identifiers read from the SMBIOS table.
+type smbiosInfo struct {
+	manufacturer string // system (type 1)
+	product      string
+	uuid         string // like Win32_ComputerSystemProduct.UUID
+	boardSerial  string // baseboard (type 2)
+	boardProduct string
+	processorIDs []string // like Win32_Processor.ProcessorId, one per socket
+}
+
+// parseRawSMBIOS parses the RawSMBIOSData structure GetSystemFirmwareTable
+// returns: the SMBIOS version, the length of the table and the table.
+func parseRawSMBIOS(raw []byte) (smbiosInfo, error) {
+	if len(raw) < 8 {
+		return smbiosInfo{}, fmt.Errorf("SMBIOS data too short")
+	}
+	major, minor := raw[1], raw[2]
+	length := binary.LittleEndian.Uint32(raw[4:8])
+	if uint64(length) > uint64(len(raw)-8) {
+		return smbiosInfo{}, fmt.Errorf("SMBIOS table truncated")
+	}
+	return parseSMBIOSTable(raw[8:8+length], major, minor), nil
+}
+
+// parseSMBIOSTable returns the identifiers in the SMBIOS structure table of
+// version major.minor.
+func parseSMBIOSTable(table []byte, major, minor byte) smbiosInfo {
+	var info smbiosInfo
+	for len(table) >= 4 {
+		typ, length := table[0], int(table[1])
+		if length < 4 || length > len(table) {
+			break
+		}
+		formatted := table[:length]
+		// The strings follow the formatted area, ending with two zero bytes.
+		end := length
+		for end+1 < len(table) && (table[end] != 0 || table[end+1] != 0) {
+			end++
+		}
+		strs := smbiosStrings(table[length:end])
+		str := func(offset int) string {
+			if offset >= len(formatted) {
+				return ""
+			}
+			index := int(formatted[offset])
+			if index == 0 || index > len(strs) {
+				return ""
+			}
+			return strings.TrimSpace(strs[index-1])
+		}
+		switch typ {
+		case 1:
+			info.manufacturer, info.product = str(4), str(5)
+			if len(formatted) >= 0x18 {
+				info.uuid = formatSMBIOSUUID(formatted[8:24], major, minor)
+			}
+		case 2:
+			info.boardProduct, info.boardSerial = str(5), str(7)
+		case 4:
+			if len(formatted) >= 0x10 {
+				if id := formatProcessorID(formatted[8:16]); id != "" {
+					info.processorIDs = append(info.processorIDs, id)
+				}
+			}
+		case 127:
+			return info
+		}
+		if end+2 > len(table) {
+			break
+		}
+		table = table[end+2:]
+	}
+	return info
+}
+
+// smbiosStrings splits the string set of a structure.
+func smbiosStrings(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(string(b), "\x00")
+}
+
+// formatSMBIOSUUID formats the system UUID like WMI: since SMBIOS 2.6 the
+// first three fields are little-endian.
+func formatSMBIOSUUID(b []byte, major, minor byte) string {
+	u := append([]byte(nil), b...)
+	if major > 2 || (major == 2 && minor >= 6) {
+		u[0], u[1], u[2], u[3] = u[3], u[2], u[1], u[0]
+		u[4], u[5] = u[5], u[4]
+		u[6], u[7] = u[7], u[6]
+	}
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// formatProcessorID formats the processor ID (EAX and EDX of CPUID 1) like
+// WMI, EDX first; an all-zero ID is "".
+func formatProcessorID(b []byte) string {
+	eax, edx := binary.LittleEndian.Uint32(b[0:4]), binary.LittleEndian.Uint32(b[4:8])
+	if eax == 0 && edx == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%08X%08X", edx, eax)
+}
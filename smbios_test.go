@@ -0,0 +1,66 @@
+package sconfig
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseRawSMBIOS(ts *testing.T) {
+	structure := func(typ byte, formatted []byte, strs ...string) []byte {
+		b := append([]byte{typ, byte(4 + len(formatted)), 0, 0}, formatted...)
+		for _, s := range strs {
+			b = append(append(b, s...), 0)
+		}
+		if len(strs) == 0 {
+			b = append(b, 0)
+		}
+		return append(b, 0)
+	}
+	uuid := []byte{0x44, 0x45, 0x4c, 0x4c, 0x38, 0x00, 0x10, 0x4e, 0x80, 0x52, 0xb4, 0xc0, 0x4f, 0x52, 0x4d, 0x32}
+	system := append([]byte{1, 2, 0, 3}, uuid...) // manufacturer, product, version, serial, UUID
+	processor := make([]byte, 12)
+	binary.LittleEndian.PutUint32(processor[4:], 0x000906EA)
+	binary.LittleEndian.PutUint32(processor[8:], 0xBFEBFBFF)
+	var table []byte
+	table = append(table, structure(0, []byte{1}, "BIOS Vendor")...)
+	table = append(table, structure(1, system, "Dell Inc.", " OptiPlex 7070 ", "SN-4711")...)
+	table = append(table, structure(2, []byte{1, 2, 0, 3}, "Dell Inc.", "0YNVJG", "/7XJ2Q23/CNFCW0099E0XYZ/")...)
+	table = append(table, structure(4, processor)...)
+	table = append(table, structure(4, make([]byte, 12))...) // empty socket
+	table = append(table, structure(127, nil)...)
+	table = append(table, structure(1, system, "after end")...)
+
+	raw := append([]byte{0, 3, 2, 0, 0, 0, 0, 0}, table...)
+	binary.LittleEndian.PutUint32(raw[4:8], uint32(len(table)))
+	info, err := parseRawSMBIOS(raw)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	want := smbiosInfo{
+		manufacturer: "Dell Inc.",
+		product:      "OptiPlex 7070",
+		uuid:         "4C4C4544-0038-4E10-8052-B4C04F524D32",
+		boardSerial:  "/7XJ2Q23/CNFCW0099E0XYZ/",
+		boardProduct: "0YNVJG",
+		processorIDs: []string{"BFEBFBFF000906EA"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		ts.Errorf("parseRawSMBIOS = %+v, want %+v", info, want)
+	}
+
+	// Before SMBIOS 2.6 the UUID is taken in byte order.
+	raw[1], raw[2] = 2, 5
+	if info, _ := parseRawSMBIOS(raw); info.uuid != "44454C4C-3800-104E-8052-B4C04F524D32" {
+		ts.Errorf("SMBIOS 2.5 UUID = %s", info.uuid)
+	}
+
+	if _, err := parseRawSMBIOS(raw[:len(raw)-10]); err == nil {
+		ts.Error("truncated table accepted")
+	}
+	// A damaged structure ends the parsing without a panic.
+	damaged := append([]byte{0, 3, 2, 0, 6, 0, 0, 0}, 1, 200, 0, 0, 0, 0)
+	if info, err := parseRawSMBIOS(damaged); err != nil || !reflect.DeepEqual(info, smbiosInfo{}) {
+		ts.Errorf("damaged table = %+v, %v", info, err)
+	}
+}
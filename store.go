@@ -0,0 +1,47 @@
+package sconfig
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+ * Typed config snapshots.
+ *
+ * Store[T] holds the latest loaded config behind an atomic.Pointer. A Loader
+ * created with WithStore publishes every Load and every automatic re-read
+ * into it, so request handlers read a consistent *T without locks and without
+ * the type assertion Snapshot needs.
+ */
+
+// Store holds the current config snapshot of type *T. The zero value is an
+// empty store. The stored value is shared by all readers and must not be
+// modified; publish a new one with Store instead. A Store is safe for
+// concurrent use.
+type Store[T any] struct {
+	p atomic.Pointer[T]
+}
+
+// Load returns the current snapshot, or nil if none has been stored yet.
+func (s *Store[T]) Load() *T {
+	return s.p.Load()
+}
+
+// Store replaces the current snapshot.
+func (s *Store[T]) Store(config *T) {
+	s.p.Store(config)
+}
+
+// WithStore makes the Loader publish every loaded and re-read config into s.
+// T must be the struct type of the config passed to NewLoader; a mismatch is
+// a programming error and panics in NewLoader.
+func WithStore[T any](s *Store[T]) LoaderOption {
+	return func(l *Loader) {
+		if _, ok := l.config.(*T); !ok {
+			panic(fmt.Sprintf("sconfig: WithStore[%T] used with a config of type %T", *new(T), l.config))
+		}
+		l.refresh.notify = append(l.refresh.notify, func(snapshot interface{}) {
+			s.Store(snapshot.(*T))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(ts *testing.T) {
+	var s Store[TestConfig]
+	if s.Load() != nil {
+		ts.Fatal("expected empty store")
+	}
+	c := &TestConfig{DatabaseHost: "a"}
+	s.Store(c)
+	if s.Load() != c {
+		ts.Error("Load did not return the stored config")
+	}
+}
+
+func TestLoader_WithStore(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "store.json")
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "first"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	var store Store[TestConfig]
+	l := NewLoader(&TestConfig{}, configPath, 1, WithAutoRefresh(10*time.Millisecond), WithStore(&store))
+	defer l.Close()
+	if err := l.Load(); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	if cfg := store.Load(); cfg == nil || cfg.DatabaseHost != "first" {
+		ts.Fatalf("store not updated by Load: %+v", cfg)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"database_host": "second"}`), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Load().DatabaseHost != "second" {
+		if time.Now().After(deadline) {
+			ts.Fatal("store not updated by the refresh")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	defer func() {
+		if recover() == nil {
+			ts.Error("expected a panic for a mismatched store type")
+		}
+	}()
+	NewLoader(&TestSliceConfig{}, configPath, 1, WithStore(&store))
+}
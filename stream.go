@@ -0,0 +1,332 @@
+package sconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+)
+
+/*
+ * Streaming file I/O for config files.
+ *
+ * Multi-megabyte generated configs must not be held twice, as raw bytes and
+ * as the converted or re-marshalled document. A JSON file is read through
+ * the JSONC filter and the text field converter (duration.go), which patches
+ * the secure fields in token by token, straight into json.Decoder; only the
+ * decoder holds the document. A new JSON file is written the other way
+ * round: json.Encoder's output runs through the converter, which indents it
+ * and moves the ciphertexts of secure fields into their shadow keys, and
+ * through the metadata writer into the file; only the encoder holds the
+ * document. A rewrite of an existing file patches the serialization into the
+ * file (rewrite.go), which needs both in memory.
+ *
+ * Every write goes into a temporary file next to the target, which then
+ * replaces the original, so readers never see a half-written config and a
+ * failed write leaves the old file in place. XML files are streamed through
+ * encoding/xml; YAML and TOML files are transcoded to JSON in memory (see
+ * format.go).
+ */
+
+// decodeConfigFile decodes the config file in path into config and returns
+// its metadata block (nil if there is none). Trailing data after the document
+// is rejected like json.Unmarshal does.
+func decodeConfigFile(path string, config interface{}) (*fileMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	defer f.Close()
+	switch formatFromExtension(path) {
+	case FormatJSON:
+		recordFormat(path, FormatJSON)
+		if err := decodeConfigStream(newJSONCReader(f), config); err != nil {
+			return nil, err
+		}
+		// The metadata block is the first member of files sconfig wrote;
+		// only the start of the file is read again.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil
+		}
+		return readJSONMetadata(newJSONCReader(f)), nil
+	case FormatXML:
+		recordFormat(path, FormatXML)
+		return decodeXMLStream(f, config)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.read_failed"), err)
+	}
+	format := formatForRead(path, content)
+	if format == FormatXML {
+		return decodeXMLStream(bytes.NewReader(content), config)
+	}
+	data, err := toJSON(format, content)
+	if err != nil {
+		return nil, fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if err := decodeConfigStream(bytes.NewReader(data), config); err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		return readJSONMetadata(bytes.NewReader(data)), nil
+	}
+	return findJSONMetadata(data), nil
+}
+
+// decodeConfigStream decodes exactly one JSON document from r into config.
+// The text fields are converted while the decoder reads.
+func decodeConfigStream(r io.Reader, config interface{}) error {
+	if typ := reflect.TypeOf(config); hasTextFields(typ) {
+		pr, pw := io.Pipe()
+		go func(r io.Reader) {
+			pw.CloseWithError(copyJSON(pw, r, typ, false, false))
+		}(r)
+		defer pr.Close() // ends the conversion if decoding stops early
+		r = pr
+	}
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(config); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+		return fmt.Errorf(t("config.failed_parsing"), err)
+	}
+	return nil
+}
+
+// decodeXMLStream decodes exactly one XML document from r into config and
+// returns the metadata from a <?sconfig?> instruction before the root element.
+// Only whitespace, comments and processing instructions may follow the root.
+func decodeXMLStream(r io.Reader, config interface{}) (*fileMetadata, error) {
+	dec := xml.NewDecoder(r)
+	var meta *fileMetadata
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf(t("config.failed_parsing"), err)
+		}
+		if pi, ok := tok.(xml.ProcInst); ok {
+			if m := parseXMLMetadata(pi); m != nil {
+				meta = m
+			}
+			continue
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if err := dec.DecodeElement(config, &start); err != nil {
+				return nil, fmt.Errorf(t("config.failed_parsing"), err)
+			}
+			break
+		}
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return meta, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf(t("config.failed_parsing"), err)
+		}
+		switch tok := tok.(type) {
+		case xml.Comment, xml.ProcInst:
+			continue
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) == 0 {
+				continue
+			}
+		}
+		return nil, fmt.Errorf(t("config.failed_parsing"), errors.New("invalid data after root element"))
+	}
+}
+
+// writeConfigFile serializes config in the format of path, together with the
+// metadata block, into a temporary file in the directory of path and
+// atomically renames it to path. mode is the mode of the existing file, see
+// writeModeFor for the mode written. Formats other than JSON and XML are
+// transcoded from JSON. A config loaded from a namespace replaces
+// only its namespace of the file. The rewrite holds the file lock.
+func writeConfigFile(path string, config interface{}, mode os.FileMode) error {
+	unlock, err := lockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeConfigFileLocked(path, config, mode)
+}
+
+// writeConfigFileLocked is writeConfigFile for callers holding the file lock.
+func writeConfigFileLocked(path string, config interface{}, mode os.FileMode) error {
+	mode = writeModeFor(path, config, mode)
+	meta := newFileMetadata(config)
+	write := writeConfigFileMeta
+	if name := namespaceOf(config); name != "" {
+		write = func(path string, config interface{}, mode os.FileMode, meta *fileMetadata) error {
+			return writeNamespace(path, name, config, mode, meta)
+		}
+	}
+	if err := write(path, config, mode, meta); err != nil {
+		return err
+	}
+	recordMetadata(path, meta)
+	return nil
+}
+
+func writeConfigFileMeta(path string, config interface{}, mode os.FileMode, meta *fileMetadata) error {
+	format := formatForWrite(path)
+	switch format {
+	case FormatJSON:
+		encode := func(w io.Writer) error {
+			mw, err := newMetadataWriter(w, meta, true)
+			if err != nil {
+				return err
+			}
+			return encodeConfig(mw, config)
+		}
+		current := readForRewrite(path)
+		if current == nil {
+			// A new file is streamed.
+			return writeFileAtomic(path, mode, encode)
+		}
+		return writeFileAtomic(path, mode, func(w io.Writer) error {
+			var buf bytes.Buffer
+			if err := encode(&buf); err != nil {
+				return err
+			}
+			_, err := w.Write(keepUnknown(path, format, current, buf.Bytes(), reflect.TypeOf(config)))
+			return err
+		})
+	case FormatXML:
+		return writeFileAtomic(path, mode, func(w io.Writer) error {
+			if _, err := io.WriteString(w, xml.Header+xmlMetadataPI(meta)); err != nil {
+				return err
+			}
+			enc := xml.NewEncoder(w)
+			enc.Indent("", "\t")
+			if err := enc.Encode(config); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, "\n")
+			return err
+		})
+	}
+	data, err := marshalConfig(config)
+	if err == nil {
+		data, err = withMetadata(data, meta)
+	}
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	if current := readForRewrite(path); current != nil {
+		data = keepUnknown(path, format, current, data, reflect.TypeOf(config))
+	}
+	out, err := fromJSON(format, data)
+	if err != nil {
+		return fmt.Errorf(t("config.failed_build_json"), err)
+	}
+	return writeFileAtomic(path, mode, func(w io.Writer) error {
+		_, err := w.Write(out)
+		return err
+	})
+}
+
+// encodeConfig writes config to w as JSON indented with tabs, with its
+// Durations as strings, its Times in their layouts and the ciphertexts of its
+// secure fields in their shadow keys.
+// The encoder runs in the calling goroutine, so a panicking MarshalJSON
+// reaches the caller.
+func encodeConfig(w io.Writer, config interface{}) (err error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := copyJSON(w, pr, reflect.TypeOf(config), true, true)
+		pr.CloseWithError(err) // ends the encoder if the copy failed
+		done <- err
+	}()
+	defer func() {
+		pw.CloseWithError(err)
+		if copyErr := <-done; err == nil {
+			err = copyErr
+		}
+	}()
+	return json.NewEncoder(pw).Encode(config)
+}
+
+// writeFileAtomic writes the output of write into a temporary file next to
+// path and renames it to path.
+func writeFileAtomic(path string, mode os.FileMode, write func(w io.Writer) error) error {
+	if err := writeFault(); err != nil {
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		if isMarshalError(err) {
+			return fmt.Errorf(t("config.failed_build_json"), err)
+		}
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf(t("config.failed_writing"), path, err)
+	}
+	checkFileMode(path, mode)
+	return nil
+}
+
+// checkFileMode warns when the file at path ended up readable by more than
+// mode allows, e.g. on file systems that ignore chmod or through an extended
+// ACL. The explicit Chmod above makes the mode independent of the umask;
+// Windows has no Unix modes and is not checked.
+func checkFileMode(path string, mode os.FileMode) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if perm := info.Mode().Perm(); perm&^mode != 0 {
+		warn(t("config.mode_broader", path, perm, mode))
+	}
+	if hasExtendedACL(path) {
+		warn(t("config.mode_acl", path, mode))
+	}
+}
+
+// isMarshalError reports whether err was caused by the value rather than by
+// the underlying writer.
+func isMarshalError(err error) bool {
+	var unsupportedType *json.UnsupportedTypeError
+	var unsupportedValue *json.UnsupportedValueError
+	var marshalerErr *json.MarshalerError
+	var xmlUnsupported *xml.UnsupportedTypeError
+	return errors.As(err, &unsupportedType) || errors.As(err, &unsupportedValue) || errors.As(err, &marshalerErr) ||
+		errors.As(err, &xmlUnsupported)
+}
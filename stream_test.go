@@ -0,0 +1,206 @@
+package sconfig
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig_FileIO(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+
+	ts.Run("Trailing data is rejected", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "trailing.json")
+		if err := os.WriteFile(configPath, []byte(`{"database_host": "a"} {"x": 1}`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := LoadConfig(&TestConfig{}, 1, configPath, false, false)
+		if err == nil || !contains(err.Error(), t("config.failed_parsing")) {
+			ts.Errorf("expected parsing error for trailing data, got: %v", err)
+		}
+	})
+
+	ts.Run("Empty file is rejected", func(ts *testing.T) {
+		configPath := filepath.Join(tempDir, "empty.json")
+		if err := os.WriteFile(configPath, nil, 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := LoadConfig(&TestConfig{}, 1, configPath, false, false)
+		if err == nil || !contains(err.Error(), t("config.failed_parsing")) {
+			ts.Errorf("expected parsing error for empty file, got: %v", err)
+		}
+	})
+
+	ts.Run("Rewrite replaces the file atomically", func(ts *testing.T) {
+		dir := filepath.Join(tempDir, "atomic")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			ts.Fatalf("Mkdir failed: %v", err)
+		}
+		configPath := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(configPath, []byte(`{"database_password": "stream-secret"}`), 0640); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg.DatabasePassword != "stream-secret" {
+			ts.Errorf("expected decrypted password, got %q", cfg.DatabasePassword)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			ts.Fatalf("ReadDir failed: %v", err)
+		}
+		for _, entry := range entries {
+			// The lock sidecar of the rewrite stays; no temporary file may.
+			if name := entry.Name(); name != "config.json" && name != "config.json.lock" {
+				ts.Errorf("unexpected file %s left in directory", name)
+			}
+		}
+		raw, _ := os.ReadFile(configPath)
+		if strings.Contains(string(raw), "stream-secret") {
+			ts.Error("plaintext password written to file")
+		}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(configPath)
+			if err != nil {
+				ts.Fatalf("Stat failed: %v", err)
+			}
+			// A file with ciphertexts is restricted to its owner.
+			if info.Mode().Perm() != 0600 {
+				ts.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+			}
+		}
+	})
+}
+
+type streamTestConfig struct {
+	Probe   heapProbe     `json:"probe"`
+	Token   string        `json:"token" secure:"true"`
+	Timeout time.Duration `json:"timeout"`
+	Hosts   []string      `json:"hosts"`
+}
+
+// heapProbe records the live heap when it is decoded. As the first member,
+// it is decoded after the decoder has read the whole document but before
+// the rest of the struct is filled.
+type heapProbe struct{ live *uint64 }
+
+func (p heapProbe) UnmarshalJSON([]byte) error {
+	*p.live = liveHeap()
+	return nil
+}
+
+// heapSampler passes writes through and records the largest live heap seen
+// every 256 KB. The encoder is blocked meanwhile, so nothing else allocates.
+type heapSampler struct {
+	w    io.Writer
+	n    int
+	peak uint64
+}
+
+func (s *heapSampler) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if s.n += n; s.n >= 256<<10 {
+		s.n = 0
+		s.peak = max(s.peak, liveHeap())
+	}
+	return n, err
+}
+
+func liveHeap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestLoadConfig_Streaming(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	// A first load sets up the key and the secure markers.
+	if err := LoadConfig(&struct{}{}, 1, filepath.Join(tempDir, "small.json"), false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	path := filepath.Join(tempDir, "big.json")
+	cfg := &streamTestConfig{Token: encryptedPrefix + "c2VhbGVk", Timeout: time.Minute, Hosts: make([]string, 50000)}
+	for i := range cfg.Hosts {
+		cfg.Hosts[i] = fmt.Sprintf("host-%06d.example.org/%s", i, strings.Repeat("x", 70))
+	}
+
+	// Only the encoder holds the document, not its conversion as well.
+	f, err := os.Create(path)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	base := liveHeap()
+	out := &heapSampler{w: f}
+	if err := encodeConfig(out, cfg); err != nil {
+		ts.Fatalf("encodeConfig failed: %v", err)
+	}
+	f.Close()
+	info, _ := os.Stat(path)
+	size := uint64(info.Size())
+	if grown := out.peak - min(out.peak, base); grown > size+size/2 {
+		ts.Errorf("writing %d bytes grew the heap by %d", size, grown)
+	}
+	raw, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(raw), "{\n\t\"probe\": {},\n\t\"token\": \""+secureMarker()+"\",\n\t\"token__enc\": \"c2VhbGVk\",\n\t\"timeout\": \"1m0s\",") {
+		ts.Fatalf("unexpected document start:\n%.200s", raw)
+	}
+
+	// Only the decoder holds the document, not the raw file or its
+	// conversion as well.
+	hosts := len(cfg.Hosts)
+	cfg, raw = nil, nil
+	f, err = os.Open(path)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	defer f.Close()
+	var live uint64
+	read := streamTestConfig{Probe: heapProbe{&live}}
+	base = liveHeap()
+	if err := decodeConfigStream(newJSONCReader(f), &read); err != nil {
+		ts.Fatalf("decodeConfigStream failed: %v", err)
+	}
+	if grown := live - min(live, base); grown > 2*size {
+		ts.Errorf("reading %d bytes grew the heap by %d", size, grown)
+	}
+	if read.Token != encryptedPrefix+"c2VhbGVk" || read.Timeout != time.Minute || len(read.Hosts) != hosts {
+		ts.Errorf("read back token %q, timeout %v, %d hosts", read.Token, read.Timeout, len(read.Hosts))
+	}
+}
+
+func TestWriteFileAtomic_Mode(ts *testing.T) {
+	if runtime.GOOS == "windows" {
+		ts.Skip("no Unix file modes")
+	}
+	var warnings []string
+	SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	defer SetWarningHandler(defaultWarningHandler)
+
+	path := filepath.Join(ts.TempDir(), "mode.json")
+	if err := writeFileAtomic(path, 0600, func(w io.Writer) error { return nil }); err != nil {
+		ts.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		ts.Errorf("expected mode 0600 regardless of the umask, got %v", info.Mode().Perm())
+	}
+	if len(warnings) != 0 {
+		ts.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	os.Chmod(path, 0640)
+	checkFileMode(path, 0600)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], path) {
+		ts.Errorf("expected a warning about the broader mode, got %v", warnings)
+	}
+}
@@ -0,0 +1,266 @@
+package sconfig
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Strict loading.
+ *
+ * encoding/json is forgiving: it skips keys the struct does not have, matches
+ * keys in any case, ignores null for a non-pointer field, takes the last of
+ * duplicate keys and stops at the first type mismatch with a path that
+ * leaves out slice indexes. A typo in a key name therefore silently loads
+ * the default. With WithStrict, Load first checks the file against the
+ * struct, as its schema, and rejects it with a *StrictError listing every
+ * problem with its document path:
+ *
+ *	servers[2].database_port: expected integer, got string
+ *	servers[2].databse_host: unknown key
+ *	Debug: unknown key, did you mean "debug"?
+ *
+ * Allowed besides the fields: the metadata block at the top and the shadow
+ * keys of secure fields. Maps and interface{} values take any key; values of
+ * types with their own UnmarshalJSON are not looked into. A Duration may be a
+ * string or a number, a field with the `,string` option must be a string.
+ * YAML and TOML files are checked after their conversion to JSON, so the
+ * paths are the same; XML files and evaluated sources are not checked.
+ */
+
+// WithStrict rejects a file with keys the struct does not have, or values of
+// the wrong type, with a *StrictError.
+func WithStrict() LoadOption {
+	return func(o *loadOptions) { o.strict = true }
+}
+
+// StrictError lists the problems the strict check found in a config file.
+type StrictError struct {
+	Path     string
+	Problems []string // localized messages, each starting with the document path
+}
+
+func (e *StrictError) Error() string {
+	return t("config.strict_failed", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// checkStrictFile checks the config file at path, or its namespace, against
+// the type of config. A file that cannot be read or parsed passes: decoding
+// reports it.
+func checkStrictFile(path, namespace string, config interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	format := formatForRead(path, content)
+	if format == FormatXML {
+		return nil
+	}
+	data, err := toJSON(format, content)
+	if err != nil {
+		return nil
+	}
+	root, err := parseJSONSpans(data)
+	if err != nil {
+		return nil
+	}
+	if namespace != "" {
+		var found *jsonNode
+		for _, m := range root.members {
+			if m.key == namespace {
+				found = m.value
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		root = found
+	}
+	c := &strictChecker{data: data, root: root}
+	c.value(root, reflect.TypeOf(config), "", "")
+	if len(c.problems) > 0 {
+		return &StrictError{Path: path, Problems: c.problems}
+	}
+	return nil
+}
+
+type strictChecker struct {
+	data     []byte
+	root     *jsonNode // may hold the metadata block
+	problems []string
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// value checks the node n, found at path, against the Go type typ; opts are
+// the options of the json tag of its field.
+func (c *strictChecker) value(n *jsonNode, typ reflect.Type, path, opts string) {
+	got := c.kindOf(n)
+	if got == "null" {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			return
+		}
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == durationType:
+		if got != "string" && got != "number" {
+			c.mismatch(path, "string", got)
+		}
+		return
+	case reflect.PointerTo(typ).Implements(jsonUnmarshalerType):
+		return
+	case reflect.PointerTo(typ).Implements(textUnmarshalerType):
+		if got != "string" {
+			c.mismatch(path, "string", got)
+		}
+		return
+	}
+	if hasTagOption(opts, "string") {
+		switch typ.Kind() {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+			if got != "string" {
+				c.mismatch(path, "string", got)
+			}
+			return
+		}
+	}
+	raw := string(c.data[n.start:n.end])
+	switch typ.Kind() {
+	case reflect.Bool:
+		if got != "boolean" {
+			c.mismatch(path, "boolean", got)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if got != "number" || strings.ContainsAny(raw, ".eE") {
+			c.mismatch(path, "integer", got)
+		} else if _, err := strconv.ParseInt(raw, 10, typ.Bits()); err != nil {
+			c.problems = append(c.problems, t("config.strict_range", path, raw, typ.Kind()))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if got != "number" || strings.ContainsAny(raw, ".eE") {
+			c.mismatch(path, "integer", got)
+		} else if _, err := strconv.ParseUint(raw, 10, typ.Bits()); err != nil {
+			c.problems = append(c.problems, t("config.strict_range", path, raw, typ.Kind()))
+		}
+	case reflect.Float32, reflect.Float64:
+		if got != "number" {
+			c.mismatch(path, "number", got)
+		} else if _, err := strconv.ParseFloat(raw, typ.Bits()); err != nil {
+			c.problems = append(c.problems, t("config.strict_range", path, raw, typ.Kind()))
+		}
+	case reflect.String:
+		if got != "string" {
+			c.mismatch(path, "string", got)
+		}
+	case reflect.Struct:
+		if got != "object" {
+			c.mismatch(path, "object", got)
+			return
+		}
+		c.object(n, typ, path)
+	case reflect.Map:
+		if got != "object" {
+			c.mismatch(path, "object", got)
+			return
+		}
+		c.object(n, typ, path)
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 && got == "string" {
+			return // []byte as base64
+		}
+		if got != "array" {
+			c.mismatch(path, "array", got)
+			return
+		}
+		for i, elem := range n.elems {
+			c.value(elem, typ.Elem(), fmt.Sprintf("%s[%d]", path, i), "")
+		}
+	}
+}
+
+// object checks the members of the object n against the struct or map type
+// typ.
+func (c *strictChecker) object(n *jsonNode, typ reflect.Type, path string) {
+	seen := make(map[string]bool, len(n.members))
+	for _, m := range n.members {
+		memberPath := joinDocumentPath(path, m.key)
+		if seen[m.key] {
+			c.problems = append(c.problems, t("config.strict_duplicate_key", memberPath))
+		}
+		seen[m.key] = true
+		if typ.Kind() == reflect.Map {
+			c.value(m.value, typ.Elem(), memberPath, "")
+			continue
+		}
+		field, ok := jsonField(typ, m.key)
+		switch {
+		case ok && jsonName(field) == m.key:
+			_, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			c.value(m.value, field.Type, memberPath, opts)
+		case ok:
+			c.problems = append(c.problems, t("config.strict_key_case", memberPath, jsonName(field)))
+		case m.key == metadataKey && n == c.root:
+		case strings.HasSuffix(m.key, shadowSuffix) && isSecureField(typ, strings.TrimSuffix(m.key, shadowSuffix)):
+		default:
+			c.problems = append(c.problems, t("config.strict_unknown_key", memberPath))
+		}
+	}
+}
+
+// kindOf returns the JSON type of n.
+func (c *strictChecker) kindOf(n *jsonNode) string {
+	switch n.kind {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	}
+	switch string(c.data[n.start:n.end]) {
+	case "true", "false":
+		return "boolean"
+	case "null":
+		return "null"
+	}
+	return "number"
+}
+
+// mismatch records a value of the JSON type got where want was expected.
+func (c *strictChecker) mismatch(path, want, got string) {
+	if path == "" {
+		path = "."
+	}
+	c.problems = append(c.problems, t("config.strict_type", path, t("config.strict_kind_"+want), t("config.strict_kind_"+got)))
+}
+
+// jsonName returns the key of the struct field in JSON.
+func jsonName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// hasTagOption reports whether the comma-separated options of a json tag
+// contain option.
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package sconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type strictServer struct {
+	DatabaseHost string `json:"database_host"`
+	DatabasePort int    `json:"database_port"`
+}
+
+type strictTestConfig struct {
+	Version  int               `json:"version"`
+	Debug    bool              `json:"debug"`
+	Ratio    float64           `json:"ratio"`
+	Workers  uint8             `json:"workers"`
+	Timeout  time.Duration     `json:"timeout"`
+	Limit    int               `json:"limit,string"`
+	Servers  []strictServer    `json:"servers"`
+	Labels   map[string]string `json:"labels"`
+	Extra    interface{}       `json:"extra"`
+	Owner    *strictServer     `json:"owner"`
+	Token    string            `json:"token" secure:"true"`
+	Password SecureString      `json:"password"`
+}
+
+func TestCheckStrict(ts *testing.T) {
+	origLang := getCurrentLanguage()
+	defer setLanguage(origLang)
+	setLanguage("en")
+	tempDir := ts.TempDir()
+	check := func(content string) []string {
+		path := filepath.Join(tempDir, "strict.jsonc")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			ts.Fatal(err)
+		}
+		err := checkStrictFile(path, "", &strictTestConfig{})
+		var serr *StrictError
+		if err != nil && !errors.As(err, &serr) {
+			ts.Fatalf("unexpected error %v", err)
+		}
+		if serr == nil {
+			return nil
+		}
+		return serr.Problems
+	}
+
+	valid := `{
+		"_sconfig": {"schema": "x"},
+		"version": 1, "debug": true, "ratio": 0.5, "workers": 8, "timeout": "5s",
+		"limit": "10", // a string by the tag
+		"servers": [{"database_host": "a", "database_port": 5432}],
+		"labels": {"any": "key"}, "extra": {"free": [1, "form"]}, "owner": null,
+		"token": "marker", "token__enc": "sealed", "password": null,
+	}`
+	if problems := check(valid); problems != nil {
+		ts.Errorf("valid file rejected: %q", problems)
+	}
+
+	invalid := `{
+		"debug": "yes",
+		"Ratio": 1,
+		"workers": 300,
+		"limit": 10,
+		"servers": [{"database_host": "a"}, {"database_port": 1.5}, {"database_port": "5432", "databse_host": "b"}],
+		"labels": {"a": 1},
+		"owner": {"database_port": null},
+		"version": 1,
+		"version": 2,
+		"nested": {"_sconfig": {}}
+	}`
+	want := []string{
+		"debug: expected boolean, got string",
+		`Ratio: unknown key, did you mean "ratio"?`,
+		"workers: 300 is out of range for uint8",
+		"limit: expected string, got number",
+		"servers[1].database_port: expected integer, got number",
+		"servers[2].database_port: expected integer, got string",
+		"servers[2].databse_host: unknown key",
+		"labels.a: expected string, got number",
+		"owner.database_port: expected integer, got null",
+		"version: duplicate key",
+		"nested: unknown key",
+	}
+	if problems := check(invalid); !reflect.DeepEqual(problems, want) {
+		ts.Errorf("problems:\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+	if problems := check(`[1]`); !reflect.DeepEqual(problems, []string{".: expected object, got array"}) {
+		ts.Errorf("root array: %q", problems)
+	}
+}
+
+func TestLoad_Strict(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	origLang := getCurrentLanguage()
+	defer setLanguage(origLang)
+	hw := WithHardwareIDFunc(func() (uint64, error) { return 4711, nil })
+	configPath := filepath.Join(tempDir, "strict.yaml")
+	content := "database_host: db.internal\ndatabase_prot: 5433\n"
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+
+	// Without WithStrict the typo silently keeps the default.
+	cfg := &TestConfig{}
+	if err := Load(cfg, configPath, hw, WithVersion(1)); err != nil || cfg.DatabasePort != 5432 {
+		ts.Fatalf("Load = %v, port %d", err, cfg.DatabasePort)
+	}
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	setLanguage("de")
+	err := Load(&TestConfig{}, configPath, hw, WithVersion(1), WithStrict())
+	var serr *StrictError
+	if !errors.As(err, &serr) || len(serr.Problems) != 1 || serr.Problems[0] != "database_prot: unbekannter Schlüssel" {
+		ts.Fatalf("strict Load = %v", err)
+	}
+	if raw, _ := os.ReadFile(configPath); string(raw) != content {
+		ts.Errorf("rejected file was rewritten:\n%s", raw)
+	}
+
+	// A file written by sconfig passes, also in a namespace.
+	if err := os.WriteFile(configPath, []byte("database_host: db.internal\n"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := Load(&TestConfig{}, configPath, hw, WithVersion(1), WithStrict()); err != nil {
+			ts.Fatalf("strict Load of a valid file (round %d): %v", i, err)
+		}
+	}
+	sharedPath := filepath.Join(tempDir, "strict-shared.json")
+	if err := os.WriteFile(sharedPath, []byte(`{"billing": {"currency": "USD"}, "other": {"anything": true}}`), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := Load(&billingTestConfig{}, sharedPath, hw, WithNamespace("billing"), WithStrict()); err != nil {
+		ts.Errorf("strict Load of a namespace: %v", err)
+	}
+	if err := Load(&billingTestConfig{}, sharedPath, hw, WithNamespace("billing"), WithStrict()); err != nil {
+		ts.Errorf("strict reload of a namespace: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package sconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+/*
+ * Template values.
+ *
+ * Configs that need light parameterization may use Go template expressions in
+ * string values, e.g. "{{ .Hostname }}-worker". After SetTemplateData has been
+ * called, LoadConfig renders them against the given data and functions, like
+ * secret references in memory only: the file keeps the template, and
+ * UpdateConfig writes the template back as long as the field was not changed.
+ *
+ * Password fields and secret references are never rendered, and a reference
+ * to a missing key is an error instead of "<no value>".
+ *
+ * RenderSecretTemplate works the other way round: it renders an external file
+ * (an nginx or fluentd config, say) against the loaded config, so sconfig can
+ * be the single source of secrets for programs that are not written in Go.
+ */
+
+// templateContext holds the data and functions templates are rendered with.
+type templateContext struct {
+	data  interface{}
+	funcs template.FuncMap
+}
+
+var (
+	templateMu  sync.Mutex
+	templateCtx *templateContext
+)
+
+// SetTemplateData enables template rendering of string values for the next
+// LoadConfig. data is the value of "." in the templates (typically a
+// map[string]interface{}), funcs are additional template functions. With nil
+// data and nil funcs, template rendering is disabled again (default).
+func SetTemplateData(data interface{}, funcs template.FuncMap) {
+	templateMu.Lock()
+	defer templateMu.Unlock()
+	if data == nil && funcs == nil {
+		templateCtx = nil
+		return
+	}
+	templateCtx = &templateContext{data: data, funcs: funcs}
+}
+
+func getTemplateContext() *templateContext {
+	templateMu.Lock()
+	defer templateMu.Unlock()
+	return templateCtx
+}
+
+/*
+ * Render the template expressions in the non-secret string fields of one
+ * struct.
+ */
+func (w *walker) renderTemplates(v reflect.Value, info *structInfo) error {
+	secret := make(map[int]bool, 2*len(info.pairs))
+	for _, pair := range info.pairs {
+		secret[pair.plain], secret[pair.secure] = true, true
+	}
+	for _, i := range info.strs {
+		fieldValue := v.Field(i)
+		src := fieldValue.String()
+		if secret[i] || !fieldValue.CanSet() || isSecretRef(src) || !strings.Contains(src, "{{") {
+			continue
+		}
+		name := v.Type().Field(i).Name
+		tmpl, err := template.New(name).Funcs(w.tmpl.funcs).Option("missingkey=error").Parse(src)
+		if err != nil {
+			return fmt.Errorf("%s", t("config.template_failed", name, err))
+		}
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, w.tmpl.data); err != nil {
+			return fmt.Errorf("%s", t("config.template_failed", name, err))
+		}
+		fieldValue.SetString(rendered.String())
+		w.refs = append(w.refs, secretBinding{field: fieldValue, ref: src, resolved: rendered.String()})
+	}
+	return nil
+}
+
+// RenderSecretTemplate renders the Go template file at tmplPath with the
+// loaded config as "." (so {{ .Database.Password }} is the decrypted
+// password) and writes the result to outPath with mode 0600, replacing the
+// file atomically. Functions given to SetTemplateData are available. Missing
+// fields are an error.
+func RenderSecretTemplate(tmplPath, outPath string, config interface{}) error {
+	src, err := os.ReadFile(tmplPath)
+	if err != nil {
+		return fmt.Errorf(t("config.read_failed"), err)
+	}
+	var funcs template.FuncMap
+	if ctx := getTemplateContext(); ctx != nil {
+		funcs = ctx.funcs
+	}
+	tmpl, err := template.New(tmplPath).Funcs(funcs).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return fmt.Errorf("%s", t("config.render_failed", tmplPath, err))
+	}
+	var rendered bytes.Buffer
+	defer func() { wipe(rendered.Bytes()[:rendered.Cap()]) }()
+	if err := tmpl.Execute(&rendered, config); err != nil {
+		return fmt.Errorf("%s", t("config.render_failed", tmplPath, err))
+	}
+	return writeFileAtomic(outPath, 0600, func(w io.Writer) error {
+		_, err := w.Write(rendered.Bytes())
+		return err
+	})
+}
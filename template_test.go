@@ -0,0 +1,96 @@
+package sconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestLoadConfig_Templates(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	SetTemplateData(map[string]interface{}{"Hostname": "node7"}, template.FuncMap{"upper": strings.ToUpper})
+	defer SetTemplateData(nil, nil)
+
+	configPath := filepath.Join(tempDir, "templates.json")
+	content := `{
+		"database_host": "{{ .Hostname }}-worker",
+		"database_name": "{{ upper .Hostname }}",
+		"database_password": "{{ not rendered }}"
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DatabaseHost != "node7-worker" || cfg.DatabaseName != "NODE7" {
+		ts.Errorf("templates not rendered: host=%q name=%q", cfg.DatabaseHost, cfg.DatabaseName)
+	}
+	if cfg.DatabasePassword != "{{ not rendered }}" {
+		ts.Errorf("password field rendered: %q", cfg.DatabasePassword)
+	}
+
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+	raw, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(raw), "{{ .Hostname }}-worker") || strings.Contains(string(raw), "node7-worker") {
+		ts.Errorf("expected the template in the file:\n%s", raw)
+	}
+
+	ts.Run("Missing key", func(ts *testing.T) {
+		path := filepath.Join(tempDir, "templates_missing.json")
+		if err := os.WriteFile(path, []byte(`{"database_host": "{{ .Missing }}"}`), 0644); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		err := LoadConfig(&TestConfig{}, 1, path, false, false)
+		if err == nil || !strings.Contains(err.Error(), "DatabaseHost") {
+			ts.Errorf("expected template error for DatabaseHost, got %v", err)
+		}
+	})
+
+	ts.Run("Disabled", func(ts *testing.T) {
+		SetTemplateData(nil, nil)
+		cfg := &TestConfig{}
+		if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+			ts.Fatalf("LoadConfig failed: %v", err)
+		}
+		if cfg.DatabaseHost != "{{ .Hostname }}-worker" {
+			ts.Errorf("template rendered while disabled: %q", cfg.DatabaseHost)
+		}
+	})
+}
+
+func TestRenderSecretTemplate(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	cfg := &TestConfig{DatabasePassword: "render-secret"}
+	if err := LoadConfig(cfg, 1, filepath.Join(tempDir, "render.json"), false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	tmplPath := filepath.Join(tempDir, "app.conf.tmpl")
+	outPath := filepath.Join(tempDir, "app.conf")
+	if err := os.WriteFile(tmplPath, []byte("host {{ .DatabaseHost }}\npassword {{ .DatabasePassword }}\n"), 0644); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := RenderSecretTemplate(tmplPath, outPath, cfg); err != nil {
+		ts.Fatalf("RenderSecretTemplate failed: %v", err)
+	}
+	raw, _ := os.ReadFile(outPath)
+	if string(raw) != "host localhost\npassword render-secret\n" {
+		ts.Errorf("unexpected output:\n%s", raw)
+	}
+	if info, _ := os.Stat(outPath); filepath.Separator == '/' && info.Mode().Perm() != 0600 {
+		ts.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	os.WriteFile(tmplPath, []byte("{{ .NoSuchField }}"), 0644)
+	if err := RenderSecretTemplate(tmplPath, outPath, cfg); err == nil {
+		ts.Error("expected error for a missing field")
+	}
+	if raw2, _ := os.ReadFile(outPath); string(raw2) != string(raw) {
+		ts.Error("a failed render replaced the output file")
+	}
+}
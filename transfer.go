@@ -0,0 +1,361 @@
+package sconfig
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+ * Transferring configs to a new machine over the network.
+ *
+ * A bundle (see bundle.go) needs a key agreed out of band and a file that is
+ * copied around. For moving the configs of a machine to its replacement,
+ * ReceiveTransfer and SendTransfer do the same over one TCP connection,
+ * guarded by a short-lived pairing code:
+ *
+ *  1. The new machine listens (sconfig transfer --receive :7420 /etc/app)
+ *     and shows a random pairing code of 10 characters, valid for 10 minutes.
+ *  2. The old machine connects (sconfig transfer --to new-host:7420
+ *     config.json) and the operator enters the code there.
+ *  3. Both sides agree on a key with ephemeral X25519 and prove to each
+ *     other that they know the code: each sends an HMAC of the transcript
+ *     under a key derived from the code with Argon2id, so a man in the middle
+ *     gets one guess and cannot brute-force the code in its lifetime. The
+ *     sender reveals the passwords only after the receiver's proof.
+ *  4. The sender packs the configs like PackBundle, with the passwords
+ *     decrypted in memory, and sends the archive encrypted with the session
+ *     key. The receiver checks it, encrypts the passwords with its own key,
+ *     writes the files (0600) and confirms.
+ *
+ * The receiver accepts exactly one connection: a wrong code ends the
+ * transfer, and a new code is needed. Plaintext passwords never reach the
+ * disk on either side. Both sides write audit lines. XML configs are not
+ * supported.
+ *
+ * The connections are opened by the Transport (see transport.go), TCP by
+ * default. Every message is a frame: a 4-byte big-endian length and the
+ * payload.
+ */
+
+const (
+	transferMagic        = "sconfig-transfer-v1"
+	transferCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789" // no 0/O, 1/I/L
+	transferCodeLength   = 10
+	transferCodeLifetime = 10 * time.Minute
+	transferTimeout      = 2 * time.Minute // for the exchange after connecting
+	transferMaxFrame     = 64 << 20
+)
+
+// transferResult is the confirmation of the receiver.
+type transferResult struct {
+	Files     int    `json:"files"`
+	Passwords int    `json:"passwords"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewTransferCode returns a random pairing code for ReceiveTransfer, e.g.
+// "K7MQ2-XH4RT".
+func NewTransferCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(transferCodeAlphabet)))
+	for i := 0; i < transferCodeLength; i++ {
+		if i == transferCodeLength/2 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(transferCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// normalizeTransferCode makes the code as entered comparable: upper case,
+// without dashes and spaces.
+func normalizeTransferCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// ListenTransfer listens on addr for ReceiveTransfer with the transport set
+// with SetTransport.
+func ListenTransfer(addr string) (net.Listener, error) {
+	return getTransport().Listen(addr)
+}
+
+// ReceiveTransfer waits on l (from ListenTransfer, or any listener) for one
+// SendTransfer with code (from NewTransferCode) for at most 10 minutes,
+// writes the received config files to destDir with the passwords encrypted
+// with the key of this machine and returns their paths. Existing files of the
+// same name are replaced. It returns after the first connection, whether the
+// transfer succeeded or not; l is closed when the code expires.
+func ReceiveTransfer(l net.Listener, code, destDir string) ([]string, error) {
+	var expired atomic.Bool
+	timer := time.AfterFunc(transferCodeLifetime, func() {
+		expired.Store(true)
+		l.Close()
+	})
+	conn, err := l.Accept()
+	timer.Stop()
+	if err != nil {
+		if expired.Load() {
+			return nil, fmt.Errorf("%s", t("config.transfer_expired"))
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(transferTimeout))
+	peer := conn.RemoteAddr().String()
+
+	session, err := transferHandshake(conn, code, true)
+	if err != nil {
+		auditLog(t("config.transfer_audit_rejected", peer, err))
+		return nil, err
+	}
+	defer wipe(session)
+	frame, err := readTransferFrame(conn, peer)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := decryptWithKey(session, string(frame))
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.transfer_protocol", peer))
+	}
+	archiveData := []byte(archive)
+	defer wipe(archiveData)
+	written, passwords, err := receiveTransferArchive(peer, archiveData, destDir)
+	result := transferResult{Files: len(written), Passwords: passwords}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if sendErr := writeTransferResult(conn, session, result); err == nil {
+		err = sendErr
+	}
+	if err != nil {
+		return written, err
+	}
+	auditLog(t("config.transfer_audit_receive", peer, destDir, len(written), passwords))
+	return written, nil
+}
+
+// receiveTransferArchive checks the received archive and writes its files.
+func receiveTransferArchive(peer string, archive []byte, destDir string) ([]string, int, error) {
+	manifest, contents, err := readBundleArchive(peer, archive)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		for _, content := range contents {
+			wipe(content)
+		}
+	}()
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		return nil, 0, err
+	}
+	return unpackBundleFiles(manifest, contents, destDir)
+}
+
+func writeTransferResult(conn net.Conn, session []byte, result transferResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptWithKey(session, string(data))
+	if err != nil {
+		return err
+	}
+	return writeTransferFrame(conn, []byte(sealed))
+}
+
+// SendTransfer sends the config files at paths to the ReceiveTransfer at
+// addr ("host:port") that shows code, with the transport set with
+// SetTransport. The passwords are decrypted with the
+// key of this machine in memory only. The files must have distinct base
+// names.
+func SendTransfer(addr, code string, paths []string) error {
+	stateMu.Lock()
+	if err := config_init(secure_config_getHardwareID, false); err != nil {
+		stateMu.Unlock()
+		return err
+	}
+	archive, manifest, passwords, err := packBundleArchive(paths, addr)
+	stateMu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer wipe(archive)
+
+	conn, err := getTransport().Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(transferTimeout))
+
+	session, err := transferHandshake(conn, code, false)
+	if err != nil {
+		return err
+	}
+	defer wipe(session)
+	sealed, err := encryptWithKey(session, string(archive))
+	if err != nil {
+		return err
+	}
+	if err := writeTransferFrame(conn, []byte(sealed)); err != nil {
+		return err
+	}
+	frame, err := readTransferFrame(conn, addr)
+	if err != nil {
+		return err
+	}
+	data, err := decryptWithKey(session, string(frame))
+	var result transferResult
+	if err != nil || json.Unmarshal([]byte(data), &result) != nil {
+		return fmt.Errorf("%s", t("config.transfer_protocol", addr))
+	}
+	if result.Error != "" {
+		return fmt.Errorf("%s", t("config.transfer_remote", result.Error))
+	}
+	auditLog(t("config.transfer_audit_send", len(manifest.Files), passwords, addr))
+	return nil
+}
+
+// transferHandshake agrees on the session key with the peer on conn and
+// checks that both sides know code. The receiver speaks first.
+func transferHandshake(conn net.Conn, code string, receiver bool) ([]byte, error) {
+	peer := conn.RemoteAddr().String()
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	own := priv.PublicKey().Bytes()
+	var receiverKey, senderKey, peerKey []byte
+	if receiver {
+		if err := writeTransferFrame(conn, append([]byte(transferMagic), own...)); err != nil {
+			return nil, err
+		}
+		frame, err := readTransferFrame(conn, peer)
+		if err != nil {
+			return nil, err
+		}
+		receiverKey, senderKey, peerKey = own, frame, frame
+	} else {
+		frame, err := readTransferFrame(conn, peer)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := bytes.CutPrefix(frame, []byte(transferMagic))
+		if !ok {
+			return nil, fmt.Errorf("%s", t("config.transfer_protocol", peer))
+		}
+		if err := writeTransferFrame(conn, own); err != nil {
+			return nil, err
+		}
+		receiverKey, senderKey, peerKey = key, own, key
+	}
+	pub, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.transfer_protocol", peer))
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%s", t("config.transfer_protocol", peer))
+	}
+	defer wipe(shared)
+
+	h := sha256.New()
+	h.Write([]byte(transferMagic))
+	h.Write(receiverKey)
+	h.Write(senderKey)
+	transcript := h.Sum(nil)
+	authKey := argon2.IDKey([]byte(normalizeTransferCode(code)), transcript, 1, 64*1024, 4, 32)
+	defer wipe(authKey)
+	senderProof := transferMAC(authKey, "sender", transcript)
+	receiverProof := transferMAC(authKey, "receiver", transcript)
+
+	wrongCode := fmt.Errorf("%s", t("config.transfer_code_wrong"))
+	if receiver {
+		proof, err := readTransferFrame(conn, peer)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(proof, senderProof) {
+			return nil, wrongCode
+		}
+		if err := writeTransferFrame(conn, receiverProof); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := writeTransferFrame(conn, senderProof); err != nil {
+			return nil, err
+		}
+		proof, err := readTransferFrame(conn, peer)
+		if err != nil {
+			// The receiver closes the connection on a wrong code.
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, wrongCode
+			}
+			return nil, err
+		}
+		if !hmac.Equal(proof, receiverProof) {
+			return nil, wrongCode
+		}
+	}
+	return transferMAC(shared, "session", transcript), nil
+}
+
+// transferMAC returns HMAC-SHA256 under key of label and data.
+func transferMAC(key []byte, label string, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(label))
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+func writeTransferFrame(w io.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readTransferFrame(r io.Reader, peer string) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > transferMaxFrame {
+		return nil, fmt.Errorf("%s", t("config.transfer_protocol", peer))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,96 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransfer(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "app.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "app-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	securedPath := filepath.Join(tempDir, "secured.json")
+	writeSecuredConfig(ts, securedPath, "token-secret", hw)
+	code, err := NewTransferCode()
+	if err != nil {
+		ts.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		ts.Fatalf("unexpected code %q", code)
+	}
+
+	receive := func(destDir string) (string, chan error) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			ts.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			defer l.Close()
+			_, err := ReceiveTransfer(l, code, destDir)
+			done <- err
+		}()
+		return l.Addr().String(), done
+	}
+
+	// A wrong code ends the transfer on both sides before any secret is sent.
+	addr, done := receive(filepath.Join(tempDir, "rejected"))
+	if err := SendTransfer(addr, "AAAAA-AAAAA", []string{configPath}); err == nil || !strings.Contains(err.Error(), t("config.transfer_code_wrong")) {
+		ts.Fatalf("expected a pairing error, got %v", err)
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), t("config.transfer_code_wrong")) {
+		ts.Fatalf("expected a pairing error on the receiver, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "rejected")); !os.IsNotExist(err) {
+		ts.Fatalf("files written after a wrong code: %v", err)
+	}
+
+	destDir := filepath.Join(tempDir, "target")
+	addr, done = receive(destDir)
+	if err := SendTransfer(addr, strings.ToLower(strings.ReplaceAll(code, "-", " ")), []string{configPath, securedPath}); err != nil {
+		ts.Fatalf("SendTransfer failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		ts.Fatalf("ReceiveTransfer failed: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(destDir, "app.json"))
+	if err != nil {
+		ts.Fatal(err)
+	}
+	if strings.Contains(string(raw), "app-secret") {
+		ts.Fatalf("plaintext password written:\n%s", raw)
+	}
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, filepath.Join(destDir, "app.json"), false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig of the received file failed: %v", err)
+	}
+	if cfg.DatabasePassword != "app-secret" {
+		ts.Errorf("DatabasePassword = %q", cfg.DatabasePassword)
+	}
+
+	// Secure fields travel in plaintext and are encrypted anew on arrival.
+	sent, _ := os.ReadFile(securedPath)
+	received, _ := os.ReadFile(filepath.Join(destDir, "secured.json"))
+	var a, b map[string]interface{}
+	json.Unmarshal(sent, &a)
+	json.Unmarshal(received, &b)
+	if sealed, _ := b["api_token__enc"].(string); sealed == "" || sealed == a["api_token__enc"] {
+		ts.Errorf("secure field not encrypted on arrival:\n%s", received)
+	}
+	secured := &securedTestConfig{}
+	if err := LoadConfig(secured, 1, filepath.Join(destDir, "secured.json"), false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig of the received file failed: %v", err)
+	}
+	if secured.APIToken != "token-secret" {
+		ts.Errorf("APIToken = %q", secured.APIToken)
+	}
+}
@@ -0,0 +1,84 @@
+package sconfig
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+/*
+ * Transports for remote operations.
+ *
+ * The remote operations (SendTransfer and ListenTransfer, see transfer.go)
+ * do not open network connections themselves but ask the Transport set with
+ * SetTransport. The default is plain TCP; TLSTransport runs the same protocol
+ * over TLS, with client certificates for mutual authentication. Other
+ * environments plug in their own Transport: an SSH client already dials and
+ * listens with net.Conn and net.Listener (golang.org/x/crypto/ssh), and a
+ * message queue can be adapted with net.Pipe. The protocols on top stay end
+ * to end encrypted and authenticated whatever the transport, which only
+ * carries the bytes; the health endpoint is an http.Handler and is served by
+ * whatever server the application runs.
+ */
+
+// Transport opens the connections of the remote operations. addr is passed
+// through as given by the caller, e.g. "host:7420".
+type Transport interface {
+	Dial(addr string) (net.Conn, error)
+	Listen(addr string) (net.Listener, error)
+}
+
+var (
+	transportMu      sync.Mutex
+	currentTransport Transport = TCPTransport{}
+)
+
+// SetTransport sets the transport of the remote operations; nil restores
+// TCPTransport.
+func SetTransport(t Transport) {
+	if t == nil {
+		t = TCPTransport{}
+	}
+	transportMu.Lock()
+	currentTransport = t
+	transportMu.Unlock()
+}
+
+func getTransport() Transport {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	return currentTransport
+}
+
+// TCPTransport is the default transport: plain TCP connections.
+type TCPTransport struct{}
+
+// Dial connects to addr with a timeout.
+func (TCPTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, transferTimeout)
+}
+
+// Listen listens on addr.
+func (TCPTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// TLSTransport returns a transport over TLS with config, which holds the
+// certificate of this side. For mutual TLS, set ClientAuth to
+// tls.RequireAndVerifyClientCert and ClientCAs on the listening side, and
+// RootCAs on the dialing side.
+func TLSTransport(config *tls.Config) Transport {
+	return tlsTransport{config: config}
+}
+
+type tlsTransport struct {
+	config *tls.Config
+}
+
+func (t tlsTransport) Dial(addr string) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: transferTimeout}, "tcp", addr, t.config)
+}
+
+func (t tlsTransport) Listen(addr string) (net.Listener, error) {
+	return tls.Listen("tcp", addr, t.config)
+}
@@ -0,0 +1,140 @@
+package sconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeTransport connects Dial and Listen in memory, like a message queue
+// adapted with net.Pipe.
+type pipeTransport struct {
+	conns chan net.Conn
+}
+
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func (p *pipeTransport) Dial(addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	p.conns <- server
+	return client, nil
+}
+
+func (p *pipeTransport) Listen(addr string) (net.Listener, error) {
+	return &pipeListener{conns: p.conns, closed: make(chan struct{})}, nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return &net.UnixAddr{Name: "pipe", Net: "pipe"} }
+
+func TestTransfer_Transports(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	defer SetTransport(nil)
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "app.json")
+	if err := LoadConfig(&TestConfig{DatabasePassword: "app-secret"}, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	transfer := func(name, addr string, receiver, sender Transport) error {
+		code, _ := NewTransferCode()
+		SetTransport(receiver)
+		l, err := ListenTransfer(addr)
+		if err != nil {
+			ts.Fatalf("%s: ListenTransfer failed: %v", name, err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := ReceiveTransfer(l, code, filepath.Join(tempDir, name))
+			l.Close()
+			done <- err
+		}()
+		SetTransport(sender)
+		err = SendTransfer(l.Addr().String(), code, []string{configPath})
+		if receiveErr := <-done; err == nil {
+			err = receiveErr
+		}
+		return err
+	}
+
+	pipe := &pipeTransport{conns: make(chan net.Conn, 1)}
+	if err := transfer("pipe", "queue", pipe, pipe); err != nil {
+		ts.Fatalf("transfer over pipe failed: %v", err)
+	}
+
+	// Mutual TLS: the receiver requires a client certificate of the CA.
+	cert, pool := testCertificate(ts)
+	mutual := TLSTransport(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ServerName:   "localhost",
+	})
+	if err := transfer("tls", "127.0.0.1:0", mutual, mutual); err != nil {
+		ts.Fatalf("transfer over mutual TLS failed: %v", err)
+	}
+	anonymous := TLSTransport(&tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err := transfer("notls", "127.0.0.1:0", mutual, anonymous); err == nil || strings.Contains(err.Error(), "app-secret") {
+		ts.Fatalf("expected a TLS error without a client certificate, got %v", err)
+	}
+}
+
+// testCertificate returns a self-signed certificate for localhost and a pool
+// holding it.
+func testCertificate(ts *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		ts.Fatal(err)
+	}
+	parsed, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
@@ -0,0 +1,149 @@
+package sconfig
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Two-person rule.
+ *
+ * Regulated environments may require that plaintext secrets are only ever
+ * written with the consent of two operators. After SetTwoPersonRule every
+ * plaintext operation (LoadConfig/UpdateConfig with cleanConfig and
+ * DumpForRecovery) needs a prior ApprovePlaintext with the passphrases of both
+ * operators. An approval is valid for one operation within approvalValidity.
+ *
+ * The rule stores only verifiers (salted PBKDF2-SHA256 hashes) created with
+ * HashApprovalPassphrase, never the passphrases.
+ */
+
+const (
+	approvalIterations = 600000
+	approvalValidity   = 5 * time.Minute
+	approvalScheme     = "pbkdf2-sha256"
+)
+
+var (
+	twoPersonMu     sync.Mutex
+	twoPersonRule   []string  // the two verifiers; nil: rule disabled
+	approvalExpires time.Time // zero: no pending approval
+)
+
+// HashApprovalPassphrase returns a verifier for passphrase, to be passed to
+// SetTwoPersonRule. Each operator creates their own (CLI: sconfig
+// approval-hash).
+func HashApprovalPassphrase(passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New(t("config.approval_invalid"))
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum, err := pbkdf2.Key(sha256.New, passphrase, salt, approvalIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", approvalScheme, approvalIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum)), nil
+}
+
+// SetTwoPersonRule requires the passphrases of two operators, given by their
+// verifiers from HashApprovalPassphrase, before any plaintext is written.
+// Calling it without verifiers disables the rule.
+func SetTwoPersonRule(verifiers ...string) error {
+	if len(verifiers) != 0 && len(verifiers) != 2 {
+		return errors.New(t("config.approval_rule_invalid"))
+	}
+	for _, v := range verifiers {
+		if _, _, _, err := parseVerifier(v); err != nil {
+			return errors.New(t("config.approval_rule_invalid"))
+		}
+	}
+	if len(verifiers) == 2 && verifiers[0] == verifiers[1] {
+		return errors.New(t("config.approval_rule_invalid"))
+	}
+	twoPersonMu.Lock()
+	defer twoPersonMu.Unlock()
+	twoPersonRule = append([]string(nil), verifiers...)
+	if len(verifiers) == 0 {
+		twoPersonRule = nil
+	}
+	approvalExpires = time.Time{}
+	return nil
+}
+
+// ApprovePlaintext approves the next plaintext operation with the passphrases
+// of two distinct operators. Without a two-person rule it has no effect.
+func ApprovePlaintext(passphrase1, passphrase2 string) error {
+	twoPersonMu.Lock()
+	rule := twoPersonRule
+	twoPersonMu.Unlock()
+	if rule == nil {
+		return nil
+	}
+	ok := passphrase1 != passphrase2 &&
+		(verifyPassphrase(rule[0], passphrase1) && verifyPassphrase(rule[1], passphrase2) ||
+			verifyPassphrase(rule[1], passphrase1) && verifyPassphrase(rule[0], passphrase2))
+	if !ok {
+		auditLog(t("config.approval_rejected"))
+		return errors.New(t("config.approval_rejected"))
+	}
+	twoPersonMu.Lock()
+	approvalExpires = time.Now().Add(approvalValidity)
+	twoPersonMu.Unlock()
+	auditLog(t("config.approval_granted"))
+	return nil
+}
+
+// requirePlaintextApproval consumes the pending approval if the two-person
+// rule is active, or returns an error if there is none.
+func requirePlaintextApproval() error {
+	twoPersonMu.Lock()
+	defer twoPersonMu.Unlock()
+	if twoPersonRule == nil {
+		return nil
+	}
+	valid := !approvalExpires.IsZero() && time.Now().Before(approvalExpires)
+	approvalExpires = time.Time{}
+	if !valid {
+		return errors.New(t("config.approval_required"))
+	}
+	return nil
+}
+
+func parseVerifier(v string) (iter int, salt, sum []byte, err error) {
+	parts := strings.Split(v, "$")
+	if len(parts) != 4 || parts[0] != approvalScheme {
+		return 0, nil, nil, errors.New("unknown verifier format")
+	}
+	if iter, err = strconv.Atoi(parts[1]); err != nil || iter < 1 {
+		return 0, nil, nil, errors.New("invalid iteration count")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, err
+	}
+	if sum, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return 0, nil, nil, err
+	}
+	return iter, salt, sum, nil
+}
+
+func verifyPassphrase(verifier, passphrase string) bool {
+	iter, salt, sum, err := parseVerifier(verifier)
+	if err != nil || passphrase == "" {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, passphrase, salt, iter, len(sum))
+	return err == nil && subtle.ConstantTimeCompare(got, sum) == 1
+}
@@ -0,0 +1,55 @@
+package sconfig
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTwoPersonRule(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	configPath := filepath.Join(tempDir, "twoperson.json")
+	alice, err := HashApprovalPassphrase("alice passphrase")
+	if err != nil {
+		ts.Fatalf("HashApprovalPassphrase failed: %v", err)
+	}
+	bob, _ := HashApprovalPassphrase("bob passphrase")
+	if err := SetTwoPersonRule(alice, alice); err == nil {
+		ts.Error("expected error for identical verifiers")
+	}
+	if err := SetTwoPersonRule(alice, bob); err != nil {
+		ts.Fatalf("SetTwoPersonRule failed: %v", err)
+	}
+	defer SetTwoPersonRule()
+
+	cfg := &TestConfig{DatabasePassword: "two-person-secret"}
+	if err := LoadConfig(cfg, 1, configPath, false, false); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := UpdateConfig(cfg, configPath, true); err == nil || !contains(err.Error(), t("config.approval_required")) {
+		ts.Fatalf("expected approval error, got %v", err)
+	}
+	if _, err := DumpForRecovery(configPath, RecoveryConfirmation); err == nil || !contains(err.Error(), t("config.approval_required")) {
+		ts.Fatalf("expected approval error, got %v", err)
+	}
+
+	if err := ApprovePlaintext("alice passphrase", "alice passphrase"); err == nil {
+		ts.Error("one operator approved alone")
+	}
+	if err := ApprovePlaintext("alice passphrase", "wrong"); err == nil {
+		ts.Error("wrong passphrase accepted")
+	}
+	if err := ApprovePlaintext("bob passphrase", "alice passphrase"); err != nil {
+		ts.Fatalf("ApprovePlaintext failed: %v", err)
+	}
+	if err := UpdateConfig(cfg, configPath, true); err != nil {
+		ts.Fatalf("approved UpdateConfig failed: %v", err)
+	}
+	// The approval is consumed by the first plaintext operation.
+	if err := LoadConfig(&TestConfig{}, 1, configPath, true, false); err == nil || !contains(err.Error(), t("config.approval_required")) {
+		ts.Fatalf("expected approval error on second operation, got %v", err)
+	}
+	// Encrypted writes are not affected by the rule.
+	if err := UpdateConfig(cfg, configPath); err != nil {
+		ts.Fatalf("UpdateConfig failed: %v", err)
+	}
+}
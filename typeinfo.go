@@ -0,0 +1,179 @@
+package sconfig
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Cached per-type field metadata for the reflection walkers.
+ *
+ * Large generated configs (thousands of fields, deeply nested slices) would
+ * otherwise pay on every load for the tag lookups and for the quadratic search
+ * of the matching <Name>Password field for each <Name>SecurePassword field.
+ * The metadata only depends on the struct type, so it is computed once and
+ * shared by all walkers.
+ */
+
+// passwordPair links a <Name>SecurePassword field to its <Name>Password field.
+type passwordPair struct {
+	name   string // <Name> prefix, used in error messages
+	desc   string // `desc:"..."` of <Name>Password, used in error messages
+	secure int    // index of <Name>SecurePassword
+	plain  int    // index of <Name>Password
+	binary bool   // <Name>Password is a []byte
+	wiped  bool   // <Name>Password is a SecureString, wiped when scrubbed
+}
+
+// isRef reports whether the <Name>Password field of the struct v holds a
+// secret reference; a binary password never does.
+func (pair passwordPair) isRef(v reflect.Value) bool {
+	return !pair.binary && isSecretRef(v.Field(pair.plain).String())
+}
+
+// defaultField is a scalar, time.Time or scalar slice field carrying a
+// `default:"..."` tag.
+type defaultField struct {
+	index  int
+	value  string
+	layout string // `layout:"..."` of a time.Time field
+	sep    string // separator of the elements of a slice default
+}
+
+// checkField is a string field carrying a `preflight:"..."` tag.
+type checkField struct {
+	index int
+	kind  string // "path", "file", "dir" or "tcp"
+}
+
+// structInfo holds everything the walkers need to know about one struct type.
+type structInfo struct {
+	versionIndex int   // index of an integer "Version" field, -1 if none
+	nested       []int // fields of struct type
+	ptrs         []int // fields of pointer-to-struct type
+	slices       []int // fields of slice-of-struct or slice-of-pointer-to-struct type
+	maps         []int // fields of map type with struct or pointer-to-struct values
+	strs         []int // fields of string kind (candidates for secret references)
+	secured      []int // string fields tagged secure:"true"
+	defaults     []defaultField
+	pairs        []passwordPair
+	checks       []checkField
+	tagged       []taggedField
+	rules        []validateField
+	descs        map[int]string // `desc:"..."` texts by field index
+}
+
+var structInfoCache sync.Map // reflect.Type -> *structInfo
+
+// getStructInfo returns the cached metadata for the struct type t.
+func getStructInfo(t reflect.Type) *structInfo {
+	if cached, ok := structInfoCache.Load(t); ok {
+		return cached.(*structInfo)
+	}
+	info := buildStructInfo(t)
+	actual, _ := structInfoCache.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+func buildStructInfo(t reflect.Type) *structInfo {
+	info := &structInfo{versionIndex: -1}
+	byName := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		byName[t.Field(i).Name] = i
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if desc := field.Tag.Get("desc"); desc != "" {
+			if info.descs == nil {
+				info.descs = make(map[int]string)
+			}
+			info.descs[i] = desc
+		}
+		if env, flag := field.Tag.Get("env"), field.Tag.Get("flag"); env != "" || flag != "" {
+			info.tagged = append(info.tagged, taggedField{index: i, env: env, flag: flag, layout: field.Tag.Get("layout")})
+		}
+		if tag, found := field.Tag.Lookup("validate"); found {
+			info.rules = append(info.rules, parseValidateTag(i, tag))
+		}
+		if field.Type == timeType {
+			if defaultValue, found := field.Tag.Lookup("default"); found {
+				info.defaults = append(info.defaults, defaultField{index: i, value: defaultValue, layout: field.Tag.Get("layout")})
+			}
+		}
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			info.nested = append(info.nested, i)
+			continue
+		case reflect.Slice:
+			if elem := field.Type.Elem(); elem.Kind() == reflect.Struct ||
+				elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct {
+				info.slices = append(info.slices, i)
+			} else if defaultValue, found := field.Tag.Lookup("default"); found {
+				sep := field.Tag.Get("sep")
+				if sep == "" {
+					sep = ","
+				}
+				info.defaults = append(info.defaults, defaultField{index: i, value: defaultValue, layout: field.Tag.Get("layout"), sep: sep})
+			}
+			continue
+		case reflect.Ptr:
+			if field.Type.Elem().Kind() == reflect.Struct && field.IsExported() {
+				info.ptrs = append(info.ptrs, i)
+				continue
+			}
+		case reflect.Map:
+			if elem := field.Type.Elem(); elem.Kind() == reflect.Struct ||
+				elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct {
+				info.maps = append(info.maps, i)
+			}
+		}
+		if field.Type.Kind() == reflect.String {
+			info.strs = append(info.strs, i)
+			if field.Tag.Get("secure") == "true" {
+				info.secured = append(info.secured, i)
+			}
+			if kind, found := field.Tag.Lookup("preflight"); found {
+				info.checks = append(info.checks, checkField{index: i, kind: kind})
+			}
+		}
+		if defaultValue, found := field.Tag.Lookup("default"); found {
+			info.defaults = append(info.defaults, defaultField{index: i, value: defaultValue})
+		}
+		if field.Name == "Version" && info.versionIndex < 0 {
+			switch field.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				info.versionIndex = i
+			}
+		}
+		if strings.HasSuffix(field.Name, "SecurePassword") {
+			prefix := strings.TrimSuffix(field.Name, "SecurePassword")
+			if j, ok := byName[prefix+"Password"]; ok && field.Type.Kind() == reflect.String {
+				pair := passwordPair{name: prefix, desc: t.Field(j).Tag.Get("desc"), secure: i, plain: j}
+				switch plain := t.Field(j).Type; {
+				case plain.Kind() == reflect.String:
+					info.pairs = append(info.pairs, pair)
+				case plain.Kind() == reflect.Slice && plain.Elem().Kind() == reflect.Uint8:
+					pair.binary = true
+					pair.wiped = plain == secureStringType
+					info.pairs = append(info.pairs, pair)
+				}
+			}
+		}
+	}
+	return info
+}
+
+// label names field i of the struct type t in error messages, followed by its
+// description if it has one.
+func (info *structInfo) label(t reflect.Type, i int) string {
+	return describedName(t.Field(i).Name, info.descs[i])
+}
+
+// describedName appends the description desc to name, if there is one.
+func describedName(name, desc string) string {
+	if desc == "" {
+		return name
+	}
+	return name + " (" + desc + ")"
+}
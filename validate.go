@@ -0,0 +1,213 @@
+package sconfig
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Validation tags.
+ *
+ * A config that lacks a value or holds an impossible one should stop the
+ * program at startup, not at the first request that needs it. Fields declare
+ * their constraints in a `validate:"..."` tag of comma-separated rules:
+ *
+ *	DatabaseHost string `json:"database_host" validate:"required"`
+ *	DatabasePort int    `json:"database_port" validate:"min=1,max=65535"`
+ *	Schema       string `json:"schema" validate:"pattern=^[a-z_]+$"`
+ *
+ * required rejects the zero value (an empty string, 0, false, an empty slice
+ * or map). min and max bound numbers by value and strings, slices and maps by
+ * length. pattern matches strings against a regular expression; as the
+ * expression may itself contain commas, pattern must be the last rule.
+ *
+ * LoadConfig validates after the file, the overrides and the secret
+ * references have been applied, so the values the program will use are
+ * checked, and returns all violations at once as a *ValidationError. A
+ * malformed tag is reported the same way, naming the field.
+ *
+ * Constraints that span several fields are checked by the struct itself: a
+ * config struct, or any nested struct, that implements Validator has its
+ * Validate method called after its tags are checked, nested structs before
+ * the struct containing them. The errors of all Validate methods are
+ * collected into the same *ValidationError, so one run reports everything
+ * that is wrong with the config.
+ */
+
+// Validator is implemented by config structs that check themselves after
+// loading, with a pointer or a value receiver.
+type Validator interface {
+	Validate() error
+}
+
+// validateField is a field carrying a `validate:"..."` tag.
+type validateField struct {
+	index    int
+	tag      string
+	required bool
+	min, max *float64
+	pattern  *regexp.Regexp
+	err      error // malformed tag
+}
+
+// parseValidateTag parses the rules of the validate tag on field i.
+func parseValidateTag(i int, tag string) validateField {
+	f := validateField{index: i, tag: tag}
+	rest := tag
+	for rest != "" {
+		var rule string
+		if strings.HasPrefix(rest, "pattern=") {
+			rule, rest = rest, ""
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			rule, rest = rest[:comma], rest[comma+1:]
+		} else {
+			rule, rest = rest, ""
+		}
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			f.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				f.err = fmt.Errorf("%s: %w", name, err)
+				return f
+			}
+			if name == "min" {
+				f.min = &n
+			} else {
+				f.max = &n
+			}
+		case "pattern":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				f.err = err
+				return f
+			}
+			f.pattern = re
+		default:
+			f.err = fmt.Errorf("unknown rule %q", rule)
+			return f
+		}
+	}
+	return f
+}
+
+// ValidationError lists the constraints a config violates.
+type ValidationError struct {
+	Violations []string // localized messages, one per violated rule or Validate error
+	errs       []error  // the errors returned by Validate methods
+}
+
+func (e *ValidationError) Error() string {
+	return t("config.validate_failed", strings.Join(e.Violations, "; "))
+}
+
+// Unwrap returns the errors returned by Validate methods, for errors.Is and
+// errors.As.
+func (e *ValidationError) Unwrap() []error {
+	return e.errs
+}
+
+// validateConfig checks the validate tags of config (a pointer to a struct).
+func validateConfig(config interface{}) error {
+	w := &walker{phases: phaseValidate}
+	if err := w.walk(reflect.ValueOf(config)); err != nil {
+		return err
+	}
+	if len(w.violations) > 0 {
+		return &ValidationError{Violations: w.violations, errs: w.validateErrs}
+	}
+	return nil
+}
+
+/*
+ * Check the validate tags of one struct
+ */
+func (w *walker) validateFields(v reflect.Value, info *structInfo) {
+	for _, rule := range info.rules {
+		label := info.label(v.Type(), rule.index)
+		if rule.err != nil {
+			w.violations = append(w.violations, t("config.validate_tag_invalid", label, rule.tag, rule.err))
+			continue
+		}
+		fieldValue := v.Field(rule.index)
+		if rule.required && fieldValue.IsZero() {
+			w.violations = append(w.violations, t("config.validate_required", label))
+			continue
+		}
+		n, isLength, ok := measure(fieldValue)
+		if rule.min != nil || rule.max != nil {
+			if !ok {
+				w.violations = append(w.violations, t("config.validate_tag_invalid", label, rule.tag, fieldValue.Kind()))
+				continue
+			}
+			if rule.min != nil && n < *rule.min {
+				key := "config.validate_min"
+				if isLength {
+					key = "config.validate_min_len"
+				}
+				w.violations = append(w.violations, t(key, label, *rule.min))
+			}
+			if rule.max != nil && n > *rule.max {
+				key := "config.validate_max"
+				if isLength {
+					key = "config.validate_max_len"
+				}
+				w.violations = append(w.violations, t(key, label, *rule.max))
+			}
+		}
+		if rule.pattern != nil {
+			if fieldValue.Kind() != reflect.String {
+				w.violations = append(w.violations, t("config.validate_tag_invalid", label, rule.tag, fieldValue.Kind()))
+			} else if !rule.pattern.MatchString(fieldValue.String()) {
+				w.violations = append(w.violations, t("config.validate_pattern", label, rule.pattern.String()))
+			}
+		}
+	}
+	if !v.CanAddr() {
+		return
+	}
+	if validator, ok := v.Addr().Interface().(Validator); ok {
+		w.addValidateError(v.Type().Name(), validator.Validate())
+	}
+}
+
+// addValidateError records err, returned by the Validate method of the
+// struct type name, and the errors it joins.
+func (w *walker) addValidateError(name string, err error) {
+	switch e := err.(type) {
+	case nil:
+	case *ValidationError:
+		w.violations = append(w.violations, e.Violations...)
+		w.validateErrs = append(w.validateErrs, e.errs...)
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			w.addValidateError(name, inner)
+		}
+	default:
+		w.violations = append(w.violations, t("config.validate_custom", name, err))
+		w.validateErrs = append(w.validateErrs, err)
+	}
+}
+
+// measure returns what min and max compare for v: the value of a number, or
+// the length of a string, slice or map.
+func measure(v reflect.Value) (n float64, isLength, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true, true
+	case reflect.Slice, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
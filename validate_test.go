@@ -0,0 +1,141 @@
+package sconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type validateTestConfig struct {
+	Host    string   `json:"host" validate:"required"`
+	Port    int      `json:"port" default:"5432" validate:"min=1,max=65535"`
+	Schema  string   `json:"schema" default:"app" validate:"min=2,pattern=^[a-z_]{1,16}$"`
+	Ratio   float64  `json:"ratio" validate:"max=1"`
+	Tags    []string `json:"tags" validate:"max=2"`
+	Workers []struct {
+		Name string `json:"name" validate:"required"`
+	} `json:"workers"`
+}
+
+func TestLoadConfig_Validate(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	load := func(content string) error {
+		if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+			ts.Fatalf("WriteFile failed: %v", err)
+		}
+		return LoadConfig(&validateTestConfig{}, 1, configPath, false, false, hw)
+	}
+
+	if err := load(`{"host": "db", "tags": ["a", "b"], "workers": [{"name": "w1"}]}`); err != nil {
+		ts.Fatalf("valid config rejected: %v", err)
+	}
+
+	err := load(`{"port": 70000, "schema": "App-1", "ratio": 1.5, "tags": ["a", "b", "c"], "workers": [{"name": ""}]}`)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		ts.Fatalf("expected a ValidationError, got %v", err)
+	}
+	// Nested structs are checked before the fields of their parent.
+	want := []string{
+		t("config.validate_required", "Name"),
+		t("config.validate_required", "Host"),
+		t("config.validate_max", "Port", 65535.0),
+		t("config.validate_pattern", "Schema", "^[a-z_]{1,16}$"),
+		t("config.validate_max", "Ratio", 1.0),
+		t("config.validate_max_len", "Tags", 2.0),
+	}
+	if len(verr.Violations) != len(want) {
+		ts.Fatalf("violations = %q, want %q", verr.Violations, want)
+	}
+	for i := range want {
+		if verr.Violations[i] != want[i] {
+			ts.Errorf("violation %d = %q, want %q", i, verr.Violations[i], want[i])
+		}
+	}
+}
+
+func TestValidate_MalformedTag(ts *testing.T) {
+	type malformed struct {
+		Port int    `validate:"min=one"`
+		Name string `validate:"between=1"`
+		Flag bool   `validate:"max=1"`
+	}
+	err := validateConfig(&malformed{Flag: true})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Violations) != 3 {
+		ts.Fatalf("expected three tag errors, got %v", err)
+	}
+}
+
+var errSamePorts = errors.New("admin port equals the main port")
+
+type validatorTestConfig struct {
+	Port      int                    `json:"port" default:"8080" validate:"min=1"`
+	AdminPort int                    `json:"admin_port" default:"8080"`
+	Backends  []validatorTestBackend `json:"backends"`
+}
+
+func (c *validatorTestConfig) Validate() error {
+	if c.Port == c.AdminPort {
+		return errSamePorts
+	}
+	return nil
+}
+
+type validatorTestBackend struct {
+	URL     string `json:"url"`
+	Timeout int    `json:"timeout"`
+}
+
+func (b validatorTestBackend) Validate() error {
+	var errs []error
+	if b.URL == "" {
+		errs = append(errs, errors.New("url missing"))
+	}
+	if b.Timeout < 0 {
+		errs = append(errs, errors.New("negative timeout"))
+	}
+	return errors.Join(errs...)
+}
+
+func TestLoadConfig_Validator(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"port": 0, "backends": [{"url": "http://a", "timeout": -1}, {"timeout": 5}]}`), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	err := LoadConfig(&validatorTestConfig{}, 1, configPath, false, false, hw)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		ts.Fatalf("expected a ValidationError, got %v", err)
+	}
+	want := []string{
+		t("config.validate_custom", "validatorTestBackend", "negative timeout"),
+		t("config.validate_custom", "validatorTestBackend", "url missing"),
+		t("config.validate_min", "Port", 1.0),
+	}
+	if len(verr.Violations) != len(want) {
+		ts.Fatalf("violations = %q, want %q", verr.Violations, want)
+	}
+	for i := range want {
+		if verr.Violations[i] != want[i] {
+			ts.Errorf("violation %d = %q, want %q", i, verr.Violations[i], want[i])
+		}
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"port": 8080}`), 0600); err != nil {
+		ts.Fatalf("WriteFile failed: %v", err)
+	}
+	err = LoadConfig(&validatorTestConfig{}, 1, configPath, false, false, hw)
+	if !errors.Is(err, errSamePorts) {
+		ts.Errorf("expected errSamePorts, got %v", err)
+	}
+}
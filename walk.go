@@ -0,0 +1,496 @@
+package sconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Single-pass reflection pipeline.
+ *
+ * Defaults, version sync, password encryption and decryption used to be four
+ * separate recursive walks, each with its own recursion and field matching.
+ * They are now steps of one walker driven by the cached structInfo: a walk
+ * visits every struct reachable from the config exactly once and applies the
+ * selected phases to it. New per-field behaviour is added as a further phase
+ * here instead of yet another recursive function.
+ */
+
+// phase selects the steps a walk applies to each struct.
+type phase uint16
+
+const (
+	phaseDefaults  phase = 1 << iota // set values from `default:"..."` tags
+	phaseVersion                     // sync integer Version fields
+	phaseEncrypt                     // encrypt new plaintext passwords
+	phaseRekey                       // re-encrypt ciphertexts from oldKey to the current key
+	phaseDecrypt                     // decrypt <Name>SecurePassword into <Name>Password
+	phaseResolve                     // replace secretref: values by the resolved secret
+	phaseTemplate                    // render {{ ... }} template expressions in string values
+	phaseScrub                       // replace decrypted passwords by the secure marker
+	phaseStrip                       // like phaseScrub, and drop passwords without ciphertext
+	phasePreflight                   // check references and preflight-tagged resources
+	phaseCount                       // count the encrypted passwords
+	phaseEnv                         // set env-tagged fields from the environment
+	phaseFlags                       // set flag-tagged fields from the parsed flags
+	phaseValidate                    // check the validate-tagged fields and call Validate methods
+	phaseRedact                      // replace passwords by placeholders derived from the plaintext
+)
+
+// bindingPhases remember the fields they set, to put the original values
+// back before a rewrite. They need addressable structs and skip the values of
+// maps of structs (map[string]*T is walked like a slice).
+const bindingPhases = phaseResolve | phaseTemplate | phaseEnv | phaseFlags
+
+// walker carries the parameters and the result of one walk.
+type walker struct {
+	phases       phase
+	version      int
+	changed      bool                  // set when phaseVersion or phaseEncrypt modified the config
+	refs         []secretBinding       // values replaced by phaseResolve and phaseTemplate
+	tmpl         *templateContext      // data and functions for phaseTemplate
+	report       *PreflightReport      // results of phasePreflight
+	secrets      int                   // encrypted passwords counted by phaseCount
+	oldKey       []byte                // key of the ciphertexts for phaseRekey
+	flags        map[string]string     // values of the flags set, for phaseFlags
+	overridden   []overrideBinding     // fields set by phaseEnv and phaseFlags
+	violations   []string              // messages of the rules failed in phaseValidate
+	validateErrs []error               // errors of the Validate methods called in phaseValidate
+	allocating   map[reflect.Type]bool // struct types allocated by phaseDefaults on the current path
+}
+
+// walkPtr walks the struct the pointer field p points to. A nil pointer to a
+// struct type with default tags is allocated by phaseDefaults, unless the
+// type is already being allocated further up (a recursive type).
+func (w *walker) walkPtr(p reflect.Value) error {
+	if p.IsNil() {
+		typ := p.Type().Elem()
+		if w.phases&phaseDefaults == 0 || len(getStructInfo(typ).defaults) == 0 || w.allocating[typ] {
+			return nil
+		}
+		p.Set(reflect.New(typ))
+		if w.allocating == nil {
+			w.allocating = make(map[reflect.Type]bool)
+		}
+		w.allocating[typ] = true
+		defer delete(w.allocating, typ)
+	}
+	return w.walk(p)
+}
+
+// walkMap walks the struct values of the map m. Values of struct type are
+// not addressable; each is walked as a copy that is stored back.
+func (w *walker) walkMap(m reflect.Value) error {
+	iter := m.MapRange()
+	for iter.Next() {
+		value := iter.Value()
+		if value.Kind() == reflect.Ptr {
+			if err := w.walk(value); err != nil {
+				return err
+			}
+			continue
+		}
+		if w.phases&bindingPhases != 0 {
+			continue
+		}
+		elem := reflect.New(value.Type()).Elem()
+		elem.Set(value)
+		if err := w.walk(elem); err != nil {
+			return err
+		}
+		m.SetMapIndex(iter.Key(), elem)
+	}
+	return nil
+}
+
+// walk applies w.phases to v (a struct or pointer to struct) and, recursively,
+// to all nested structs (also behind pointers), struct slice elements (also
+// behind pointers) and struct map values.
+func (w *walker) walk(v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	info := getStructInfo(v.Type())
+	if w.phases&phaseDefaults != 0 {
+		if err := applyDefaults(v, info); err != nil {
+			return err
+		}
+	}
+	for _, i := range info.nested {
+		if err := w.walk(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	for _, i := range info.ptrs {
+		if err := w.walkPtr(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	for _, i := range info.slices {
+		fieldValue := v.Field(i)
+		for k := 0; k < fieldValue.Len(); k++ {
+			elem := fieldValue.Index(k)
+			if elem.Kind() == reflect.Ptr && elem.IsNil() {
+				continue
+			}
+			if err := w.walk(elem); err != nil {
+				return err
+			}
+		}
+	}
+	for _, i := range info.maps {
+		if err := w.walkMap(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseVersion != 0 && info.versionIndex >= 0 {
+		fieldValue := v.Field(info.versionIndex)
+		if fieldValue.Int() != int64(w.version) {
+			fieldValue.SetInt(int64(w.version))
+			w.changed = true
+		}
+	}
+	if w.phases&phaseRekey != 0 {
+		if err := w.rekeyPairs(v, info); err != nil {
+			return err
+		}
+		if err := w.rekeySecured(v, info); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseEncrypt != 0 {
+		if err := w.encryptPairs(v, info); err != nil {
+			return err
+		}
+		if err := w.encryptSecured(v, info); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseDecrypt != 0 {
+		if err := decryptPairs(v, info); err != nil {
+			return err
+		}
+		if err := decryptSecured(v, info); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseTemplate != 0 {
+		if err := w.renderTemplates(v, info); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseResolve != 0 {
+		if err := w.resolveRefs(v, info); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseEnv != 0 {
+		if err := w.applyTagged(v, info, phaseEnv); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseFlags != 0 {
+		if err := w.applyTagged(v, info, phaseFlags); err != nil {
+			return err
+		}
+	}
+	if w.phases&phaseValidate != 0 {
+		w.validateFields(v, info)
+	}
+	if w.phases&phasePreflight != 0 {
+		w.preflight(v, info)
+	}
+	if w.phases&phaseCount != 0 {
+		for _, pair := range info.pairs {
+			if v.Field(pair.secure).String() != "" {
+				w.secrets++
+			}
+		}
+		for _, i := range info.secured {
+			if value := v.Field(i).String(); value != "" && !isSecretRef(value) {
+				w.secrets++
+			}
+		}
+	}
+	if w.phases&(phaseScrub|phaseStrip) != 0 {
+		scrubPairs(v, info, w.phases&phaseStrip != 0)
+		scrubSecured(v, info, w.phases&phaseStrip != 0)
+	}
+	if w.phases&phaseRedact != 0 {
+		redactPairs(v, info)
+	}
+	return nil
+}
+
+/*
+ * Set the default values present in the annotations of one struct
+ */
+func applyDefaults(v reflect.Value, info *structInfo) error {
+	for _, def := range info.defaults {
+		fieldValue := v.Field(def.index)
+		var supported bool
+		var err error
+		if fieldValue.Kind() == reflect.Slice {
+			supported, err = setSliceDefault(fieldValue, def)
+		} else {
+			supported, err = setDefault(fieldValue, def.value, def.layout)
+		}
+		if !supported {
+			return fmt.Errorf(t("config.default_unsupported"), fmt.Sprintf("%v (%s)", fieldValue.Type(), info.label(v.Type(), def.index)))
+		}
+		if err != nil {
+			return fmt.Errorf(t("config.default_error"), fmt.Errorf("%s: %w", info.label(v.Type(), def.index), err))
+		}
+	}
+	return nil
+}
+
+// setSliceDefault sets the slice field to the elements of the default split
+// at its separator (a comma unless the field has a `sep:"..."` tag), without
+// the spaces around them. An empty default is an empty slice.
+func setSliceDefault(field reflect.Value, def defaultField) (bool, error) {
+	var parts []string
+	if def.value != "" {
+		parts = strings.Split(def.value, def.sep)
+	}
+	slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+	for i, part := range parts {
+		if supported, err := setDefault(slice.Index(i), strings.TrimSpace(part), def.layout); !supported || err != nil {
+			return supported, err
+		}
+	}
+	field.Set(slice)
+	return true, nil
+}
+
+// setDefault parses the default value into the scalar, Duration or Time
+// field. It reports whether the type of the field takes defaults.
+func setDefault(field reflect.Value, value, layout string) (bool, error) {
+	switch field.Type() {
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			field.SetInt(int64(d))
+		}
+		return true, err
+	case timeType:
+		tm, err := parseTime(value, layout)
+		if err == nil {
+			field.Set(reflect.ValueOf(tm))
+		}
+		return true, err
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return true, err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return true, err
+		}
+		field.SetBool(b)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+/*
+ * Encrypt every password of one struct that is not the secure marker
+ */
+func (w *walker) encryptPairs(v reflect.Value, info *structInfo) error {
+	for _, pair := range info.pairs {
+		plainValue := v.Field(pair.plain)
+		if pair.binary {
+			// An empty binary password is the secure state.
+			if plainValue.Len() > 0 {
+				password, err := encrypt(string(plainValue.Bytes()))
+				if err != nil {
+					return err
+				}
+				v.Field(pair.secure).SetString(password)
+				plainValue.SetBytes(nil)
+				w.changed = true
+			}
+			continue
+		}
+		if isSecretRef(plainValue.String()) {
+			// A reference is not a secret itself: it stays readable in the
+			// file and a stale ciphertext from an earlier password is dropped.
+			if secureValue := v.Field(pair.secure); secureValue.String() != "" {
+				secureValue.SetString("")
+				w.changed = true
+			}
+			continue
+		}
+		if plainValue.String() != PASSWORD_IS_SECURE_de && plainValue.String() != PASSWORD_IS_SECURE_en {
+			// New password found in plain text
+			// New Secure_Password is calculated
+			password, err := encrypt(plainValue.String())
+			if err != nil {
+				return err
+			}
+			v.Field(pair.secure).SetString(password)
+			plainValue.SetString(PASSWORD_IS_SECURE)
+			w.changed = true
+		}
+	}
+	return nil
+}
+
+/*
+ * Re-encrypt the ciphertexts of one struct from w.oldKey to the current key.
+ */
+func (w *walker) rekeyPairs(v reflect.Value, info *structInfo) error {
+	for _, pair := range info.pairs {
+		secureValue := v.Field(pair.secure)
+		if secureValue.String() == "" || pair.isRef(v) {
+			continue
+		}
+		password, err := decryptWithKey(w.oldKey, secureValue.String())
+		if err != nil {
+			decryptFailures.Add(1)
+			return fmt.Errorf("%s", t("config.decrypt_failed", describedName(pair.name, pair.desc), err))
+		}
+		secure, err := encrypt(password)
+		if err != nil {
+			return err
+		}
+		secureValue.SetString(secure)
+		w.changed = true
+	}
+	return nil
+}
+
+/*
+ * Decrypt the encrypted passwords of one struct so that the encryption is
+ * transparent in the main program.
+ */
+func decryptPairs(v reflect.Value, info *structInfo) error {
+	for _, pair := range info.pairs {
+		if pair.isRef(v) || pair.binary && v.Field(pair.secure).String() == "" {
+			continue
+		}
+		var password string
+		var plaintext []byte
+		var err error
+		if pair.binary {
+			plaintext, err = decryptBytes(v.Field(pair.secure).String())
+		} else {
+			password, err = decrypt(v.Field(pair.secure).String())
+		}
+		if fault := decryptFault(pair.name); fault != nil && v.Field(pair.secure).String() != "" {
+			err = fault
+		}
+		if err != nil {
+			wipe(plaintext)
+			decryptFailures.Add(1)
+			if debugMode {
+				writeDebugLog(lastDebugHardwareID, lastDebugIdentifiers, false)
+			}
+			// Always show a field name (use translated fallback if prefix empty)
+			fieldName := pair.name
+			if fieldName == "" {
+				fieldName = t("config.unknown_password_field")
+			}
+			return fmt.Errorf("%s", t("config.decrypt_failed", describedName(fieldName, pair.desc), err))
+		}
+		if pair.binary {
+			v.Field(pair.plain).SetBytes(plaintext)
+			rememberSecretBytes(plaintext)
+		} else {
+			v.Field(pair.plain).SetString(password)
+			rememberSecret(password)
+		}
+	}
+	return nil
+}
+
+/*
+ * Drop the decrypted passwords of one struct; the ciphertexts stay so that the
+ * struct can still be written. With strip, plaintext passwords that have no
+ * ciphertext yet are dropped as well.
+ */
+func scrubPairs(v reflect.Value, info *structInfo, strip bool) {
+	for _, pair := range info.pairs {
+		plainValue := v.Field(pair.plain)
+		if pair.isRef(v) {
+			continue
+		}
+		if pair.binary {
+			if v.Field(pair.secure).String() != "" || strip {
+				if pair.wiped {
+					wipe(plainValue.Bytes())
+				}
+				plainValue.SetBytes(nil)
+			}
+			continue
+		}
+		if v.Field(pair.secure).String() != "" {
+			plainValue.SetString(secureMarker())
+		} else if strip {
+			plainValue.SetString("")
+		}
+	}
+}
+
+// markerMu guards the PASSWORD_IS_SECURE markers against readers that do
+// not hold stateMu (Clone, Merge, Loader.Close); writers hold both.
+var markerMu sync.RWMutex
+
+// secureMarker returns PASSWORD_IS_SECURE, also before the first LoadConfig.
+func secureMarker() string {
+	markerMu.RLock()
+	marker := PASSWORD_IS_SECURE
+	markerMu.RUnlock()
+	if marker != "" {
+		return marker
+	}
+	return t("config.password_message")
+}
+
+// updateDefaultValues sets the tag defaults of the whole config.
+func updateDefaultValues(v reflect.Value) error {
+	return (&walker{phases: phaseDefaults}).walk(v)
+}
+
+// updateVersionAndPasswords syncs Version fields and encrypts new plaintext
+// passwords; *changed is set when the config has to be written back.
+func updateVersionAndPasswords(v reflect.Value, version int, changed *bool) error {
+	w := &walker{phases: phaseVersion | phaseEncrypt, version: version}
+	err := w.walk(v)
+	if w.changed {
+		*changed = true
+	}
+	return err
+}
+
+// decodePasswords decrypts all secure passwords of the whole config.
+func decodePasswords(v reflect.Value) error {
+	return (&walker{phases: phaseDecrypt}).walk(v)
+}
@@ -0,0 +1,325 @@
+package sconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+ * Hot reload.
+ *
+ * Long-running services pick up config changes without a restart.
+ * WatchConfig watches the directory of the config file with fsnotify (editors
+ * and writeFileAtomic replace the file by a rename, which a watch on the file
+ * itself would lose) and, when the file changed, reads it with LoadConfig
+ * into a fresh struct: parsing, defaults, decryption, and encryption of newly
+ * added plaintext passwords, which are written back to the file at once. The
+ * fresh struct becomes the current copy, returned by Config, and onChange is
+ * called. A failed reload calls onChange with the error and keeps the
+ * previous copy.
+ *
+ * Bursts of events (an editor writes and renames, the write-back of
+ * LoadConfig itself) are coalesced: a reload starts after watchSettle
+ * without further events, and content that was already loaded is not loaded
+ * again.
+ *
+ * Network filesystems (NFS, SMB/CIFS) deliver no events for changes made on
+ * other hosts, so a file on such a mount (see isNetworkFS) is polled instead,
+ * as is every file watched WithPolling: at each interval, varied by a random
+ * jitter so that many clients do not hit the file server in step, the
+ * modification time and size are compared, and only if one of them changed
+ * is the file read and its hash compared.
+ *
+ * A reload uses only the version and namespace of the watched config; the
+ * other options it was loaded with are passed WithLoadOptions.
+ *
+ * WithTrial makes a reload two-phase: the loaded and validated config is
+ * first handed to the application as a candidate, which may try it out
+ * (connect to the database with the new password, bind the new port). Only
+ * if the trial succeeds is the candidate promoted to the current copy; a
+ * rejected candidate is dropped, the previous copy stays active and onChange
+ * gets a *TrialError. The rejected content is not tried again until the file
+ * changes.
+ */
+
+// watchSettle is the quiet time after the last event before a reload.
+const watchSettle = 100 * time.Millisecond
+
+// Polling on network filesystems, unless set WithPolling.
+const (
+	defaultPollInterval = 2 * time.Second
+	defaultPollJitter   = 500 * time.Millisecond
+)
+
+// ConfigWatcher reloads a config file when it changes; see WatchConfig. Its
+// methods are safe for concurrent use.
+type ConfigWatcher struct {
+	typ       reflect.Type
+	version   int
+	namespace string // of the config passed to WatchConfig
+	path      string
+	onChange  func(error)
+	trial     func(candidate interface{}) error
+	loadOpts  []LoadOption      // of WithLoadOptions, for every reload
+	fsw       *fsnotify.Watcher // nil when polling
+	poll      time.Duration     // polling interval, 0 for fsnotify
+	jitter    time.Duration
+	current   atomic.Value // latest config, same type as the config passed to WatchConfig
+	lastSum   [32]byte     // hash of the content last loaded
+	lastMod   time.Time    // modification time at the last poll
+	lastSize  int64        // size at the last poll
+	quit      chan struct{}
+	done      chan struct{}
+	stop      sync.Once
+}
+
+// WatchOption configures a ConfigWatcher.
+type WatchOption func(*ConfigWatcher)
+
+// WithPolling polls the file every interval, varied randomly by up to
+// jitter in either direction, instead of waiting for fsnotify events. Files
+// on network filesystems are polled without it, every 2s ± 0.5s.
+func WithPolling(interval, jitter time.Duration) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.poll, w.jitter = interval, jitter
+	}
+}
+
+// WithTrial passes every reloaded config to trial before it becomes the
+// current copy. candidate has the type of the config passed to WatchConfig
+// and must not be kept if trial fails; an error rejects it, and Config keeps
+// returning the previous copy. trial runs on the watcher goroutine.
+func WithTrial(trial func(candidate interface{}) error) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.trial = trial
+	}
+}
+
+// WithLoadOptions passes opts to every reload, e.g. the options the config
+// was loaded with: WithStrict, WithLastGood, WithPassphrase and the others
+// then apply to the reloads as well. The version and namespace of the
+// watched config are passed before them.
+func WithLoadOptions(opts ...LoadOption) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.loadOpts = append(w.loadOpts, opts...)
+	}
+}
+
+// TrialError reports a reloaded config rejected by the trial of WithTrial.
+type TrialError struct {
+	Path string
+	Err  error
+}
+
+func (e *TrialError) Error() string {
+	return t("config.trial_rejected", e.Path, e.Err)
+}
+
+func (e *TrialError) Unwrap() error {
+	return e.Err
+}
+
+// WatchConfig watches the config file at path, which config (a pointer to a
+// struct) was loaded from with LoadConfig or Load, and reloads it into a fresh struct
+// of the same type and version whenever it changes. After every reload
+// onChange is called with nil, and Config returns the new copy; if the
+// reload fails, onChange gets the error and Config keeps the previous copy.
+// onChange runs on the watcher goroutine. config itself is not modified.
+// Files on network filesystems are polled, others too WithPolling. Call
+// Close to stop watching.
+func WatchConfig(config interface{}, path string, onChange func(error), opts ...WatchOption) (*ConfigWatcher, error) {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s", t("config.config_no_struct"))
+	}
+	resolved, err := resolveConfigPath(path)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return nil, err
+	}
+	w := &ConfigWatcher{
+		typ:       v.Elem().Type(),
+		version:   getStructVersion(v.Elem()),
+		namespace: namespaceOf(config),
+		path:      resolved,
+		onChange:  onChange,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.poll <= 0 && isNetworkFS(filepath.Dir(resolved)) {
+		w.poll, w.jitter = defaultPollInterval, defaultPollJitter
+	}
+	if w.poll <= 0 {
+		fsw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("%s", t("config.watch_failed", resolved, err))
+		}
+		if err := fsw.Add(filepath.Dir(resolved)); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("%s", t("config.watch_failed", resolved, err))
+		}
+		w.fsw = fsw
+	}
+	w.current.Store(deepCopy(v).Interface())
+	if data, err := os.ReadFile(resolved); err == nil {
+		w.lastSum = sha256.Sum256(data)
+	}
+	if info, err := os.Stat(resolved); err == nil {
+		w.lastMod, w.lastSize = info.ModTime(), info.Size()
+	}
+	if w.fsw != nil {
+		go w.loop()
+	} else {
+		go w.pollLoop()
+	}
+	return w, nil
+}
+
+// Config returns the most recently loaded config, a pointer of the type
+// passed to WatchConfig that is safe to read from any goroutine and must not
+// be modified.
+func (w *ConfigWatcher) Config() interface{} {
+	return w.current.Load()
+}
+
+// Close stops watching and waits for a running reload. It is idempotent.
+func (w *ConfigWatcher) Close() error {
+	var err error
+	w.stop.Do(func() {
+		close(w.quit)
+		if w.fsw != nil {
+			err = w.fsw.Close()
+		}
+	})
+	<-w.done
+	return err
+}
+
+func (w *ConfigWatcher) loop() {
+	defer close(w.done)
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+	defer settle.Stop()
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == w.path && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				settle.Reset(watchSettle)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.notify(fmt.Errorf("%s", t("config.watch_failed", w.path, err)))
+		case <-settle.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *ConfigWatcher) pollLoop() {
+	defer close(w.done)
+	timer := time.NewTimer(w.nextPoll())
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-timer.C:
+			if w.modified() {
+				w.reload()
+			}
+			timer.Reset(w.nextPoll())
+		}
+	}
+}
+
+// nextPoll returns the polling interval varied by the jitter.
+func (w *ConfigWatcher) nextPoll() time.Duration {
+	d := w.poll
+	if w.jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*w.jitter)+1)) - w.jitter
+	}
+	if d <= 0 {
+		d = w.poll
+	}
+	return d
+}
+
+// modified reports whether the modification time or size of the file
+// changed since the last poll. An error other than a missing file counts as
+// a change, so that reload reports it.
+func (w *ConfigWatcher) modified() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	if info.ModTime().Equal(w.lastMod) && info.Size() == w.lastSize {
+		return false
+	}
+	w.lastMod, w.lastSize = info.ModTime(), info.Size()
+	return true
+}
+
+// reload loads the file into a fresh struct if its content changed.
+func (w *ConfigWatcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return // replaced by a rename that has not happened yet
+		}
+		w.notify(err)
+		return
+	}
+	if sum := sha256.Sum256(data); sum == w.lastSum {
+		return
+	}
+	fresh := reflect.New(w.typ).Interface()
+	opts := append([]LoadOption{WithVersion(w.version), WithNamespace(w.namespace)}, w.loadOpts...)
+	err = Load(fresh, w.path, opts...)
+	// The copy is never written back; its bindings are not needed.
+	forgetSecretRefs(fresh)
+	forgetOverrides(fresh)
+	setNamespace(fresh, "")
+	forgetLoadedFile(fresh)
+	if err != nil {
+		w.notify(err)
+		return
+	}
+	// The write-back of new passwords changed the file again.
+	if data, err := os.ReadFile(w.path); err == nil {
+		w.lastSum = sha256.Sum256(data)
+	}
+	if w.trial != nil {
+		if err := w.trial(fresh); err != nil {
+			w.notify(&TrialError{Path: w.path, Err: err})
+			return
+		}
+	}
+	w.current.Store(fresh)
+	w.notify(nil)
+}
+
+func (w *ConfigWatcher) notify(err error) {
+	if w.onChange != nil {
+		w.onChange(err)
+	}
+}
@@ -0,0 +1,251 @@
+package sconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "watch.json")
+	cfg := &TestConfig{DatabasePassword: "first-secret"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	changes := make(chan error, 16)
+	w, err := WatchConfig(cfg, configPath, func(err error) { changes <- err })
+	if err != nil {
+		ts.Fatalf("WatchConfig failed: %v", err)
+	}
+	defer w.Close()
+	if w.Config().(*TestConfig).DatabasePassword != "first-secret" {
+		ts.Fatalf("unexpected initial copy: %+v", w.Config())
+	}
+	wait := func() error {
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(5 * time.Second):
+			ts.Fatal("no reload")
+			return nil
+		}
+	}
+
+	// A new plaintext password is encrypted on reload.
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	doc["database_host"] = "db.new"
+	doc["database_password"] = "second-secret"
+	raw, _ = json.Marshal(doc)
+	if err := os.WriteFile(configPath, raw, 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	got := w.Config().(*TestConfig)
+	if got.DatabaseHost != "db.new" || got.DatabasePassword != "second-secret" {
+		ts.Errorf("reloaded copy = %+v", got)
+	}
+	if cfg.DatabaseHost != "localhost" {
+		ts.Errorf("the watched struct was modified: %+v", cfg)
+	}
+	raw, _ = os.ReadFile(configPath)
+	json.Unmarshal(raw, &doc)
+	if doc["database_password"] != PASSWORD_IS_SECURE {
+		ts.Errorf("new password not encrypted:\n%s", raw)
+	}
+
+	// A broken file reports the error and keeps the copy.
+	if err := os.WriteFile(configPath, []byte("{broken"), 0600); err != nil {
+		ts.Fatal(err)
+	}
+	if err := wait(); err == nil {
+		ts.Error("expected a reload error")
+	}
+	if w.Config().(*TestConfig) != got {
+		ts.Error("a failed reload replaced the copy")
+	}
+	if err := w.Close(); err != nil {
+		ts.Errorf("Close failed: %v", err)
+	}
+	w.Close()
+}
+
+func TestWatchConfig_Polling(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "poll.json")
+	cfg := &TestConfig{}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	changes := make(chan error, 16)
+	w, err := WatchConfig(cfg, configPath, func(err error) { changes <- err }, WithPolling(20*time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		ts.Fatalf("WatchConfig failed: %v", err)
+	}
+	defer w.Close()
+	if w.fsw != nil {
+		ts.Fatal("WithPolling still uses fsnotify")
+	}
+	for i := 0; i < 100; i++ {
+		if d := w.nextPoll(); d < 10*time.Millisecond || d > 30*time.Millisecond {
+			ts.Fatalf("poll interval %v outside the jitter", d)
+		}
+	}
+
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	doc["database_host"] = "db.polled"
+	raw, _ = json.Marshal(doc)
+	if err := os.WriteFile(configPath, raw, 0600); err != nil {
+		ts.Fatal(err)
+	}
+	select {
+	case err := <-changes:
+		if err != nil {
+			ts.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		ts.Fatal("no reload")
+	}
+	if got := w.Config().(*TestConfig); got.DatabaseHost != "db.polled" {
+		ts.Errorf("reloaded copy = %+v", got)
+	}
+
+	// Touching the file without changing it does not reload.
+	now := time.Now().Add(time.Second)
+	if err := os.Chtimes(configPath, now, now); err != nil {
+		ts.Fatal(err)
+	}
+	select {
+	case err := <-changes:
+		ts.Errorf("unchanged content reloaded: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := w.Close(); err != nil {
+		ts.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestWatchConfig_Trial(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "trial.json")
+	cfg := &TestConfig{DatabasePassword: "first-secret"}
+	if err := LoadConfig(cfg, 1, configPath, false, false, hw); err != nil {
+		ts.Fatalf("LoadConfig failed: %v", err)
+	}
+	// The trial accepts every password but "wrong-secret".
+	errWrong := errors.New("login failed")
+	tried := make(chan string, 16)
+	changes := make(chan error, 16)
+	w, err := WatchConfig(cfg, configPath, func(err error) { changes <- err }, WithTrial(func(candidate interface{}) error {
+		password := candidate.(*TestConfig).DatabasePassword
+		tried <- password
+		if password == "wrong-secret" {
+			return errWrong
+		}
+		return nil
+	}))
+	if err != nil {
+		ts.Fatalf("WatchConfig failed: %v", err)
+	}
+	defer w.Close()
+	setPassword := func(password string) error {
+		raw, _ := os.ReadFile(configPath)
+		var doc map[string]interface{}
+		json.Unmarshal(raw, &doc)
+		doc["database_password"] = password
+		raw, _ = json.Marshal(doc)
+		if err := os.WriteFile(configPath, raw, 0600); err != nil {
+			ts.Fatal(err)
+		}
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(5 * time.Second):
+			ts.Fatal("no reload")
+			return nil
+		}
+	}
+
+	before := w.Config()
+	err = setPassword("wrong-secret")
+	var trialErr *TrialError
+	if !errors.As(err, &trialErr) || !errors.Is(err, errWrong) {
+		ts.Fatalf("expected a rejected trial, got %v", err)
+	}
+	if w.Config() != before {
+		ts.Error("a rejected candidate was promoted")
+	}
+	if got := <-tried; got != "wrong-secret" {
+		ts.Errorf("trial got password %q", got)
+	}
+	// The write-back of the rejected password is not tried again.
+	select {
+	case err := <-changes:
+		ts.Errorf("rejected content tried again: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := setPassword("second-secret"); err != nil {
+		ts.Fatalf("reload failed: %v", err)
+	}
+	if got := w.Config().(*TestConfig); got.DatabasePassword != "second-secret" {
+		ts.Errorf("accepted candidate not promoted: %+v", got)
+	}
+}
+
+func TestWatchConfig_LoadOptions(ts *testing.T) {
+	tempDir := testExeRoot(ts)
+	invalidateKey()
+	defer invalidateKey()
+	hw := func() (uint64, error) { return 4711, nil }
+	configPath := filepath.Join(tempDir, "options.json")
+	opts := []LoadOption{WithVersion(1), WithHardwareIDFunc(hw), WithStrict()}
+	cfg := &TestConfig{}
+	if err := Load(cfg, configPath, opts...); err != nil {
+		ts.Fatalf("Load failed: %v", err)
+	}
+	changes := make(chan error, 16)
+	w, err := WatchConfig(cfg, configPath, func(err error) { changes <- err },
+		WithPolling(20*time.Millisecond, 10*time.Millisecond), WithLoadOptions(opts...))
+	if err != nil {
+		ts.Fatalf("WatchConfig failed: %v", err)
+	}
+	defer w.Close()
+
+	// A key typo passes a plain reload but not the strict one of Load.
+	raw, _ := os.ReadFile(configPath)
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	doc["databse_host"] = "db.typo"
+	raw, _ = json.Marshal(doc)
+	if err := os.WriteFile(configPath, raw, 0600); err != nil {
+		ts.Fatal(err)
+	}
+	select {
+	case err := <-changes:
+		var strictErr *StrictError
+		if !errors.As(err, &strictErr) {
+			ts.Errorf("expected a *StrictError from the reload, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		ts.Fatal("no reload")
+	}
+}